@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"echo/internal/middleware"
+	"echo/pkg/queueserver"
+	"errors"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/syl/Go/pkg/examples/queue/inmemory"
+)
+
+func main() {
+	addr := flag.String("addr", "0.0.0.0:8081", "address to listen on")
+	visibility := flag.Duration("visibility-timeout", queueserver.DefaultVisibilityTimeout, "how long a leased message waits for its acknowledgement")
+	flag.Parse()
+
+	q := inmemory.NewInMemoryQueue()
+	server := queueserver.NewServer(q, queueserver.WithVisibilityTimeout(*visibility))
+
+	e := echo.New()
+	e.Use(middleware.Logger)
+	e.Use(middleware.JWT)
+	queueserver.RegisterHandlers(e, server)
+
+	go func() {
+		if err := e.Start(*addr); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal(err)
+		}
+	}()
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	<-sigChan
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := e.Shutdown(ctx); err != nil {
+		log.Printf("shutdown: %v", err)
+	}
+	if err := server.Close(ctx); err != nil {
+		log.Printf("requeue unacknowledged messages: %v", err)
+	}
+	if err := q.Close(); err != nil {
+		log.Printf("close queue: %v", err)
+	}
+}
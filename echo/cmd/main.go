@@ -1,11 +1,53 @@
 package main
 
 import (
+	"context"
 	"echo/internal/pong"
+	"echo/pkg/events"
+	"errors"
 	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/syl/Go/pkg/examples/queue/broker"
+	"github.com/syl/Go/pkg/examples/queue/inmemory"
+	"github.com/syl/Go/pkg/examples/queue/webhook"
 )
 
 func main() {
-	e := pong.PongEchoServer()
-	log.Fatal(e.Start("0.0.0.0:8080"))
+	q := inmemory.NewInMemoryQueue()
+	// The streams see the messages enqueued through the server, such as redrives
+	hub := events.NewHub()
+	// The dispatcher consumes the topics of the registered endpoints
+	dispatcher := webhook.NewDispatcher(broker.NewQueueConsumer(q))
+	e := pong.PongEchoServer(events.NewTap(q, hub), pong.WithEventHub(hub), pong.WithWebhookDispatcher(dispatcher))
+
+	go func() {
+		if err := e.Start("0.0.0.0:8080"); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal(err)
+		}
+	}()
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	<-sigChan
+
+	// Ending the streams first lets the shutdown complete instead of waiting for them
+	if err := hub.Close(); err != nil {
+		log.Printf("close event hub: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := e.Shutdown(ctx); err != nil {
+		log.Printf("shutdown: %v", err)
+	}
+	if err := dispatcher.Close(); err != nil {
+		log.Printf("close webhook dispatcher: %v", err)
+	}
+	if err := q.Close(); err != nil {
+		log.Printf("close queue: %v", err)
+	}
 }
@@ -9,6 +9,7 @@ import (
 	echojwt "github.com/labstack/echo-jwt/v4"
 	"github.com/labstack/echo/v4"
 	"github.com/lestrrat-go/jwx/v3/jwk"
+	"strings"
 )
 
 var JwksURL = "https://url-to-your-jwks.com"
@@ -37,18 +38,31 @@ func getKey(token *jwt.Token) (interface{}, error) {
 	return &pubkey, nil
 }
 
+// streamPrefix is the path of the live streams, authenticated by StreamJWT instead of JWT
+const streamPrefix = "/stream/"
+
 func skipper(c echo.Context) bool {
-	skipPaths := []string{"/ping", "/health", "/status"}
+	skipPaths := []string{"/ping", "/health", "/ready", "/status"}
 	for _, path := range skipPaths {
 		if c.Path() == path {
 			return true
 		}
 	}
-	return false
+	return strings.HasPrefix(c.Path(), streamPrefix)
 }
 
+// JWT reads the token from the Authorization header, the live streams under /stream/ are left to StreamJWT
 var JWT = echojwt.WithConfig(echojwt.Config{
 	KeyFunc:       getKey,
 	SigningMethod: "RS256",
 	Skipper:       skipper,
+	TokenLookup:   "header:Authorization:Bearer ",
+})
+
+// StreamJWT also reads the token from the access_token query parameter, for browsers that cannot set headers
+// on EventSource and WebSocket connections. Only the /stream/ routes use it, the Logger redacts the parameter.
+var StreamJWT = echojwt.WithConfig(echojwt.Config{
+	KeyFunc:       getKey,
+	SigningMethod: "RS256",
+	TokenLookup:   "header:Authorization:Bearer ,query:" + accessTokenParam,
 })
@@ -1,6 +1,8 @@
 package middleware
 
 import (
+	"strings"
+
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
 	"go.uber.org/zap"
@@ -13,7 +15,7 @@ func NewLogValuesFunc(logger *zap.Logger) func(c echo.Context, v middleware.Requ
 		fields := []zap.Field{
 			zap.String("remote_ip", v.RemoteIP),
 			zap.String("host", v.Host),
-			zap.String("uri", v.URI),
+			zap.String("uri", redactURI(v.URI)),
 			zap.String("method", v.Method),
 			zap.Int("status", v.Status),
 			zap.Duration("latency", v.Latency),
@@ -31,6 +33,25 @@ func NewLogValuesFunc(logger *zap.Logger) func(c echo.Context, v middleware.Requ
 	}
 }
 
+// accessTokenParam is the query parameter carrying the token of the live streams
+const accessTokenParam = "access_token"
+
+// redactURI hides the value of the access_token query parameter, keeping the rest of the URI as sent
+func redactURI(uri string) string {
+	path, query, found := strings.Cut(uri, "?")
+	if !found {
+		return uri
+	}
+
+	params := strings.Split(query, "&")
+	for i, param := range params {
+		if name, _, _ := strings.Cut(param, "="); name == accessTokenParam {
+			params[i] = accessTokenParam + "=REDACTED"
+		}
+	}
+	return path + "?" + strings.Join(params, "&")
+}
+
 var zapLoggerConfig = middleware.RequestLoggerConfig{
 	LogRemoteIP:   true,
 	LogHost:       true,
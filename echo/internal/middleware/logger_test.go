@@ -0,0 +1,22 @@
+package middleware
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedactURI(t *testing.T) {
+	for _, tc := range []struct {
+		uri      string
+		expected string
+	}{
+		{"/stream/orders/events", "/stream/orders/events"},
+		{"/stream/orders/events?access_token=secret", "/stream/orders/events?access_token=REDACTED"},
+		{"/stream/orders/ws?filter=region+%3D+%27eu%27&access_token=secret&access_token=again",
+			"/stream/orders/ws?filter=region+%3D+%27eu%27&access_token=REDACTED&access_token=REDACTED"},
+		{"/admin/topics?limit=10", "/admin/topics?limit=10"},
+	} {
+		assert.Equal(t, tc.expected, redactURI(tc.uri))
+	}
+}
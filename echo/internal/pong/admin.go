@@ -0,0 +1,134 @@
+package pong
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+
+	"github.com/labstack/echo/v4"
+	"github.com/syl/Go/pkg/examples/queue"
+	"github.com/syl/Go/pkg/examples/queue/broker"
+)
+
+const defaultPageLimit = 10
+
+// GetAdminTopics (GET /admin/topics)
+func (s Server) GetAdminTopics(ctx echo.Context) error {
+	c := ctx.Request().Context()
+
+	topics, err := s.queue.Topics(c)
+	if err != nil {
+		return adminError(ctx, http.StatusInternalServerError, err)
+	}
+	sort.Strings(topics)
+
+	resp := TopicList{Topics: make([]TopicSize, 0, len(topics))}
+	for _, topic := range topics {
+		size, err := s.queue.Size(c, topic)
+		if err != nil {
+			return adminError(ctx, http.StatusInternalServerError, err)
+		}
+		resp.Topics = append(resp.Topics, TopicSize{Topic: topic, Size: size})
+	}
+	return ctx.JSON(http.StatusOK, resp)
+}
+
+// GetAdminTopicsTopicSize (GET /admin/topics/{topic}/size)
+func (s Server) GetAdminTopicsTopicSize(ctx echo.Context, topic Topic) error {
+	size, err := s.queue.Size(ctx.Request().Context(), topic)
+	if err != nil {
+		return adminError(ctx, http.StatusInternalServerError, err)
+	}
+	return ctx.JSON(http.StatusOK, TopicSize{Topic: topic, Size: size})
+}
+
+// GetAdminTopicsTopicMessages (GET /admin/topics/{topic}/messages)
+func (s Server) GetAdminTopicsTopicMessages(ctx echo.Context, topic Topic, params GetAdminTopicsTopicMessagesParams) error {
+	inspector, ok := s.queue.(queue.Inspector)
+	if !ok {
+		return adminError(ctx, http.StatusNotImplemented, errors.New("queue does not support browsing messages"))
+	}
+
+	offset, limit := 0, defaultPageLimit
+	if params.Offset != nil {
+		offset = *params.Offset
+	}
+	if params.Limit != nil {
+		limit = *params.Limit
+	}
+
+	if offset < 0 {
+		return adminError(ctx, http.StatusBadRequest, errors.New("offset must not be negative"))
+	}
+	if limit <= 0 {
+		return adminError(ctx, http.StatusBadRequest, errors.New("limit must be positive"))
+	}
+
+	page, err := inspector.Browse(ctx.Request().Context(), topic, offset, limit)
+	if err != nil {
+		return adminError(ctx, http.StatusInternalServerError, err)
+	}
+
+	resp := MessagePage{
+		Messages:   make([]Message, 0, len(page.Messages)),
+		Offset:     page.Offset,
+		NextOffset: page.NextOffset,
+		HasMore:    page.HasMore,
+		Total:      page.Total,
+	}
+	for _, m := range page.Messages {
+		resp.Messages = append(resp.Messages, apiMessage(m))
+	}
+	return ctx.JSON(http.StatusOK, resp)
+}
+
+// DeleteAdminTopicsTopicMessages (DELETE /admin/topics/{topic}/messages)
+func (s Server) DeleteAdminTopicsTopicMessages(ctx echo.Context, topic Topic) error {
+	inspector, ok := s.queue.(queue.Inspector)
+	if !ok {
+		return adminError(ctx, http.StatusNotImplemented, errors.New("queue does not support purging topics"))
+	}
+
+	purged, err := inspector.Purge(ctx.Request().Context(), topic)
+	if err != nil {
+		return adminError(ctx, http.StatusInternalServerError, err)
+	}
+
+	ctx.Logger().Infof("Purged %d messages from topic %s", purged, topic)
+	return ctx.JSON(http.StatusOK, PurgeResult{Topic: topic, Purged: purged})
+}
+
+// PostAdminTopicsTopicRedrive (POST /admin/topics/{topic}/redrive)
+func (s Server) PostAdminTopicsTopicRedrive(ctx echo.Context, topic Topic) error {
+	var req RedriveRequest
+	if ctx.Request().ContentLength != 0 {
+		if err := ctx.Bind(&req); err != nil {
+			return adminError(ctx, http.StatusBadRequest, err)
+		}
+	}
+
+	source := broker.DeadLetterTopic(topic)
+	if req.Source != nil && *req.Source != "" {
+		source = *req.Source
+	}
+	if source == topic {
+		return adminError(ctx, http.StatusBadRequest, errors.New("source and target topics must differ"))
+	}
+
+	max := 0
+	if req.Max != nil {
+		max = *req.Max
+	}
+
+	moved, err := broker.Redrive(ctx.Request().Context(), s.queue, source, topic, max)
+	if err != nil {
+		return adminError(ctx, http.StatusInternalServerError, err)
+	}
+
+	ctx.Logger().Infof("Redrove %d messages from %s to %s", moved, source, topic)
+	return ctx.JSON(http.StatusOK, RedriveResult{Source: source, Topic: topic, Moved: moved})
+}
+
+func adminError(ctx echo.Context, status int, err error) error {
+	return ctx.JSON(status, Error{Message: err.Error()})
+}
@@ -0,0 +1,228 @@
+package pong
+
+import (
+	"context"
+	"echo/internal/middleware"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/syl/Go/pkg/examples/queue"
+	"github.com/syl/Go/pkg/examples/queue/inmemory"
+)
+
+// AdminTestFixture serves the admin API over an in-memory queue with a mocked JWKS
+type AdminTestFixture struct {
+	Queue  *inmemory.InMemoryQueue
+	Server *echo.Echo
+	T      *testing.T
+}
+
+func NewAdminTestFixture(t *testing.T) *AdminTestFixture {
+	t.Helper()
+
+	mockJWKS := startMockJWKS()
+	middleware.JwksURL = mockJWKS.URL
+
+	q := inmemory.NewInMemoryQueue()
+
+	t.Cleanup(func() {
+		q.Close()
+		mockJWKS.Close()
+	})
+
+	return &AdminTestFixture{
+		Queue:  q,
+		Server: PongEchoServer(q),
+		T:      t,
+	}
+}
+
+// Enqueue adds messages with the given payloads to a topic
+func (f *AdminTestFixture) Enqueue(topic string, payloads ...string) {
+	f.T.Helper()
+
+	for _, payload := range payloads {
+		err := f.Queue.Enqueue(context.Background(), topic, &queue.Message{
+			ID:        topic + "-" + payload,
+			Topic:     topic,
+			Payload:   []byte(payload),
+			Headers:   map[string]string{"key": "value"},
+			Timestamp: time.Now(),
+		})
+		require.NoError(f.T, err, "Should enqueue %s", payload)
+	}
+}
+
+// Do sends an authenticated request and decodes the JSON response into out
+func (f *AdminTestFixture) Do(method, target, body string, out interface{}) int {
+	f.T.Helper()
+
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+testToken)
+	if body != "" {
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	}
+	rec := httptest.NewRecorder()
+
+	f.Server.ServeHTTP(rec, req)
+
+	if out != nil {
+		require.NoError(f.T, json.Unmarshal(rec.Body.Bytes(), out), "Should decode response %s", rec.Body.String())
+	}
+	return rec.Code
+}
+
+func TestAdmin(t *testing.T) {
+	t.Run("RequiresToken", func(t *testing.T) {
+		fixture := NewAdminTestFixture(t)
+
+		req := httptest.NewRequest(http.MethodGet, "/admin/topics", nil)
+		rec := httptest.NewRecorder()
+		fixture.Server.ServeHTTP(rec, req)
+
+		assert.Equal(t, http.StatusUnauthorized, rec.Code, "Admin endpoints should require a JWT")
+	})
+
+	t.Run("ListTopics", func(t *testing.T) {
+		fixture := NewAdminTestFixture(t)
+		fixture.Enqueue("payments", "p1")
+		fixture.Enqueue("orders", "o1", "o2")
+
+		var resp TopicList
+		code := fixture.Do(http.MethodGet, "/admin/topics", "", &resp)
+
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, []TopicSize{{Topic: "orders", Size: 2}, {Topic: "payments", Size: 1}}, resp.Topics)
+	})
+
+	t.Run("TopicSize", func(t *testing.T) {
+		fixture := NewAdminTestFixture(t)
+		fixture.Enqueue("orders", "o1", "o2", "o3")
+
+		var resp TopicSize
+		code := fixture.Do(http.MethodGet, "/admin/topics/orders/size", "", &resp)
+
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, TopicSize{Topic: "orders", Size: 3}, resp)
+	})
+
+	t.Run("PeekMessages", func(t *testing.T) {
+		fixture := NewAdminTestFixture(t)
+		fixture.Enqueue("orders", "o1", "o2", "o3")
+
+		var resp MessagePage
+		code := fixture.Do(http.MethodGet, "/admin/topics/orders/messages?offset=1&limit=1", "", &resp)
+
+		assert.Equal(t, http.StatusOK, code)
+		require.Len(t, resp.Messages, 1, "Should return one message")
+		assert.Equal(t, "orders-o2", resp.Messages[0].Id)
+		assert.Equal(t, []byte("o2"), resp.Messages[0].Payload)
+		assert.Equal(t, "value", resp.Messages[0].Headers["key"])
+		assert.True(t, resp.HasMore, "Should have more messages")
+		assert.Equal(t, 2, resp.NextOffset)
+		assert.Equal(t, 3, resp.Total)
+
+		size, err := fixture.Queue.Size(context.Background(), "orders")
+		require.NoError(t, err)
+		assert.Equal(t, 3, size, "Peeking should not consume messages")
+	})
+
+	t.Run("PeekInvalidLimit", func(t *testing.T) {
+		fixture := NewAdminTestFixture(t)
+
+		var resp Error
+		code := fixture.Do(http.MethodGet, "/admin/topics/orders/messages?limit=0", "", &resp)
+
+		assert.Equal(t, http.StatusBadRequest, code)
+		assert.NotEmpty(t, resp.Message, "Should explain the error")
+	})
+
+	t.Run("PeekClosedQueue", func(t *testing.T) {
+		fixture := NewAdminTestFixture(t)
+		require.NoError(t, fixture.Queue.Close())
+
+		var resp Error
+		code := fixture.Do(http.MethodGet, "/admin/topics/orders/messages", "", &resp)
+
+		assert.Equal(t, http.StatusInternalServerError, code, "A closed queue is not a client error")
+		assert.Equal(t, "queue is closed", resp.Message)
+	})
+
+	t.Run("PurgeTopic", func(t *testing.T) {
+		fixture := NewAdminTestFixture(t)
+		fixture.Enqueue("orders", "o1", "o2")
+
+		var resp PurgeResult
+		code := fixture.Do(http.MethodDelete, "/admin/topics/orders/messages", "", &resp)
+
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, PurgeResult{Topic: "orders", Purged: 2}, resp)
+
+		size, err := fixture.Queue.Size(context.Background(), "orders")
+		require.NoError(t, err)
+		assert.Equal(t, 0, size, "Topic should be empty")
+	})
+
+	t.Run("RedriveDeadLetters", func(t *testing.T) {
+		fixture := NewAdminTestFixture(t)
+		fixture.Enqueue("orders.dlq", "f1", "f2")
+
+		var resp RedriveResult
+		code := fixture.Do(http.MethodPost, "/admin/topics/orders/redrive", "", &resp)
+
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, RedriveResult{Source: "orders.dlq", Topic: "orders", Moved: 2}, resp)
+
+		size, err := fixture.Queue.Size(context.Background(), "orders")
+		require.NoError(t, err)
+		assert.Equal(t, 2, size, "Failed messages should be back on the topic")
+	})
+
+	t.Run("RedriveFromSource", func(t *testing.T) {
+		fixture := NewAdminTestFixture(t)
+		fixture.Enqueue("orders.parked", "f1", "f2", "f3")
+
+		var resp RedriveResult
+		code := fixture.Do(http.MethodPost, "/admin/topics/orders/redrive", `{"source":"orders.parked","max":2}`, &resp)
+
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, RedriveResult{Source: "orders.parked", Topic: "orders", Moved: 2}, resp)
+	})
+
+	t.Run("RedriveToItself", func(t *testing.T) {
+		fixture := NewAdminTestFixture(t)
+
+		var resp Error
+		code := fixture.Do(http.MethodPost, "/admin/topics/orders/redrive", `{"source":"orders"}`, &resp)
+
+		assert.Equal(t, http.StatusBadRequest, code)
+	})
+
+	t.Run("InspectorNotSupported", func(t *testing.T) {
+		mockJWKS := startMockJWKS()
+		defer mockJWKS.Close()
+		middleware.JwksURL = mockJWKS.URL
+
+		q := &queueOnly{Queue: queue.NewMock()}
+		e := PongEchoServer(q)
+
+		req := httptest.NewRequest(http.MethodGet, "/admin/topics/orders/messages", nil)
+		req.Header.Set("Authorization", "Bearer "+testToken)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+
+		assert.Equal(t, http.StatusNotImplemented, rec.Code, "Should report missing inspection support")
+	})
+}
+
+// queueOnly hides the Inspector methods of the wrapped queue
+type queueOnly struct {
+	queue.Queue
+}
@@ -4,19 +4,227 @@
 package pong
 
 import (
+	"fmt"
+	"net/http"
+	"time"
+
 	"github.com/labstack/echo/v4"
+	"github.com/oapi-codegen/runtime"
+)
+
+// Defines values for HealthStatus.
+const (
+	Down HealthStatus = "down"
+	Up   HealthStatus = "up"
+)
+
+// Defines values for WebhookDeliveryStatus.
+const (
+	Failed    WebhookDeliveryStatus = "failed"
+	Succeeded WebhookDeliveryStatus = "succeeded"
 )
 
+// Defines values for WebhookEndpointCircuit.
+const (
+	Closed   WebhookEndpointCircuit = "closed"
+	HalfOpen WebhookEndpointCircuit = "half-open"
+	Open     WebhookEndpointCircuit = "open"
+)
+
+// Error defines model for Error.
+type Error struct {
+	Message string `json:"message"`
+}
+
+// HealthCheck defines model for HealthCheck.
+type HealthCheck struct {
+	DurationMs float64      `json:"duration_ms"`
+	Error      *string      `json:"error,omitempty"`
+	Name       string       `json:"name"`
+	Status     HealthStatus `json:"status"`
+}
+
+// HealthReport defines model for HealthReport.
+type HealthReport struct {
+	Checks []HealthCheck `json:"checks"`
+	Status HealthStatus  `json:"status"`
+}
+
+// HealthStatus defines model for HealthStatus.
+type HealthStatus string
+
+// Message defines model for Message.
+type Message struct {
+	Headers   map[string]string `json:"headers"`
+	Id        string            `json:"id"`
+	Payload   []byte            `json:"payload"`
+	Timestamp time.Time         `json:"timestamp"`
+	Topic     string            `json:"topic"`
+}
+
+// MessagePage defines model for MessagePage.
+type MessagePage struct {
+	HasMore    bool      `json:"has_more"`
+	Messages   []Message `json:"messages"`
+	NextOffset int       `json:"next_offset"`
+	Offset     int       `json:"offset"`
+	Total      int       `json:"total"`
+}
+
 // Pong defines model for Pong.
 type Pong struct {
 	Ping string `json:"ping"`
 }
 
+// PurgeResult defines model for PurgeResult.
+type PurgeResult struct {
+	Purged int    `json:"purged"`
+	Topic  string `json:"topic"`
+}
+
+// RedriveRequest defines model for RedriveRequest.
+type RedriveRequest struct {
+	// Max maximum number of messages to move, 0 moves all of them
+	Max *int `json:"max,omitempty"`
+
+	// Source topic to move messages from, defaults to the dead-letter topic `<topic>.dlq`
+	Source *string `json:"source,omitempty"`
+}
+
+// RedriveResult defines model for RedriveResult.
+type RedriveResult struct {
+	Moved  int    `json:"moved"`
+	Source string `json:"source"`
+	Topic  string `json:"topic"`
+}
+
+// TopicList defines model for TopicList.
+type TopicList struct {
+	Topics []TopicSize `json:"topics"`
+}
+
+// TopicSize defines model for TopicSize.
+type TopicSize struct {
+	Size  int    `json:"size"`
+	Topic string `json:"topic"`
+}
+
+// WebhookDelivery defines model for WebhookDelivery.
+type WebhookDelivery struct {
+	Attempts   int                   `json:"attempts"`
+	DurationMs float64               `json:"duration_ms"`
+	EndpointId string                `json:"endpoint_id"`
+	Error      *string               `json:"error,omitempty"`
+	Id         string                `json:"id"`
+	MessageId  string                `json:"message_id"`
+	StartedAt  time.Time             `json:"started_at"`
+	Status     WebhookDeliveryStatus `json:"status"`
+
+	// StatusCode HTTP status of the last attempt
+	StatusCode *int   `json:"status_code,omitempty"`
+	Topic      string `json:"topic"`
+}
+
+// WebhookDeliveryStatus defines model for WebhookDelivery.Status.
+type WebhookDeliveryStatus string
+
+// WebhookDeliveryList defines model for WebhookDeliveryList.
+type WebhookDeliveryList struct {
+	Deliveries []WebhookDelivery `json:"deliveries"`
+}
+
+// WebhookEndpoint defines model for WebhookEndpoint.
+type WebhookEndpoint struct {
+	Circuit   WebhookEndpointCircuit `json:"circuit"`
+	CreatedAt time.Time              `json:"created_at"`
+	Id        string                 `json:"id"`
+
+	// Secret only returned when the endpoint is registered
+	Secret *string  `json:"secret,omitempty"`
+	Topics []string `json:"topics"`
+	Url    string   `json:"url"`
+}
+
+// WebhookEndpointCircuit defines model for WebhookEndpoint.Circuit.
+type WebhookEndpointCircuit string
+
+// WebhookList defines model for WebhookList.
+type WebhookList struct {
+	Endpoints []WebhookEndpoint `json:"endpoints"`
+}
+
+// WebhookRequest defines model for WebhookRequest.
+type WebhookRequest struct {
+	// Secret key of the HMAC-SHA256 signatures, generated when empty
+	Secret *string  `json:"secret,omitempty"`
+	Topics []string `json:"topics"`
+	Url    string   `json:"url"`
+}
+
+// Topic defines model for Topic.
+type Topic = string
+
+// WebhookId defines model for WebhookId.
+type WebhookId = string
+
+// GetAdminTopicsTopicMessagesParams defines parameters for GetAdminTopicsTopicMessages.
+type GetAdminTopicsTopicMessagesParams struct {
+	Offset *int `form:"offset,omitempty" json:"offset,omitempty"`
+	Limit  *int `form:"limit,omitempty" json:"limit,omitempty"`
+}
+
+// GetAdminWebhooksIdDeliveriesParams defines parameters for GetAdminWebhooksIdDeliveries.
+type GetAdminWebhooksIdDeliveriesParams struct {
+	Limit *int `form:"limit,omitempty" json:"limit,omitempty"`
+}
+
+// PostAdminTopicsTopicRedriveJSONRequestBody defines body for PostAdminTopicsTopicRedrive for application/json ContentType.
+type PostAdminTopicsTopicRedriveJSONRequestBody = RedriveRequest
+
+// PostAdminWebhooksJSONRequestBody defines body for PostAdminWebhooks for application/json ContentType.
+type PostAdminWebhooksJSONRequestBody = WebhookRequest
+
 // ServerInterface represents all server handlers.
 type ServerInterface interface {
+	// List topics with their sizes
+	// (GET /admin/topics)
+	GetAdminTopics(ctx echo.Context) error
+	// Purge all pending messages of a topic
+	// (DELETE /admin/topics/{topic}/messages)
+	DeleteAdminTopicsTopicMessages(ctx echo.Context, topic Topic) error
+	// Peek at pending messages without consuming them
+	// (GET /admin/topics/{topic}/messages)
+	GetAdminTopicsTopicMessages(ctx echo.Context, topic Topic, params GetAdminTopicsTopicMessagesParams) error
+	// Move failed messages from a dead-letter topic back to the topic
+	// (POST /admin/topics/{topic}/redrive)
+	PostAdminTopicsTopicRedrive(ctx echo.Context, topic Topic) error
+	// Get the number of pending messages of a topic
+	// (GET /admin/topics/{topic}/size)
+	GetAdminTopicsTopicSize(ctx echo.Context, topic Topic) error
+	// List the webhook endpoints
+	// (GET /admin/webhooks)
+	GetAdminWebhooks(ctx echo.Context) error
+	// Register an HTTPS endpoint receiving the messages of topics
+	// (POST /admin/webhooks)
+	PostAdminWebhooks(ctx echo.Context) error
+	// Unregister a webhook endpoint
+	// (DELETE /admin/webhooks/{id})
+	DeleteAdminWebhooksId(ctx echo.Context, id WebhookId) error
+	// Get a webhook endpoint and the state of its circuit breaker
+	// (GET /admin/webhooks/{id})
+	GetAdminWebhooksId(ctx echo.Context, id WebhookId) error
+	// List the most recent deliveries to a webhook endpoint
+	// (GET /admin/webhooks/{id}/deliveries)
+	GetAdminWebhooksIdDeliveries(ctx echo.Context, id WebhookId, params GetAdminWebhooksIdDeliveriesParams) error
+	// Liveness probe, down when a component must be restarted
+	// (GET /health)
+	GetHealth(ctx echo.Context) error
 
 	// (GET /ping)
 	GetPing(ctx echo.Context) error
+	// Readiness probe, down when the server should not receive traffic
+	// (GET /ready)
+	GetReady(ctx echo.Context) error
 
 	// (GET /restricted)
 	GetRestricted(ctx echo.Context) error
@@ -27,6 +235,179 @@ type ServerInterfaceWrapper struct {
 	Handler ServerInterface
 }
 
+// GetAdminTopics converts echo context to params.
+func (w *ServerInterfaceWrapper) GetAdminTopics(ctx echo.Context) error {
+	var err error
+
+	// Invoke the callback with all the unmarshaled arguments
+	err = w.Handler.GetAdminTopics(ctx)
+	return err
+}
+
+// DeleteAdminTopicsTopicMessages converts echo context to params.
+func (w *ServerInterfaceWrapper) DeleteAdminTopicsTopicMessages(ctx echo.Context) error {
+	var err error
+	// ------------- Path parameter "topic" -------------
+	var topic Topic
+
+	err = runtime.BindStyledParameterWithOptions("simple", "topic", ctx.Param("topic"), &topic, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter topic: %s", err))
+	}
+
+	// Invoke the callback with all the unmarshaled arguments
+	err = w.Handler.DeleteAdminTopicsTopicMessages(ctx, topic)
+	return err
+}
+
+// GetAdminTopicsTopicMessages converts echo context to params.
+func (w *ServerInterfaceWrapper) GetAdminTopicsTopicMessages(ctx echo.Context) error {
+	var err error
+	// ------------- Path parameter "topic" -------------
+	var topic Topic
+
+	err = runtime.BindStyledParameterWithOptions("simple", "topic", ctx.Param("topic"), &topic, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter topic: %s", err))
+	}
+
+	// Parameter object where we will unmarshal all parameters from the context
+	var params GetAdminTopicsTopicMessagesParams
+	// ------------- Optional query parameter "offset" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "offset", ctx.QueryParams(), &params.Offset)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter offset: %s", err))
+	}
+
+	// ------------- Optional query parameter "limit" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "limit", ctx.QueryParams(), &params.Limit)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter limit: %s", err))
+	}
+
+	// Invoke the callback with all the unmarshaled arguments
+	err = w.Handler.GetAdminTopicsTopicMessages(ctx, topic, params)
+	return err
+}
+
+// PostAdminTopicsTopicRedrive converts echo context to params.
+func (w *ServerInterfaceWrapper) PostAdminTopicsTopicRedrive(ctx echo.Context) error {
+	var err error
+	// ------------- Path parameter "topic" -------------
+	var topic Topic
+
+	err = runtime.BindStyledParameterWithOptions("simple", "topic", ctx.Param("topic"), &topic, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter topic: %s", err))
+	}
+
+	// Invoke the callback with all the unmarshaled arguments
+	err = w.Handler.PostAdminTopicsTopicRedrive(ctx, topic)
+	return err
+}
+
+// GetAdminTopicsTopicSize converts echo context to params.
+func (w *ServerInterfaceWrapper) GetAdminTopicsTopicSize(ctx echo.Context) error {
+	var err error
+	// ------------- Path parameter "topic" -------------
+	var topic Topic
+
+	err = runtime.BindStyledParameterWithOptions("simple", "topic", ctx.Param("topic"), &topic, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter topic: %s", err))
+	}
+
+	// Invoke the callback with all the unmarshaled arguments
+	err = w.Handler.GetAdminTopicsTopicSize(ctx, topic)
+	return err
+}
+
+// GetAdminWebhooks converts echo context to params.
+func (w *ServerInterfaceWrapper) GetAdminWebhooks(ctx echo.Context) error {
+	var err error
+
+	// Invoke the callback with all the unmarshaled arguments
+	err = w.Handler.GetAdminWebhooks(ctx)
+	return err
+}
+
+// PostAdminWebhooks converts echo context to params.
+func (w *ServerInterfaceWrapper) PostAdminWebhooks(ctx echo.Context) error {
+	var err error
+
+	// Invoke the callback with all the unmarshaled arguments
+	err = w.Handler.PostAdminWebhooks(ctx)
+	return err
+}
+
+// DeleteAdminWebhooksId converts echo context to params.
+func (w *ServerInterfaceWrapper) DeleteAdminWebhooksId(ctx echo.Context) error {
+	var err error
+	// ------------- Path parameter "id" -------------
+	var id WebhookId
+
+	err = runtime.BindStyledParameterWithOptions("simple", "id", ctx.Param("id"), &id, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter id: %s", err))
+	}
+
+	// Invoke the callback with all the unmarshaled arguments
+	err = w.Handler.DeleteAdminWebhooksId(ctx, id)
+	return err
+}
+
+// GetAdminWebhooksId converts echo context to params.
+func (w *ServerInterfaceWrapper) GetAdminWebhooksId(ctx echo.Context) error {
+	var err error
+	// ------------- Path parameter "id" -------------
+	var id WebhookId
+
+	err = runtime.BindStyledParameterWithOptions("simple", "id", ctx.Param("id"), &id, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter id: %s", err))
+	}
+
+	// Invoke the callback with all the unmarshaled arguments
+	err = w.Handler.GetAdminWebhooksId(ctx, id)
+	return err
+}
+
+// GetAdminWebhooksIdDeliveries converts echo context to params.
+func (w *ServerInterfaceWrapper) GetAdminWebhooksIdDeliveries(ctx echo.Context) error {
+	var err error
+	// ------------- Path parameter "id" -------------
+	var id WebhookId
+
+	err = runtime.BindStyledParameterWithOptions("simple", "id", ctx.Param("id"), &id, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter id: %s", err))
+	}
+
+	// Parameter object where we will unmarshal all parameters from the context
+	var params GetAdminWebhooksIdDeliveriesParams
+	// ------------- Optional query parameter "limit" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "limit", ctx.QueryParams(), &params.Limit)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter limit: %s", err))
+	}
+
+	// Invoke the callback with all the unmarshaled arguments
+	err = w.Handler.GetAdminWebhooksIdDeliveries(ctx, id, params)
+	return err
+}
+
+// GetHealth converts echo context to params.
+func (w *ServerInterfaceWrapper) GetHealth(ctx echo.Context) error {
+	var err error
+
+	// Invoke the callback with all the unmarshaled arguments
+	err = w.Handler.GetHealth(ctx)
+	return err
+}
+
 // GetPing converts echo context to params.
 func (w *ServerInterfaceWrapper) GetPing(ctx echo.Context) error {
 	var err error
@@ -36,6 +417,15 @@ func (w *ServerInterfaceWrapper) GetPing(ctx echo.Context) error {
 	return err
 }
 
+// GetReady converts echo context to params.
+func (w *ServerInterfaceWrapper) GetReady(ctx echo.Context) error {
+	var err error
+
+	// Invoke the callback with all the unmarshaled arguments
+	err = w.Handler.GetReady(ctx)
+	return err
+}
+
 // GetRestricted converts echo context to params.
 func (w *ServerInterfaceWrapper) GetRestricted(ctx echo.Context) error {
 	var err error
@@ -73,7 +463,19 @@ func RegisterHandlersWithBaseURL(router EchoRouter, si ServerInterface, baseURL
 		Handler: si,
 	}
 
+	router.GET(baseURL+"/admin/topics", wrapper.GetAdminTopics)
+	router.DELETE(baseURL+"/admin/topics/:topic/messages", wrapper.DeleteAdminTopicsTopicMessages)
+	router.GET(baseURL+"/admin/topics/:topic/messages", wrapper.GetAdminTopicsTopicMessages)
+	router.POST(baseURL+"/admin/topics/:topic/redrive", wrapper.PostAdminTopicsTopicRedrive)
+	router.GET(baseURL+"/admin/topics/:topic/size", wrapper.GetAdminTopicsTopicSize)
+	router.GET(baseURL+"/admin/webhooks", wrapper.GetAdminWebhooks)
+	router.POST(baseURL+"/admin/webhooks", wrapper.PostAdminWebhooks)
+	router.DELETE(baseURL+"/admin/webhooks/:id", wrapper.DeleteAdminWebhooksId)
+	router.GET(baseURL+"/admin/webhooks/:id", wrapper.GetAdminWebhooksId)
+	router.GET(baseURL+"/admin/webhooks/:id/deliveries", wrapper.GetAdminWebhooksIdDeliveries)
+	router.GET(baseURL+"/health", wrapper.GetHealth)
 	router.GET(baseURL+"/ping", wrapper.GetPing)
+	router.GET(baseURL+"/ready", wrapper.GetReady)
 	router.GET(baseURL+"/restricted", wrapper.GetRestricted)
 
 }
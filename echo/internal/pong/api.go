@@ -1,8 +1,13 @@
 package pong
 
 import (
+	"echo/pkg/events"
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/syl/Go/pkg/examples/queue"
+	"github.com/syl/Go/pkg/examples/queue/health"
+	"github.com/syl/Go/pkg/examples/queue/webhook"
 	"net/http"
+	"time"
 
 	"github.com/labstack/echo/v4"
 )
@@ -10,10 +15,59 @@ import (
 // ensure that we've conformed to the `ServerInterface` with a compile-time check
 var _ ServerInterface = (*Server)(nil)
 
-type Server struct{}
+type Server struct {
+	queue     queue.Queue
+	health    *health.Registry
+	events    *events.Hub
+	heartbeat time.Duration
+	webhooks  *webhook.Dispatcher
+}
+
+// ServerOption configures a Server created with NewServer
+type ServerOption func(*Server)
+
+// WithHealthRegistry serves the checks of registry at /health and /ready instead of a registry holding only the queue
+// The queue is added to registry as the "queue" readiness check when it implements queue.HealthChecker.
+func WithHealthRegistry(registry *health.Registry) ServerOption {
+	return func(s *Server) {
+		s.health = registry
+	}
+}
+
+// WithEventHub streams the messages of hub, the live streams answer 501 without a hub
+// The hub sees the messages that producers enqueue through an events.Tap of the administered queue.
+func WithEventHub(hub *events.Hub) ServerOption {
+	return func(s *Server) {
+		s.events = hub
+	}
+}
 
-func NewServer() Server {
-	return Server{}
+// WithHeartbeat sets how often idle streams send a keep-alive
+func WithHeartbeat(interval time.Duration) ServerOption {
+	return func(s *Server) {
+		s.heartbeat = interval
+	}
+}
+
+// WithWebhookDispatcher manages the endpoints of dispatcher, the webhook admin API answers 501 without a dispatcher
+// The dispatcher consumes the topics of its endpoints, give it a consumer of topics dedicated to webhooks.
+func WithWebhookDispatcher(dispatcher *webhook.Dispatcher) ServerOption {
+	return func(s *Server) {
+		s.webhooks = dispatcher
+	}
+}
+
+// NewServer creates a server administering the given queue
+func NewServer(q queue.Queue, opts ...ServerOption) Server {
+	s := Server{queue: q, health: health.NewRegistry(), heartbeat: DefaultHeartbeat}
+	for _, opt := range opts {
+		opt(&s)
+	}
+	if checker, ok := q.(queue.HealthChecker); ok {
+		// A queue draining on shutdown stops taking traffic, it does not need a restart
+		s.health.Register("queue", checker)
+	}
+	return s
 }
 
 // GetPing (GET /ping)
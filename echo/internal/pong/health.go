@@ -0,0 +1,43 @@
+package pong
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/syl/Go/pkg/examples/queue/health"
+)
+
+// GetHealth (GET /health)
+func (s Server) GetHealth(ctx echo.Context) error {
+	return healthResponse(ctx, s.health.Liveness(ctx.Request().Context()))
+}
+
+// GetReady (GET /ready)
+func (s Server) GetReady(ctx echo.Context) error {
+	return healthResponse(ctx, s.health.Readiness(ctx.Request().Context()))
+}
+
+// healthResponse answers 200 when every check is up and 503 otherwise
+func healthResponse(ctx echo.Context, report health.Report) error {
+	resp := HealthReport{
+		Status: HealthStatus(report.Status),
+		Checks: make([]HealthCheck, 0, len(report.Checks)),
+	}
+	for _, result := range report.Checks {
+		check := HealthCheck{
+			Name:       result.Name,
+			Status:     HealthStatus(result.Status),
+			DurationMs: float64(result.Duration.Microseconds()) / 1000,
+		}
+		if result.Error != "" {
+			check.Error = &result.Error
+		}
+		resp.Checks = append(resp.Checks, check)
+	}
+
+	status := http.StatusOK
+	if !report.Up() {
+		status = http.StatusServiceUnavailable
+	}
+	return ctx.JSON(status, resp)
+}
@@ -0,0 +1,74 @@
+package pong
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/syl/Go/pkg/examples/queue/health"
+	"github.com/syl/Go/pkg/examples/queue/inmemory"
+)
+
+// getHealth calls a probe endpoint without a token and decodes the report
+func getHealth(t *testing.T, handler http.Handler, path string) (int, HealthReport) {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+
+	var report HealthReport
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &report), rec.Body.String())
+	return rec.Code, report
+}
+
+func TestHealth(t *testing.T) {
+	t.Run("QueueIsReadinessCheck", func(t *testing.T) {
+		q := inmemory.NewInMemoryQueue()
+		e := PongEchoServer(q)
+
+		code, report := getHealth(t, e, "/ready")
+		assert.Equal(t, http.StatusOK, code, "/ready should not require a token")
+		assert.Equal(t, Up, report.Status)
+		require.Len(t, report.Checks, 1)
+		assert.Equal(t, "queue", report.Checks[0].Name)
+
+		require.NoError(t, q.Close())
+		code, report = getHealth(t, e, "/ready")
+		assert.Equal(t, http.StatusServiceUnavailable, code)
+		assert.Equal(t, Down, report.Status)
+		require.NotNil(t, report.Checks[0].Error)
+		assert.Equal(t, "queue is closed", *report.Checks[0].Error)
+
+		code, report = getHealth(t, e, "/health")
+		assert.Equal(t, http.StatusOK, code, "A closed or draining queue should not fail the liveness probe")
+		assert.Equal(t, Up, report.Status)
+		assert.Empty(t, report.Checks)
+	})
+
+	t.Run("ReadinessCheck", func(t *testing.T) {
+		q := inmemory.NewInMemoryQueue()
+		defer q.Close()
+
+		registry := health.NewRegistry()
+		registry.Register("database", health.CheckFunc(func(ctx context.Context) error {
+			return errors.New("database unreachable")
+		}))
+		e := PongEchoServer(q, WithHealthRegistry(registry))
+
+		code, report := getHealth(t, e, "/health")
+		assert.Equal(t, http.StatusOK, code, "Readiness checks should not fail the liveness probe")
+		assert.Empty(t, report.Checks)
+
+		code, report = getHealth(t, e, "/ready")
+		assert.Equal(t, http.StatusServiceUnavailable, code)
+		require.Len(t, report.Checks, 2)
+		assert.Equal(t, "database", report.Checks[0].Name)
+		assert.Equal(t, Down, report.Checks[0].Status)
+		assert.Equal(t, Up, report.Checks[1].Status)
+	})
+}
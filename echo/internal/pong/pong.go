@@ -4,16 +4,18 @@ import (
 	"echo/internal/middleware"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/gommon/log"
+	"github.com/syl/Go/pkg/examples/queue"
 )
 
-func PongEchoServer() *echo.Echo {
-	server := NewServer()
+func PongEchoServer(q queue.Queue, opts ...ServerOption) *echo.Echo {
+	server := NewServer(q, opts...)
 
 	e := echo.New()
 	e.Use(middleware.Logger)
 	e.Use(middleware.JWT)
 	e.Logger.SetLevel(log.DEBUG)
 	RegisterHandlers(e, server)
+	registerStreams(e, server)
 	//r := e.Group("/restricted")
 	//{
 	//	r.Use(middleware.JWT)
@@ -0,0 +1,170 @@
+package pong
+
+import (
+	"echo/internal/middleware"
+	"echo/pkg/events"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/syl/Go/pkg/examples/queue"
+	"github.com/syl/Go/pkg/examples/queue/filter"
+	"golang.org/x/net/websocket"
+)
+
+const (
+	// DefaultHeartbeat is how often an idle stream sends a keep-alive so that proxies do not close it
+	DefaultHeartbeat = 15 * time.Second
+	// sseRetry is the reconnection delay, in milliseconds, advertised to EventSource clients
+	sseRetry = 3000
+)
+
+// StreamFrame is a WebSocket frame, Type is "message" or "heartbeat"
+type StreamFrame struct {
+	Type    string   `json:"type"`
+	ID      uint64   `json:"id,omitempty"`
+	Message *Message `json:"message,omitempty"`
+}
+
+// registerStreams adds the live streams, they are not part of the OpenAPI spec
+// They accept the token in the access_token query parameter as well, unlike the other routes.
+func registerStreams(e *echo.Echo, s Server) {
+	e.GET("/stream/:topic/events", s.StreamEvents, middleware.StreamJWT)
+	e.GET("/stream/:topic/ws", s.StreamWebSocket, middleware.StreamJWT)
+}
+
+// StreamEvents (GET /stream/{topic}/events) streams the messages of a topic as server-sent events
+func (s Server) StreamEvents(ctx echo.Context) error {
+	sub, err := s.subscribe(ctx)
+	if err != nil {
+		return err
+	}
+	defer sub.Close()
+
+	w := ctx.Response()
+	w.Header().Set(echo.HeaderContentType, "text/event-stream")
+	w.Header().Set(echo.HeaderCacheControl, "no-cache")
+	w.Header().Set(echo.HeaderConnection, "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "retry: %d\n\n", sseRetry)
+	w.Flush()
+
+	heartbeat := time.NewTicker(s.heartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-ctx.Request().Context().Done():
+			return nil
+		case <-sub.Done():
+			return nil
+		case event := <-sub.Events():
+			data, err := json.Marshal(apiMessage(event.Message))
+			if err != nil {
+				return err
+			}
+			if _, err := fmt.Fprintf(w, "id: %d\nevent: message\ndata: %s\n\n", event.ID, data); err != nil {
+				return nil
+			}
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return nil
+			}
+		}
+		w.Flush()
+	}
+}
+
+// StreamWebSocket (GET /stream/{topic}/ws) streams the messages of a topic as JSON WebSocket frames
+func (s Server) StreamWebSocket(ctx echo.Context) error {
+	sub, err := s.subscribe(ctx)
+	if err != nil {
+		return err
+	}
+	defer sub.Close()
+
+	websocket.Handler(func(ws *websocket.Conn) {
+		defer ws.Close()
+
+		// The client sends nothing, reading only detects when it goes away
+		gone := make(chan struct{})
+		go func() {
+			defer close(gone)
+			var discard string
+			for websocket.Message.Receive(ws, &discard) == nil {
+			}
+		}()
+
+		heartbeat := time.NewTicker(s.heartbeat)
+		defer heartbeat.Stop()
+
+		for {
+			var frame StreamFrame
+			select {
+			case <-gone:
+				return
+			case <-sub.Done():
+				return
+			case event := <-sub.Events():
+				message := apiMessage(event.Message)
+				frame = StreamFrame{Type: "message", ID: event.ID, Message: &message}
+			case <-heartbeat.C:
+				frame = StreamFrame{Type: "heartbeat"}
+			}
+			if err := websocket.JSON.Send(ws, frame); err != nil {
+				return
+			}
+		}
+	}).ServeHTTP(ctx.Response(), ctx.Request())
+	return nil
+}
+
+// subscribe reads the topic, the filter and the last event ID of a stream request and subscribes to the hub
+// Browsers send the last event ID in the Last-Event-ID header when an EventSource reconnects,
+// WebSocket clients pass it as the last_event_id query parameter.
+func (s Server) subscribe(ctx echo.Context) (*events.Subscription, error) {
+	if s.events == nil {
+		return nil, echo.NewHTTPError(http.StatusNotImplemented, "live streams are not enabled")
+	}
+	topic := ctx.Param("topic")
+
+	var f *filter.Filter
+	if expression := ctx.QueryParam("filter"); expression != "" {
+		var err error
+		if f, err = filter.Parse(expression); err != nil {
+			return nil, echo.NewHTTPError(http.StatusBadRequest, "invalid filter: "+err.Error())
+		}
+	}
+
+	lastEventID := ctx.Request().Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = ctx.QueryParam("last_event_id")
+	}
+	var last uint64
+	if lastEventID != "" {
+		var err error
+		if last, err = strconv.ParseUint(lastEventID, 10, 64); err != nil {
+			return nil, echo.NewHTTPError(http.StatusBadRequest, "invalid last event ID: "+err.Error())
+		}
+	}
+
+	sub, err := s.events.Subscribe(topic, last, f)
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusServiceUnavailable, err.Error())
+	}
+	return sub, nil
+}
+
+// apiMessage converts a queue message to its API representation
+func apiMessage(m *queue.Message) Message {
+	return Message{
+		Id:        m.ID,
+		Topic:     m.Topic,
+		Payload:   m.Payload,
+		Headers:   m.Headers,
+		Timestamp: m.Timestamp,
+	}
+}
@@ -0,0 +1,255 @@
+package pong
+
+import (
+	"bufio"
+	"context"
+	"echo/internal/middleware"
+	"echo/pkg/events"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/syl/Go/pkg/examples/queue"
+	"github.com/syl/Go/pkg/examples/queue/inmemory"
+	"golang.org/x/net/websocket"
+)
+
+// StreamTestFixture serves the streams of a hub tapping an in-memory queue, with a mocked JWKS
+type StreamTestFixture struct {
+	T     *testing.T
+	Queue *inmemory.InMemoryQueue
+	Tap   *events.Tap
+	URL   string
+}
+
+func NewStreamTestFixture(t *testing.T) *StreamTestFixture {
+	t.Helper()
+
+	mockJWKS := startMockJWKS()
+	middleware.JwksURL = mockJWKS.URL
+
+	q := inmemory.NewInMemoryQueue()
+	hub := events.NewHub()
+	server := httptest.NewServer(PongEchoServer(q, WithEventHub(hub), WithHeartbeat(20*time.Millisecond)))
+
+	t.Cleanup(func() {
+		server.Close()
+		hub.Close()
+		q.Close()
+		mockJWKS.Close()
+	})
+
+	return &StreamTestFixture{T: t, Queue: q, Tap: events.NewTap(q, hub), URL: server.URL}
+}
+
+// Enqueue adds a message with the given region header to a topic, through the tap as a producer would
+func (f *StreamTestFixture) Enqueue(topic, id, region string) {
+	f.T.Helper()
+
+	err := f.Tap.Enqueue(context.Background(), topic, &queue.Message{
+		ID:        id,
+		Topic:     topic,
+		Payload:   []byte(id),
+		Headers:   map[string]string{"region": region},
+		Timestamp: time.Now(),
+	})
+	require.NoError(f.T, err, "Should enqueue %s", id)
+}
+
+// OpenEvents opens an event stream authenticated with the access_token query parameter
+func (f *StreamTestFixture) OpenEvents(path string, query url.Values, header http.Header) (*http.Response, *bufio.Reader) {
+	f.T.Helper()
+
+	query.Set("access_token", testToken)
+	req, err := http.NewRequest(http.MethodGet, f.URL+path+"?"+query.Encode(), nil)
+	require.NoError(f.T, err)
+	for key, values := range header {
+		req.Header[key] = values
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(f.T, err)
+	f.T.Cleanup(func() { resp.Body.Close() })
+	return resp, bufio.NewReader(resp.Body)
+}
+
+// sseEvent is a server-sent event, Comment holds the comment lines such as heartbeats
+type sseEvent struct {
+	ID      string
+	Event   string
+	Data    string
+	Comment string
+}
+
+// ReadEvent reads the next event or comment block of a stream
+func (f *StreamTestFixture) ReadEvent(r *bufio.Reader) sseEvent {
+	f.T.Helper()
+
+	var event sseEvent
+	for {
+		line, err := r.ReadString('\n')
+		require.NoError(f.T, err, "Stream should stay open")
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			return event
+		}
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		switch field {
+		case "":
+			event.Comment = value
+		case "id":
+			event.ID = value
+		case "event":
+			event.Event = value
+		case "data":
+			event.Data = value
+		}
+	}
+}
+
+// NextMessage skips heartbeats until the next message event
+func (f *StreamTestFixture) NextMessage(r *bufio.Reader) sseEvent {
+	f.T.Helper()
+
+	for {
+		if event := f.ReadEvent(r); event.Event == "message" {
+			return event
+		}
+	}
+}
+
+func TestStreamEvents(t *testing.T) {
+	t.Run("RequiresJWT", func(t *testing.T) {
+		fixture := NewStreamTestFixture(t)
+
+		resp, err := http.Get(fixture.URL + "/stream/orders/events")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	})
+
+	t.Run("QueryTokenOnlyOnStreams", func(t *testing.T) {
+		fixture := NewStreamTestFixture(t)
+
+		resp, err := http.Get(fixture.URL + "/admin/topics?access_token=" + testToken)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode, "Other routes should only read the Authorization header")
+
+		req, err := http.NewRequest(http.MethodGet, fixture.URL+"/admin/topics", nil)
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer "+testToken)
+		resp, err = http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		resp, _ = fixture.OpenEvents("/stream/orders/events", url.Values{}, nil)
+		assert.Equal(t, http.StatusOK, resp.StatusCode, "Streams should accept the access_token query parameter")
+	})
+
+	t.Run("FilterAndHeartbeat", func(t *testing.T) {
+		fixture := NewStreamTestFixture(t)
+		resp, stream := fixture.OpenEvents("/stream/orders/events", url.Values{"filter": {"region = 'us'"}}, nil)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+		fixture.Enqueue("orders", "o1", "eu")
+		fixture.Enqueue("orders", "o2", "us")
+
+		event := fixture.NextMessage(stream)
+		assert.Equal(t, "2", event.ID, "Should skip events rejected by the filter")
+		var message Message
+		require.NoError(t, json.Unmarshal([]byte(event.Data), &message))
+		assert.Equal(t, "o2", message.Id)
+		assert.Equal(t, "us", message.Headers["region"])
+
+		assert.Equal(t, "heartbeat", fixture.ReadEvent(stream).Comment, "Idle streams should send heartbeats")
+	})
+
+	t.Run("ConsumersKeepMessages", func(t *testing.T) {
+		fixture := NewStreamTestFixture(t)
+		_, stream := fixture.OpenEvents("/stream/orders/events", url.Values{}, nil)
+		fixture.Enqueue("orders", "o1", "eu")
+		assert.Equal(t, "1", fixture.NextMessage(stream).ID)
+
+		size, err := fixture.Queue.Size(context.Background(), "orders")
+		require.NoError(t, err)
+		assert.Equal(t, 1, size, "Streaming should leave the message to the consumers of the topic")
+	})
+
+	t.Run("DisabledWithoutHub", func(t *testing.T) {
+		fixture := NewStreamTestFixture(t)
+		q := inmemory.NewInMemoryQueue()
+		defer q.Close()
+		server := httptest.NewServer(PongEchoServer(q))
+		defer server.Close()
+		fixture.URL = server.URL
+
+		resp, _ := fixture.OpenEvents("/stream/orders/events", url.Values{}, nil)
+		assert.Equal(t, http.StatusNotImplemented, resp.StatusCode, "Should not consume the queue without a hub")
+		size, err := q.Size(context.Background(), "orders")
+		require.NoError(t, err)
+		assert.Zero(t, size)
+	})
+
+	t.Run("ReconnectWithLastEventID", func(t *testing.T) {
+		fixture := NewStreamTestFixture(t)
+		resp, stream := fixture.OpenEvents("/stream/orders/events", url.Values{}, nil)
+		fixture.Enqueue("orders", "o1", "eu")
+		fixture.Enqueue("orders", "o2", "eu")
+		fixture.Enqueue("orders", "o3", "eu")
+		assert.Equal(t, "1", fixture.NextMessage(stream).ID)
+		resp.Body.Close()
+
+		_, stream = fixture.OpenEvents("/stream/orders/events", url.Values{}, http.Header{"Last-Event-Id": {"1"}})
+		assert.Equal(t, "2", fixture.NextMessage(stream).ID, "Should replay the events after the last event ID")
+		assert.Equal(t, "3", fixture.NextMessage(stream).ID)
+	})
+
+	t.Run("InvalidFilter", func(t *testing.T) {
+		fixture := NewStreamTestFixture(t)
+		resp, _ := fixture.OpenEvents("/stream/orders/events", url.Values{"filter": {"region ="}}, nil)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+}
+
+func TestStreamWebSocket(t *testing.T) {
+	fixture := NewStreamTestFixture(t)
+	query := url.Values{"access_token": {testToken}, "filter": {"region = 'eu'"}}
+	target := "ws" + strings.TrimPrefix(fixture.URL, "http") + "/stream/orders/ws?" + query.Encode()
+
+	ws, err := websocket.Dial(target, "", fixture.URL)
+	require.NoError(t, err)
+	defer ws.Close()
+
+	var frame StreamFrame
+	require.NoError(t, ws.SetReadDeadline(time.Now().Add(5*time.Second)))
+	require.NoError(t, websocket.JSON.Receive(ws, &frame))
+	assert.Equal(t, "heartbeat", frame.Type, "Idle streams should send heartbeats")
+
+	fixture.Enqueue("orders", "o1", "us")
+	fixture.Enqueue("orders", "o2", "eu")
+
+	for {
+		var frame StreamFrame
+		require.NoError(t, ws.SetReadDeadline(time.Now().Add(5*time.Second)))
+		require.NoError(t, websocket.JSON.Receive(ws, &frame))
+		if frame.Type == "heartbeat" {
+			continue
+		}
+
+		assert.Equal(t, "message", frame.Type)
+		assert.Equal(t, uint64(2), frame.ID, "Should skip events rejected by the filter")
+		require.NotNil(t, frame.Message)
+		assert.Equal(t, "o2", frame.Message.Id)
+		break
+	}
+}
@@ -0,0 +1,139 @@
+package pong
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/syl/Go/pkg/examples/queue/webhook"
+)
+
+const defaultDeliveryLimit = 50
+
+// errWebhooksDisabled is answered with 501 by a server created without a webhook dispatcher
+var errWebhooksDisabled = errors.New("webhooks are not enabled")
+
+// GetAdminWebhooks (GET /admin/webhooks)
+func (s Server) GetAdminWebhooks(ctx echo.Context) error {
+	if s.webhooks == nil {
+		return adminError(ctx, http.StatusNotImplemented, errWebhooksDisabled)
+	}
+	endpoints := s.webhooks.Endpoints()
+
+	resp := WebhookList{Endpoints: make([]WebhookEndpoint, 0, len(endpoints))}
+	for _, e := range endpoints {
+		resp.Endpoints = append(resp.Endpoints, s.webhookEndpoint(e))
+	}
+	return ctx.JSON(http.StatusOK, resp)
+}
+
+// PostAdminWebhooks (POST /admin/webhooks)
+func (s Server) PostAdminWebhooks(ctx echo.Context) error {
+	if s.webhooks == nil {
+		return adminError(ctx, http.StatusNotImplemented, errWebhooksDisabled)
+	}
+	var req WebhookRequest
+	if err := ctx.Bind(&req); err != nil {
+		return adminError(ctx, http.StatusBadRequest, err)
+	}
+
+	e := webhook.Endpoint{URL: req.Url, Topics: req.Topics}
+	if req.Secret != nil {
+		e.Secret = *req.Secret
+	}
+
+	e, err := s.webhooks.Register(ctx.Request().Context(), e)
+	if err != nil {
+		return adminError(ctx, http.StatusBadRequest, err)
+	}
+
+	ctx.Logger().Infof("Registered webhook %s for %v", e.ID, e.Topics)
+	resp := s.webhookEndpoint(e)
+	resp.Secret = &e.Secret
+	return ctx.JSON(http.StatusCreated, resp)
+}
+
+// GetAdminWebhooksId (GET /admin/webhooks/{id})
+func (s Server) GetAdminWebhooksId(ctx echo.Context, id WebhookId) error {
+	if s.webhooks == nil {
+		return adminError(ctx, http.StatusNotImplemented, errWebhooksDisabled)
+	}
+	e, err := s.webhooks.Endpoint(id)
+	if err != nil {
+		return webhookError(ctx, err)
+	}
+	return ctx.JSON(http.StatusOK, s.webhookEndpoint(e))
+}
+
+// DeleteAdminWebhooksId (DELETE /admin/webhooks/{id})
+func (s Server) DeleteAdminWebhooksId(ctx echo.Context, id WebhookId) error {
+	if s.webhooks == nil {
+		return adminError(ctx, http.StatusNotImplemented, errWebhooksDisabled)
+	}
+	if err := s.webhooks.Unregister(ctx.Request().Context(), id); err != nil {
+		return webhookError(ctx, err)
+	}
+
+	ctx.Logger().Infof("Unregistered webhook %s", id)
+	return ctx.NoContent(http.StatusNoContent)
+}
+
+// GetAdminWebhooksIdDeliveries (GET /admin/webhooks/{id}/deliveries)
+func (s Server) GetAdminWebhooksIdDeliveries(ctx echo.Context, id WebhookId, params GetAdminWebhooksIdDeliveriesParams) error {
+	if s.webhooks == nil {
+		return adminError(ctx, http.StatusNotImplemented, errWebhooksDisabled)
+	}
+	if _, err := s.webhooks.Endpoint(id); err != nil {
+		return webhookError(ctx, err)
+	}
+
+	limit := defaultDeliveryLimit
+	if params.Limit != nil {
+		limit = *params.Limit
+	}
+
+	deliveries := s.webhooks.Deliveries(id, limit)
+	resp := WebhookDeliveryList{Deliveries: make([]WebhookDelivery, 0, len(deliveries))}
+	for _, d := range deliveries {
+		delivery := WebhookDelivery{
+			Id:         d.ID,
+			EndpointId: d.EndpointID,
+			MessageId:  d.MessageID,
+			Topic:      d.Topic,
+			Status:     WebhookDeliveryStatus(d.Status),
+			Attempts:   d.Attempts,
+			StartedAt:  d.StartedAt,
+			DurationMs: float64(d.Duration.Microseconds()) / 1000,
+		}
+		if d.StatusCode != 0 {
+			delivery.StatusCode = &d.StatusCode
+		}
+		if d.Error != "" {
+			delivery.Error = &d.Error
+		}
+		resp.Deliveries = append(resp.Deliveries, delivery)
+	}
+	return ctx.JSON(http.StatusOK, resp)
+}
+
+// webhookEndpoint converts an endpoint to its API representation, without its secret
+func (s Server) webhookEndpoint(e webhook.Endpoint) WebhookEndpoint {
+	resp := WebhookEndpoint{
+		Id:        e.ID,
+		Url:       e.URL,
+		Topics:    e.Topics,
+		Circuit:   Closed,
+		CreatedAt: e.CreatedAt,
+	}
+	if state, err := s.webhooks.BreakerState(e.ID); err == nil {
+		resp.Circuit = WebhookEndpointCircuit(state.String())
+	}
+	return resp
+}
+
+func webhookError(ctx echo.Context, err error) error {
+	if errors.Is(err, webhook.ErrEndpointNotFound) {
+		return adminError(ctx, http.StatusNotFound, err)
+	}
+	return adminError(ctx, http.StatusInternalServerError, err)
+}
@@ -0,0 +1,105 @@
+package pong
+
+import (
+	"context"
+	"echo/internal/middleware"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/syl/Go/pkg/examples/queue/broker"
+	"github.com/syl/Go/pkg/examples/queue/inmemory"
+	"github.com/syl/Go/pkg/examples/queue/webhook"
+)
+
+// NewWebhookTestFixture serves the admin API with a dispatcher trusting the TLS receiver
+func NewWebhookTestFixture(t *testing.T, receiver *httptest.Server) *AdminTestFixture {
+	t.Helper()
+
+	mockJWKS := startMockJWKS()
+	middleware.JwksURL = mockJWKS.URL
+
+	q := inmemory.NewInMemoryQueue()
+	consumer := broker.NewQueueConsumer(q)
+	dispatcher := webhook.NewDispatcher(consumer, webhook.WithHTTPClient(receiver.Client()))
+
+	t.Cleanup(func() {
+		dispatcher.Close()
+		consumer.Close()
+		q.Close()
+		mockJWKS.Close()
+	})
+
+	return &AdminTestFixture{
+		Queue:  q,
+		Server: PongEchoServer(q, WithWebhookDispatcher(dispatcher)),
+		T:      t,
+	}
+}
+
+func TestWebhooks(t *testing.T) {
+	receiver := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer receiver.Close()
+
+	t.Run("Lifecycle", func(t *testing.T) {
+		fixture := NewWebhookTestFixture(t, receiver)
+
+		var created WebhookEndpoint
+		code := fixture.Do(http.MethodPost, "/admin/webhooks", fmt.Sprintf(`{"url":%q,"topics":["orders"]}`, receiver.URL), &created)
+		require.Equal(t, http.StatusCreated, code)
+		require.NotNil(t, created.Secret, "Should return the secret on creation")
+		assert.NotEmpty(t, *created.Secret)
+
+		var list WebhookList
+		assert.Equal(t, http.StatusOK, fixture.Do(http.MethodGet, "/admin/webhooks", "", &list))
+		require.Len(t, list.Endpoints, 1)
+		assert.Nil(t, list.Endpoints[0].Secret, "Should not expose the secret afterwards")
+		assert.Equal(t, Closed, list.Endpoints[0].Circuit)
+
+		fixture.Enqueue("orders", "o1")
+		var deliveries WebhookDeliveryList
+		require.Eventually(t, func() bool {
+			fixture.Do(http.MethodGet, "/admin/webhooks/"+created.Id+"/deliveries", "", &deliveries)
+			return len(deliveries.Deliveries) == 1
+		}, 5*time.Second, 50*time.Millisecond, "Should deliver the message")
+		assert.Equal(t, Succeeded, deliveries.Deliveries[0].Status)
+		assert.Equal(t, "orders-o1", deliveries.Deliveries[0].MessageId)
+		require.NotNil(t, deliveries.Deliveries[0].StatusCode)
+		assert.Equal(t, http.StatusNoContent, *deliveries.Deliveries[0].StatusCode)
+
+		assert.Equal(t, http.StatusNoContent, fixture.Do(http.MethodDelete, "/admin/webhooks/"+created.Id, "", nil))
+		var resp Error
+		assert.Equal(t, http.StatusNotFound, fixture.Do(http.MethodGet, "/admin/webhooks/"+created.Id, "", &resp))
+		assert.Equal(t, http.StatusNotFound, fixture.Do(http.MethodDelete, "/admin/webhooks/"+created.Id, "", &resp))
+	})
+
+	t.Run("RequiresHTTPS", func(t *testing.T) {
+		fixture := NewWebhookTestFixture(t, receiver)
+
+		var resp Error
+		code := fixture.Do(http.MethodPost, "/admin/webhooks", `{"url":"http://partner.example.com","topics":["orders"]}`, &resp)
+		assert.Equal(t, http.StatusBadRequest, code)
+		assert.NotEmpty(t, resp.Message)
+	})
+
+	t.Run("DisabledWithoutDispatcher", func(t *testing.T) {
+		fixture := NewAdminTestFixture(t)
+		fixture.Enqueue("orders", "o1")
+
+		var resp Error
+		code := fixture.Do(http.MethodPost, "/admin/webhooks", fmt.Sprintf(`{"url":%q,"topics":["orders"]}`, receiver.URL), &resp)
+		assert.Equal(t, http.StatusNotImplemented, code)
+		assert.Equal(t, "webhooks are not enabled", resp.Message)
+		assert.Equal(t, http.StatusNotImplemented, fixture.Do(http.MethodGet, "/admin/webhooks", "", &resp))
+
+		size, err := fixture.Queue.Size(context.Background(), "orders")
+		require.NoError(t, err)
+		assert.Equal(t, 1, size, "Should not consume the administered queue without a dispatcher")
+	})
+}
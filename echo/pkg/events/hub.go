@@ -0,0 +1,240 @@
+// Package events fans the messages of queue topics out to live subscribers such as browser streams.
+//
+// The hub observes the messages enqueued through a Tap, so the consumers of the topics still receive every message.
+// It numbers the messages of each topic so that a subscriber reconnecting with the ID of the last event it saw
+// is replayed what it missed, as long as it is still buffered.
+package events
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/syl/Go/pkg/examples/queue"
+	"github.com/syl/Go/pkg/examples/queue/filter"
+)
+
+const (
+	// DefaultReplaySize is the number of recent events kept per topic for reconnecting subscribers
+	DefaultReplaySize = 256
+	// DefaultSubscriberBuffer is the number of live events a subscriber may lag behind before it is dropped
+	DefaultSubscriberBuffer = 64
+)
+
+// ErrHubClosed is returned by Subscribe once the hub is closed
+var ErrHubClosed = errors.New("hub is closed")
+
+// Event is a message of a topic, IDs start at 1 and increase by one per topic
+type Event struct {
+	ID      uint64
+	Message *queue.Message
+}
+
+// Option configures a Hub created with NewHub
+type Option func(*Hub)
+
+// WithConsumer makes the hub consume the subscribed topics with consumer, on top of the messages of its taps
+// The hub then takes the messages away from the other consumers, use it for topics dedicated to the hub.
+func WithConsumer(consumer queue.Consumer) Option {
+	return func(h *Hub) {
+		h.consumer = consumer
+	}
+}
+
+// WithReplaySize sets the number of recent events kept per topic for reconnecting subscribers
+func WithReplaySize(size int) Option {
+	return func(h *Hub) {
+		h.replaySize = size
+	}
+}
+
+// WithSubscriberBuffer sets the number of live events a subscriber may lag behind before it is dropped
+func WithSubscriberBuffer(size int) Option {
+	return func(h *Hub) {
+		h.subscriberBuffer = size
+	}
+}
+
+// Hub delivers the messages of the subscribed topics to every matching subscriber
+// With WithConsumer, a topic is consumed from its first subscriber until the hub is closed.
+type Hub struct {
+	consumer         queue.Consumer
+	replaySize       int
+	subscriberBuffer int
+	ctx              context.Context
+	cancel           context.CancelFunc
+
+	mu     sync.Mutex
+	topics map[string]*topicState
+	closed bool
+}
+
+// topicState holds the recent events and the subscribers of a topic
+type topicState struct {
+	lastID      uint64
+	replay      []Event
+	subscribers map[*Subscription]struct{}
+}
+
+// NewHub creates a hub receiving the messages published through its taps
+func NewHub(opts ...Option) *Hub {
+	ctx, cancel := context.WithCancel(context.Background())
+	h := &Hub{
+		replaySize:       DefaultReplaySize,
+		subscriberBuffer: DefaultSubscriberBuffer,
+		ctx:              ctx,
+		cancel:           cancel,
+		topics:           make(map[string]*topicState),
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
+}
+
+// Subscribe starts receiving the events of topic whose headers match f, a nil filter matches every event
+// The buffered events after lastEventID are delivered first, pass 0 to only receive new events.
+func (h *Hub) Subscribe(topic string, lastEventID uint64, f *filter.Filter) (*Subscription, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closed {
+		return nil, ErrHubClosed
+	}
+
+	state, exists := h.topics[topic]
+	if !exists {
+		if h.consumer != nil {
+			err := h.consumer.Subscribe(h.ctx, topic, func(ctx context.Context, message *queue.Message) error {
+				h.Publish(topic, message)
+				return nil
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+		state = &topicState{subscribers: make(map[*Subscription]struct{})}
+		h.topics[topic] = state
+	}
+
+	sub := &Subscription{
+		hub:    h,
+		topic:  topic,
+		filter: f,
+		events: make(chan Event, h.replaySize+h.subscriberBuffer),
+		done:   make(chan struct{}),
+	}
+	if lastEventID > 0 {
+		for _, event := range state.replay {
+			if event.ID > lastEventID && sub.match(event) {
+				sub.events <- event
+			}
+		}
+	}
+	state.subscribers[sub] = struct{}{}
+	return sub, nil
+}
+
+// Close ends every subscription and stops consuming the topics
+func (h *Hub) Close() error {
+	h.mu.Lock()
+	if h.closed {
+		h.mu.Unlock()
+		return nil
+	}
+	h.closed = true
+	topics := h.topics
+	h.topics = make(map[string]*topicState)
+	for _, state := range topics {
+		for sub := range state.subscribers {
+			sub.end()
+		}
+	}
+	h.mu.Unlock()
+
+	var errs []error
+	for topic := range topics {
+		if h.consumer == nil {
+			break
+		}
+		if err := h.consumer.Unsubscribe(h.ctx, topic); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	h.cancel()
+	return errors.Join(errs...)
+}
+
+// Publish numbers the message, keeps it for replay and hands it to the matching subscribers
+// Messages of topics without subscribers are ignored. Subscribers whose buffer is full are dropped,
+// they catch up by subscribing again with their last event ID.
+func (h *Hub) Publish(topic string, message *queue.Message) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	state, exists := h.topics[topic]
+	if !exists {
+		return
+	}
+
+	state.lastID++
+	event := Event{ID: state.lastID, Message: message}
+	if h.replaySize > 0 {
+		if len(state.replay) == h.replaySize {
+			copy(state.replay, state.replay[1:])
+			state.replay = state.replay[:h.replaySize-1]
+		}
+		state.replay = append(state.replay, event)
+	}
+
+	for sub := range state.subscribers {
+		if !sub.match(event) {
+			continue
+		}
+		select {
+		case sub.events <- event:
+		default:
+			delete(state.subscribers, sub)
+			sub.end()
+		}
+	}
+}
+
+// Subscription receives the events of a topic
+type Subscription struct {
+	hub    *Hub
+	topic  string
+	filter *filter.Filter
+	events chan Event
+	done   chan struct{}
+	once   sync.Once
+}
+
+// Events returns the channel delivering the events in order
+func (s *Subscription) Events() <-chan Event {
+	return s.events
+}
+
+// Done is closed when the subscription ends because it was too slow to keep up or the hub was closed
+func (s *Subscription) Done() <-chan struct{} {
+	return s.done
+}
+
+// Close ends the subscription
+func (s *Subscription) Close() {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+
+	if state, exists := s.hub.topics[s.topic]; exists {
+		delete(state.subscribers, s)
+	}
+	s.end()
+}
+
+func (s *Subscription) match(event Event) bool {
+	return s.filter == nil || s.filter.Match(event.Message.Headers)
+}
+
+func (s *Subscription) end() {
+	s.once.Do(func() { close(s.done) })
+}
@@ -0,0 +1,157 @@
+package events
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/syl/Go/pkg/examples/queue"
+	"github.com/syl/Go/pkg/examples/queue/broker"
+	"github.com/syl/Go/pkg/examples/queue/filter"
+)
+
+// HubTestFixture runs a hub tapping the producer of a mock queue
+type HubTestFixture struct {
+	T        *testing.T
+	Ctx      context.Context
+	Hub      *Hub
+	Queue    queue.Queue
+	Producer *broker.QueueProducer
+}
+
+func NewHubTestFixture(t *testing.T, opts ...Option) *HubTestFixture {
+	q := queue.NewMock()
+	hub := NewHub(opts...)
+	t.Cleanup(func() {
+		hub.Close()
+		q.Close()
+	})
+	return &HubTestFixture{T: t, Ctx: context.Background(), Hub: hub, Queue: q, Producer: broker.NewQueueProducer(NewTap(q, hub))}
+}
+
+// Publish sends messages with the given payloads and region header to a topic
+func (f *HubTestFixture) Publish(topic, region string, payloads ...string) {
+	f.T.Helper()
+
+	for _, payload := range payloads {
+		require.NoError(f.T, f.Producer.Publish(f.Ctx, topic, []byte(payload), map[string]string{"region": region}))
+	}
+}
+
+// Receive waits for n events of the subscription and returns their payloads
+func (f *HubTestFixture) Receive(sub *Subscription, n int) []string {
+	f.T.Helper()
+
+	var payloads []string
+	for len(payloads) < n {
+		select {
+		case event := <-sub.Events():
+			payloads = append(payloads, fmt.Sprintf("%d:%s", event.ID, event.Message.Payload))
+		case <-time.After(5 * time.Second):
+			f.T.Fatalf("Received %v, expected %d events", payloads, n)
+		}
+	}
+	return payloads
+}
+
+func TestHub(t *testing.T) {
+	t.Run("FanOut", func(t *testing.T) {
+		fixture := NewHubTestFixture(t)
+		first, err := fixture.Hub.Subscribe("orders", 0, nil)
+		require.NoError(t, err)
+		second, err := fixture.Hub.Subscribe("orders", 0, nil)
+		require.NoError(t, err)
+
+		fixture.Publish("orders", "eu", "o1", "o2")
+
+		assert.Equal(t, []string{"1:o1", "2:o2"}, fixture.Receive(first, 2))
+		assert.Equal(t, []string{"1:o1", "2:o2"}, fixture.Receive(second, 2), "Every subscriber should get every event")
+	})
+
+	t.Run("ConsumersKeepMessages", func(t *testing.T) {
+		fixture := NewHubTestFixture(t)
+		sub, err := fixture.Hub.Subscribe("orders", 0, nil)
+		require.NoError(t, err)
+
+		fixture.Publish("orders", "eu", "o1")
+		assert.Equal(t, []string{"1:o1"}, fixture.Receive(sub, 1))
+
+		message, err := fixture.Queue.Dequeue(fixture.Ctx, "orders")
+		require.NoError(t, err)
+		require.NotNil(t, message, "The hub should not take the message away from the consumers")
+		assert.Equal(t, []byte("o1"), message.Payload)
+	})
+
+	t.Run("Consumer", func(t *testing.T) {
+		q := queue.NewMock()
+		consumer := broker.NewQueueConsumer(q)
+		hub := NewHub(WithConsumer(consumer))
+		t.Cleanup(func() {
+			hub.Close()
+			consumer.Close()
+			q.Close()
+		})
+		fixture := &HubTestFixture{T: t, Ctx: context.Background(), Hub: hub, Queue: q, Producer: broker.NewQueueProducer(q)}
+
+		sub, err := hub.Subscribe("dashboard", 0, nil)
+		require.NoError(t, err)
+		fixture.Publish("dashboard", "eu", "o1", "o2")
+		assert.Equal(t, []string{"1:o1", "2:o2"}, fixture.Receive(sub, 2), "Should consume the topics of a dedicated consumer")
+	})
+
+	t.Run("Filter", func(t *testing.T) {
+		fixture := NewHubTestFixture(t)
+		sub, err := fixture.Hub.Subscribe("orders", 0, filter.MustParse("region = 'us'"))
+		require.NoError(t, err)
+
+		fixture.Publish("orders", "eu", "o1")
+		fixture.Publish("orders", "us", "o2")
+
+		assert.Equal(t, []string{"2:o2"}, fixture.Receive(sub, 1), "Should skip events rejected by the filter")
+	})
+
+	t.Run("Replay", func(t *testing.T) {
+		fixture := NewHubTestFixture(t, WithReplaySize(2))
+		sub, err := fixture.Hub.Subscribe("orders", 0, nil)
+		require.NoError(t, err)
+		fixture.Publish("orders", "eu", "o1", "o2", "o3")
+		fixture.Receive(sub, 3)
+		sub.Close()
+
+		again, err := fixture.Hub.Subscribe("orders", 1, nil)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"2:o2", "3:o3"}, fixture.Receive(again, 2), "Should replay the buffered events after the last event ID")
+
+		fixture.Publish("orders", "eu", "o4")
+		assert.Equal(t, []string{"4:o4"}, fixture.Receive(again, 1), "Should continue with live events")
+	})
+
+	t.Run("SlowSubscriberIsDropped", func(t *testing.T) {
+		fixture := NewHubTestFixture(t, WithReplaySize(0), WithSubscriberBuffer(1))
+		slow, err := fixture.Hub.Subscribe("orders", 0, nil)
+		require.NoError(t, err)
+
+		fixture.Publish("orders", "eu", "o1", "o2")
+
+		select {
+		case <-slow.Done():
+		case <-time.After(5 * time.Second):
+			t.Fatal("A subscriber not keeping up should be dropped")
+		}
+	})
+
+	t.Run("Close", func(t *testing.T) {
+		fixture := NewHubTestFixture(t)
+		sub, err := fixture.Hub.Subscribe("orders", 0, nil)
+		require.NoError(t, err)
+
+		require.NoError(t, fixture.Hub.Close())
+		<-sub.Done()
+
+		_, err = fixture.Hub.Subscribe("orders", 0, nil)
+		assert.ErrorIs(t, err, ErrHubClosed)
+	})
+}
@@ -0,0 +1,32 @@
+package events
+
+import (
+	"context"
+
+	"github.com/syl/Go/pkg/examples/queue"
+)
+
+// ensure that Tap implements the Queue interface
+var _ queue.Queue = (*Tap)(nil)
+
+// Tap is a queue.Queue publishing the messages enqueued through it to a hub
+// Producers enqueue through the tap and consumers dequeue from the tapped queue as before,
+// so the hub sees every message without taking it away from the consumers.
+type Tap struct {
+	queue.Queue
+	hub *Hub
+}
+
+// NewTap wraps q so that the messages enqueued through it are also published to hub
+func NewTap(q queue.Queue, hub *Hub) *Tap {
+	return &Tap{Queue: q, hub: hub}
+}
+
+// Enqueue adds the message to the tapped queue, then publishes it to the hub
+func (t *Tap) Enqueue(ctx context.Context, topic string, message *queue.Message) error {
+	if err := t.Queue.Enqueue(ctx, topic, message); err != nil {
+		return err
+	}
+	t.hub.Publish(topic, message)
+	return nil
+}
@@ -0,0 +1,421 @@
+// Package queueserver exposes a queue.Queue over HTTP+JSON so that several processes share it
+// through remote.Client:
+//
+//	POST /topics/{topic}/messages                  enqueue the message in the body
+//	POST /topics/{topic}/dequeue?wait=5s&ack=true  long-poll the next message, 204 when none arrived
+//	POST /ack/{receipt}                            acknowledge a message dequeued without ack
+//	GET  /topics/{topic}/size                      number of pending messages
+//	DELETE /topics/{topic}/messages                remove the pending messages, 501 when the queue cannot purge
+//	GET  /topics                                   list the topics
+//	GET  /health                                   503 when the queue is unhealthy
+//
+// Messages dequeued without ack=true are leased: they return to their topic when they are not
+// acknowledged within the visibility timeout, at the next request reading the topic. A message that cannot be written to the client returns to its topic at once.
+package queueserver
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/syl/Go/pkg/examples/queue"
+	"github.com/syl/Go/pkg/examples/queue/remote"
+)
+
+const (
+	// DefaultVisibilityTimeout is how long a leased message waits for its acknowledgement
+	DefaultVisibilityTimeout = 30 * time.Second
+	// DefaultMaxWait caps the long-poll wait requested by clients
+	DefaultMaxWait = 20 * time.Second
+	// PollInterval is how often a long-poll checks the queue for messages enqueued by other means than the server
+	PollInterval = 100 * time.Millisecond
+)
+
+// EchoRouter is the part of echo.Echo and echo.Group used to register the handlers
+type EchoRouter interface {
+	GET(path string, h echo.HandlerFunc, m ...echo.MiddlewareFunc) *echo.Route
+	POST(path string, h echo.HandlerFunc, m ...echo.MiddlewareFunc) *echo.Route
+	DELETE(path string, h echo.HandlerFunc, m ...echo.MiddlewareFunc) *echo.Route
+}
+
+// Option configures a Server created with NewServer
+type Option func(*Server)
+
+// WithVisibilityTimeout sets how long a leased message waits for its acknowledgement before it is delivered again
+func WithVisibilityTimeout(timeout time.Duration) Option {
+	return func(s *Server) {
+		s.visibilityTimeout = timeout
+	}
+}
+
+// WithMaxWait caps the long-poll wait of dequeues
+func WithMaxWait(wait time.Duration) Option {
+	return func(s *Server) {
+		s.maxWait = wait
+	}
+}
+
+// WithClock sets the clock used for lease expiry and long-poll timers
+func WithClock(clock queue.Clock) Option {
+	return func(s *Server) {
+		s.clock = clock
+	}
+}
+
+// Server serves a queue to remote clients
+type Server struct {
+	queue             queue.Queue
+	clock             queue.Clock
+	visibilityTimeout time.Duration
+	maxWait           time.Duration
+
+	mu      sync.Mutex
+	leases  map[string]lease
+	arrived map[string]chan struct{}
+}
+
+// lease is a dequeued message waiting for its acknowledgement
+type lease struct {
+	topic   string
+	message *queue.Message
+	expires time.Time
+}
+
+// NewServer creates a server for q
+func NewServer(q queue.Queue, opts ...Option) *Server {
+	s := &Server{
+		queue:             q,
+		clock:             queue.SystemClock,
+		visibilityTimeout: DefaultVisibilityTimeout,
+		maxWait:           DefaultMaxWait,
+		leases:            make(map[string]lease),
+		arrived:           make(map[string]chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// RegisterHandlers adds the routes of the server to router
+func RegisterHandlers(router EchoRouter, s *Server) {
+	router.POST("/topics/:topic/messages", s.Enqueue)
+	router.POST("/topics/:topic/dequeue", s.Dequeue)
+	router.POST("/ack/:receipt", s.Ack)
+	router.GET("/topics/:topic/size", s.Size)
+	router.DELETE("/topics/:topic/messages", s.Purge)
+	router.GET("/topics", s.Topics)
+	router.GET("/health", s.Health)
+}
+
+// Enqueue (POST /topics/{topic}/messages)
+func (s *Server) Enqueue(ctx echo.Context) error {
+	topic, err := pathParam(ctx, "topic")
+	if err != nil {
+		return err
+	}
+
+	message := new(queue.Message)
+	if err := ctx.Bind(message); err != nil {
+		return err
+	}
+	if message.Topic == "" {
+		message.Topic = topic
+	}
+
+	if err := s.queue.Enqueue(ctx.Request().Context(), topic, message); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	s.notify(topic)
+	return ctx.NoContent(http.StatusNoContent)
+}
+
+// Dequeue (POST /topics/{topic}/dequeue)
+// It waits up to the wait query parameter for a message and leases it unless ack is true.
+func (s *Server) Dequeue(ctx echo.Context) error {
+	topic, err := pathParam(ctx, "topic")
+	if err != nil {
+		return err
+	}
+
+	var wait time.Duration
+	if param := ctx.QueryParam("wait"); param != "" {
+		if wait, err = time.ParseDuration(param); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "invalid wait: "+err.Error())
+		}
+	}
+	wait = min(wait, s.maxWait)
+
+	ack := false
+	if param := ctx.QueryParam("ack"); param != "" {
+		if ack, err = strconv.ParseBool(param); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "invalid ack: "+err.Error())
+		}
+	}
+
+	c := ctx.Request().Context()
+	s.requeueExpired(c)
+
+	var timeout, poll <-chan time.Time
+	if wait > 0 {
+		timer := s.clock.NewTimer(wait)
+		defer timer.Stop()
+		timeout = timer.C()
+
+		ticker := s.clock.NewTicker(PollInterval)
+		defer ticker.Stop()
+		poll = ticker.C()
+	}
+
+	for {
+		// Watch before dequeueing so that a message enqueued in between wakes the wait
+		var arrived <-chan struct{}
+		if timeout != nil {
+			arrived = s.watch(topic)
+		}
+
+		message, err := s.queue.Dequeue(c, topic)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+		}
+		if message != nil {
+			return s.deliver(ctx, topic, message, ack)
+		}
+		if timeout == nil {
+			return ctx.NoContent(http.StatusNoContent)
+		}
+
+		select {
+		case <-arrived:
+		case <-poll:
+		case <-timeout:
+			return ctx.NoContent(http.StatusNoContent)
+		case <-c.Done():
+			return c.Err()
+		}
+	}
+}
+
+// deliver writes the dequeued message to the client
+// The message is leased until the response is written, even when it is acknowledged on dequeue,
+// so that it returns to its topic when the client went away in the meantime.
+func (s *Server) deliver(ctx echo.Context, topic string, message *queue.Message, ack bool) error {
+	receipt := s.lease(topic, message)
+	resp := remote.DequeueResponse{Message: message}
+	if !ack {
+		resp.Receipt = receipt
+	}
+
+	c := ctx.Request().Context()
+	if err := c.Err(); err != nil {
+		s.release(c, receipt)
+		return err
+	}
+	if err := ctx.JSON(http.StatusOK, resp); err != nil {
+		s.release(c, receipt)
+		return err
+	}
+
+	if ack {
+		s.mu.Lock()
+		delete(s.leases, receipt)
+		s.mu.Unlock()
+	}
+	return nil
+}
+
+// release returns a leased message to its topic at once
+func (s *Server) release(ctx context.Context, receipt string) {
+	s.mu.Lock()
+	l, exists := s.leases[receipt]
+	delete(s.leases, receipt)
+	s.mu.Unlock()
+
+	if exists {
+		s.restore(ctx, receipt, l)
+	}
+}
+
+// Ack (POST /ack/{receipt})
+func (s *Server) Ack(ctx echo.Context) error {
+	receipt, err := pathParam(ctx, "receipt")
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	l, exists := s.leases[receipt]
+	// An expired lease is left for the next read to requeue
+	acked := exists && s.clock.Now().Before(l.expires)
+	if acked {
+		delete(s.leases, receipt)
+	}
+	s.mu.Unlock()
+
+	if !acked {
+		return echo.NewHTTPError(http.StatusNotFound, remote.ErrReceiptNotFound.Error())
+	}
+	return ctx.NoContent(http.StatusNoContent)
+}
+
+// Size (GET /topics/{topic}/size)
+func (s *Server) Size(ctx echo.Context) error {
+	topic, err := pathParam(ctx, "topic")
+	if err != nil {
+		return err
+	}
+
+	c := ctx.Request().Context()
+	s.requeueExpired(c)
+
+	size, err := s.queue.Size(c, topic)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return ctx.JSON(http.StatusOK, remote.SizeResponse{Topic: topic, Size: size})
+}
+
+// Purge (DELETE /topics/{topic}/messages)
+func (s *Server) Purge(ctx echo.Context) error {
+	topic, err := pathParam(ctx, "topic")
+	if err != nil {
+		return err
+	}
+
+	inspector, ok := s.queue.(queue.Inspector)
+	if !ok {
+		return echo.NewHTTPError(http.StatusNotImplemented, "queue does not support purging topics")
+	}
+
+	c := ctx.Request().Context()
+	s.requeueExpired(c)
+
+	purged, err := inspector.Purge(c, topic)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return ctx.JSON(http.StatusOK, remote.PurgeResponse{Topic: topic, Purged: purged})
+}
+
+// Topics (GET /topics)
+func (s *Server) Topics(ctx echo.Context) error {
+	c := ctx.Request().Context()
+	s.requeueExpired(c)
+
+	topics, err := s.queue.Topics(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	if topics == nil {
+		topics = []string{}
+	}
+	return ctx.JSON(http.StatusOK, remote.TopicsResponse{Topics: topics})
+}
+
+// Health (GET /health)
+func (s *Server) Health(ctx echo.Context) error {
+	if checker, ok := s.queue.(queue.HealthChecker); ok {
+		if err := checker.CheckHealth(ctx.Request().Context()); err != nil {
+			return echo.NewHTTPError(http.StatusServiceUnavailable, err.Error())
+		}
+	}
+	return ctx.NoContent(http.StatusNoContent)
+}
+
+// Close returns the messages still waiting for an acknowledgement to their topics
+// Call it before closing the queue so that a snapshot on close keeps them.
+func (s *Server) Close(ctx context.Context) error {
+	s.mu.Lock()
+	leases := s.leases
+	s.leases = make(map[string]lease)
+	s.mu.Unlock()
+
+	var errs []error
+	for _, l := range leases {
+		if err := s.queue.Enqueue(ctx, l.topic, l.message); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// lease records the message as waiting for an acknowledgement and returns its receipt
+func (s *Server) lease(topic string, message *queue.Message) string {
+	receipt := uuid.NewString()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.leases[receipt] = lease{topic: topic, message: message, expires: s.clock.Now().Add(s.visibilityTimeout)}
+	return receipt
+}
+
+// requeueExpired returns the messages whose visibility timeout expired to their topics
+// Every endpoint reading the topics calls it first, so they count the expired messages as pending.
+func (s *Server) requeueExpired(ctx context.Context) {
+	now := s.clock.Now()
+
+	s.mu.Lock()
+	expired := make(map[string]lease)
+	for receipt, l := range s.leases {
+		if !now.Before(l.expires) {
+			expired[receipt] = l
+			delete(s.leases, receipt)
+		}
+	}
+	s.mu.Unlock()
+
+	for receipt, l := range expired {
+		s.restore(ctx, receipt, l)
+	}
+}
+
+// restore puts an expired lease back into its topic, or keeps it for the next attempt when the topic is full
+func (s *Server) restore(ctx context.Context, receipt string, l lease) {
+	if err := s.queue.Enqueue(context.WithoutCancel(ctx), l.topic, l.message); err != nil {
+		s.mu.Lock()
+		s.leases[receipt] = l
+		s.mu.Unlock()
+		return
+	}
+	s.notify(l.topic)
+}
+
+// watch returns a channel closed when the server enqueues the next message of the topic
+func (s *Server) watch(topic string) <-chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ch, exists := s.arrived[topic]
+	if !exists {
+		ch = make(chan struct{})
+		s.arrived[topic] = ch
+	}
+	return ch
+}
+
+// notify wakes the dequeues waiting for the topic
+func (s *Server) notify(topic string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if ch, exists := s.arrived[topic]; exists {
+		close(ch)
+		delete(s.arrived, topic)
+	}
+}
+
+// pathParam returns the unescaped path parameter
+func pathParam(ctx echo.Context, name string) (string, error) {
+	value, err := url.PathUnescape(ctx.Param(name))
+	if err != nil || value == "" {
+		return "", echo.NewHTTPError(http.StatusBadRequest, "invalid "+name)
+	}
+	return value, nil
+}
@@ -0,0 +1,277 @@
+package queueserver
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/syl/Go/pkg/examples/queue"
+	"github.com/syl/Go/pkg/examples/queue/broker"
+	"github.com/syl/Go/pkg/examples/queue/inmemory"
+	"github.com/syl/Go/pkg/examples/queue/inmemory/testutils"
+	"github.com/syl/Go/pkg/examples/queue/queuetest"
+	"github.com/syl/Go/pkg/examples/queue/remote"
+)
+
+// ServerTestFixture runs a server over an in-memory queue
+type ServerTestFixture struct {
+	T      *testing.T
+	Ctx    context.Context
+	Queue  *inmemory.InMemoryQueue
+	Server *Server
+	URL    string
+}
+
+// NewServerTestFixture starts a server, it is stopped when the test ends
+func NewServerTestFixture(t *testing.T, opts ...Option) *ServerTestFixture {
+	q := inmemory.NewInMemoryQueue()
+	server := NewServer(q, opts...)
+
+	e := echo.New()
+	RegisterHandlers(e, server)
+	httpServer := httptest.NewServer(e)
+	t.Cleanup(func() {
+		httpServer.Close()
+		q.Close()
+	})
+
+	return &ServerTestFixture{T: t, Ctx: context.Background(), Queue: q, Server: server, URL: httpServer.URL}
+}
+
+// NewClient creates a client of the server, as another process would
+func (f *ServerTestFixture) NewClient(opts ...remote.Option) *remote.Client {
+	client := remote.NewClient(f.URL, opts...)
+	f.T.Cleanup(func() { client.Close() })
+	return client
+}
+
+func TestConformance(t *testing.T) {
+	queuetest.Run(t, func(t *testing.T) queue.Queue {
+		return NewServerTestFixture(t).NewClient()
+	})
+}
+
+func TestServer(t *testing.T) {
+	t.Run("LongPoll", func(t *testing.T) {
+		fixture := NewServerTestFixture(t)
+		consumer := fixture.NewClient()
+		producer := fixture.NewClient()
+
+		start := time.Now()
+		delivery, err := consumer.Receive(fixture.Ctx, "orders", 50*time.Millisecond)
+		require.NoError(t, err)
+		assert.Nil(t, delivery, "Should return nothing when no message arrives")
+		assert.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond, "Should wait for a message")
+
+		received := make(chan *remote.Delivery, 1)
+		go func() {
+			delivery, err := consumer.Receive(fixture.Ctx, "orders", 10*time.Second)
+			assert.NoError(t, err)
+			received <- delivery
+		}()
+
+		time.Sleep(50 * time.Millisecond)
+		require.NoError(t, producer.Enqueue(fixture.Ctx, "orders", &queue.Message{ID: "o1", Payload: []byte("order")}))
+
+		select {
+		case delivery := <-received:
+			require.NotNil(t, delivery)
+			assert.Equal(t, "o1", delivery.Message.ID)
+			assert.Equal(t, "orders", delivery.Message.Topic)
+		case <-time.After(5 * time.Second):
+			t.Fatal("The waiting dequeue should be woken by the enqueue")
+		}
+	})
+
+	t.Run("LongPollSeesLocalEnqueue", func(t *testing.T) {
+		fixture := NewServerTestFixture(t)
+		client := fixture.NewClient(remote.WithWait(10 * time.Second))
+
+		go func() {
+			time.Sleep(50 * time.Millisecond)
+			fixture.Queue.Enqueue(fixture.Ctx, "orders", &queue.Message{ID: "local"})
+		}()
+
+		message, err := client.Dequeue(fixture.Ctx, "orders")
+		require.NoError(t, err)
+		require.NotNil(t, message, "Should poll the queue for messages not enqueued through the server")
+		assert.Equal(t, "local", message.ID)
+	})
+
+	t.Run("Ack", func(t *testing.T) {
+		fixture := NewServerTestFixture(t)
+		client := fixture.NewClient()
+		require.NoError(t, client.Enqueue(fixture.Ctx, "orders", &queue.Message{ID: "o1"}))
+
+		delivery, err := client.Receive(fixture.Ctx, "orders", 0)
+		require.NoError(t, err)
+		require.NotNil(t, delivery)
+		assert.NotEmpty(t, delivery.Receipt)
+
+		require.NoError(t, client.Ack(fixture.Ctx, delivery.Receipt))
+		assert.ErrorIs(t, client.Ack(fixture.Ctx, delivery.Receipt), remote.ErrReceiptNotFound, "Should only ack once")
+		assert.ErrorIs(t, client.Ack(fixture.Ctx, "unknown"), remote.ErrReceiptNotFound)
+	})
+
+	t.Run("RedeliversUnacknowledged", func(t *testing.T) {
+		clock := testutils.NewFakeClock(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+		fixture := NewServerTestFixture(t, WithClock(clock), WithVisibilityTimeout(time.Minute))
+		client := fixture.NewClient()
+		require.NoError(t, client.Enqueue(fixture.Ctx, "orders", &queue.Message{ID: "o1"}))
+
+		first, err := client.Receive(fixture.Ctx, "orders", 0)
+		require.NoError(t, err)
+		require.NotNil(t, first)
+
+		second, err := client.Receive(fixture.Ctx, "orders", 0)
+		require.NoError(t, err)
+		assert.Nil(t, second, "Should hide the message while it is leased")
+
+		clock.Advance(time.Minute)
+		assert.ErrorIs(t, client.Ack(fixture.Ctx, first.Receipt), remote.ErrReceiptNotFound, "Should reject an expired receipt")
+
+		second, err = client.Receive(fixture.Ctx, "orders", 0)
+		require.NoError(t, err)
+		require.NotNil(t, second, "Should deliver the message again")
+		assert.Equal(t, "o1", second.Message.ID)
+		assert.NotEqual(t, first.Receipt, second.Receipt)
+	})
+
+	t.Run("ReadsRequeueExpired", func(t *testing.T) {
+		clock := testutils.NewFakeClock(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+		fixture := NewServerTestFixture(t, WithClock(clock), WithVisibilityTimeout(time.Minute))
+		client := fixture.NewClient()
+
+		lease := func(topic string) {
+			require.NoError(t, client.Enqueue(fixture.Ctx, topic, &queue.Message{ID: topic + "-1"}))
+			received, err := client.Receive(fixture.Ctx, topic, 0)
+			require.NoError(t, err)
+			require.NotNil(t, received)
+		}
+
+		lease("orders")
+		size, err := client.Size(fixture.Ctx, "orders")
+		require.NoError(t, err)
+		assert.Equal(t, 0, size, "Should not count a leased message")
+
+		clock.Advance(time.Minute)
+		size, err = client.Size(fixture.Ctx, "orders")
+		require.NoError(t, err)
+		assert.Equal(t, 1, size, "Size should count an expired lease as pending")
+
+		lease("payments")
+		require.NoError(t, fixture.Queue.DeleteTopic(fixture.Ctx, "payments"))
+		clock.Advance(time.Minute)
+		topics, err := client.Topics(fixture.Ctx)
+		require.NoError(t, err)
+		assert.Contains(t, topics, "payments", "Topics should list the topic of an expired lease")
+
+		lease("refunds")
+		clock.Advance(time.Minute)
+		purged, err := client.Purge(fixture.Ctx, "refunds")
+		require.NoError(t, err)
+		assert.Equal(t, 1, purged, "Purge should remove an expired lease")
+	})
+
+	t.Run("CloseRequeuesLeases", func(t *testing.T) {
+		fixture := NewServerTestFixture(t)
+		client := fixture.NewClient()
+		require.NoError(t, client.Enqueue(fixture.Ctx, "orders", &queue.Message{ID: "o1"}))
+
+		_, err := client.Receive(fixture.Ctx, "orders", 0)
+		require.NoError(t, err)
+
+		require.NoError(t, fixture.Server.Close(fixture.Ctx))
+		size, err := fixture.Queue.Size(fixture.Ctx, "orders")
+		require.NoError(t, err)
+		assert.Equal(t, 1, size, "Unacknowledged messages should return to their topic")
+	})
+
+	t.Run("RequeuesUndeliveredMessage", func(t *testing.T) {
+		fixture := NewServerTestFixture(t)
+		require.NoError(t, fixture.Queue.Enqueue(fixture.Ctx, "orders", &queue.Message{ID: "o1"}))
+
+		for _, query := range []string{"ack=true", "ack=false"} {
+			e := echo.New()
+			ctx := e.NewContext(httptest.NewRequest(http.MethodPost, "/topics/orders/dequeue?"+query, nil), failingWriter{httptest.NewRecorder()})
+			ctx.SetParamNames("topic")
+			ctx.SetParamValues("orders")
+
+			require.Error(t, fixture.Server.Dequeue(ctx), "Should fail when the client went away")
+			size, err := fixture.Queue.Size(fixture.Ctx, "orders")
+			require.NoError(t, err)
+			assert.Equal(t, 1, size, "The undelivered message should return to its topic with %s", query)
+		}
+	})
+
+	t.Run("Purge", func(t *testing.T) {
+		fixture := NewServerTestFixture(t)
+		client := fixture.NewClient()
+		for _, id := range []string{"o1", "o2"} {
+			require.NoError(t, client.Enqueue(fixture.Ctx, "orders", &queue.Message{ID: id}))
+		}
+
+		purged, err := client.Purge(fixture.Ctx, "orders")
+		require.NoError(t, err)
+		assert.Equal(t, 2, purged)
+
+		size, err := client.Size(fixture.Ctx, "orders")
+		require.NoError(t, err)
+		assert.Zero(t, size)
+	})
+
+	t.Run("Health", func(t *testing.T) {
+		fixture := NewServerTestFixture(t)
+		client := fixture.NewClient()
+		assert.NoError(t, client.CheckHealth(fixture.Ctx))
+
+		require.NoError(t, fixture.Queue.Close())
+		err := client.CheckHealth(fixture.Ctx)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "queue is closed (503)")
+	})
+}
+
+// failingWriter is a response writer whose client went away
+type failingWriter struct {
+	*httptest.ResponseRecorder
+}
+
+func (w failingWriter) Write([]byte) (int, error) {
+	return 0, errors.New("connection reset by peer")
+}
+
+func TestBrokerAcrossProcesses(t *testing.T) {
+	fixture := NewServerTestFixture(t)
+	producer := broker.NewQueueProducer(fixture.NewClient())
+	consumer := broker.NewQueueConsumer(fixture.NewClient(remote.WithWait(time.Second)))
+	defer consumer.Close()
+
+	var mu sync.Mutex
+	var received []string
+	err := consumer.Subscribe(fixture.Ctx, "orders", func(ctx context.Context, message *queue.Message) error {
+		mu.Lock()
+		defer mu.Unlock()
+		received = append(received, string(message.Payload))
+		return nil
+	})
+	require.NoError(t, err)
+
+	for _, payload := range []string{"o1", "o2", "o3"} {
+		require.NoError(t, producer.Publish(fixture.Ctx, "orders", []byte(payload), map[string]string{"source": "test"}))
+	}
+
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(received) == 3
+	}, 5*time.Second, 10*time.Millisecond, "The consumer should receive the messages of the producer")
+	assert.Equal(t, []string{"o1", "o2", "o3"}, received)
+}
@@ -0,0 +1,241 @@
+// Package claimcheck implements the claim-check pattern on top of the queue interfaces.
+// Payloads above a size threshold are stored in S3 and only a reference travels through the queue.
+package claimcheck
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/google/uuid"
+	"github.com/syl/Go/pkg/examples/queue"
+)
+
+const (
+	// HeaderBucket is set on claim-checked messages with the bucket holding the payload
+	HeaderBucket = "claim-check-bucket"
+	// HeaderKey is set on claim-checked messages with the object key of the payload
+	HeaderKey = "claim-check-key"
+
+	// DefaultThreshold matches the SQS maximum message size
+	DefaultThreshold = 256 * 1024
+	// DefaultKeyPrefix is prepended to the object key of every stored payload
+	DefaultKeyPrefix = "claim-check/"
+)
+
+// S3API is the subset of the S3 client used to store and fetch payloads
+type S3API interface {
+	PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error)
+	GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error)
+	DeleteObject(ctx context.Context, params *s3.DeleteObjectInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectOutput, error)
+}
+
+// Reference is the payload published in place of an offloaded message body
+type Reference struct {
+	Bucket string `json:"bucket"`
+	Key    string `json:"key"`
+	Size   int    `json:"size"`
+}
+
+// Producer wraps a queue.Producer and offloads large payloads to S3
+type Producer struct {
+	producer  queue.Producer
+	client    S3API
+	bucket    string
+	threshold int
+	keyPrefix string
+}
+
+// ProducerOption configures a Producer
+type ProducerOption func(*Producer)
+
+// WithThreshold sets the payload size in bytes above which payloads are offloaded
+func WithThreshold(threshold int) ProducerOption {
+	return func(p *Producer) {
+		p.threshold = threshold
+	}
+}
+
+// WithKeyPrefix sets the prefix used for the object keys of offloaded payloads
+func WithKeyPrefix(prefix string) ProducerOption {
+	return func(p *Producer) {
+		p.keyPrefix = prefix
+	}
+}
+
+// NewProducer creates a producer that stores large payloads in the given bucket
+func NewProducer(producer queue.Producer, client S3API, bucket string, opts ...ProducerOption) *Producer {
+	p := &Producer{
+		producer:  producer,
+		client:    client,
+		bucket:    bucket,
+		threshold: DefaultThreshold,
+		keyPrefix: DefaultKeyPrefix,
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
+}
+
+// Publish sends the payload as is when it fits under the threshold,
+// otherwise it uploads it to S3 and publishes a Reference instead
+func (p *Producer) Publish(ctx context.Context, topic string, payload []byte, headers map[string]string) error {
+	if len(payload) <= p.threshold {
+		return p.producer.Publish(ctx, topic, payload, headers)
+	}
+
+	ref := Reference{
+		Bucket: p.bucket,
+		Key:    p.keyPrefix + topic + "/" + uuid.New().String(),
+		Size:   len(payload),
+	}
+
+	_, err := p.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket: &ref.Bucket,
+		Key:    &ref.Key,
+		Body:   bytes.NewReader(payload),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to upload payload to s3://%s/%s: %w", ref.Bucket, ref.Key, err)
+	}
+
+	body, err := json.Marshal(ref)
+	if err != nil {
+		return fmt.Errorf("failed to marshal claim check reference: %w", err)
+	}
+
+	claimHeaders := make(map[string]string, len(headers)+2)
+	for k, v := range headers {
+		claimHeaders[k] = v
+	}
+	claimHeaders[HeaderBucket] = ref.Bucket
+	claimHeaders[HeaderKey] = ref.Key
+
+	if err := p.producer.Publish(ctx, topic, body, claimHeaders); err != nil {
+		// The reference never made it to the queue, so nobody will claim the object
+		if _, deleteErr := p.client.DeleteObject(ctx, &s3.DeleteObjectInput{Bucket: &ref.Bucket, Key: &ref.Key}); deleteErr != nil {
+			return errors.Join(err, fmt.Errorf("failed to delete orphaned payload s3://%s/%s: %w", ref.Bucket, ref.Key, deleteErr))
+		}
+		return err
+	}
+
+	return nil
+}
+
+// Close closes the wrapped producer
+func (p *Producer) Close() error {
+	return p.producer.Close()
+}
+
+// Consumer wraps a queue.Consumer and resolves claim-checked payloads before calling handlers
+type Consumer struct {
+	consumer            queue.Consumer
+	client              S3API
+	logger              *log.Logger
+	deleteAfterHandling bool
+}
+
+// ConsumerOption configures a Consumer
+type ConsumerOption func(*Consumer)
+
+// WithDeleteAfterHandling removes the stored payload once the handler succeeded
+// A failed delete is logged, the message is handled and the object is left for a bucket lifecycle rule.
+func WithDeleteAfterHandling() ConsumerOption {
+	return func(c *Consumer) {
+		c.deleteAfterHandling = true
+	}
+}
+
+// WithLogger logs the payloads that could not be deleted to logger instead of the standard logger
+func WithLogger(logger *log.Logger) ConsumerOption {
+	return func(c *Consumer) {
+		c.logger = logger
+	}
+}
+
+// NewConsumer creates a consumer that fetches offloaded payloads from S3
+func NewConsumer(consumer queue.Consumer, client S3API, opts ...ConsumerOption) *Consumer {
+	c := &Consumer{
+		consumer: consumer,
+		client:   client,
+		logger:   log.Default(),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Subscribe starts consuming messages from the specified topic
+// The handler receives the original payload whether or not it was offloaded
+func (c *Consumer) Subscribe(ctx context.Context, topic string, handler queue.MessageHandler) error {
+	return c.consumer.Subscribe(ctx, topic, c.wrap(handler))
+}
+
+// Unsubscribe stops consuming messages from the specified topic
+func (c *Consumer) Unsubscribe(ctx context.Context, topic string) error {
+	return c.consumer.Unsubscribe(ctx, topic)
+}
+
+// Close closes the wrapped consumer
+func (c *Consumer) Close() error {
+	return c.consumer.Close()
+}
+
+// wrap resolves the claim check of a message before handing it to the handler
+func (c *Consumer) wrap(handler queue.MessageHandler) queue.MessageHandler {
+	return func(ctx context.Context, message *queue.Message) error {
+		bucket, key := message.Headers[HeaderBucket], message.Headers[HeaderKey]
+		if key == "" {
+			return handler(ctx, message)
+		}
+
+		payload, err := c.fetch(ctx, bucket, key)
+		if err != nil {
+			return err
+		}
+
+		resolved := *message
+		resolved.Payload = payload
+		resolved.Headers = make(map[string]string, len(message.Headers))
+		for k, v := range message.Headers {
+			if k != HeaderBucket && k != HeaderKey {
+				resolved.Headers[k] = v
+			}
+		}
+
+		if err := handler(ctx, &resolved); err != nil {
+			return err
+		}
+
+		// Failing the message now would only have it handled again
+		if c.deleteAfterHandling {
+			if _, err := c.client.DeleteObject(ctx, &s3.DeleteObjectInput{Bucket: &bucket, Key: &key}); err != nil {
+				c.logger.Printf("Failed to delete payload s3://%s/%s of handled message %s: %v", bucket, key, message.ID, err)
+			}
+		}
+
+		return nil
+	}
+}
+
+// fetch downloads an offloaded payload
+func (c *Consumer) fetch(ctx context.Context, bucket, key string) ([]byte, error) {
+	output, err := c.client.GetObject(ctx, &s3.GetObjectInput{Bucket: &bucket, Key: &key})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch payload s3://%s/%s: %w", bucket, key, err)
+	}
+	defer output.Body.Close()
+
+	payload, err := io.ReadAll(output.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read payload s3://%s/%s: %w", bucket, key, err)
+	}
+	return payload, nil
+}
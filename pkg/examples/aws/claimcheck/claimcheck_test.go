@@ -0,0 +1,231 @@
+package claimcheck
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/syl/Go/pkg/examples/queue"
+	"github.com/syl/Go/pkg/examples/queue/broker"
+)
+
+// fakeS3 stores objects in memory so the claim check can be tested without LocalStack
+type fakeS3 struct {
+	mu        sync.Mutex
+	objects   map[string][]byte
+	deleteErr error
+}
+
+func newFakeS3() *fakeS3 {
+	return &fakeS3{objects: make(map[string][]byte)}
+}
+
+func (f *fakeS3) PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	body, err := io.ReadAll(params.Body)
+	if err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.objects[*params.Bucket+"/"+*params.Key] = body
+	return &s3.PutObjectOutput{}, nil
+}
+
+func (f *fakeS3) GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	body, exists := f.objects[*params.Bucket+"/"+*params.Key]
+	if !exists {
+		return nil, fmt.Errorf("no such key: %s", *params.Key)
+	}
+	return &s3.GetObjectOutput{Body: io.NopCloser(bytes.NewReader(body))}, nil
+}
+
+func (f *fakeS3) DeleteObject(ctx context.Context, params *s3.DeleteObjectInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.deleteErr != nil {
+		return nil, f.deleteErr
+	}
+	delete(f.objects, *params.Bucket+"/"+*params.Key)
+	return &s3.DeleteObjectOutput{}, nil
+}
+
+func (f *fakeS3) Len() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.objects)
+}
+
+// failingProducer rejects every publish
+type failingProducer struct{}
+
+func (failingProducer) Publish(ctx context.Context, topic string, payload []byte, headers map[string]string) error {
+	return errors.New("queue is closed")
+}
+
+func (failingProducer) Close() error { return nil }
+
+func receive(t *testing.T, received <-chan *queue.Message) *queue.Message {
+	t.Helper()
+
+	select {
+	case msg := <-received:
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timeout waiting for message")
+		return nil
+	}
+}
+
+func TestClaimCheck(t *testing.T) {
+	ctx := context.Background()
+	bucket := "claim-check-bucket"
+
+	t.Run("SmallPayloadIsPublishedInline", func(t *testing.T) {
+		q := queue.NewMock()
+		defer q.Close()
+		store := newFakeS3()
+		producer := NewProducer(broker.NewQueueProducer(q), store, bucket, WithThreshold(10))
+
+		err := producer.Publish(ctx, "orders", []byte("small"), map[string]string{"key": "value"})
+		require.NoError(t, err, "Should publish small payload")
+
+		msg, err := q.Dequeue(ctx, "orders")
+		require.NoError(t, err)
+		require.NotNil(t, msg)
+		assert.Equal(t, []byte("small"), msg.Payload, "Payload should be published inline")
+		assert.Empty(t, msg.Headers[HeaderKey], "Should not set claim check headers")
+		assert.Equal(t, 0, store.Len(), "Should not upload anything")
+	})
+
+	t.Run("LargePayloadIsOffloaded", func(t *testing.T) {
+		q := queue.NewMock()
+		defer q.Close()
+		store := newFakeS3()
+		producer := NewProducer(broker.NewQueueProducer(q), store, bucket, WithThreshold(10))
+		payload := []byte(strings.Repeat("x", 64))
+
+		err := producer.Publish(ctx, "orders", payload, map[string]string{"key": "value"})
+		require.NoError(t, err, "Should publish large payload")
+
+		msg, err := q.Dequeue(ctx, "orders")
+		require.NoError(t, err)
+		require.NotNil(t, msg)
+
+		var ref Reference
+		require.NoError(t, json.Unmarshal(msg.Payload, &ref), "Payload should be a reference")
+		assert.Equal(t, bucket, ref.Bucket, "Reference bucket should match")
+		assert.Equal(t, len(payload), ref.Size, "Reference size should match")
+		assert.True(t, strings.HasPrefix(ref.Key, DefaultKeyPrefix+"orders/"), "Key should use prefix and topic")
+		assert.Equal(t, ref.Key, msg.Headers[HeaderKey], "Key header should match reference")
+		assert.Equal(t, "value", msg.Headers["key"], "Original headers should be kept")
+		assert.Equal(t, 1, store.Len(), "Payload should be uploaded")
+	})
+
+	t.Run("ConsumerResolvesPayload", func(t *testing.T) {
+		q := queue.NewMock()
+		defer q.Close()
+		store := newFakeS3()
+		producer := NewProducer(broker.NewQueueProducer(q), store, bucket, WithThreshold(10))
+		consumer := NewConsumer(broker.NewQueueConsumer(q), store)
+		defer consumer.Close()
+		payload := []byte(strings.Repeat("y", 64))
+
+		received := make(chan *queue.Message, 1)
+		err := consumer.Subscribe(ctx, "orders", func(ctx context.Context, message *queue.Message) error {
+			received <- message
+			return nil
+		})
+		require.NoError(t, err, "Should subscribe")
+
+		require.NoError(t, producer.Publish(ctx, "orders", payload, map[string]string{"key": "value"}))
+
+		msg := receive(t, received)
+		assert.Equal(t, payload, msg.Payload, "Handler should receive the original payload")
+		assert.Equal(t, map[string]string{"key": "value"}, msg.Headers, "Claim check headers should be stripped")
+		assert.Equal(t, 1, store.Len(), "Payload should be kept by default")
+	})
+
+	t.Run("DeleteAfterHandling", func(t *testing.T) {
+		q := queue.NewMock()
+		defer q.Close()
+		store := newFakeS3()
+		producer := NewProducer(broker.NewQueueProducer(q), store, bucket, WithThreshold(10))
+		consumer := NewConsumer(broker.NewQueueConsumer(q), store, WithDeleteAfterHandling())
+		defer consumer.Close()
+
+		handled := make(chan *queue.Message, 2)
+		failFirst := true
+		err := consumer.Subscribe(ctx, "orders", func(ctx context.Context, message *queue.Message) error {
+			defer func() { handled <- message }()
+			if failFirst {
+				failFirst = false
+				return errors.New("handler failed")
+			}
+			return nil
+		})
+		require.NoError(t, err, "Should subscribe")
+
+		require.NoError(t, producer.Publish(ctx, "orders", []byte(strings.Repeat("a", 64)), nil))
+		receive(t, handled)
+		assert.Equal(t, 1, store.Len(), "Payload should be kept when the handler fails")
+
+		require.NoError(t, producer.Publish(ctx, "orders", []byte(strings.Repeat("b", 64)), nil))
+		receive(t, handled)
+		assert.Eventually(t, func() bool { return store.Len() == 1 }, time.Second, 10*time.Millisecond,
+			"Only the successfully handled payload should be deleted")
+	})
+
+	t.Run("PublishFailureDeletesPayload", func(t *testing.T) {
+		store := newFakeS3()
+		producer := NewProducer(failingProducer{}, store, bucket, WithThreshold(10))
+
+		err := producer.Publish(ctx, "orders", []byte(strings.Repeat("c", 64)), nil)
+		assert.EqualError(t, err, "queue is closed")
+		assert.Equal(t, 0, store.Len(), "The orphaned payload should be deleted")
+
+		store.deleteErr = errors.New("access denied")
+		err = producer.Publish(ctx, "orders", []byte(strings.Repeat("d", 64)), nil)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "queue is closed")
+		assert.Contains(t, err.Error(), "failed to delete orphaned payload s3://"+bucket+"/")
+		assert.Contains(t, err.Error(), "access denied")
+	})
+
+	t.Run("DeleteFailureIsLogged", func(t *testing.T) {
+		q := queue.NewMock()
+		defer q.Close()
+		store := newFakeS3()
+		producer := NewProducer(broker.NewQueueProducer(q), store, bucket, WithThreshold(10))
+		var logs bytes.Buffer
+		consumer := NewConsumer(broker.NewQueueConsumer(q), store, WithDeleteAfterHandling(), WithLogger(log.New(&logs, "", 0)))
+
+		require.NoError(t, producer.Publish(ctx, "orders", []byte(strings.Repeat("e", 64)), nil))
+		msg, err := q.Dequeue(ctx, "orders")
+		require.NoError(t, err)
+		require.NotNil(t, msg)
+
+		store.deleteErr = errors.New("access denied")
+		handled := 0
+		err = consumer.wrap(func(ctx context.Context, message *queue.Message) error {
+			handled++
+			return nil
+		})(ctx, msg)
+		assert.NoError(t, err, "A failed delete should not fail a handled message")
+		assert.Equal(t, 1, handled)
+		assert.Contains(t, logs.String(), "Failed to delete payload s3://"+bucket+"/")
+		assert.Equal(t, 1, store.Len())
+	})
+}
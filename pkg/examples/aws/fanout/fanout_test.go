@@ -0,0 +1,239 @@
+package fanout
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	sdk "github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/syl/Go/pkg/examples/queue"
+)
+
+const account = "arn:aws:%s:us-east-1:000000000000:%s"
+
+// fakeSNS records topics, subscriptions and published messages so the fan-out can be tested without LocalStack
+type fakeSNS struct {
+	mu            sync.Mutex
+	topics        map[string]bool
+	subscriptions map[string]*sns.SubscribeInput
+	published     []*sns.PublishInput
+}
+
+func newFakeSNS() *fakeSNS {
+	return &fakeSNS{topics: make(map[string]bool), subscriptions: make(map[string]*sns.SubscribeInput)}
+}
+
+func (f *fakeSNS) CreateTopic(ctx context.Context, params *sns.CreateTopicInput, optFns ...func(*sns.Options)) (*sns.CreateTopicOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.topics[*params.Name] = true
+	return &sns.CreateTopicOutput{TopicArn: sdk.String(fmt.Sprintf(account, "sns", *params.Name))}, nil
+}
+
+func (f *fakeSNS) Subscribe(ctx context.Context, params *sns.SubscribeInput, optFns ...func(*sns.Options)) (*sns.SubscribeOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	// Subscribing the same endpoint twice returns the existing subscription
+	arn := *params.TopicArn + ":" + *params.Endpoint
+	f.subscriptions[arn] = params
+	return &sns.SubscribeOutput{SubscriptionArn: sdk.String(arn)}, nil
+}
+
+func (f *fakeSNS) Publish(ctx context.Context, params *sns.PublishInput, optFns ...func(*sns.Options)) (*sns.PublishOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.published = append(f.published, params)
+	return &sns.PublishOutput{MessageId: sdk.String(fmt.Sprint(len(f.published)))}, nil
+}
+
+// fakeSQS records queues and their attributes
+type fakeSQS struct {
+	mu     sync.Mutex
+	queues map[string]map[string]string
+}
+
+func newFakeSQS() *fakeSQS {
+	return &fakeSQS{queues: make(map[string]map[string]string)}
+}
+
+func (f *fakeSQS) CreateQueue(ctx context.Context, params *sqs.CreateQueueInput, optFns ...func(*sqs.Options)) (*sqs.CreateQueueOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	url := "http://sqs.local/000000000000/" + *params.QueueName
+	if f.queues[url] == nil {
+		f.queues[url] = map[string]string{"QueueArn": fmt.Sprintf(account, "sqs", *params.QueueName)}
+	}
+	return &sqs.CreateQueueOutput{QueueUrl: sdk.String(url)}, nil
+}
+
+func (f *fakeSQS) GetQueueAttributes(ctx context.Context, params *sqs.GetQueueAttributesInput, optFns ...func(*sqs.Options)) (*sqs.GetQueueAttributesOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	attributes, ok := f.queues[*params.QueueUrl]
+	if !ok {
+		return nil, errors.New("queue does not exist")
+	}
+	return &sqs.GetQueueAttributesOutput{Attributes: map[string]string{"QueueArn": attributes["QueueArn"]}}, nil
+}
+
+func (f *fakeSQS) SetQueueAttributes(ctx context.Context, params *sqs.SetQueueAttributesInput, optFns ...func(*sqs.Options)) (*sqs.SetQueueAttributesOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for key, value := range params.Attributes {
+		f.queues[*params.QueueUrl][key] = value
+	}
+	return &sqs.SetQueueAttributesOutput{}, nil
+}
+
+func TestProvision(t *testing.T) {
+	ctx := context.Background()
+	topology := Topology{
+		Topics: []string{"payments"},
+		Subscriptions: []Subscription{
+			{Queue: "billing", Topics: []string{"orders", "payments"}},
+			{Queue: "shipping-eu", Topics: []string{"orders"}, FilterPolicy: `{"region":["eu"]}`},
+		},
+	}
+
+	t.Run("CreatesTopicsQueuesAndSubscriptions", func(t *testing.T) {
+		snsClient, sqsClient := newFakeSNS(), newFakeSQS()
+
+		provisioned, err := Provision(ctx, snsClient, sqsClient, topology)
+		require.NoError(t, err)
+
+		assert.Equal(t, map[string]string{
+			"orders":   "arn:aws:sns:us-east-1:000000000000:orders",
+			"payments": "arn:aws:sns:us-east-1:000000000000:payments",
+		}, provisioned.TopicARNs)
+		assert.Equal(t, "arn:aws:sqs:us-east-1:000000000000:billing", provisioned.QueueARNs["billing"])
+		assert.Equal(t, "http://sqs.local/000000000000/shipping-eu", provisioned.QueueURLs["shipping-eu"])
+		assert.Len(t, provisioned.SubscriptionARNs, 3, "Should subscribe every queue to each of its topics")
+
+		billing := snsClient.subscriptions[provisioned.TopicARNs["orders"]+":"+provisioned.QueueARNs["billing"]]
+		require.NotNil(t, billing)
+		assert.Equal(t, "sqs", *billing.Protocol)
+		assert.Equal(t, map[string]string{"RawMessageDelivery": "true"}, billing.Attributes)
+
+		shipping := snsClient.subscriptions[provisioned.TopicARNs["orders"]+":"+provisioned.QueueARNs["shipping-eu"]]
+		require.NotNil(t, shipping)
+		assert.Equal(t, `{"region":["eu"]}`, shipping.Attributes["FilterPolicy"])
+		assert.Equal(t, "MessageAttributes", shipping.Attributes["FilterPolicyScope"])
+
+		var policy struct {
+			Statement []struct {
+				Resource  string
+				Condition map[string]map[string][]string
+			}
+		}
+		require.NoError(t, json.Unmarshal([]byte(sqsClient.queues[provisioned.QueueURLs["billing"]]["Policy"]), &policy))
+		require.Len(t, policy.Statement, 1)
+		assert.Equal(t, provisioned.QueueARNs["billing"], policy.Statement[0].Resource)
+		assert.Equal(t, []string{provisioned.TopicARNs["orders"], provisioned.TopicARNs["payments"]},
+			policy.Statement[0].Condition["ArnEquals"]["aws:SourceArn"], "Should allow every topic of the queue to send")
+	})
+
+	t.Run("Idempotent", func(t *testing.T) {
+		snsClient, sqsClient := newFakeSNS(), newFakeSQS()
+
+		first, err := Provision(ctx, snsClient, sqsClient, topology)
+		require.NoError(t, err)
+		second, err := Provision(ctx, snsClient, sqsClient, topology)
+		require.NoError(t, err)
+
+		assert.Equal(t, first, second)
+		assert.Len(t, snsClient.subscriptions, 3)
+		assert.Len(t, sqsClient.queues, 2)
+	})
+
+	t.Run("Validation", func(t *testing.T) {
+		for name, invalid := range map[string]Topology{
+			"Empty":         {},
+			"NoQueue":       {Subscriptions: []Subscription{{Topics: []string{"orders"}}}},
+			"NoTopics":      {Subscriptions: []Subscription{{Queue: "billing"}}},
+			"InvalidPolicy": {Subscriptions: []Subscription{{Queue: "billing", Topics: []string{"orders"}, FilterPolicy: "{region"}}},
+		} {
+			_, err := Provision(ctx, newFakeSNS(), newFakeSQS(), invalid)
+			assert.Error(t, err, name)
+		}
+	})
+}
+
+func TestProducer(t *testing.T) {
+	ctx := context.Background()
+	client := newFakeSNS()
+	producer := NewProducer(client, map[string]string{"orders": "arn:aws:sns:us-east-1:000000000000:orders"})
+
+	require.NoError(t, producer.Publish(ctx, "orders", []byte(`{"order_id":"o1"}`), map[string]string{"region": "eu"}))
+
+	require.Len(t, client.published, 1)
+	published := client.published[0]
+	assert.Equal(t, "arn:aws:sns:us-east-1:000000000000:orders", *published.TopicArn)
+	assert.Equal(t, `{"order_id":"o1"}`, *published.Message)
+	assert.Equal(t, "eu", *published.MessageAttributes["region"].StringValue, "Headers should be message attributes")
+	assert.Equal(t, "String", *published.MessageAttributes["region"].DataType)
+	assert.NotEmpty(t, *published.MessageAttributes[AttributeMessageID].StringValue)
+	assert.NotEmpty(t, *published.MessageAttributes[AttributeTimestamp].StringValue)
+
+	assert.ErrorIs(t, producer.Publish(ctx, "payments", []byte("p1"), nil), queue.ErrTopicNotFound)
+	assert.Nil(t, published.MessageAttributes[AttributeEncoding].StringValue, "Should send text payloads as is")
+
+	t.Run("BinaryPayload", func(t *testing.T) {
+		client := newFakeSNS()
+		producer := NewProducer(client, map[string]string{"orders": "arn:aws:sns:us-east-1:000000000000:orders"})
+
+		require.NoError(t, producer.Publish(ctx, "orders", []byte{0xff, 0xfe, 0x00}, nil))
+
+		require.Len(t, client.published, 1)
+		published := client.published[0]
+		assert.Equal(t, "//4A", *published.Message, "Should base64 encode binary payloads")
+		assert.Equal(t, EncodingBase64, *published.MessageAttributes[AttributeEncoding].StringValue)
+
+		payload, err := DecodePayload(*published.Message, *published.MessageAttributes[AttributeEncoding].StringValue)
+		require.NoError(t, err)
+		assert.Equal(t, []byte{0xff, 0xfe, 0x00}, payload)
+	})
+
+	t.Run("TooManyAttributes", func(t *testing.T) {
+		client := newFakeSNS()
+		producer := NewProducer(client, map[string]string{"orders": "arn:aws:sns:us-east-1:000000000000:orders"})
+
+		headers := make(map[string]string)
+		for i := 0; i < MaxHeaders; i++ {
+			headers[fmt.Sprint("h", i)] = "v"
+		}
+		require.NoError(t, producer.Publish(ctx, "orders", []byte("o2"), headers), "Should send as many headers as SNS carries")
+
+		err := producer.Publish(ctx, "orders", []byte{0xff}, headers)
+		assert.EqualError(t, err, "sns messages carry at most 10 attributes, 8 headers and the 3 attributes of the producer make 11",
+			"The encoding of a binary payload should take an attribute")
+
+		headers["h8"] = "v"
+		assert.Error(t, producer.Publish(ctx, "orders", []byte("o3"), headers), "Should reject more headers than SNS carries")
+		assert.Len(t, client.published, 1)
+	})
+
+	t.Run("ReservedHeader", func(t *testing.T) {
+		client := newFakeSNS()
+		producer := NewProducer(client, map[string]string{"orders": "arn:aws:sns:us-east-1:000000000000:orders"})
+
+		err := producer.Publish(ctx, "orders", []byte("o1"), map[string]string{AttributeEncoding: "gzip"})
+		assert.EqualError(t, err, "header queue-encoding is reserved by the producer")
+		assert.Empty(t, client.published)
+	})
+
+	t.Run("DecodePayload", func(t *testing.T) {
+		payload, err := DecodePayload("o1", "")
+		require.NoError(t, err)
+		assert.Equal(t, []byte("o1"), payload)
+
+		_, err = DecodePayload("o1", "gzip")
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,126 @@
+// Package fanout publishes queue messages to SNS topics and provisions the SNS topics, SQS queues and subscriptions
+// that give every consumer service a queue of its own receiving a copy of each message.
+//
+//	provisioned, err := fanout.Provision(ctx, snsClient, sqsClient, fanout.Topology{
+//		Subscriptions: []fanout.Subscription{
+//			{Queue: "billing", Topics: []string{"orders"}},
+//			{Queue: "shipping-eu", Topics: []string{"orders"}, FilterPolicy: `{"region":["eu"]}`},
+//		},
+//	})
+//	producer := fanout.NewProducer(snsClient, provisioned.TopicARNs)
+package fanout
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"time"
+	"unicode/utf8"
+
+	sdk "github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	"github.com/aws/aws-sdk-go-v2/service/sns/types"
+	"github.com/google/uuid"
+	"github.com/syl/Go/pkg/examples/queue"
+)
+
+const (
+	// AttributeMessageID and AttributeTimestamp carry the ID and timestamp of the message,
+	// the headers of the message are sent as the other message attributes
+	AttributeMessageID = "queue-message-id"
+	AttributeTimestamp = "queue-timestamp"
+	// AttributeEncoding is set to EncodingBase64 when the payload is not UTF-8 text and the SNS message holds it base64 encoded
+	AttributeEncoding = "queue-encoding"
+	EncodingBase64    = "base64"
+
+	// MaxAttributes is the number of message attributes SNS carries
+	MaxAttributes = 10
+	// MaxHeaders is the number of headers left once the ID and timestamp took two of the SNS message attributes,
+	// a binary payload takes one more for its encoding
+	MaxHeaders = MaxAttributes - 2
+)
+
+// ensure that Producer implements the Producer interface
+var _ queue.Producer = (*Producer)(nil)
+
+// SNSAPI is the subset of the SNS client used to publish messages
+type SNSAPI interface {
+	Publish(ctx context.Context, params *sns.PublishInput, optFns ...func(*sns.Options)) (*sns.PublishOutput, error)
+}
+
+// Producer implements the Producer interface by publishing to SNS topics
+// The payload is the SNS message and the headers are string message attributes, so subscription filter policies
+// can match them. Payloads that are not UTF-8 text are sent base64 encoded, see DecodePayload.
+// SNS messages hold at most 256 KiB, wrap the producer with a claimcheck.Producer for larger payloads.
+type Producer struct {
+	client SNSAPI
+	arns   map[string]string
+}
+
+// NewProducer creates a producer publishing to the SNS topics whose ARN is given per topic name (see Provisioned)
+func NewProducer(client SNSAPI, topicARNs map[string]string) *Producer {
+	arns := make(map[string]string, len(topicARNs))
+	for topic, arn := range topicARNs {
+		arns[topic] = arn
+	}
+	return &Producer{client: client, arns: arns}
+}
+
+// Publish sends a message to the SNS topic of the given name
+func (p *Producer) Publish(ctx context.Context, topic string, payload []byte, headers map[string]string) error {
+	arn, ok := p.arns[topic]
+	if !ok {
+		return fmt.Errorf("no sns topic for %s: %w", topic, queue.ErrTopicNotFound)
+	}
+
+	message := string(payload)
+	attributes := make(map[string]types.MessageAttributeValue, len(headers)+3)
+	if !utf8.Valid(payload) {
+		message = base64.StdEncoding.EncodeToString(payload)
+		attributes[AttributeEncoding] = stringAttribute(EncodingBase64)
+	}
+	attributes[AttributeMessageID] = stringAttribute(uuid.New().String())
+	attributes[AttributeTimestamp] = stringAttribute(time.Now().UTC().Format(time.RFC3339Nano))
+
+	if len(headers)+len(attributes) > MaxAttributes {
+		return fmt.Errorf("sns messages carry at most %d attributes, %d headers and the %d attributes of the producer make %d",
+			MaxAttributes, len(headers), len(attributes), len(headers)+len(attributes))
+	}
+	for key, value := range headers {
+		if key == AttributeMessageID || key == AttributeTimestamp || key == AttributeEncoding {
+			return fmt.Errorf("header %s is reserved by the producer", key)
+		}
+		attributes[key] = stringAttribute(value)
+	}
+
+	_, err := p.client.Publish(ctx, &sns.PublishInput{
+		TopicArn:          sdk.String(arn),
+		Message:           sdk.String(message),
+		MessageAttributes: attributes,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to publish to %s: %w", arn, err)
+	}
+	return nil
+}
+
+// Close closes the producer, the SNS client needs no cleanup
+func (p *Producer) Close() error {
+	return nil
+}
+
+// DecodePayload returns the payload of a message received from the SNS topic, given its AttributeEncoding attribute
+func DecodePayload(message, encoding string) ([]byte, error) {
+	switch encoding {
+	case "":
+		return []byte(message), nil
+	case EncodingBase64:
+		return base64.StdEncoding.DecodeString(message)
+	default:
+		return nil, fmt.Errorf("unsupported payload encoding %s", encoding)
+	}
+}
+
+func stringAttribute(value string) types.MessageAttributeValue {
+	return types.MessageAttributeValue{DataType: sdk.String("String"), StringValue: sdk.String(value)}
+}
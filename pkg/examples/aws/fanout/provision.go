@@ -0,0 +1,228 @@
+package fanout
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+
+	sdk "github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	sqstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// SNSAdminAPI is the subset of the SNS client used to create topics and subscriptions
+type SNSAdminAPI interface {
+	CreateTopic(ctx context.Context, params *sns.CreateTopicInput, optFns ...func(*sns.Options)) (*sns.CreateTopicOutput, error)
+	Subscribe(ctx context.Context, params *sns.SubscribeInput, optFns ...func(*sns.Options)) (*sns.SubscribeOutput, error)
+}
+
+// SQSAdminAPI is the subset of the SQS client used to create queues and allow SNS to send to them
+type SQSAdminAPI interface {
+	CreateQueue(ctx context.Context, params *sqs.CreateQueueInput, optFns ...func(*sqs.Options)) (*sqs.CreateQueueOutput, error)
+	GetQueueAttributes(ctx context.Context, params *sqs.GetQueueAttributesInput, optFns ...func(*sqs.Options)) (*sqs.GetQueueAttributesOutput, error)
+	SetQueueAttributes(ctx context.Context, params *sqs.SetQueueAttributesInput, optFns ...func(*sqs.Options)) (*sqs.SetQueueAttributesOutput, error)
+}
+
+// Topology lists the SNS topics and the queues of the consumer services subscribed to them
+type Topology struct {
+	// Topics are created even without subscriptions, the topics of subscriptions are created too
+	Topics        []string
+	Subscriptions []Subscription
+}
+
+// Subscription gives a consumer service an SQS queue receiving every message of the topics
+type Subscription struct {
+	// Queue is the name of the SQS queue of the service
+	Queue  string
+	Topics []string
+	// FilterPolicy is an optional SNS filter policy on the message attributes, e.g. {"region":["eu"]}
+	FilterPolicy string
+}
+
+// Provisioned holds the ARNs and URLs of the provisioned resources by name
+type Provisioned struct {
+	TopicARNs        map[string]string
+	QueueURLs        map[string]string
+	QueueARNs        map[string]string
+	SubscriptionARNs []string
+}
+
+// Provision creates the topics, queues and subscriptions of the topology unless they exist.
+// Subscriptions use raw message delivery, so the queues receive the payload and headers as published
+// instead of an SNS envelope, and each queue gets a policy allowing its topics to send to it.
+// Running it again with the same topology changes nothing.
+func Provision(ctx context.Context, snsClient SNSAdminAPI, sqsClient SQSAdminAPI, topology Topology) (*Provisioned, error) {
+	if err := topology.validate(); err != nil {
+		return nil, err
+	}
+
+	p := &Provisioned{
+		TopicARNs: make(map[string]string),
+		QueueURLs: make(map[string]string),
+		QueueARNs: make(map[string]string),
+	}
+
+	for _, topic := range topology.topics() {
+		output, err := snsClient.CreateTopic(ctx, &sns.CreateTopicInput{Name: sdk.String(topic)})
+		if err != nil {
+			return nil, fmt.Errorf("failed to create topic %s: %w", topic, err)
+		}
+		p.TopicARNs[topic] = sdk.ToString(output.TopicArn)
+	}
+
+	for _, sub := range topology.Subscriptions {
+		if err := p.provisionQueue(ctx, sqsClient, sub.Queue); err != nil {
+			return nil, err
+		}
+	}
+
+	for queueName, topics := range topology.queueTopics() {
+		if err := p.allowTopics(ctx, sqsClient, queueName, topics); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, sub := range topology.Subscriptions {
+		attributes := map[string]string{"RawMessageDelivery": "true"}
+		if sub.FilterPolicy != "" {
+			attributes["FilterPolicy"] = sub.FilterPolicy
+			attributes["FilterPolicyScope"] = "MessageAttributes"
+		}
+
+		for _, topic := range sub.Topics {
+			output, err := snsClient.Subscribe(ctx, &sns.SubscribeInput{
+				TopicArn:              sdk.String(p.TopicARNs[topic]),
+				Protocol:              sdk.String("sqs"),
+				Endpoint:              sdk.String(p.QueueARNs[sub.Queue]),
+				Attributes:            attributes,
+				ReturnSubscriptionArn: true,
+			})
+			if err != nil {
+				return nil, fmt.Errorf("failed to subscribe %s to %s: %w", sub.Queue, topic, err)
+			}
+			p.SubscriptionARNs = append(p.SubscriptionARNs, sdk.ToString(output.SubscriptionArn))
+		}
+	}
+
+	return p, nil
+}
+
+// provisionQueue creates a queue and records its URL and ARN
+func (p *Provisioned) provisionQueue(ctx context.Context, sqsClient SQSAdminAPI, name string) error {
+	if _, done := p.QueueURLs[name]; done {
+		return nil
+	}
+
+	created, err := sqsClient.CreateQueue(ctx, &sqs.CreateQueueInput{QueueName: sdk.String(name)})
+	if err != nil {
+		return fmt.Errorf("failed to create queue %s: %w", name, err)
+	}
+	url := sdk.ToString(created.QueueUrl)
+
+	attributes, err := sqsClient.GetQueueAttributes(ctx, &sqs.GetQueueAttributesInput{
+		QueueUrl:       sdk.String(url),
+		AttributeNames: []sqstypes.QueueAttributeName{sqstypes.QueueAttributeNameQueueArn},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to get the arn of queue %s: %w", name, err)
+	}
+
+	p.QueueURLs[name] = url
+	p.QueueARNs[name] = attributes.Attributes[string(sqstypes.QueueAttributeNameQueueArn)]
+	return nil
+}
+
+// allowTopics sets the policy of a queue allowing the topics to send messages to it
+func (p *Provisioned) allowTopics(ctx context.Context, sqsClient SQSAdminAPI, queueName string, topics []string) error {
+	sources := make([]string, 0, len(topics))
+	for _, topic := range topics {
+		sources = append(sources, p.TopicARNs[topic])
+	}
+
+	policy, err := json.Marshal(map[string]interface{}{
+		"Version": "2012-10-17",
+		"Statement": []map[string]interface{}{{
+			"Effect":    "Allow",
+			"Principal": map[string]string{"Service": "sns.amazonaws.com"},
+			"Action":    "sqs:SendMessage",
+			"Resource":  p.QueueARNs[queueName],
+			"Condition": map[string]interface{}{"ArnEquals": map[string][]string{"aws:SourceArn": sources}},
+		}},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal the policy of queue %s: %w", queueName, err)
+	}
+
+	_, err = sqsClient.SetQueueAttributes(ctx, &sqs.SetQueueAttributesInput{
+		QueueUrl:   sdk.String(p.QueueURLs[queueName]),
+		Attributes: map[string]string{string(sqstypes.QueueAttributeNamePolicy): string(policy)},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to set the policy of queue %s: %w", queueName, err)
+	}
+	return nil
+}
+
+// validate reports the first invalid subscription
+func (t Topology) validate() error {
+	for i, sub := range t.Subscriptions {
+		if sub.Queue == "" {
+			return fmt.Errorf("subscription %d has no queue", i)
+		}
+		if len(sub.Topics) == 0 {
+			return fmt.Errorf("subscription of %s has no topics", sub.Queue)
+		}
+		if sub.FilterPolicy != "" && !json.Valid([]byte(sub.FilterPolicy)) {
+			return fmt.Errorf("subscription of %s has an invalid filter policy", sub.Queue)
+		}
+	}
+	if len(t.Topics) == 0 && len(t.Subscriptions) == 0 {
+		return errors.New("topology is empty")
+	}
+	return nil
+}
+
+// topics returns the sorted names of every topic of the topology
+func (t Topology) topics() []string {
+	seen := make(map[string]bool)
+	for _, topic := range t.Topics {
+		seen[topic] = true
+	}
+	for _, sub := range t.Subscriptions {
+		for _, topic := range sub.Topics {
+			seen[topic] = true
+		}
+	}
+	return sortedKeys(seen)
+}
+
+// queueTopics returns the sorted topics each queue is subscribed to, a queue can appear in several subscriptions
+func (t Topology) queueTopics() map[string][]string {
+	seen := make(map[string]map[string]bool)
+	for _, sub := range t.Subscriptions {
+		if seen[sub.Queue] == nil {
+			seen[sub.Queue] = make(map[string]bool)
+		}
+		for _, topic := range sub.Topics {
+			seen[sub.Queue][topic] = true
+		}
+	}
+
+	queues := make(map[string][]string, len(seen))
+	for queueName, topics := range seen {
+		queues[queueName] = sortedKeys(topics)
+	}
+	return queues
+}
+
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
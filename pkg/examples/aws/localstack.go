@@ -4,6 +4,9 @@ import (
 	"context"
 	sdk "github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
 	"github.com/testcontainers/testcontainers-go"
 	"github.com/testcontainers/testcontainers-go/modules/localstack"
 	"log"
@@ -71,3 +74,20 @@ func (ls *Localstack) Terminate() {
 		log.Printf("failed to terminate container: %s", err)
 	}
 }
+
+// S3Client returns an S3 client configured for the LocalStack endpoint
+func (ls *Localstack) S3Client() *s3.Client {
+	return s3.NewFromConfig(ls.Config, func(o *s3.Options) {
+		o.UsePathStyle = true
+	})
+}
+
+// SNSClient returns an SNS client configured for the LocalStack endpoint
+func (ls *Localstack) SNSClient() *sns.Client {
+	return sns.NewFromConfig(ls.Config)
+}
+
+// SQSClient returns an SQS client configured for the LocalStack endpoint
+func (ls *Localstack) SQSClient() *sqs.Client {
+	return sqs.NewFromConfig(ls.Config)
+}
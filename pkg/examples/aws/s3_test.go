@@ -23,11 +23,7 @@ func TestS3(t *testing.T) {
 		t.Fatalf("failed to start LocalStack: %s", err)
 	}
 	defer localStack.Terminate()
-	//staticCredentials := aws.NewCredentialsCache(credentials.NewStaticCredentialsProvider("test", "test", ""))
-	s3Client := s3.NewFromConfig(localStack.Config, func(o *s3.Options) {
-		o.UsePathStyle = true
-		//o.Credentials = staticCredentials
-	})
+	s3Client := localStack.S3Client()
 
 	t.Run("Create bucket", func(t *testing.T) {
 		_, err = s3Client.CreateBucket(ctx, &s3.CreateBucketInput{
@@ -0,0 +1,70 @@
+package localstack
+
+import (
+	"context"
+	"sort"
+	"testing"
+	"time"
+
+	sdk "github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"src/pkg/examples/aws/fanout"
+)
+
+func TestSNSFanOut(t *testing.T) {
+	ctx := context.Background()
+
+	localStack, err := New()
+	if err != nil {
+		t.Fatalf("failed to start LocalStack: %s", err)
+	}
+	defer localStack.Terminate()
+
+	sqsClient := localStack.SQSClient()
+	provisioned, err := fanout.Provision(ctx, localStack.SNSClient(), sqsClient, fanout.Topology{
+		Subscriptions: []fanout.Subscription{
+			{Queue: "billing", Topics: []string{"orders"}},
+			{Queue: "analytics", Topics: []string{"orders"}},
+			{Queue: "shipping-eu", Topics: []string{"orders"}, FilterPolicy: `{"region":["eu"]}`},
+		},
+	})
+	require.NoError(t, err, "Should provision the topology")
+
+	producer := fanout.NewProducer(localStack.SNSClient(), provisioned.TopicARNs)
+	require.NoError(t, producer.Publish(ctx, "orders", []byte("o1"), map[string]string{"region": "eu"}))
+	require.NoError(t, producer.Publish(ctx, "orders", []byte("o2"), map[string]string{"region": "us"}))
+
+	// receive collects the raw bodies delivered to a queue until it holds n messages
+	receive := func(queueName string, n int) []string {
+		var bodies []string
+		require.Eventually(t, func() bool {
+			output, err := sqsClient.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{
+				QueueUrl:              sdk.String(provisioned.QueueURLs[queueName]),
+				MaxNumberOfMessages:   10,
+				MessageAttributeNames: []string{"All"},
+			})
+			require.NoError(t, err)
+			for _, msg := range output.Messages {
+				bodies = append(bodies, *msg.Body)
+				assert.Contains(t, msg.MessageAttributes, "region", "Raw delivery should keep the headers as attributes")
+			}
+			return len(bodies) >= n
+		}, 10*time.Second, 100*time.Millisecond, "Queue %s should receive %d messages", queueName, n)
+		sort.Strings(bodies)
+		return bodies
+	}
+
+	assert.Equal(t, []string{"o1", "o2"}, receive("billing", 2), "Every service should get every message")
+	assert.Equal(t, []string{"o1", "o2"}, receive("analytics", 2))
+	assert.Equal(t, []string{"o1"}, receive("shipping-eu", 1), "The filter policy should only let EU orders through")
+
+	attributes, err := sqsClient.GetQueueAttributes(ctx, &sqs.GetQueueAttributesInput{
+		QueueUrl:       sdk.String(provisioned.QueueURLs["shipping-eu"]),
+		AttributeNames: []types.QueueAttributeName{types.QueueAttributeNameApproximateNumberOfMessages},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "0", attributes.Attributes[string(types.QueueAttributeNameApproximateNumberOfMessages)])
+}
@@ -0,0 +1,195 @@
+package broker
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/syl/Go/pkg/examples/queue"
+)
+
+// BreakerState is the state of a CircuitBreaker
+type BreakerState int
+
+const (
+	// BreakerClosed lets every call through
+	BreakerClosed BreakerState = iota
+	// BreakerOpen rejects every call until the cooldown elapsed
+	BreakerOpen
+	// BreakerHalfOpen lets a single trial call through to decide whether to close or open again
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	default:
+		return fmt.Sprintf("BreakerState(%d)", int(s))
+	}
+}
+
+// BreakerEvent describes a state change of a CircuitBreaker
+type BreakerEvent struct {
+	// Name identifies the breaker, the topic of the subscription for consumer breakers
+	Name string
+	From BreakerState
+	To   BreakerState
+	// Err is the failure that opened the breaker, nil for other transitions
+	Err error
+	At  time.Time
+}
+
+// CircuitBreaker stops calls to a failing dependency after threshold consecutive failures
+// and lets a trial call through once cooldown elapsed.
+type CircuitBreaker struct {
+	mu            sync.Mutex
+	clock         queue.Clock
+	name          string
+	threshold     int
+	cooldown      time.Duration
+	onStateChange func(BreakerEvent)
+	state         BreakerState
+	failures      int
+	openedAt      time.Time
+	trial         bool
+}
+
+// NewCircuitBreaker creates a closed breaker, onStateChange is optional and called synchronously
+func NewCircuitBreaker(name string, threshold int, cooldown time.Duration, onStateChange func(BreakerEvent)) (*CircuitBreaker, error) {
+	return NewCircuitBreakerWithClock(queue.SystemClock, name, threshold, cooldown, onStateChange)
+}
+
+// NewCircuitBreakerWithClock creates a closed breaker measuring the cooldown with clock
+func NewCircuitBreakerWithClock(clock queue.Clock, name string, threshold int, cooldown time.Duration, onStateChange func(BreakerEvent)) (*CircuitBreaker, error) {
+	if threshold <= 0 {
+		return nil, fmt.Errorf("threshold must be positive, got %d", threshold)
+	}
+	if cooldown <= 0 {
+		return nil, fmt.Errorf("cooldown must be positive, got %v", cooldown)
+	}
+
+	return &CircuitBreaker{
+		clock:         clock,
+		name:          name,
+		threshold:     threshold,
+		cooldown:      cooldown,
+		onStateChange: onStateChange,
+	}, nil
+}
+
+// State returns the current state of the breaker
+func (b *CircuitBreaker) State() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.state
+}
+
+// Allow reports whether a call may proceed
+// An allowed call must be followed by Success, Failure or Abort.
+func (b *CircuitBreaker) Allow() bool {
+	b.mu.Lock()
+	var event *BreakerEvent
+	allowed := false
+
+	switch b.state {
+	case BreakerClosed:
+		allowed = true
+	case BreakerOpen:
+		if b.clock.Since(b.openedAt) >= b.cooldown {
+			event = b.transition(BreakerHalfOpen, nil)
+			b.trial = true
+			allowed = true
+		}
+	case BreakerHalfOpen:
+		if !b.trial {
+			b.trial = true
+			allowed = true
+		}
+	}
+	b.mu.Unlock()
+
+	b.notify(event)
+	return allowed
+}
+
+// Success records a successful call, closing the breaker after a successful trial
+func (b *CircuitBreaker) Success() {
+	b.mu.Lock()
+	var event *BreakerEvent
+
+	b.failures = 0
+	if b.state == BreakerHalfOpen {
+		b.trial = false
+		event = b.transition(BreakerClosed, nil)
+	}
+	b.mu.Unlock()
+
+	b.notify(event)
+}
+
+// Failure records a failed call, opening the breaker after threshold consecutive failures or a failed trial
+func (b *CircuitBreaker) Failure(err error) {
+	b.mu.Lock()
+	var event *BreakerEvent
+
+	b.failures++
+	switch {
+	case b.state == BreakerHalfOpen:
+		b.trial = false
+		event = b.transition(BreakerOpen, err)
+	case b.state == BreakerClosed && b.failures >= b.threshold:
+		event = b.transition(BreakerOpen, err)
+	}
+	b.mu.Unlock()
+
+	b.notify(event)
+}
+
+// Abort releases an allowed call that did not happen, such as a poll that found no message
+func (b *CircuitBreaker) Abort() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == BreakerHalfOpen {
+		b.trial = false
+	}
+}
+
+// transition changes the state and returns the event to notify once the lock is released
+func (b *CircuitBreaker) transition(to BreakerState, err error) *BreakerEvent {
+	event := &BreakerEvent{Name: b.name, From: b.state, To: to, Err: err, At: b.clock.Now()}
+
+	b.state = to
+	if to == BreakerOpen {
+		b.openedAt = event.At
+	}
+	if to == BreakerClosed {
+		b.failures = 0
+	}
+	return event
+}
+
+func (b *CircuitBreaker) notify(event *BreakerEvent) {
+	if event != nil && b.onStateChange != nil {
+		b.onStateChange(*event)
+	}
+}
+
+// BreakerState returns the state of the circuit breaker of the subscription
+// The boolean is false when the subscription does not exist or has no breaker.
+func (c *QueueConsumer) BreakerState(topic string) (BreakerState, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	sub, exists := c.subscriptions[topic]
+	if !exists || sub.breaker == nil {
+		return BreakerClosed, false
+	}
+	return sub.breaker.State(), true
+}
@@ -0,0 +1,150 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/syl/Go/pkg/examples/queue"
+	"github.com/syl/Go/pkg/examples/queue/inmemory/testutils"
+)
+
+// eventRecorder collects breaker state changes
+type eventRecorder struct {
+	mu     sync.Mutex
+	events []BreakerEvent
+}
+
+func (r *eventRecorder) record(event BreakerEvent) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.events = append(r.events, event)
+}
+
+func (r *eventRecorder) transitions() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	transitions := make([]string, 0, len(r.events))
+	for _, event := range r.events {
+		transitions = append(transitions, event.From.String()+"->"+event.To.String())
+	}
+	return transitions
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	errDown := errors.New("downstream is down")
+
+	t.Run("InvalidSettings", func(t *testing.T) {
+		_, err := NewCircuitBreaker("orders", 0, time.Second, nil)
+		assert.Error(t, err)
+		_, err = NewCircuitBreaker("orders", 1, 0, nil)
+		assert.Error(t, err)
+	})
+
+	t.Run("OpensAfterConsecutiveFailures", func(t *testing.T) {
+		recorder := &eventRecorder{}
+		breaker, err := NewCircuitBreaker("orders", 2, time.Hour, recorder.record)
+		require.NoError(t, err)
+
+		breaker.Failure(errDown)
+		breaker.Success()
+		breaker.Failure(errDown)
+		assert.Equal(t, BreakerClosed, breaker.State(), "A success should reset the failure count")
+
+		breaker.Failure(errDown)
+		assert.Equal(t, BreakerOpen, breaker.State())
+		assert.False(t, breaker.Allow(), "Open breaker should reject calls")
+
+		require.Len(t, recorder.events, 1)
+		assert.Equal(t, "orders", recorder.events[0].Name)
+		assert.Equal(t, errDown, recorder.events[0].Err, "Should report the failure that opened the breaker")
+	})
+
+	t.Run("HalfOpenTrial", func(t *testing.T) {
+		recorder := &eventRecorder{}
+		clock := testutils.NewFakeClock(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+		breaker, err := NewCircuitBreakerWithClock(clock, "orders", 1, 20*time.Millisecond, recorder.record)
+		require.NoError(t, err)
+
+		breaker.Failure(errDown)
+		clock.Advance(19 * time.Millisecond)
+		assert.False(t, breaker.Allow(), "Should reject calls until the cooldown elapsed")
+		clock.Advance(time.Millisecond)
+
+		assert.True(t, breaker.Allow(), "Should allow a trial after the cooldown")
+		assert.Equal(t, BreakerHalfOpen, breaker.State())
+		assert.False(t, breaker.Allow(), "Should allow a single trial at a time")
+
+		breaker.Failure(errDown)
+		assert.Equal(t, BreakerOpen, breaker.State(), "A failed trial should open the breaker again")
+
+		clock.Advance(20 * time.Millisecond)
+		require.True(t, breaker.Allow())
+		breaker.Abort()
+		require.True(t, breaker.Allow(), "An aborted trial should free the slot")
+		breaker.Success()
+
+		assert.Equal(t, BreakerClosed, breaker.State())
+		assert.Equal(t, []string{"closed->open", "open->half-open", "half-open->open", "open->half-open", "half-open->closed"}, recorder.transitions())
+	})
+}
+
+func TestConsumerCircuitBreaker(t *testing.T) {
+	t.Run("PausesAndResumes", func(t *testing.T) {
+		q := queue.NewMock()
+		fixture := NewBrokerTestFixture(t, q)
+		consumer := fixture.NewConsumer()
+
+		var healthy atomic.Bool
+		var calls, handled atomic.Int32
+		recorder := &eventRecorder{}
+		err := consumer.SubscribeWithOptions(fixture.Ctx, "orders", func(ctx context.Context, message *queue.Message) error {
+			calls.Add(1)
+			if !healthy.Load() {
+				return errors.New("downstream is down")
+			}
+			handled.Add(1)
+			return nil
+		}, WithCircuitBreaker(2, 300*time.Millisecond, recorder.record), WithDeadLetter())
+		require.NoError(t, err)
+
+		fixture.PublishMessages("orders", []string{"o1", "o2", "o3", "o4", "o5"})
+
+		fixture.Eventually(func() bool {
+			state, ok := consumer.BreakerState("orders")
+			return ok && state == BreakerOpen
+		}, "Breaker should open after two failures")
+
+		fixture.Clock.Advance(150 * time.Millisecond)
+		assert.Equal(t, int32(2), calls.Load(), "Open breaker should stop dequeuing")
+		fixture.AssertQueueSize("orders", 3, "Messages should stay in the queue while open")
+
+		healthy.Store(true)
+		fixture.Eventually(func() bool { return handled.Load() == 3 }, "Should resume after a successful trial")
+
+		state, _ := consumer.BreakerState("orders")
+		assert.Equal(t, BreakerClosed, state)
+		assert.Equal(t, []string{"closed->open", "open->half-open", "half-open->closed"}, recorder.transitions())
+		fixture.AssertQueueSize(DeadLetterTopic("orders"), 2, "Failed messages should still be dead-lettered")
+	})
+
+	t.Run("InvalidSettings", func(t *testing.T) {
+		q := queue.NewMock()
+		consumer := NewBrokerTestFixture(t, q).NewConsumer()
+
+		err := consumer.SubscribeWithOptions(context.Background(), "orders", func(ctx context.Context, message *queue.Message) error {
+			return nil
+		}, WithCircuitBreaker(0, time.Second, nil))
+		assert.Error(t, err)
+
+		_, ok := consumer.BreakerState("orders")
+		assert.False(t, ok, "Should not report a breaker for unknown subscriptions")
+	})
+}
@@ -1,8 +1,12 @@
 package broker
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"log"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -10,20 +14,19 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/syl/Go/pkg/examples/queue"
+	"github.com/syl/Go/pkg/examples/queue/inmemory"
+	"github.com/syl/Go/pkg/examples/queue/inmemory/testutils"
 )
 
-const (
-	// ConsumerStartupDelay allows consumers to start processing
-	ConsumerStartupDelay = 200 * time.Millisecond
-	// DefaultTestTimeout for broker test assertions
-	DefaultTestTimeout = 5 * time.Second
-)
+// DefaultTestTimeout for broker test assertions
+const DefaultTestTimeout = 5 * time.Second
 
 // BrokerTestFixture provides test infrastructure for broker testing
 type BrokerTestFixture struct {
 	Queue    queue.Queue
 	Producer queue.Producer
 	Consumer queue.Consumer
+	Clock    *testutils.FakeClock
 	Ctx      context.Context
 	T        *testing.T
 }
@@ -32,8 +35,9 @@ type BrokerTestFixture struct {
 func NewBrokerTestFixture(t *testing.T, q queue.Queue) *BrokerTestFixture {
 	t.Helper()
 
+	clock := testutils.NewFakeClock(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
 	producer := NewQueueProducer(q)
-	consumer := NewQueueConsumer(q)
+	consumer := NewQueueConsumer(q, WithClock(clock))
 	ctx := context.Background()
 
 	t.Cleanup(func() {
@@ -46,11 +50,28 @@ func NewBrokerTestFixture(t *testing.T, q queue.Queue) *BrokerTestFixture {
 		Queue:    q,
 		Producer: producer,
 		Consumer: consumer,
+		Clock:    clock,
 		Ctx:      ctx,
 		T:        t,
 	}
 }
 
+// NewConsumer creates a consumer driven by the fixture clock, closed when the test ends
+func (f *BrokerTestFixture) NewConsumer(opts ...ConsumerOption) *QueueConsumer {
+	f.T.Helper()
+
+	consumer := NewQueueConsumer(f.Queue, append([]ConsumerOption{WithClock(f.Clock)}, opts...)...)
+	f.T.Cleanup(func() { consumer.Close() })
+	return consumer
+}
+
+// Eventually advances the fixture clock one poll interval at a time until condition holds
+func (f *BrokerTestFixture) Eventually(condition func() bool, msgAndArgs ...interface{}) {
+	f.T.Helper()
+
+	f.Clock.AdvanceUntil(f.T, PollInterval, condition, msgAndArgs...)
+}
+
 // AssertQueueSize verifies the queue size for a topic
 func (f *BrokerTestFixture) AssertQueueSize(topic string, expectedSize int, msgAndArgs ...interface{}) {
 	f.T.Helper()
@@ -70,24 +91,107 @@ func (f *BrokerTestFixture) PublishMessages(topic string, messages []string) {
 	}
 }
 
-// AssertMessagesReceived waits for and validates received messages
-func (f *BrokerTestFixture) AssertMessagesReceived(msgChan <-chan *queue.Message, expectedCount int, timeout time.Duration) {
+// AssertMessagesReceived advances the fixture clock until the expected number of messages was received
+func (f *BrokerTestFixture) AssertMessagesReceived(msgChan <-chan *queue.Message, expectedCount int) {
 	f.T.Helper()
 
 	receivedCount := 0
-	timeoutChan := time.After(timeout)
-
-	for receivedCount < expectedCount {
-		select {
-		case msg := <-msgChan:
-			f.T.Logf("Received message: %s", string(msg.Payload))
-			receivedCount++
-		case <-timeoutChan:
-			f.T.Fatalf("Timeout waiting for messages, received %d of %d", receivedCount, expectedCount)
+	f.Eventually(func() bool {
+		for receivedCount < expectedCount {
+			select {
+			case msg := <-msgChan:
+				f.T.Logf("Received message: %s", string(msg.Payload))
+				receivedCount++
+			default:
+				return false
+			}
+		}
+		return true
+	}, "Timeout waiting for %d messages", expectedCount)
+}
+
+// rejectingQueue hides the Inspector and Selector of the wrapped queue and rejects enqueues to some topics,
+// like a full topic or a draining queue
+type rejectingQueue struct {
+	queue.Queue
+
+	mu       sync.Mutex
+	rejected map[string]error
+	attempts map[string]int
+}
+
+func (q *rejectingQueue) reject(topic string, err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.rejected == nil {
+		q.rejected = make(map[string]error)
+	}
+	q.rejected[topic] = err
+}
+
+func (q *rejectingQueue) Enqueue(ctx context.Context, topic string, message *queue.Message) error {
+	q.mu.Lock()
+	err := q.rejected[topic]
+	if err != nil {
+		if q.attempts == nil {
+			q.attempts = make(map[string]int)
 		}
+		q.attempts[topic]++
+	}
+	q.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return q.Queue.Enqueue(ctx, topic, message)
+}
+
+// rejections returns how many enqueues to the topic were rejected
+func (q *rejectingQueue) rejections(topic string) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.attempts[topic]
+}
+
+// loopingQueue sends the messages enqueued to one topic to another one, like a consumer dead-lettering
+// every message it receives
+type loopingQueue struct {
+	queue.Queue
+	from, to string
+}
+
+func (q *loopingQueue) Enqueue(ctx context.Context, topic string, message *queue.Message) error {
+	if topic == q.from {
+		topic = q.to
 	}
+	return q.Queue.Enqueue(ctx, topic, message)
+}
+
+// syncBuffer collects the consumer logs written by the subscription goroutines
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) Contains(substr string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return strings.Contains(b.buf.String(), substr)
+}
 
-	assert.Equal(f.T, expectedCount, receivedCount, "Should receive expected number of messages")
+// isClosed reports whether the channel is closed without blocking
+func isClosed(c <-chan struct{}) bool {
+	select {
+	case <-c:
+		return true
+	default:
+		return false
+	}
 }
 
 func TestBroker(t *testing.T) {
@@ -129,12 +233,10 @@ func TestBroker(t *testing.T) {
 			err := fixture.Consumer.Subscribe(fixture.Ctx, topic, handler)
 			require.NoError(t, err, "Should subscribe successfully")
 
-			time.Sleep(ConsumerStartupDelay)
-
 			testMessages := []string{"message1", "message2", "message3"}
 			fixture.PublishMessages(topic, testMessages)
 
-			fixture.AssertMessagesReceived(receivedMessages, len(testMessages), DefaultTestTimeout)
+			fixture.AssertMessagesReceived(receivedMessages, len(testMessages))
 
 			err = fixture.Consumer.Unsubscribe(fixture.Ctx, topic)
 			require.NoError(t, err, "Should unsubscribe successfully")
@@ -164,18 +266,14 @@ func TestBroker(t *testing.T) {
 			err = fixture.Consumer.Subscribe(fixture.Ctx, topic2, handler2)
 			require.NoError(t, err, "Should subscribe to topic2")
 
-			time.Sleep(ConsumerStartupDelay)
-
 			err = fixture.Producer.Publish(fixture.Ctx, topic1, []byte("message for topic1"), nil)
 			require.NoError(t, err, "Should publish to topic1")
 
 			err = fixture.Producer.Publish(fixture.Ctx, topic2, []byte("message for topic2"), nil)
 			require.NoError(t, err, "Should publish to topic2")
 
-			timeout := time.After(DefaultTestTimeout)
 			receivedTopic1, receivedTopic2 := false, false
-
-			for !receivedTopic1 || !receivedTopic2 {
+			fixture.Eventually(func() bool {
 				select {
 				case msg := <-topic1Messages:
 					t.Logf("Received from topic1: %s", string(msg.Payload))
@@ -183,10 +281,10 @@ func TestBroker(t *testing.T) {
 				case msg := <-topic2Messages:
 					t.Logf("Received from topic2: %s", string(msg.Payload))
 					receivedTopic2 = true
-				case <-timeout:
-					t.Fatalf("Timeout waiting for messages. Topic1: %v, Topic2: %v", receivedTopic1, receivedTopic2)
+				default:
 				}
-			}
+				return receivedTopic1 && receivedTopic2
+			}, "Timeout waiting for messages from both topics")
 
 			assert.True(t, receivedTopic1, "Should receive message from topic1")
 			assert.True(t, receivedTopic2, "Should receive message from topic2")
@@ -204,10 +302,8 @@ func TestBroker(t *testing.T) {
 
 			consumers := make([]*QueueConsumer, numConsumers)
 			for i := 0; i < numConsumers; i++ {
-				consumers[i] = NewQueueConsumer(fixture.Queue)
-				consumerRef := consumers[i]
-				t.Cleanup(func() { consumerRef.Close() })
-				
+				consumers[i] = fixture.NewConsumer()
+
 				handler := func(ctx context.Context, message *queue.Message) error {
 					allMessages <- message
 					return nil
@@ -217,8 +313,6 @@ func TestBroker(t *testing.T) {
 				require.NoError(t, err, "Should subscribe consumer %d", i)
 			}
 
-			time.Sleep(ConsumerStartupDelay)
-
 			wg.Add(1)
 			go func() {
 				defer wg.Done()
@@ -230,7 +324,497 @@ func TestBroker(t *testing.T) {
 
 			wg.Wait()
 
-			fixture.AssertMessagesReceived(allMessages, numMessages, 10*time.Second)
+			fixture.AssertMessagesReceived(allMessages, numMessages)
+		})
+
+		t.Run("WildcardSubscription", func(t *testing.T) {
+			q := queue.NewMock()
+			fixture := NewBrokerTestFixture(t, q)
+			received := make(chan *queue.Message, 10)
+
+			handler := func(ctx context.Context, message *queue.Message) error {
+				received <- message
+				return nil
+			}
+
+			fixture.PublishMessages("orders.created", []string{"existing"})
+
+			err := fixture.Consumer.Subscribe(fixture.Ctx, "orders.*", handler)
+			require.NoError(t, err, "Should subscribe to pattern")
+
+			fixture.PublishMessages("orders.shipped", []string{"new topic"})
+			fixture.PublishMessages("payments.created", []string{"other topic"})
+
+			topics := make(map[string]string)
+			fixture.Eventually(func() bool {
+				select {
+				case msg := <-received:
+					topics[msg.Topic] = string(msg.Payload)
+				default:
+				}
+				return len(topics) == 2
+			}, "Timeout waiting for pattern messages")
+
+			assert.Equal(t, "existing", topics["orders.created"], "Should consume from existing matching topic")
+			assert.Equal(t, "new topic", topics["orders.shipped"], "Should pick up newly created matching topic")
+			fixture.AssertQueueSize("payments.created", 1, "Should not consume from non-matching topic")
+
+			err = fixture.Consumer.Unsubscribe(fixture.Ctx, "orders.*")
+			require.NoError(t, err, "Should unsubscribe from pattern")
+		})
+
+		t.Run("GlobSubscription", func(t *testing.T) {
+			q := queue.NewMock()
+			fixture := NewBrokerTestFixture(t, q)
+			received := make(chan *queue.Message, 10)
+
+			handler := func(ctx context.Context, message *queue.Message) error {
+				received <- message
+				return nil
+			}
+
+			err := fixture.Consumer.Subscribe(fixture.Ctx, "glob:orders-*", handler)
+			require.NoError(t, err, "Should subscribe to glob pattern")
+
+			fixture.PublishMessages("orders-eu", []string{"eu order"})
+			fixture.PublishMessages("orders-us", []string{"us order"})
+
+			fixture.AssertMessagesReceived(received, 2)
+		})
+
+		t.Run("FilteredSubscription", func(t *testing.T) {
+			q := queue.NewMock()
+			fixture := NewBrokerTestFixture(t, q)
+			orders := make(chan *queue.Message, 10)
+			others := make(chan *queue.Message, 10)
+
+			consumer := fixture.NewConsumer()
+			err := consumer.SubscribeWithOptions(fixture.Ctx, "events", func(ctx context.Context, message *queue.Message) error {
+				orders <- message
+				return nil
+			}, WithFilter("message_type = 'order' AND source != 'test'"))
+			require.NoError(t, err, "Should subscribe with filter")
+
+			other := fixture.NewConsumer()
+			err = other.SubscribeWithOptions(fixture.Ctx, "events", func(ctx context.Context, message *queue.Message) error {
+				others <- message
+				return nil
+			}, WithFilter("NOT (message_type = 'order' AND source != 'test')"))
+			require.NoError(t, err, "Should subscribe second consumer with filter")
+
+			err = fixture.Producer.Publish(fixture.Ctx, "events", []byte("order"), map[string]string{"message_type": "order", "source": "shop"})
+			require.NoError(t, err)
+			err = fixture.Producer.Publish(fixture.Ctx, "events", []byte("test order"), map[string]string{"message_type": "order", "source": "test"})
+			require.NoError(t, err)
+			err = fixture.Producer.Publish(fixture.Ctx, "events", []byte("payment"), map[string]string{"message_type": "payment"})
+			require.NoError(t, err)
+
+			fixture.AssertMessagesReceived(orders, 1)
+			fixture.AssertMessagesReceived(others, 2)
+		})
+
+		t.Run("FilterSkipsNonMatchingMessages", func(t *testing.T) {
+			q := inmemory.NewInMemoryQueue(inmemory.WithClock(testutils.NewFakeClock(time.Now())))
+			fixture := NewBrokerTestFixture(t, q)
+			received := make(chan *queue.Message, 10)
+
+			consumer := fixture.NewConsumer()
+			err := consumer.SubscribeWithOptions(fixture.Ctx, "events", func(ctx context.Context, message *queue.Message) error {
+				received <- message
+				return nil
+			}, WithFilter("message_type = 'order'"))
+			require.NoError(t, err)
+
+			require.NoError(t, fixture.Producer.Publish(fixture.Ctx, "events", []byte("payment"), map[string]string{"message_type": "payment"}))
+			require.NoError(t, fixture.Producer.Publish(fixture.Ctx, "events", []byte("refund"), map[string]string{"message_type": "refund"}))
+			require.NoError(t, fixture.Producer.Publish(fixture.Ctx, "events", []byte("order"), map[string]string{"message_type": "order"}))
+			fixture.AssertMessagesReceived(received, 1)
+
+			head, err := q.Peek(fixture.Ctx, "events", 3)
+			require.NoError(t, err)
+			require.Len(t, head, 2, "Only the order should be taken")
+			assert.Equal(t, []byte("payment"), head[0].Payload, "Non-matching messages should stay in place for another subscriber")
+			assert.Equal(t, []byte("refund"), head[1].Payload)
+
+			// The queue starts draining, nothing is put back so nothing can be rejected
+			shutdownCtx, cancel := context.WithCancel(fixture.Ctx)
+			shutdown := make(chan struct{})
+			go func() {
+				defer close(shutdown)
+				q.Shutdown(shutdownCtx)
+			}()
+			for i := 0; i < 10; i++ {
+				fixture.Clock.Advance(PollInterval)
+			}
+			fixture.AssertQueueSize("events", 2, "Should not lose messages while the queue drains")
+			cancel()
+			<-shutdown
+		})
+
+		t.Run("FilterWithoutSelector", func(t *testing.T) {
+			q := &rejectingQueue{Queue: queue.NewMock()}
+			fixture := NewBrokerTestFixture(t, q)
+			received := make(chan *queue.Message, 10)
+
+			consumer := fixture.NewConsumer()
+			err := consumer.SubscribeWithOptions(fixture.Ctx, "events", func(ctx context.Context, message *queue.Message) error {
+				received <- message
+				return nil
+			}, WithFilter("message_type = 'order'"))
+			require.NoError(t, err)
+
+			for i := 0; i < FilterScanLimit; i++ {
+				require.NoError(t, fixture.Producer.Publish(fixture.Ctx, "events", []byte("payment"), map[string]string{"message_type": "payment"}))
+			}
+			require.NoError(t, fixture.Producer.Publish(fixture.Ctx, "events", []byte("order"), map[string]string{"message_type": "order"}))
+
+			mock := q.Queue.(*queue.Mock)
+			fixture.Clock.BlockUntil(1)
+			fixture.Clock.Advance(PollInterval)
+			require.Eventually(t, func() bool {
+				head, err := mock.Peek(fixture.Ctx, "events", 1)
+				return err == nil && len(head) == 1 && string(head[0].Payload) == "order"
+			}, time.Second, time.Millisecond, "The first poll should move the skipped messages behind the order")
+			assert.Empty(t, received, "Should skip at most FilterScanLimit messages per poll")
+
+			var order *queue.Message
+			fixture.Eventually(func() bool {
+				select {
+				case order = <-received:
+					return true
+				default:
+					return false
+				}
+			}, "Should reach the order on the next poll")
+			assert.Equal(t, []byte("order"), order.Payload)
+			fixture.AssertQueueSize("events", FilterScanLimit, "Skipped messages should be put back")
+		})
+
+		t.Run("FilterPutBackFails", func(t *testing.T) {
+			q := &rejectingQueue{Queue: queue.NewMock()}
+			fixture := NewBrokerTestFixture(t, q)
+			received := make(chan *queue.Message, 10)
+
+			consumer := fixture.NewConsumer()
+			err := consumer.SubscribeWithOptions(fixture.Ctx, "events", func(ctx context.Context, message *queue.Message) error {
+				received <- message
+				return nil
+			}, WithFilter("message_type = 'order'"))
+			require.NoError(t, err)
+
+			require.NoError(t, fixture.Producer.Publish(fixture.Ctx, "events", []byte("payment"), map[string]string{"message_type": "payment"}))
+			q.reject("events", errors.New("topic events is full"))
+
+			fixture.Eventually(func() bool {
+				size, err := q.Size(fixture.Ctx, DeadLetterTopic("events"))
+				return err == nil && size == 1
+			}, "A message the topic does not take back should be dead-lettered")
+			assert.Empty(t, received)
+
+			msg, err := q.Dequeue(fixture.Ctx, DeadLetterTopic("events"))
+			require.NoError(t, err)
+			require.NotNil(t, msg)
+			assert.Equal(t, []byte("payment"), msg.Payload)
+			assert.Equal(t, "failed to put back message rejected by the filter: topic events is full", msg.Headers[HeaderDeadLetterReason])
+		})
+
+		t.Run("InvalidFilter", func(t *testing.T) {
+			q := queue.NewMock()
+			fixture := NewBrokerTestFixture(t, q)
+
+			consumer := fixture.NewConsumer()
+			err := consumer.SubscribeWithOptions(fixture.Ctx, "events", func(ctx context.Context, message *queue.Message) error {
+				return nil
+			}, WithFilter("message_type ="))
+			assert.Error(t, err, "Should reject invalid filter expression")
+		})
+
+		t.Run("DeadLetter", func(t *testing.T) {
+			q := queue.NewMock()
+			fixture := NewBrokerTestFixture(t, q)
+			handled := make(chan *queue.Message, 10)
+
+			consumer := fixture.NewConsumer()
+			err := consumer.SubscribeWithOptions(fixture.Ctx, "orders", func(ctx context.Context, message *queue.Message) error {
+				defer func() { handled <- message }()
+				if string(message.Payload) == "bad" {
+					return fmt.Errorf("cannot process %s", message.Payload)
+				}
+				return nil
+			}, WithDeadLetter())
+			require.NoError(t, err, "Should subscribe with dead letter")
+
+			fixture.PublishMessages("orders", []string{"good", "bad"})
+			fixture.AssertMessagesReceived(handled, 2)
+
+			fixture.Eventually(func() bool {
+				size, err := q.Size(fixture.Ctx, DeadLetterTopic("orders"))
+				return err == nil && size == 1
+			}, "Failed message should be dead-lettered")
+
+			require.NoError(t, consumer.Unsubscribe(fixture.Ctx, "orders"))
+
+			msg, err := q.Dequeue(fixture.Ctx, "orders.dlq")
+			require.NoError(t, err)
+			require.NotNil(t, msg)
+			assert.Equal(t, []byte("bad"), msg.Payload, "Dead letter should hold the failed message")
+			assert.Equal(t, "cannot process bad", msg.Headers[HeaderDeadLetterReason], "Should record the failure reason")
+		})
+
+		t.Run("DeadLetterFails", func(t *testing.T) {
+			q := &rejectingQueue{Queue: queue.NewMock()}
+			fixture := NewBrokerTestFixture(t, q)
+			logs := &syncBuffer{}
+			handled := make(chan *queue.Message, 10)
+
+			consumer := fixture.NewConsumer(WithLogger(log.New(logs, "", 0)))
+			err := consumer.SubscribeWithOptions(fixture.Ctx, "orders", func(ctx context.Context, message *queue.Message) error {
+				handled <- message
+				return fmt.Errorf("cannot process %s", message.Payload)
+			}, WithDeadLetter())
+			require.NoError(t, err)
+
+			q.reject(DeadLetterTopic("orders"), errors.New("topic orders.dlq is full"))
+			fixture.PublishMessages("orders", []string{"bad"})
+			fixture.AssertMessagesReceived(handled, 2)
+			assert.True(t, logs.Contains("Failed to dead-letter message"), "Should log the failure")
+
+			// Once the dead-letter topic takes messages again, the message is moved there
+			q.reject(DeadLetterTopic("orders"), nil)
+			fixture.Eventually(func() bool {
+				size, err := q.Size(fixture.Ctx, DeadLetterTopic("orders"))
+				return err == nil && size == 1
+			}, "The message put back should be dead-lettered on a later failure")
+		})
+
+		t.Run("RequeueFails", func(t *testing.T) {
+			q := &rejectingQueue{Queue: queue.NewMock()}
+			fixture := NewBrokerTestFixture(t, q)
+			logs := &syncBuffer{}
+
+			consumer := fixture.NewConsumer(WithLogger(log.New(logs, "", 0)))
+			err := consumer.SubscribeWithOptions(fixture.Ctx, "orders", func(ctx context.Context, message *queue.Message) error {
+				return errors.New("cannot process")
+			}, WithDeadLetter())
+			require.NoError(t, err)
+
+			require.NoError(t, q.Enqueue(fixture.Ctx, "orders", &queue.Message{ID: "bad", Payload: []byte("bad")}))
+			q.reject("orders", errors.New("queue is shutting down"))
+			q.reject(DeadLetterTopic("orders"), errors.New("queue is shutting down"))
+
+			fixture.Eventually(func() bool {
+				return logs.Contains("Lost message bad of orders")
+			}, "Should log the message as lost once every attempt failed")
+			assert.Equal(t, RequeueAttempts, q.rejections(DeadLetterTopic("orders")), "Should retry the dead-letter topic")
+			assert.Equal(t, RequeueAttempts, q.rejections("orders"), "Should retry putting the message back")
 		})
+
+		t.Run("Retry", func(t *testing.T) {
+			q := queue.NewMock()
+			fixture := NewBrokerTestFixture(t, q)
+			var mu sync.Mutex
+			attempts := make(map[string][]time.Time)
+
+			consumer := fixture.NewConsumer()
+			err := consumer.SubscribeWithOptions(fixture.Ctx, "orders", func(ctx context.Context, message *queue.Message) error {
+				mu.Lock()
+				defer mu.Unlock()
+				payload := string(message.Payload)
+				attempts[payload] = append(attempts[payload], fixture.Clock.Now())
+				if payload == "bad" || len(attempts[payload]) < 2 {
+					return fmt.Errorf("cannot process %s", payload)
+				}
+				return nil
+			}, WithRetry(3, time.Second), WithDeadLetter())
+			require.NoError(t, err, "Should subscribe with retries")
+
+			fixture.PublishMessages("orders", []string{"flaky", "bad"})
+			fixture.Eventually(func() bool {
+				size, err := q.Size(fixture.Ctx, DeadLetterTopic("orders"))
+				return err == nil && size == 1
+			}, "Message failing every attempt should be dead-lettered")
+
+			mu.Lock()
+			defer mu.Unlock()
+			assert.Len(t, attempts["flaky"], 2, "Should stop retrying after a success")
+			require.Len(t, attempts["bad"], 3, "Should make every attempt")
+			assert.GreaterOrEqual(t, attempts["bad"][1].Sub(attempts["bad"][0]), time.Second)
+			assert.GreaterOrEqual(t, attempts["bad"][2].Sub(attempts["bad"][1]), 2*time.Second, "Should double the backoff")
+
+			err = consumer.SubscribeWithOptions(fixture.Ctx, "payments", func(ctx context.Context, message *queue.Message) error {
+				return nil
+			}, WithRetry(-1, time.Second))
+			assert.Error(t, err, "Should reject a negative retry policy")
+		})
+
+		t.Run("InvalidPattern", func(t *testing.T) {
+			q := queue.NewMock()
+			fixture := NewBrokerTestFixture(t, q)
+
+			err := fixture.Consumer.Subscribe(fixture.Ctx, "glob:[", func(ctx context.Context, message *queue.Message) error {
+				return nil
+			})
+			assert.Error(t, err, "Should reject malformed glob pattern")
+		})
+	})
+}
+
+func TestRedrive(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("MovesMessages", func(t *testing.T) {
+		q := queue.NewMock()
+		defer q.Close()
+
+		for _, payload := range []string{"a", "b", "c"} {
+			msg := &queue.Message{ID: payload, Topic: "orders.dlq", Payload: []byte(payload),
+				Headers: map[string]string{HeaderDeadLetterReason: "failed"}}
+			require.NoError(t, q.Enqueue(ctx, "orders.dlq", msg))
+		}
+
+		moved, err := Redrive(ctx, q, "orders.dlq", "orders", 2)
+		require.NoError(t, err, "Should redrive messages")
+		assert.Equal(t, 2, moved, "Should move at most max messages")
+
+		moved, err = Redrive(ctx, q, "orders.dlq", "orders", 0)
+		require.NoError(t, err, "Should redrive remaining messages")
+		assert.Equal(t, 1, moved, "Should move every remaining message")
+
+		msg, err := q.Dequeue(ctx, "orders")
+		require.NoError(t, err)
+		require.NotNil(t, msg)
+		assert.Equal(t, "a", msg.ID, "Should keep FIFO order")
+		assert.Equal(t, "orders", msg.Topic, "Should update the topic")
+		assert.NotContains(t, msg.Headers, HeaderDeadLetterReason, "Should clear the failure reason")
+	})
+
+	t.Run("StopsAtInitialSize", func(t *testing.T) {
+		q := &loopingQueue{Queue: queue.NewMock(), from: "orders", to: "orders.dlq"}
+		defer q.Close()
+		for _, payload := range []string{"a", "b"} {
+			require.NoError(t, q.Enqueue(ctx, "orders.dlq", &queue.Message{ID: payload, Payload: []byte(payload)}))
+		}
+
+		moved, err := Redrive(ctx, q, "orders.dlq", "orders", 0)
+		require.NoError(t, err, "Should not loop on messages failing again")
+		assert.Equal(t, 2, moved)
+
+		size, err := q.Size(ctx, "orders.dlq")
+		require.NoError(t, err)
+		assert.Equal(t, 2, size)
+	})
+
+	t.Run("EnqueueFailure", func(t *testing.T) {
+		q := &rejectingQueue{Queue: queue.NewMock()}
+		defer q.Close()
+		msg := &queue.Message{ID: "a", Topic: "orders.dlq", Payload: []byte("a"), Headers: map[string]string{HeaderDeadLetterReason: "failed"}}
+		require.NoError(t, q.Enqueue(ctx, "orders.dlq", msg))
+		q.reject("orders", errors.New("topic orders is full"))
+
+		moved, err := Redrive(ctx, q, "orders.dlq", "orders", 0)
+		assert.Zero(t, moved)
+		assert.EqualError(t, err, "failed to enqueue to orders: topic orders is full")
+
+		back, err := q.Dequeue(ctx, "orders.dlq")
+		require.NoError(t, err)
+		require.NotNil(t, back, "The message should be put back on the source topic")
+		assert.Equal(t, "orders.dlq", back.Topic)
+		assert.Equal(t, "failed", back.Headers[HeaderDeadLetterReason], "Should keep the failure reason")
+
+		require.NoError(t, q.Enqueue(ctx, "orders.dlq", back))
+		q.reject("orders.dlq", errors.New("queue is closed"))
+		_, err = Redrive(ctx, q, "orders.dlq", "orders", 0)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "failed to put message a back on orders.dlq: queue is closed", "Should report the lost message")
+	})
+
+	t.Run("DeadLetterTopic", func(t *testing.T) {
+		assert.Equal(t, "orders.dlq", DeadLetterTopic("orders"))
+		assert.True(t, IsDeadLetterTopic("orders.dlq"))
+		assert.False(t, IsDeadLetterTopic("orders"))
+	})
+}
+
+func TestShutdown(t *testing.T) {
+	t.Run("ProducerRejectsNewPublishes", func(t *testing.T) {
+		q := queue.NewMock()
+		defer q.Close()
+		producer := NewQueueProducer(q)
+
+		report, err := producer.Shutdown(context.Background())
+		require.NoError(t, err)
+		assert.Zero(t, report.Interrupted)
+
+		assert.Error(t, producer.Publish(context.Background(), "orders", []byte("late"), nil), "Should reject publishes after shutdown")
+	})
+
+	t.Run("ConsumerFinishesInFlightHandler", func(t *testing.T) {
+		q := queue.NewMock()
+		fixture := NewBrokerTestFixture(t, q)
+		started := make(chan struct{})
+		var finished bool
+
+		err := fixture.Consumer.Subscribe(fixture.Ctx, "orders", func(ctx context.Context, message *queue.Message) error {
+			close(started)
+			time.Sleep(200 * time.Millisecond)
+			finished = ctx.Err() == nil
+			return nil
+		})
+		require.NoError(t, err)
+		fixture.PublishMessages("orders", []string{"o1"})
+		fixture.Eventually(func() bool { return isClosed(started) }, "Handler should start")
+
+		report, err := fixture.Consumer.(*QueueConsumer).Shutdown(context.Background())
+		require.NoError(t, err)
+		assert.Zero(t, report.Interrupted)
+		assert.True(t, finished, "Handler should complete with a live context")
+
+		err = fixture.Consumer.Subscribe(fixture.Ctx, "payments", func(ctx context.Context, message *queue.Message) error { return nil })
+		assert.Error(t, err, "Should reject subscriptions after shutdown")
+	})
+
+	t.Run("ConsumerDrainsPendingMessages", func(t *testing.T) {
+		q := queue.NewMock()
+		fixture := NewBrokerTestFixture(t, q)
+		consumer := fixture.NewConsumer(WithDrainOnShutdown())
+
+		var mu sync.Mutex
+		var handled []string
+		err := consumer.Subscribe(fixture.Ctx, "orders", func(ctx context.Context, message *queue.Message) error {
+			mu.Lock()
+			defer mu.Unlock()
+			handled = append(handled, string(message.Payload))
+			return nil
+		})
+		require.NoError(t, err)
+		fixture.PublishMessages("orders", []string{"o1", "o2", "o3"})
+
+		_, err = consumer.Shutdown(context.Background())
+		require.NoError(t, err)
+
+		assert.Equal(t, []string{"o1", "o2", "o3"}, handled, "Pending messages should be handled before shutdown returns")
+		fixture.AssertQueueSize("orders", 0, "Queue should be drained")
+	})
+
+	t.Run("ConsumerReportsInterruptedHandlers", func(t *testing.T) {
+		q := queue.NewMock()
+		fixture := NewBrokerTestFixture(t, q)
+		started := make(chan struct{})
+
+		err := fixture.Consumer.Subscribe(fixture.Ctx, "orders", func(ctx context.Context, message *queue.Message) error {
+			close(started)
+			<-ctx.Done()
+			return ctx.Err()
+		})
+		require.NoError(t, err)
+		fixture.PublishMessages("orders", []string{"slow"})
+		fixture.Eventually(func() bool { return isClosed(started) }, "Handler should start")
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+
+		report, err := fixture.Consumer.(*QueueConsumer).Shutdown(ctx)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.Equal(t, 1, report.Interrupted, "Should report the handler still running at the deadline")
 	})
 }
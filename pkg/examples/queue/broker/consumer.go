@@ -2,41 +2,127 @@ package broker
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/syl/Go/pkg/examples/queue"
+	"github.com/syl/Go/pkg/examples/queue/filter"
 )
 
+// ensure that QueueConsumer supports graceful shutdown and health checks
+var (
+	_ queue.Shutdowner    = (*QueueConsumer)(nil)
+	_ queue.HealthChecker = (*QueueConsumer)(nil)
+)
+
+// PollInterval is how often a subscription polls its topics for a new message
+const PollInterval = 100 * time.Millisecond
+
+// RequeueAttempts is how many times, PollInterval apart, a consumer tries to put a dequeued message it did not
+// handle back on its topic or its dead-letter topic before giving up on it
+const RequeueAttempts = 3
+
+// FilterScanLimit is how many messages a filtered subscription looks past to find a matching one on queues
+// that cannot select messages (see queue.Selector): at most that many non-matching messages are moved
+// from the head to the tail of the topic per poll.
+const FilterScanLimit = 100
+
 // QueueConsumer implements the Consumer interface using a Queue
 type QueueConsumer struct {
 	queue         queue.Queue
+	clock         queue.Clock
+	logger        *log.Logger
 	subscriptions map[string]*subscription
 	mu            sync.RWMutex
 	closed        bool
+	drain         bool
+	limiter       *RateLimiter
+	slots         chan struct{}
 }
 
-// subscription represents an active subscription to a topic
+// subscription represents an active subscription to a topic or topic pattern
 type subscription struct {
 	topic     string
+	pattern   bool
+	filter    *filter.Filter
+	dlq       bool
+	limiter   *RateLimiter
+	breaker   *CircuitBreaker
+	attempts  int
+	backoff   time.Duration
 	handler   queue.MessageHandler
 	ctx       context.Context
 	cancel    context.CancelFunc
+	stop      chan struct{}
+	done      chan struct{}
+	err       error
+	inFlight  atomic.Int32
+	stats     handlerStats
 	wg        sync.WaitGroup
 }
 
 // NewQueueConsumer creates a new consumer that uses the provided queue
-func NewQueueConsumer(q queue.Queue) *QueueConsumer {
-	return &QueueConsumer{
+func NewQueueConsumer(q queue.Queue, opts ...ConsumerOption) *QueueConsumer {
+	c := &QueueConsumer{
 		queue:         q,
+		clock:         queue.SystemClock,
+		logger:        log.Default(),
 		subscriptions: make(map[string]*subscription),
 		closed:        false,
 	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
 }
 
 // Subscribe starts consuming messages from the specified topic
+// The topic can be a pattern (see IsPattern) matching existing and future topics
 func (c *QueueConsumer) Subscribe(ctx context.Context, topic string, handler queue.MessageHandler) error {
+	return c.SubscribeWithOptions(ctx, topic, handler)
+}
+
+// SubscribeWithOptions starts consuming messages from the specified topic with additional settings
+func (c *QueueConsumer) SubscribeWithOptions(ctx context.Context, topic string, handler queue.MessageHandler, opts ...SubscriptionOption) error {
+	config := &subscriptionConfig{}
+	for _, opt := range opts {
+		opt(config)
+	}
+
+	var messageFilter *filter.Filter
+	if config.filter != "" {
+		var err error
+		if messageFilter, err = filter.Parse(config.filter); err != nil {
+			return fmt.Errorf("invalid filter %q: %w", config.filter, err)
+		}
+	}
+
+	var limiter *RateLimiter
+	if config.rate != 0 || config.burst != 0 {
+		var err error
+		if limiter, err = NewRateLimiterWithClock(c.clock, config.rate, config.burst); err != nil {
+			return fmt.Errorf("invalid rate limit: %w", err)
+		}
+	}
+
+	var breaker *CircuitBreaker
+	if config.breakerThreshold != 0 || config.breakerCooldown != 0 {
+		var err error
+		breaker, err = NewCircuitBreakerWithClock(c.clock, topic, config.breakerThreshold, config.breakerCooldown, config.onBreakerChange)
+		if err != nil {
+			return fmt.Errorf("invalid circuit breaker: %w", err)
+		}
+	}
+
+	if config.retryAttempts < 0 || config.retryBackoff < 0 {
+		return fmt.Errorf("invalid retry policy: attempts and backoff must not be negative")
+	}
+
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
@@ -48,13 +134,26 @@ func (c *QueueConsumer) Subscribe(ctx context.Context, topic string, handler que
 		return fmt.Errorf("already subscribed to topic: %s", topic)
 	}
 
+	if err := validatePattern(topic); err != nil {
+		return fmt.Errorf("invalid topic pattern %s: %w", topic, err)
+	}
+
 	subCtx, cancel := context.WithCancel(ctx)
 	
 	sub := &subscription{
-		topic:   topic,
-		handler: handler,
-		ctx:     subCtx,
-		cancel:  cancel,
+		topic:    topic,
+		pattern:  IsPattern(topic),
+		filter:   messageFilter,
+		dlq:      config.deadLetter,
+		limiter:  limiter,
+		breaker:  breaker,
+		attempts: config.retryAttempts,
+		backoff:  config.retryBackoff,
+		handler:  handler,
+		ctx:      subCtx,
+		cancel:   cancel,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
 	}
 
 	c.subscriptions[topic] = sub
@@ -108,28 +207,347 @@ func (c *QueueConsumer) Close() error {
 	return nil
 }
 
+// Shutdown stops polling for new messages and waits for in-flight handlers until ctx is done
+// With WithDrainOnShutdown the messages pending on subscribed topics are handed to the handlers first.
+// Handlers still running at the deadline see their context canceled and are reported as interrupted.
+func (c *QueueConsumer) Shutdown(ctx context.Context) (queue.ShutdownReport, error) {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return queue.ShutdownReport{}, nil
+	}
+	c.closed = true
+	subs := make([]*subscription, 0, len(c.subscriptions))
+	for _, sub := range c.subscriptions {
+		subs = append(subs, sub)
+	}
+	c.subscriptions = make(map[string]*subscription)
+	c.mu.Unlock()
+
+	for _, sub := range subs {
+		close(sub.stop)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		for _, sub := range subs {
+			sub.wg.Wait()
+		}
+		close(done)
+	}()
+
+	report := queue.ShutdownReport{}
+	var err error
+	select {
+	case <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+		for _, sub := range subs {
+			report.Interrupted += int(sub.inFlight.Load())
+		}
+	}
+
+	for _, sub := range subs {
+		sub.cancel()
+	}
+	<-done
+
+	return report, err
+}
+
+// CheckHealth reports an error when the consumer is closed or a subscription stopped polling
+// A subscription stops when its handler panics or the context passed to Subscribe is done.
+func (c *QueueConsumer) CheckHealth(ctx context.Context) error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if c.closed {
+		return fmt.Errorf("consumer is closed")
+	}
+
+	var errs []error
+	for _, sub := range c.subscriptions {
+		select {
+		case <-sub.done:
+			errs = append(errs, fmt.Errorf("subscription %s stopped: %w", sub.topic, sub.err))
+		default:
+		}
+	}
+	sort.Slice(errs, func(i, j int) bool { return errs[i].Error() < errs[j].Error() })
+	return errors.Join(errs...)
+}
+
 // consumeMessages continuously polls for messages from the queue
+// A panicking handler stops the subscription, which is then reported by CheckHealth.
 func (c *QueueConsumer) consumeMessages(sub *subscription) {
 	defer sub.wg.Done()
+	defer close(sub.done)
+	defer func() {
+		if r := recover(); r != nil {
+			sub.err = fmt.Errorf("handler panicked: %v", r)
+		} else {
+			sub.err = sub.ctx.Err()
+		}
+	}()
 
-	ticker := time.NewTicker(100 * time.Millisecond)
+	ticker := c.clock.NewTicker(PollInterval)
 	defer ticker.Stop()
 
 	for {
 		select {
 		case <-sub.ctx.Done():
 			return
-		case <-ticker.C:
-			message, err := c.queue.Dequeue(sub.ctx, sub.topic)
-			if err != nil {
-				continue
+		case <-sub.stop:
+			if c.drain {
+				c.drainSubscription(sub)
+			}
+			return
+		case <-ticker.C():
+			for _, topic := range c.matchingTopics(sub) {
+				if stopping(sub) {
+					break
+				}
+				c.consumeMessage(sub, topic)
 			}
+		}
+	}
+}
 
-			if message != nil {
-				if err := sub.handler(sub.ctx, message); err != nil {
-					continue
+// drainSubscription hands the pending messages of the subscribed topics to the handler
+// until the topics are empty or only hold messages rejected by the filter
+func (c *QueueConsumer) drainSubscription(sub *subscription) {
+	for sub.ctx.Err() == nil {
+		handled := false
+		for _, topic := range c.matchingTopics(sub) {
+			size, err := c.queue.Size(sub.ctx, topic)
+			if err != nil {
+				return
+			}
+			for i := 0; i < size && sub.ctx.Err() == nil; i++ {
+				if c.consumeMessage(sub, topic) {
+					handled = true
 				}
 			}
 		}
+		if !handled {
+			return
+		}
+	}
+}
+
+// stopping reports whether the subscription was asked to stop
+func stopping(sub *subscription) bool {
+	select {
+	case <-sub.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// matchingTopics returns the topics a subscription polls
+// Pattern subscriptions are resolved on every poll so new topics are picked up,
+// dead-letter topics are never matched by patterns
+func (c *QueueConsumer) matchingTopics(sub *subscription) []string {
+	if !sub.pattern {
+		return []string{sub.topic}
+	}
+
+	topics, err := c.queue.Topics(sub.ctx)
+	if err != nil {
+		return nil
+	}
+
+	matched := make([]string, 0, len(topics))
+	for _, topic := range topics {
+		if MatchTopic(sub.topic, topic) && !IsDeadLetterTopic(topic) {
+			matched = append(matched, topic)
+		}
+	}
+	sort.Strings(matched)
+	return matched
+}
+
+// hasMatch reports whether one of the first FilterScanLimit messages of the topic passes the filter of the subscription
+// Queues that cannot be peeked report a match, the messages are then checked once dequeued.
+func (c *QueueConsumer) hasMatch(sub *subscription, topic string) bool {
+	inspector, ok := c.queue.(queue.Inspector)
+	if !ok {
+		return true
+	}
+	messages, err := inspector.Peek(sub.ctx, topic, FilterScanLimit)
+	if err != nil {
+		return false
+	}
+	for _, message := range messages {
+		if sub.filter.Match(message.Headers) {
+			return true
+		}
+	}
+	return false
+}
+
+// dequeueMatching removes the first message of the topic passing the filter of the subscription,
+// leaving the messages before it in place for the other subscribers of the topic.
+// Queues that cannot select messages have up to FilterScanLimit non-matching messages put back to the tail instead.
+func (c *QueueConsumer) dequeueMatching(sub *subscription, topic string) (*queue.Message, error) {
+	match := func(message *queue.Message) bool {
+		return sub.filter.Match(message.Headers)
+	}
+	if selector, ok := c.queue.(queue.Selector); ok {
+		return selector.DequeueMatching(sub.ctx, topic, match)
+	}
+
+	size, err := c.queue.Size(sub.ctx, topic)
+	if err != nil {
+		return nil, err
+	}
+	for skipped := 0; skipped < min(size, FilterScanLimit); skipped++ {
+		message, err := c.queue.Dequeue(sub.ctx, topic)
+		if err != nil || message == nil || match(message) {
+			return message, err
+		}
+		c.putBack(sub.ctx, topic, message)
+	}
+	return nil, nil
+}
+
+// putBack returns a dequeued message rejected by the filter to the tail of its topic
+// When the topic does not take it, a full topic or a closed or draining queue, the message goes to
+// the dead-letter topic rather than being lost.
+func (c *QueueConsumer) putBack(ctx context.Context, topic string, message *queue.Message) {
+	err := c.requeue(ctx, func() error {
+		err := c.queue.Enqueue(context.WithoutCancel(ctx), topic, message)
+		if err == nil {
+			return nil
+		}
+		return c.deadLetter(ctx, topic, message, fmt.Errorf("failed to put back message rejected by the filter: %w", err))
+	})
+	if err != nil {
+		c.logger.Printf("Lost message %s of %s rejected by the filter: %v", message.ID, topic, err)
+	}
+}
+
+// deadLetterFailed moves a message whose handler failed to the dead-letter topic
+// When the dead-letter topic does not take it, the message is put back on its topic to be handled again.
+func (c *QueueConsumer) deadLetterFailed(ctx context.Context, topic string, message *queue.Message, reason error) {
+	err := c.requeue(ctx, func() error {
+		err := c.deadLetter(ctx, topic, message, reason)
+		if err == nil {
+			return nil
+		}
+		if putBackErr := c.queue.Enqueue(context.WithoutCancel(ctx), topic, message); putBackErr != nil {
+			return errors.Join(err, putBackErr)
+		}
+		c.logger.Printf("Failed to dead-letter message %s of %s, put it back on the topic: %v", message.ID, topic, err)
+		return nil
+	})
+	if err != nil {
+		c.logger.Printf("Lost message %s of %s: %v", message.ID, topic, err)
+	}
+}
+
+// requeue calls enqueue up to RequeueAttempts times, PollInterval apart, until it succeeds
+// It stops waiting when ctx is done and returns the last error.
+func (c *QueueConsumer) requeue(ctx context.Context, enqueue func() error) error {
+	err := enqueue()
+	for attempt := 1; err != nil && attempt < RequeueAttempts; attempt++ {
+		timer := c.clock.NewTimer(PollInterval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C():
+		}
+		err = enqueue()
+	}
+	return err
+}
+
+// handle calls the handler, retrying failures according to the retry policy of the subscription
+func (c *QueueConsumer) handle(sub *subscription, message *queue.Message) error {
+	err := sub.handler(sub.ctx, message)
+	backoff := sub.backoff
+	for attempt := 1; err != nil && attempt < sub.attempts; attempt++ {
+		if backoff > 0 {
+			timer := c.clock.NewTimer(backoff)
+			select {
+			case <-sub.ctx.Done():
+				timer.Stop()
+				return err
+			case <-timer.C():
+			}
+			backoff *= 2
+		}
+		err = sub.handler(sub.ctx, message)
+	}
+	return err
+}
+
+// consumeMessage dequeues a single message from the topic and hands it to the subscription handler
+// It returns whether a message was handed to the handler.
+func (c *QueueConsumer) consumeMessage(sub *subscription, topic string) bool {
+	// Do not wait for a rate limit token when there is nothing to handle
+	if sub.limiter != nil || c.limiter != nil {
+		if size, err := c.queue.Size(sub.ctx, topic); err != nil || size == 0 {
+			return false
+		}
+	}
+
+	// An open breaker pauses the subscription, messages stay in the queue
+	if sub.breaker != nil && !sub.breaker.Allow() {
+		return false
+	}
+	handled := false
+	defer func() {
+		if !handled && sub.breaker != nil {
+			sub.breaker.Abort()
+		}
+	}()
+
+	// Do not take a handler slot when no message passes the filter
+	_, selects := c.queue.(queue.Selector)
+	if sub.filter != nil && !selects && !c.hasMatch(sub, topic) {
+		return false
+	}
+
+	release, err := c.acquire(sub)
+	if err != nil {
+		return false
+	}
+	defer release()
+
+	var message *queue.Message
+	if sub.filter != nil {
+		message, err = c.dequeueMatching(sub, topic)
+	} else {
+		message, err = c.queue.Dequeue(sub.ctx, topic)
+	}
+	if err != nil || message == nil {
+		c.refund(sub)
+		return false
+	}
+
+	if message.Topic == "" {
+		message.Topic = topic
+	}
+
+	sub.inFlight.Add(1)
+	defer sub.inFlight.Add(-1)
+
+	handled = true
+	start := c.clock.Now()
+	err = c.handle(sub, message)
+	sub.stats.record(c.clock.Since(start), err, c.clock.Now())
+	if sub.breaker != nil {
+		if err != nil {
+			sub.breaker.Failure(err)
+		} else {
+			sub.breaker.Success()
+		}
+	}
+	if err != nil && sub.dlq {
+		c.deadLetterFailed(sub.ctx, topic, message, err)
 	}
+	return true
 }
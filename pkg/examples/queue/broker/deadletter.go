@@ -0,0 +1,83 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/syl/Go/pkg/examples/queue"
+)
+
+const (
+	// DeadLetterSuffix is appended to a topic name to get its dead-letter topic
+	DeadLetterSuffix = ".dlq"
+	// HeaderDeadLetterReason holds the handler error of a dead-lettered message
+	HeaderDeadLetterReason = "dead-letter-reason"
+)
+
+// DeadLetterTopic returns the topic failed messages of the given topic are moved to
+func DeadLetterTopic(topic string) string {
+	return topic + DeadLetterSuffix
+}
+
+// IsDeadLetterTopic reports whether the topic holds failed messages of another topic
+func IsDeadLetterTopic(topic string) bool {
+	return strings.HasSuffix(topic, DeadLetterSuffix)
+}
+
+// Redrive moves up to max messages from the source topic back to the target topic,
+// a max of zero or less moves every message. Only the messages queued when it starts are moved,
+// so messages failing again into the source topic are not redriven in a loop.
+// It returns the number of moved messages.
+func Redrive(ctx context.Context, q queue.Queue, source, target string, max int) (int, error) {
+	size, err := q.Size(ctx, source)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get size of %s: %w", source, err)
+	}
+	if max <= 0 || max > size {
+		max = size
+	}
+
+	moved := 0
+	for moved < max {
+		message, err := q.Dequeue(ctx, source)
+		if err != nil {
+			return moved, fmt.Errorf("failed to dequeue from %s: %w", source, err)
+		}
+		if message == nil {
+			break
+		}
+
+		reason := message.Headers[HeaderDeadLetterReason]
+		message.Topic = target
+		delete(message.Headers, HeaderDeadLetterReason)
+
+		if err := q.Enqueue(ctx, target, message); err != nil {
+			err = fmt.Errorf("failed to enqueue to %s: %w", target, err)
+
+			// Put it back so the message is not lost
+			message.Topic = source
+			if reason != "" {
+				message.Headers[HeaderDeadLetterReason] = reason
+			}
+			if putBackErr := q.Enqueue(context.WithoutCancel(ctx), source, message); putBackErr != nil {
+				err = errors.Join(err, fmt.Errorf("failed to put message %s back on %s: %w", message.ID, source, putBackErr))
+			}
+			return moved, err
+		}
+		moved++
+	}
+	return moved, nil
+}
+
+// deadLetter moves a message whose handler failed to the dead-letter topic
+func (c *QueueConsumer) deadLetter(ctx context.Context, topic string, message *queue.Message, reason error) error {
+	failed := message.Clone()
+	if failed.Headers == nil {
+		failed.Headers = make(map[string]string)
+	}
+	failed.Headers[HeaderDeadLetterReason] = reason.Error()
+
+	return c.queue.Enqueue(context.WithoutCancel(ctx), DeadLetterTopic(topic), failed)
+}
@@ -0,0 +1,116 @@
+package broker
+
+import (
+	"log"
+	"time"
+
+	"github.com/syl/Go/pkg/examples/queue"
+)
+
+// ConsumerOption configures a QueueConsumer created with NewQueueConsumer
+type ConsumerOption func(*QueueConsumer)
+
+// WithDrainOnShutdown hands the messages pending on subscribed topics to the handlers during Shutdown
+func WithDrainOnShutdown() ConsumerOption {
+	return func(c *QueueConsumer) {
+		c.drain = true
+	}
+}
+
+// WithClock drives the polling, rate limits and circuit breakers of the subscriptions with clock instead of the system clock
+// A limiter passed to WithGlobalRateLimit keeps the clock it was created with.
+func WithClock(clock queue.Clock) ConsumerOption {
+	return func(c *QueueConsumer) {
+		c.clock = clock
+	}
+}
+
+// WithLogger logs the messages a consumer fails to dead-letter or put back to logger instead of the standard logger
+func WithLogger(logger *log.Logger) ConsumerOption {
+	return func(c *QueueConsumer) {
+		c.logger = logger
+	}
+}
+
+// WithGlobalRateLimit makes every subscription of the consumer take a token from the limiter before handling a message
+// The limiter can also be shared with other consumers.
+func WithGlobalRateLimit(limiter *RateLimiter) ConsumerOption {
+	return func(c *QueueConsumer) {
+		c.limiter = limiter
+	}
+}
+
+// WithMaxConcurrentHandlers limits how many handlers of the consumer run at the same time across subscriptions
+func WithMaxConcurrentHandlers(n int) ConsumerOption {
+	return func(c *QueueConsumer) {
+		if n > 0 {
+			c.slots = make(chan struct{}, n)
+		}
+	}
+}
+
+// subscriptionConfig holds the optional settings of a subscription
+type subscriptionConfig struct {
+	filter     string
+	deadLetter bool
+	rate       float64
+	burst      int
+
+	retryAttempts int
+	retryBackoff  time.Duration
+
+	breakerThreshold int
+	breakerCooldown  time.Duration
+	onBreakerChange  func(BreakerEvent)
+}
+
+// SubscriptionOption configures a subscription created with SubscribeWithOptions
+type SubscriptionOption func(*subscriptionConfig)
+
+// WithFilter only delivers messages whose headers match the filter expression (see package filter).
+// On queues implementing queue.Selector, the first matching message is taken out of the topic and the
+// non-matching ones are left in place, in order, for other subscribers.
+// Other queues have up to FilterScanLimit non-matching messages per poll put back at the tail of the topic,
+// or dead-lettered when the topic does not take them back.
+func WithFilter(expression string) SubscriptionOption {
+	return func(c *subscriptionConfig) {
+		c.filter = expression
+	}
+}
+
+// WithDeadLetter moves messages whose handler returned an error to the dead-letter topic (see DeadLetterTopic)
+func WithDeadLetter() SubscriptionOption {
+	return func(c *subscriptionConfig) {
+		c.deadLetter = true
+	}
+}
+
+// WithRateLimit handles at most rate messages per second with bursts of up to burst messages.
+// Throttled messages stay in the queue until a token is available.
+func WithRateLimit(rate float64, burst int) SubscriptionOption {
+	return func(c *subscriptionConfig) {
+		c.rate = rate
+		c.burst = burst
+	}
+}
+
+// WithCircuitBreaker stops dequeuing after threshold consecutive handler failures and leaves the messages in the queue.
+// Once cooldown elapsed a single trial message is handled, its success resumes the subscription.
+// onStateChange is optional and receives every state change, with the topic as breaker name.
+func WithCircuitBreaker(threshold int, cooldown time.Duration, onStateChange func(BreakerEvent)) SubscriptionOption {
+	return func(c *subscriptionConfig) {
+		c.breakerThreshold = threshold
+		c.breakerCooldown = cooldown
+		c.onBreakerChange = onStateChange
+	}
+}
+
+// WithRetry calls a failing handler again up to attempts times in total before the failure counts,
+// waiting backoff before the first retry and doubling it for each of the next ones.
+// Dead-lettering and circuit breakers only see the failure of the last attempt.
+func WithRetry(attempts int, backoff time.Duration) SubscriptionOption {
+	return func(c *subscriptionConfig) {
+		c.retryAttempts = attempts
+		c.retryBackoff = backoff
+	}
+}
@@ -0,0 +1,74 @@
+package broker
+
+import (
+	"path"
+	"strings"
+)
+
+const (
+	// GlobPrefix marks a subscription topic as a glob pattern, e.g. "glob:orders-*"
+	GlobPrefix = "glob:"
+
+	wordSeparator  = "."
+	singleWildcard = "*"
+	multiWildcard  = "#"
+)
+
+// IsPattern reports whether a subscription topic matches several topics.
+// AMQP-style patterns use '.' separated words where '*' matches exactly one word
+// and '#' matches zero or more words. Topics prefixed with GlobPrefix use path.Match syntax.
+func IsPattern(topic string) bool {
+	if strings.HasPrefix(topic, GlobPrefix) {
+		return true
+	}
+
+	for _, word := range strings.Split(topic, wordSeparator) {
+		if word == singleWildcard || word == multiWildcard {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchTopic reports whether the topic matches the subscription pattern
+func MatchTopic(pattern, topic string) bool {
+	if glob, ok := strings.CutPrefix(pattern, GlobPrefix); ok {
+		matched, err := path.Match(glob, topic)
+		return err == nil && matched
+	}
+
+	return matchWords(strings.Split(pattern, wordSeparator), strings.Split(topic, wordSeparator))
+}
+
+// validatePattern checks that a glob pattern is well-formed
+func validatePattern(pattern string) error {
+	if glob, ok := strings.CutPrefix(pattern, GlobPrefix); ok {
+		_, err := path.Match(glob, "")
+		return err
+	}
+	return nil
+}
+
+func matchWords(pattern, words []string) bool {
+	if len(pattern) == 0 {
+		return len(words) == 0
+	}
+
+	if pattern[0] == multiWildcard {
+		for i := 0; i <= len(words); i++ {
+			if matchWords(pattern[1:], words[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if len(words) == 0 {
+		return false
+	}
+
+	if pattern[0] == singleWildcard || pattern[0] == words[0] {
+		return matchWords(pattern[1:], words[1:])
+	}
+	return false
+}
@@ -0,0 +1,48 @@
+package broker
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTopicPattern(t *testing.T) {
+	t.Run("IsPattern", func(t *testing.T) {
+		assert.True(t, IsPattern("orders.*"), "Single word wildcard is a pattern")
+		assert.True(t, IsPattern("orders.#"), "Multi word wildcard is a pattern")
+		assert.True(t, IsPattern("glob:orders-*"), "Glob prefix is a pattern")
+		assert.False(t, IsPattern("orders"), "Plain topic is not a pattern")
+		assert.False(t, IsPattern("orders*"), "Wildcard inside a word is not a pattern")
+	})
+
+	t.Run("MatchTopic", func(t *testing.T) {
+		cases := []struct {
+			pattern string
+			topic   string
+			matches bool
+		}{
+			{"orders", "orders", true},
+			{"orders", "orders.created", false},
+			{"orders.*", "orders.created", true},
+			{"orders.*", "orders", false},
+			{"orders.*", "orders.eu.created", false},
+			{"orders.#", "orders", true},
+			{"orders.#", "orders.created", true},
+			{"orders.#", "orders.eu.created", true},
+			{"orders.#", "payments.created", false},
+			{"*.created", "orders.created", true},
+			{"#.created", "orders.eu.created", true},
+			{"orders.#.created", "orders.created", true},
+			{"orders.#.created", "orders.eu.west.created", true},
+			{"orders.#.created", "orders.eu.updated", false},
+			{"glob:orders-*", "orders-eu", true},
+			{"glob:orders-*", "payments-eu", false},
+			{"glob:orders-?", "orders-1", true},
+			{"glob:[", "[", false},
+		}
+
+		for _, c := range cases {
+			assert.Equal(t, c.matches, MatchTopic(c.pattern, c.topic), "MatchTopic(%q, %q)", c.pattern, c.topic)
+		}
+	})
+}
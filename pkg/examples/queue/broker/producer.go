@@ -2,15 +2,24 @@ package broker
 
 import (
 	"context"
+	"fmt"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/syl/Go/pkg/examples/queue"
 )
 
+// ensure that QueueProducer supports graceful shutdown
+var _ queue.Shutdowner = (*QueueProducer)(nil)
+
 // QueueProducer implements the Producer interface using a Queue
 type QueueProducer struct {
-	queue queue.Queue
+	queue    queue.Queue
+	mu       sync.Mutex
+	inFlight sync.WaitGroup
+	active   int
+	stopped  bool
 }
 
 // NewQueueProducer creates a new producer that uses the provided queue
@@ -22,6 +31,11 @@ func NewQueueProducer(q queue.Queue) *QueueProducer {
 
 // Publish sends a message to the specified topic
 func (p *QueueProducer) Publish(ctx context.Context, topic string, payload []byte, headers map[string]string) error {
+	if err := p.begin(); err != nil {
+		return err
+	}
+	defer p.end()
+
 	message := &queue.Message{
 		ID:        uuid.New().String(),
 		Topic:     topic,
@@ -33,6 +47,50 @@ func (p *QueueProducer) Publish(ctx context.Context, topic string, payload []byt
 	return p.queue.Enqueue(ctx, topic, message)
 }
 
+// begin registers an in-flight publish unless the producer is shutting down
+func (p *QueueProducer) begin() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.stopped {
+		return fmt.Errorf("producer is shutting down")
+	}
+
+	p.active++
+	p.inFlight.Add(1)
+	return nil
+}
+
+func (p *QueueProducer) end() {
+	p.mu.Lock()
+	p.active--
+	p.mu.Unlock()
+
+	p.inFlight.Done()
+}
+
+// Shutdown rejects new publishes and waits for in-flight ones to complete until ctx is done
+func (p *QueueProducer) Shutdown(ctx context.Context) (queue.ShutdownReport, error) {
+	p.mu.Lock()
+	p.stopped = true
+	p.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		p.inFlight.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return queue.ShutdownReport{}, nil
+	case <-ctx.Done():
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		return queue.ShutdownReport{Interrupted: p.active}, ctx.Err()
+	}
+}
+
 // Close closes the producer
 func (p *QueueProducer) Close() error {
 	return nil
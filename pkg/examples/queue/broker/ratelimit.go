@@ -0,0 +1,168 @@
+package broker
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/syl/Go/pkg/examples/queue"
+)
+
+// RateLimiter is a token bucket allowing rate messages per second with bursts of up to burst messages
+// A single limiter can be shared by several subscriptions and consumers.
+type RateLimiter struct {
+	mu        sync.Mutex
+	clock     queue.Clock
+	rate      float64
+	burst     float64
+	tokens    float64
+	last      time.Time
+	throttled time.Duration
+}
+
+// NewRateLimiter creates a token bucket that starts full
+func NewRateLimiter(rate float64, burst int) (*RateLimiter, error) {
+	return NewRateLimiterWithClock(queue.SystemClock, rate, burst)
+}
+
+// NewRateLimiterWithClock creates a token bucket that refills and waits according to clock
+func NewRateLimiterWithClock(clock queue.Clock, rate float64, burst int) (*RateLimiter, error) {
+	if rate <= 0 {
+		return nil, fmt.Errorf("rate must be positive, got %v", rate)
+	}
+	if burst <= 0 {
+		return nil, fmt.Errorf("burst must be positive, got %d", burst)
+	}
+
+	return &RateLimiter{
+		clock:  clock,
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   clock.Now(),
+	}, nil
+}
+
+// Wait takes a token, blocking until one is available or ctx is done
+func (l *RateLimiter) Wait(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	l.refill(l.clock.Now())
+	l.tokens--
+	var wait time.Duration
+	if l.tokens < 0 {
+		wait = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	l.mu.Unlock()
+
+	if wait == 0 {
+		return nil
+	}
+
+	timer := l.clock.NewTimer(wait)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		l.refund()
+		return ctx.Err()
+	case <-timer.C():
+		l.mu.Lock()
+		l.throttled += wait
+		l.mu.Unlock()
+		return nil
+	}
+}
+
+// Throttled returns the total time callers of Wait were blocked
+func (l *RateLimiter) Throttled() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.throttled
+}
+
+// refund gives back a token taken by Wait that was not used
+func (l *RateLimiter) refund() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.tokens = min(l.tokens+1, l.burst)
+}
+
+// refill adds the tokens earned since the last call
+func (l *RateLimiter) refill(now time.Time) {
+	elapsed := now.Sub(l.last).Seconds()
+	l.last = now
+	l.tokens = min(l.tokens+elapsed*l.rate, l.burst)
+}
+
+// ThrottleStats reports how long a consumer waited for its rate limits
+type ThrottleStats struct {
+	// Global is the time spent waiting for the limiter shared by all subscriptions
+	Global time.Duration
+	// Subscriptions is the time spent waiting for the limiter of each rate-limited subscription
+	Subscriptions map[string]time.Duration
+}
+
+// ThrottleStats returns the time spent waiting for the global and per-subscription rate limits
+func (c *QueueConsumer) ThrottleStats() ThrottleStats {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	stats := ThrottleStats{Subscriptions: make(map[string]time.Duration)}
+	if c.limiter != nil {
+		stats.Global = c.limiter.Throttled()
+	}
+	for topic, sub := range c.subscriptions {
+		if sub.limiter != nil {
+			stats.Subscriptions[topic] = sub.limiter.Throttled()
+		}
+	}
+	return stats
+}
+
+// acquire waits for the subscription and global rate limits and a free handler slot
+// The returned release function must be called once the message is handled.
+func (c *QueueConsumer) acquire(sub *subscription) (release func(), err error) {
+	if sub.limiter != nil {
+		if err := sub.limiter.Wait(sub.ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	if c.limiter != nil {
+		if err := c.limiter.Wait(sub.ctx); err != nil {
+			if sub.limiter != nil {
+				sub.limiter.refund()
+			}
+			return nil, err
+		}
+	}
+
+	if c.slots == nil {
+		return func() {}, nil
+	}
+
+	select {
+	case c.slots <- struct{}{}:
+		return func() { <-c.slots }, nil
+	case <-sub.ctx.Done():
+		c.refund(sub)
+		return nil, sub.ctx.Err()
+	}
+}
+
+// refund gives back the rate limit tokens taken by acquire when no message was handled
+func (c *QueueConsumer) refund(sub *subscription) {
+	if sub.limiter != nil {
+		sub.limiter.refund()
+	}
+	if c.limiter != nil {
+		c.limiter.refund()
+	}
+}
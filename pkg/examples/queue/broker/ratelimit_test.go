@@ -0,0 +1,160 @@
+package broker
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/syl/Go/pkg/examples/queue"
+	"github.com/syl/Go/pkg/examples/queue/inmemory/testutils"
+)
+
+func TestRateLimiter(t *testing.T) {
+	t.Run("InvalidSettings", func(t *testing.T) {
+		_, err := NewRateLimiter(0, 1)
+		assert.Error(t, err, "Should reject a zero rate")
+		_, err = NewRateLimiter(1, 0)
+		assert.Error(t, err, "Should reject a zero burst")
+	})
+
+	t.Run("BurstThenThrottle", func(t *testing.T) {
+		clock := testutils.NewFakeClock(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+		limiter, err := NewRateLimiterWithClock(clock, 20, 2)
+		require.NoError(t, err)
+		ctx := context.Background()
+
+		require.NoError(t, limiter.Wait(ctx))
+		require.NoError(t, limiter.Wait(ctx), "Burst should not wait")
+		assert.Zero(t, limiter.Throttled())
+
+		done := make(chan error, 1)
+		go func() { done <- limiter.Wait(ctx) }()
+		clock.BlockUntil(1)
+
+		clock.Advance(49 * time.Millisecond)
+		select {
+		case <-done:
+			t.Fatal("Should wait for the next token")
+		default:
+		}
+
+		clock.Advance(time.Millisecond)
+		require.NoError(t, <-done)
+		assert.Equal(t, 50*time.Millisecond, limiter.Throttled(), "Should record throttled time")
+	})
+
+	t.Run("CanceledWaitRefundsToken", func(t *testing.T) {
+		clock := testutils.NewFakeClock(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+		limiter, err := NewRateLimiterWithClock(clock, 1, 1)
+		require.NoError(t, err)
+		require.NoError(t, limiter.Wait(context.Background()))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error, 1)
+		go func() { done <- limiter.Wait(ctx) }()
+		clock.BlockUntil(1)
+		cancel()
+		assert.ErrorIs(t, <-done, context.Canceled)
+		assert.Zero(t, clock.Waiters(), "Canceled wait should stop its timer")
+
+		limiter.mu.Lock()
+		defer limiter.mu.Unlock()
+		assert.Greater(t, limiter.tokens, -1.0, "Canceled wait should give its token back")
+	})
+}
+
+// countingHandler counts handled messages and the highest number of concurrent calls
+type countingHandler struct {
+	handled    atomic.Int32
+	running    atomic.Int32
+	maxRunning atomic.Int32
+	delay      time.Duration
+}
+
+func (h *countingHandler) handle(ctx context.Context, message *queue.Message) error {
+	running := h.running.Add(1)
+	defer h.running.Add(-1)
+	for {
+		max := h.maxRunning.Load()
+		if running <= max || h.maxRunning.CompareAndSwap(max, running) {
+			break
+		}
+	}
+
+	time.Sleep(h.delay)
+	h.handled.Add(1)
+	return nil
+}
+
+func TestConsumerLimits(t *testing.T) {
+	t.Run("SubscriptionRateLimit", func(t *testing.T) {
+		q := queue.NewMock()
+		fixture := NewBrokerTestFixture(t, q)
+		consumer := fixture.NewConsumer()
+
+		fixture.PublishMessages("orders", []string{"o1", "o2", "o3", "o4", "o5"})
+
+		handler := &countingHandler{}
+		start := fixture.Clock.Now()
+		require.NoError(t, consumer.SubscribeWithOptions(fixture.Ctx, "orders", handler.handle, WithRateLimit(4, 1)))
+
+		fixture.Eventually(func() bool { return handler.handled.Load() == 5 })
+		assert.GreaterOrEqual(t, fixture.Clock.Since(start), time.Second, "Four messages after the burst should take a second at 4/s")
+		assert.Greater(t, consumer.ThrottleStats().Subscriptions["orders"], time.Duration(0), "Should report throttled time")
+		fixture.AssertQueueSize("orders", 0, "Every message should be handled")
+	})
+
+	t.Run("InvalidRateLimit", func(t *testing.T) {
+		q := queue.NewMock()
+		consumer := NewBrokerTestFixture(t, q).NewConsumer()
+
+		err := consumer.SubscribeWithOptions(context.Background(), "orders", (&countingHandler{}).handle, WithRateLimit(-1, 1))
+		assert.Error(t, err, "Should reject an invalid rate")
+	})
+
+	t.Run("GlobalRateLimit", func(t *testing.T) {
+		q := queue.NewMock()
+		fixture := NewBrokerTestFixture(t, q)
+		limiter, err := NewRateLimiterWithClock(fixture.Clock, 5, 1)
+		require.NoError(t, err)
+		consumer := fixture.NewConsumer(WithGlobalRateLimit(limiter))
+
+		fixture.PublishMessages("orders", []string{"o1", "o2", "o3"})
+		fixture.PublishMessages("payments", []string{"p1", "p2", "p3"})
+
+		handler := &countingHandler{}
+		start := fixture.Clock.Now()
+		require.NoError(t, consumer.Subscribe(fixture.Ctx, "orders", handler.handle))
+		require.NoError(t, consumer.Subscribe(fixture.Ctx, "payments", handler.handle))
+
+		fixture.Eventually(func() bool { return handler.handled.Load() == 6 })
+		assert.GreaterOrEqual(t, fixture.Clock.Since(start), time.Second, "Subscriptions should share the global limit of 5/s")
+		assert.Greater(t, consumer.ThrottleStats().Global, time.Duration(0), "Should report global throttled time")
+	})
+
+	t.Run("MaxConcurrentHandlers", func(t *testing.T) {
+		q := queue.NewMock()
+		fixture := NewBrokerTestFixture(t, q)
+		consumer := fixture.NewConsumer(WithMaxConcurrentHandlers(1))
+
+		topics := []string{"orders", "payments", "shipments"}
+		handler := &countingHandler{delay: 50 * time.Millisecond}
+		var wg sync.WaitGroup
+		for _, topic := range topics {
+			fixture.PublishMessages(topic, []string{"m1", "m2"})
+			wg.Add(1)
+			go func(topic string) {
+				defer wg.Done()
+				require.NoError(t, consumer.Subscribe(fixture.Ctx, topic, handler.handle))
+			}(topic)
+		}
+		wg.Wait()
+
+		fixture.Eventually(func() bool { return handler.handled.Load() == 6 })
+		assert.Equal(t, int32(1), handler.maxRunning.Load(), "Handlers should never run concurrently")
+	})
+}
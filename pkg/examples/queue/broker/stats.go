@@ -0,0 +1,114 @@
+package broker
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/syl/Go/pkg/examples/queue"
+)
+
+// SubscriptionStats describes the activity and backlog of a subscription
+type SubscriptionStats struct {
+	// Topic is the subscribed topic or pattern
+	Topic string
+	// Processed is the number of messages whose handler succeeded
+	Processed int64
+	// Failed is the number of messages whose handler returned an error
+	Failed int64
+	// LastError is the most recent handler error, nil if no handler failed yet
+	LastError   error
+	LastErrorAt time.Time
+	// AverageLatency is the mean handler duration over processed and failed messages
+	AverageLatency time.Duration
+	// InFlight is the number of handlers currently running
+	InFlight int
+	// Pending is the number of messages waiting in the subscribed topics
+	Pending int
+	// OldestAge is how long the oldest pending message has been waiting,
+	// zero when the queue does not implement queue.AgeReporter
+	OldestAge time.Duration
+}
+
+// ConsumerStats reports the state of every subscription of a consumer
+type ConsumerStats struct {
+	Subscriptions map[string]SubscriptionStats
+}
+
+// handlerStats accumulates the handler results of a subscription
+type handlerStats struct {
+	mu           sync.Mutex
+	processed    int64
+	failed       int64
+	lastErr      error
+	lastErrAt    time.Time
+	totalLatency time.Duration
+}
+
+// record adds the outcome of a handler call
+func (s *handlerStats) record(latency time.Duration, err error, at time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.totalLatency += latency
+	if err != nil {
+		s.failed++
+		s.lastErr = err
+		s.lastErrAt = at
+		return
+	}
+	s.processed++
+}
+
+// fill copies the accumulated results into stats
+func (s *handlerStats) fill(stats *SubscriptionStats) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats.Processed = s.processed
+	stats.Failed = s.failed
+	stats.LastError = s.lastErr
+	stats.LastErrorAt = s.lastErrAt
+	if calls := s.processed + s.failed; calls > 0 {
+		stats.AverageLatency = s.totalLatency / time.Duration(calls)
+	}
+}
+
+// Stats returns the handler results, in-flight handlers and backlog of every subscription
+// The backlog of pattern subscriptions covers every matching topic.
+func (c *QueueConsumer) Stats() ConsumerStats {
+	c.mu.RLock()
+	subs := make([]*subscription, 0, len(c.subscriptions))
+	for _, sub := range c.subscriptions {
+		subs = append(subs, sub)
+	}
+	c.mu.RUnlock()
+
+	stats := ConsumerStats{Subscriptions: make(map[string]SubscriptionStats, len(subs))}
+	for _, sub := range subs {
+		subStats := SubscriptionStats{Topic: sub.topic, InFlight: int(sub.inFlight.Load())}
+		sub.stats.fill(&subStats)
+		subStats.Pending, subStats.OldestAge = c.backlog(sub)
+		stats.Subscriptions[sub.topic] = subStats
+	}
+	return stats
+}
+
+// backlog returns the number of pending messages of the subscription and the age of the oldest one
+func (c *QueueConsumer) backlog(sub *subscription) (pending int, oldest time.Duration) {
+	ctx := context.WithoutCancel(sub.ctx)
+	reporter, _ := c.queue.(queue.AgeReporter)
+
+	for _, topic := range c.matchingTopics(sub) {
+		if size, err := c.queue.Size(ctx, topic); err == nil {
+			pending += size
+		}
+		if reporter == nil {
+			continue
+		}
+		if age, err := reporter.OldestMessageAge(ctx, topic); err == nil {
+			oldest = max(oldest, age)
+		}
+	}
+	return pending, oldest
+}
@@ -0,0 +1,79 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/syl/Go/pkg/examples/queue"
+)
+
+func TestConsumerStats(t *testing.T) {
+	t.Run("HandlerResults", func(t *testing.T) {
+		q := queue.NewMock()
+		fixture := NewBrokerTestFixture(t, q)
+		consumer := fixture.NewConsumer()
+
+		errBad := errors.New("bad order")
+		err := consumer.Subscribe(fixture.Ctx, "orders", func(ctx context.Context, message *queue.Message) error {
+			fixture.Clock.Advance(30 * time.Millisecond)
+			if string(message.Payload) == "bad" {
+				return errBad
+			}
+			return nil
+		})
+		require.NoError(t, err)
+		fixture.PublishMessages("orders", []string{"good", "bad", "good"})
+
+		fixture.Eventually(func() bool {
+			stats := consumer.Stats().Subscriptions["orders"]
+			return stats.Processed+stats.Failed == 3
+		}, "Every message should be handled")
+
+		stats := consumer.Stats().Subscriptions["orders"]
+		assert.Equal(t, "orders", stats.Topic)
+		assert.Equal(t, int64(2), stats.Processed)
+		assert.Equal(t, int64(1), stats.Failed)
+		assert.Equal(t, errBad, stats.LastError, "Should keep the last handler error")
+		assert.False(t, stats.LastErrorAt.IsZero())
+		assert.Equal(t, 30*time.Millisecond, stats.AverageLatency, "Should average the handler durations")
+		assert.Zero(t, stats.InFlight)
+		assert.Zero(t, stats.Pending)
+		assert.Zero(t, stats.OldestAge)
+	})
+
+	t.Run("Lag", func(t *testing.T) {
+		q := queue.NewMock()
+		fixture := NewBrokerTestFixture(t, q)
+		consumer := fixture.NewConsumer()
+
+		release := make(chan struct{})
+		defer close(release)
+		err := consumer.Subscribe(fixture.Ctx, "orders.*", func(ctx context.Context, message *queue.Message) error {
+			<-release
+			return nil
+		})
+		require.NoError(t, err)
+
+		stale := &queue.Message{ID: "stale", Payload: []byte("stale"), Timestamp: time.Now().Add(-time.Hour)}
+		fixture.PublishMessages("orders.eu", []string{"first"})
+		require.NoError(t, q.Enqueue(fixture.Ctx, "orders.eu", stale))
+		fixture.PublishMessages("orders.us", []string{"other"})
+
+		fixture.Eventually(func() bool { return consumer.Stats().Subscriptions["orders.*"].InFlight == 1 }, "Handler should start")
+
+		stats := consumer.Stats().Subscriptions["orders.*"]
+		assert.Equal(t, 2, stats.Pending, "Should count the pending messages of every matching topic")
+		assert.GreaterOrEqual(t, stats.OldestAge, time.Hour, "Should report the oldest pending message")
+	})
+
+	t.Run("UnknownSubscription", func(t *testing.T) {
+		q := queue.NewMock()
+		consumer := NewBrokerTestFixture(t, q).NewConsumer()
+
+		assert.Empty(t, consumer.Stats().Subscriptions)
+	})
+}
@@ -0,0 +1,262 @@
+// Package chaos wraps a queue.Queue to inject faults for resilience testing.
+//
+// Faults are drawn from a seeded random source so that a failing run can be reproduced
+// with the same seed and the same sequence of operations.
+package chaos
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/syl/Go/pkg/examples/queue"
+)
+
+// ErrInjected is returned by operations that failed on purpose
+var ErrInjected = errors.New("chaos: injected failure")
+
+// Stats counts the faults injected so far
+type Stats struct {
+	Delayed    int
+	Errors     int
+	Lost       int
+	Duplicates int
+	Reordered  int
+}
+
+// Option configures a Queue created with New
+type Option func(*Queue)
+
+// WithSeed sets the seed of the random source, the default seed is 1
+func WithSeed(seed int64) Option {
+	return func(q *Queue) {
+		q.rng = rand.New(rand.NewSource(seed))
+	}
+}
+
+// WithClock times the injected latency with clock instead of the system clock
+func WithClock(clock queue.Clock) Option {
+	return func(q *Queue) {
+		q.clock = clock
+	}
+}
+
+// WithLatency delays a fraction rate of the operations by a random duration up to max
+func WithLatency(rate float64, max time.Duration) Option {
+	return func(q *Queue) {
+		q.latencyRate = rate
+		q.maxLatency = max
+	}
+}
+
+// WithErrorRate makes a fraction rate of Enqueue and Dequeue calls fail with ErrInjected
+func WithErrorRate(rate float64) Option {
+	return func(q *Queue) {
+		q.errorRate = rate
+	}
+}
+
+// WithLossRate silently drops a fraction rate of the enqueued messages
+func WithLossRate(rate float64) Option {
+	return func(q *Queue) {
+		q.lossRate = rate
+	}
+}
+
+// WithDuplicateRate delivers a fraction rate of the dequeued messages a second time later
+func WithDuplicateRate(rate float64) Option {
+	return func(q *Queue) {
+		q.duplicateRate = rate
+	}
+}
+
+// WithReorderRate swaps a fraction rate of the dequeued messages with the message behind them
+func WithReorderRate(rate float64) Option {
+	return func(q *Queue) {
+		q.reorderRate = rate
+	}
+}
+
+// Queue decorates a queue.Queue with injected latency, errors, loss, duplicates and reordering
+type Queue struct {
+	inner queue.Queue
+	clock queue.Clock
+
+	mu            sync.Mutex
+	rng           *rand.Rand
+	latencyRate   float64
+	maxLatency    time.Duration
+	errorRate     float64
+	lossRate      float64
+	duplicateRate float64
+	reorderRate   float64
+	held          map[string][]*queue.Message
+	stats         Stats
+}
+
+// New wraps the queue, without options it behaves like the wrapped queue
+func New(inner queue.Queue, opts ...Option) *Queue {
+	q := &Queue{
+		inner: inner,
+		clock: queue.SystemClock,
+		rng:   rand.New(rand.NewSource(1)),
+		held:  make(map[string][]*queue.Message),
+	}
+	for _, opt := range opts {
+		opt(q)
+	}
+	return q
+}
+
+// Enqueue adds the message to the wrapped queue unless it is lost or fails
+func (q *Queue) Enqueue(ctx context.Context, topic string, message *queue.Message) error {
+	if err := q.delay(ctx); err != nil {
+		return err
+	}
+
+	q.mu.Lock()
+	fail := q.roll(q.errorRate, &q.stats.Errors)
+	lost := !fail && q.roll(q.lossRate, &q.stats.Lost)
+	q.mu.Unlock()
+
+	if fail {
+		return ErrInjected
+	}
+	if lost {
+		return nil
+	}
+	return q.inner.Enqueue(ctx, topic, message)
+}
+
+// Dequeue retrieves a message, possibly swapped with the next one or delivered again later
+// The lock is not held while dequeuing from the wrapped queue, so that a slow topic does not hold up the others.
+func (q *Queue) Dequeue(ctx context.Context, topic string) (*queue.Message, error) {
+	if err := q.delay(ctx); err != nil {
+		return nil, err
+	}
+
+	q.mu.Lock()
+	if q.roll(q.errorRate, &q.stats.Errors) {
+		q.mu.Unlock()
+		return nil, ErrInjected
+	}
+	if held := q.held[topic]; len(held) > 0 {
+		message := held[0]
+		q.held[topic] = held[1:]
+		q.mu.Unlock()
+		return message, nil
+	}
+	q.mu.Unlock()
+
+	message, err := q.inner.Dequeue(ctx, topic)
+	if err != nil || message == nil {
+		return message, err
+	}
+
+	q.mu.Lock()
+	reorder := q.reorderRate > 0 && q.rng.Float64() < q.reorderRate
+	q.mu.Unlock()
+
+	var next *queue.Message
+	if reorder {
+		next, err = q.inner.Dequeue(ctx, topic)
+		if err != nil {
+			next = nil
+		}
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if next != nil {
+		q.stats.Reordered++
+		q.held[topic] = append(q.held[topic], message)
+		message = next
+	}
+	if q.roll(q.duplicateRate, &q.stats.Duplicates) {
+		q.held[topic] = append(q.held[topic], message.Clone())
+	}
+
+	return message, nil
+}
+
+// Size returns the number of messages in the wrapped queue plus the ones held back for reordering or duplication
+func (q *Queue) Size(ctx context.Context, topic string) (int, error) {
+	size, err := q.inner.Size(ctx, topic)
+	if err != nil {
+		return 0, err
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return size + len(q.held[topic]), nil
+}
+
+// Topics returns the topics of the wrapped queue
+func (q *Queue) Topics(ctx context.Context) ([]string, error) {
+	return q.inner.Topics(ctx)
+}
+
+// Close closes the wrapped queue, held back messages are discarded
+func (q *Queue) Close() error {
+	q.mu.Lock()
+	q.held = make(map[string][]*queue.Message)
+	q.mu.Unlock()
+
+	return q.inner.Close()
+}
+
+// CheckHealth checks the wrapped queue when it implements queue.HealthChecker, faults are not injected here
+func (q *Queue) CheckHealth(ctx context.Context) error {
+	if checker, ok := q.inner.(queue.HealthChecker); ok {
+		return checker.CheckHealth(ctx)
+	}
+	return nil
+}
+
+// Stats returns the number of faults injected so far
+func (q *Queue) Stats() Stats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.stats
+}
+
+// roll draws a number and counts a fault when it falls under rate, the caller holds the lock
+func (q *Queue) roll(rate float64, counter *int) bool {
+	if rate <= 0 {
+		return false
+	}
+	if q.rng.Float64() >= rate {
+		return false
+	}
+	*counter++
+	return true
+}
+
+// delay sleeps for the injected latency or until ctx is done
+func (q *Queue) delay(ctx context.Context) error {
+	q.mu.Lock()
+	var latency time.Duration
+	if q.maxLatency > 0 && q.roll(q.latencyRate, &q.stats.Delayed) {
+		latency = time.Duration(q.rng.Int63n(int64(q.maxLatency)) + 1)
+	}
+	q.mu.Unlock()
+
+	if latency == 0 {
+		return ctx.Err()
+	}
+
+	timer := q.clock.NewTimer(latency)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C():
+		return nil
+	}
+}
@@ -0,0 +1,276 @@
+package chaos
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/syl/Go/pkg/examples/queue"
+	"github.com/syl/Go/pkg/examples/queue/broker"
+	"github.com/syl/Go/pkg/examples/queue/example"
+	"github.com/syl/Go/pkg/examples/queue/inmemory/testutils"
+	"github.com/syl/Go/pkg/examples/queue/queuetest"
+)
+
+// ChaosTestFixture wraps a mock queue with the given faults
+type ChaosTestFixture struct {
+	Queue *Queue
+	Ctx   context.Context
+	T     *testing.T
+}
+
+func NewChaosTestFixture(t *testing.T, opts ...Option) *ChaosTestFixture {
+	t.Helper()
+
+	q := New(queue.NewMock(), opts...)
+	t.Cleanup(func() { q.Close() })
+
+	return &ChaosTestFixture{Queue: q, Ctx: context.Background(), T: t}
+}
+
+// EnqueueIDs enqueues messages with the given ids, ignoring injected failures
+func (f *ChaosTestFixture) EnqueueIDs(topic string, n int) {
+	f.T.Helper()
+
+	for i := 0; i < n; i++ {
+		err := f.Queue.Enqueue(f.Ctx, topic, queuetest.NewMessage(fmt.Sprintf("m%03d", i), topic))
+		if !errors.Is(err, ErrInjected) {
+			require.NoError(f.T, err)
+		}
+	}
+}
+
+// DequeueIDs dequeues until the topic is empty and returns the delivered ids
+func (f *ChaosTestFixture) DequeueIDs(topic string) []string {
+	f.T.Helper()
+
+	var ids []string
+	for {
+		msg, err := f.Queue.Dequeue(f.Ctx, topic)
+		if errors.Is(err, ErrInjected) {
+			continue
+		}
+		require.NoError(f.T, err)
+		if msg == nil {
+			return ids
+		}
+		ids = append(ids, msg.ID)
+	}
+}
+
+func TestConformance(t *testing.T) {
+	queuetest.Run(t, func(t *testing.T) queue.Queue {
+		return New(queue.NewMock())
+	})
+}
+
+func TestChaos(t *testing.T) {
+	t.Run("Errors", func(t *testing.T) {
+		fixture := NewChaosTestFixture(t, WithErrorRate(1))
+
+		err := fixture.Queue.Enqueue(fixture.Ctx, "orders", queuetest.NewMessage("m1", "orders"))
+		assert.ErrorIs(t, err, ErrInjected)
+		_, err = fixture.Queue.Dequeue(fixture.Ctx, "orders")
+		assert.ErrorIs(t, err, ErrInjected)
+		assert.Equal(t, 2, fixture.Queue.Stats().Errors)
+	})
+
+	t.Run("Loss", func(t *testing.T) {
+		fixture := NewChaosTestFixture(t, WithSeed(7), WithLossRate(0.5))
+		fixture.EnqueueIDs("orders", 100)
+
+		delivered := fixture.DequeueIDs("orders")
+		lost := fixture.Queue.Stats().Lost
+		assert.Greater(t, lost, 0, "Some messages should be lost")
+		assert.Len(t, delivered, 100-lost, "Lost messages should never be delivered")
+	})
+
+	t.Run("Duplicates", func(t *testing.T) {
+		fixture := NewChaosTestFixture(t, WithSeed(7), WithDuplicateRate(0.3))
+		fixture.EnqueueIDs("orders", 50)
+
+		delivered := fixture.DequeueIDs("orders")
+		duplicates := fixture.Queue.Stats().Duplicates
+		assert.Greater(t, duplicates, 0, "Some messages should be duplicated")
+		assert.Len(t, delivered, 50+duplicates, "Duplicates should be delivered again")
+	})
+
+	t.Run("Reordering", func(t *testing.T) {
+		fixture := NewChaosTestFixture(t, WithSeed(7), WithReorderRate(0.3))
+		fixture.EnqueueIDs("orders", 50)
+
+		delivered := fixture.DequeueIDs("orders")
+		assert.Len(t, delivered, 50, "Reordering should not lose messages")
+		assert.Greater(t, fixture.Queue.Stats().Reordered, 0)
+
+		sorted := true
+		for i := 1; i < len(delivered); i++ {
+			sorted = sorted && delivered[i-1] < delivered[i]
+		}
+		assert.False(t, sorted, "Messages should be delivered out of order")
+	})
+
+	t.Run("Latency", func(t *testing.T) {
+		clock := testutils.NewFakeClock(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+		fixture := NewChaosTestFixture(t, WithClock(clock), WithLatency(1, 20*time.Millisecond))
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			fixture.EnqueueIDs("orders", 1)
+		}()
+
+		clock.BlockUntil(1)
+		select {
+		case <-done:
+			t.Fatal("Should wait for the injected latency")
+		case <-time.After(10 * time.Millisecond):
+		}
+		clock.Advance(20 * time.Millisecond)
+		<-done
+		assert.Equal(t, 1, fixture.Queue.Stats().Delayed)
+
+		ctx, cancel := context.WithCancel(fixture.Ctx)
+		cancel()
+		_, err := fixture.Queue.Dequeue(ctx, "orders")
+		assert.ErrorIs(t, err, context.Canceled, "Latency should respect the context")
+	})
+
+	t.Run("SlowTopicDoesNotBlockOthers", func(t *testing.T) {
+		inner := &slowQueue{Queue: queue.NewMock(), topic: "slow", release: make(chan struct{}), entered: make(chan struct{})}
+		q := New(inner, WithReorderRate(0.5), WithDuplicateRate(0.5))
+		defer q.Close()
+		ctx := context.Background()
+		require.NoError(t, q.Enqueue(ctx, "fast", queuetest.NewMessage("f1", "fast")))
+
+		slow := make(chan struct{})
+		go func() {
+			defer close(slow)
+			q.Dequeue(ctx, "slow")
+		}()
+		<-inner.entered
+
+		fast := make(chan *queue.Message, 1)
+		go func() {
+			msg, _ := q.Dequeue(ctx, "fast")
+			fast <- msg
+		}()
+		select {
+		case msg := <-fast:
+			require.NotNil(t, msg)
+			assert.Equal(t, "f1", msg.ID)
+		case <-time.After(5 * time.Second):
+			t.Fatal("Dequeue of another topic should not wait for the slow one")
+		}
+
+		close(inner.release)
+		<-slow
+	})
+
+	t.Run("SameSeedSameFaults", func(t *testing.T) {
+		run := func() ([]string, Stats) {
+			fixture := NewChaosTestFixture(t, WithSeed(42), WithLossRate(0.1), WithDuplicateRate(0.1), WithReorderRate(0.1), WithErrorRate(0.1))
+			fixture.EnqueueIDs("orders", 100)
+			return fixture.DequeueIDs("orders"), fixture.Queue.Stats()
+		}
+
+		firstIDs, firstStats := run()
+		secondIDs, secondStats := run()
+		assert.Equal(t, firstIDs, secondIDs, "Same seed should deliver the same sequence")
+		assert.Equal(t, firstStats, secondStats, "Same seed should inject the same faults")
+	})
+}
+
+// TestIdempotentConsumer shows how a handler deduplicating by message ID copes with an unreliable queue
+func TestIdempotentConsumer(t *testing.T) {
+	q := New(queue.NewMock(), WithSeed(3), WithDuplicateRate(0.3), WithReorderRate(0.2), WithErrorRate(0.1))
+	defer q.Close()
+	ctx := context.Background()
+
+	producer := broker.NewQueueProducer(q)
+	published := 0
+	for published < 20 {
+		if err := producer.Publish(ctx, "orders", []byte(fmt.Sprint(published)), nil); err == nil {
+			published++
+		}
+	}
+
+	var mu sync.Mutex
+	processed := map[string]int{}
+	deliveries := 0
+	consumer := broker.NewQueueConsumer(q)
+	defer consumer.Close()
+	err := consumer.Subscribe(ctx, "orders", func(ctx context.Context, message *queue.Message) error {
+		mu.Lock()
+		defer mu.Unlock()
+
+		deliveries++
+		processed[message.ID]++
+		return nil
+	})
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(processed) == published
+	}, 10*time.Second, 20*time.Millisecond, "Every published order should be processed once deduplicated")
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Greater(t, deliveries, published, "The handler should have seen duplicate deliveries")
+}
+
+// TestConsumerServiceIdempotency checks that the example consumer service processes every order once
+// when the queue delivers duplicates out of order
+func TestConsumerServiceIdempotency(t *testing.T) {
+	q := New(queue.NewMock(), WithSeed(5), WithDuplicateRate(0.3), WithReorderRate(0.2), WithErrorRate(0.1))
+	defer q.Close()
+	ctx := context.Background()
+
+	producer := example.NewProducerService(broker.NewQueueProducer(q), testutils.CreateLogger("TEST-CHAOS-PRODUCER"))
+	const orders = 20
+	for published := 0; published < orders; {
+		if err := producer.PublishOrder(ctx); err == nil {
+			published++
+		}
+	}
+
+	clock := testutils.NewFakeClock(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	service := example.NewConsumerService(broker.NewQueueConsumer(q, broker.WithClock(clock)), testutils.CreateLogger("TEST-CHAOS-CONSUMER"))
+	defer service.Stop()
+	serviceCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go service.Start(serviceCtx)
+
+	clock.AdvanceUntil(t, broker.PollInterval, func() bool {
+		processed, duplicates := service.Processed()
+		return processed == orders && duplicates == q.Stats().Duplicates
+	}, "Every duplicate delivery should be skipped")
+
+	processed, duplicates := service.Processed()
+	assert.Equal(t, orders, processed, "Every order should be processed once")
+	assert.Positive(t, duplicates, "The queue should have delivered duplicates")
+}
+
+// slowQueue blocks dequeues of one topic until release is closed
+type slowQueue struct {
+	queue.Queue
+	topic   string
+	release chan struct{}
+	entered chan struct{}
+	once    sync.Once
+}
+
+func (q *slowQueue) Dequeue(ctx context.Context, topic string) (*queue.Message, error) {
+	if topic == q.topic {
+		q.once.Do(func() { close(q.entered) })
+		<-q.release
+	}
+	return q.Queue.Dequeue(ctx, topic)
+}
@@ -0,0 +1,369 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/syl/Go/pkg/examples/queue/broker"
+	"github.com/syl/Go/pkg/examples/queue/filter"
+	"github.com/syl/Go/pkg/examples/queue/inmemory"
+	"github.com/syl/Go/pkg/examples/queue/scheduler"
+	"gopkg.in/yaml.v3"
+)
+
+// Backend types selected by backend.type
+const (
+	backendMemory = "memory"
+	backendFile   = "file"
+)
+
+// config is the configuration of the example, read from a YAML file and QUEUE_* environment variables
+//
+//	backend:
+//	  type: file
+//	  file:
+//	    path: /var/lib/queue/snapshot.json
+//	topics:
+//	  - name: orders
+//	    dead_letter: true
+//	    retry:
+//	      attempts: 3
+//	      backoff: 1s
+//	consumer:
+//	  max_concurrent_handlers: 4
+//	services:
+//	  producer:
+//	    schedule: "*/5 * * * *"
+//	    timezone: Europe/Paris
+type config struct {
+	Backend         backendConfig  `yaml:"backend"`
+	Topics          []topicConfig  `yaml:"topics"`
+	Consumer        consumerConfig `yaml:"consumer"`
+	Services        servicesConfig `yaml:"services"`
+	ShutdownTimeout time.Duration  `yaml:"shutdown_timeout"`
+}
+
+// backendConfig selects the queue implementation, only the section of the selected type is used
+type backendConfig struct {
+	Type string     `yaml:"type"`
+	File fileConfig `yaml:"file"`
+}
+
+// fileConfig keeps the in-memory queue in a snapshot file across restarts
+type fileConfig struct {
+	Path             string        `yaml:"path"`
+	Format           string        `yaml:"format"`
+	SnapshotInterval time.Duration `yaml:"snapshot_interval"`
+}
+
+// topicConfig holds the subscription settings of a topic
+type topicConfig struct {
+	Name       string  `yaml:"name"`
+	Filter     string  `yaml:"filter,omitempty"`
+	DeadLetter bool    `yaml:"dead_letter"`
+	RateLimit  float64 `yaml:"rate_limit,omitempty"`
+	Burst      int     `yaml:"burst,omitempty"`
+	// Retry overrides the retry policy of the consumer for this topic
+	Retry *retryConfig `yaml:"retry,omitempty"`
+}
+
+// consumerConfig holds the settings shared by every subscription of the consumer
+type consumerConfig struct {
+	MaxConcurrentHandlers int           `yaml:"max_concurrent_handlers"`
+	DrainOnShutdown       bool          `yaml:"drain_on_shutdown"`
+	Retry                 retryConfig   `yaml:"retry"`
+	CircuitBreaker        breakerConfig `yaml:"circuit_breaker"`
+}
+
+// retryConfig is the number of attempts of a failing handler and the backoff before the first retry
+type retryConfig struct {
+	Attempts int           `yaml:"attempts"`
+	Backoff  time.Duration `yaml:"backoff"`
+}
+
+// breakerConfig enables a circuit breaker on every subscription when Threshold is set
+type breakerConfig struct {
+	Threshold int           `yaml:"threshold"`
+	Cooldown  time.Duration `yaml:"cooldown"`
+}
+
+// servicesConfig selects the services to run
+type servicesConfig struct {
+	Producer producerConfig `yaml:"producer"`
+	Consumer serviceConfig  `yaml:"consumer"`
+}
+
+// producerConfig publishes an order every Interval, or on the cron Schedule when it is set
+// StartDelay gives the consumer time to subscribe before the first order.
+type producerConfig struct {
+	Enabled    bool          `yaml:"enabled"`
+	StartDelay time.Duration `yaml:"start_delay"`
+	Interval   time.Duration `yaml:"interval"`
+	Schedule   string        `yaml:"schedule,omitempty"`
+	Timezone   string        `yaml:"timezone,omitempty"`
+	Jitter     time.Duration `yaml:"jitter,omitempty"`
+}
+
+// serviceConfig enables a service
+type serviceConfig struct {
+	Enabled bool `yaml:"enabled"`
+}
+
+// defaultConfig is the configuration used for the settings missing from the file and the environment
+func defaultConfig() *config {
+	return &config{
+		Backend: backendConfig{
+			Type: backendMemory,
+			File: fileConfig{
+				Path:             "queue-snapshot.json",
+				Format:           string(inmemory.SnapshotJSON),
+				SnapshotInterval: 30 * time.Second,
+			},
+		},
+		Topics: []topicConfig{{Name: "orders"}},
+		Consumer: consumerConfig{
+			DrainOnShutdown: true,
+			Retry:           retryConfig{Attempts: 1},
+		},
+		Services: servicesConfig{
+			Producer: producerConfig{Enabled: true, StartDelay: 500 * time.Millisecond, Interval: 2 * time.Second},
+			Consumer: serviceConfig{Enabled: true},
+		},
+		ShutdownTimeout: 10 * time.Second,
+	}
+}
+
+// loadConfig reads the YAML file at path, when not empty, over the defaults and applies the environment variables
+// The result is checked separately by validate.
+func loadConfig(path string, getenv func(string) string) (*config, error) {
+	c := defaultConfig()
+
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read config: %w", err)
+		}
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		// Misspelled settings are errors rather than silently ignored
+		decoder.KnownFields(true)
+		if err := decoder.Decode(c); err != nil && err != io.EOF {
+			return nil, fmt.Errorf("failed to parse config %s: %w", path, err)
+		}
+	}
+
+	if err := c.applyEnv(getenv); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// envVars maps the environment variables to the settings they override
+func (c *config) envVars() []struct {
+	name    string
+	setting any
+} {
+	return []struct {
+		name    string
+		setting any
+	}{
+		{"QUEUE_BACKEND", &c.Backend.Type},
+		{"QUEUE_FILE_PATH", &c.Backend.File.Path},
+		{"QUEUE_FILE_FORMAT", &c.Backend.File.Format},
+		{"QUEUE_FILE_SNAPSHOT_INTERVAL", &c.Backend.File.SnapshotInterval},
+		{"QUEUE_CONSUMER_MAX_CONCURRENT_HANDLERS", &c.Consumer.MaxConcurrentHandlers},
+		{"QUEUE_CONSUMER_RETRY_ATTEMPTS", &c.Consumer.Retry.Attempts},
+		{"QUEUE_CONSUMER_RETRY_BACKOFF", &c.Consumer.Retry.Backoff},
+		{"QUEUE_PRODUCER_ENABLED", &c.Services.Producer.Enabled},
+		{"QUEUE_PRODUCER_INTERVAL", &c.Services.Producer.Interval},
+		{"QUEUE_PRODUCER_SCHEDULE", &c.Services.Producer.Schedule},
+		{"QUEUE_PRODUCER_TIMEZONE", &c.Services.Producer.Timezone},
+		{"QUEUE_CONSUMER_ENABLED", &c.Services.Consumer.Enabled},
+		{"QUEUE_SHUTDOWN_TIMEOUT", &c.ShutdownTimeout},
+	}
+}
+
+// applyEnv overrides the settings whose environment variable is set
+func (c *config) applyEnv(getenv func(string) string) error {
+	var errs []error
+	for _, v := range c.envVars() {
+		value := getenv(v.name)
+		if value == "" {
+			continue
+		}
+
+		var err error
+		switch setting := v.setting.(type) {
+		case *string:
+			*setting = value
+		case *int:
+			*setting, err = strconv.Atoi(value)
+		case *bool:
+			*setting, err = strconv.ParseBool(value)
+		case *time.Duration:
+			*setting, err = time.ParseDuration(value)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: invalid value %q", v.name, value))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// validate checks every setting and returns all the problems found, one per line
+func (c *config) validate() error {
+	var errs []error
+	fail := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	switch backend := c.Backend; backend.Type {
+	case backendMemory:
+	case backendFile:
+		if backend.File.Path == "" {
+			fail("backend.file.path is required")
+		}
+		if format := inmemory.SnapshotFormat(backend.File.Format); format != inmemory.SnapshotJSON && format != inmemory.SnapshotBinary {
+			fail("backend.file.format: unknown format %q, expected %s or %s", backend.File.Format, inmemory.SnapshotJSON, inmemory.SnapshotBinary)
+		}
+		if backend.File.SnapshotInterval < 0 {
+			fail("backend.file.snapshot_interval must not be negative")
+		}
+	default:
+		fail("backend.type: unknown backend %q, expected %s or %s", backend.Type, backendMemory, backendFile)
+	}
+
+	seen := make(map[string]bool)
+	for i, topic := range c.Topics {
+		switch {
+		case topic.Name == "":
+			fail("topics[%d].name is required", i)
+		case seen[topic.Name]:
+			fail("topics[%d].name: duplicate topic %s", i, topic.Name)
+		}
+		seen[topic.Name] = true
+
+		if topic.Filter != "" {
+			if _, err := filter.Parse(topic.Filter); err != nil {
+				fail("topics[%d].filter: %v", i, err)
+			}
+		}
+		if topic.RateLimit != 0 || topic.Burst != 0 {
+			if _, err := broker.NewRateLimiter(topic.RateLimit, topic.Burst); err != nil {
+				fail("topics[%d].rate_limit: %v", i, err)
+			}
+		}
+		if topic.Retry != nil {
+			errs = append(errs, topic.Retry.validate(fmt.Sprintf("topics[%d].retry", i))...)
+		}
+	}
+
+	if c.Consumer.MaxConcurrentHandlers < 0 {
+		fail("consumer.max_concurrent_handlers must not be negative")
+	}
+	errs = append(errs, c.Consumer.Retry.validate("consumer.retry")...)
+	if breaker := c.Consumer.CircuitBreaker; breaker.Threshold != 0 || breaker.Cooldown != 0 {
+		if _, err := broker.NewCircuitBreaker("config", breaker.Threshold, breaker.Cooldown, nil); err != nil {
+			fail("consumer.circuit_breaker: %v", err)
+		}
+	}
+
+	producer := c.Services.Producer
+	if !producer.Enabled && !c.Services.Consumer.Enabled {
+		fail("services: no service is enabled")
+	}
+	if producer.Schedule != "" {
+		if _, err := scheduler.ParseCron(producer.Schedule); err != nil {
+			fail("services.producer.schedule: %v", err)
+		}
+	} else if producer.Interval <= 0 {
+		fail("services.producer.interval must be positive")
+	}
+	if producer.Timezone != "" {
+		if _, err := time.LoadLocation(producer.Timezone); err != nil {
+			fail("services.producer.timezone: unknown time zone %q", producer.Timezone)
+		}
+	}
+	if producer.Jitter < 0 {
+		fail("services.producer.jitter must not be negative")
+	}
+	if producer.StartDelay < 0 {
+		fail("services.producer.start_delay must not be negative")
+	}
+
+	if c.ShutdownTimeout <= 0 {
+		fail("shutdown_timeout must be positive")
+	}
+	return errors.Join(errs...)
+}
+
+// validate checks a retry policy, the errors are prefixed with the path of the setting
+func (r retryConfig) validate(path string) []error {
+	var errs []error
+	if r.Attempts < 1 {
+		errs = append(errs, fmt.Errorf("%s.attempts must be at least 1, got %d", path, r.Attempts))
+	}
+	if r.Backoff < 0 {
+		errs = append(errs, fmt.Errorf("%s.backoff must not be negative", path))
+	}
+	return errs
+}
+
+// print writes the configuration as YAML
+func (c *config) print(w io.Writer) error {
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(c); err != nil {
+		return fmt.Errorf("failed to print config: %w", err)
+	}
+	return encoder.Close()
+}
+
+// topic returns the settings of a topic, nil when it has none
+func (c *config) topic(name string) *topicConfig {
+	for i := range c.Topics {
+		if c.Topics[i].Name == name {
+			return &c.Topics[i]
+		}
+	}
+	return nil
+}
+
+// subscriptionOptions returns the broker options of a subscription to the topic
+func (c *config) subscriptionOptions(name string) []broker.SubscriptionOption {
+	retry := c.Consumer.Retry
+	var opts []broker.SubscriptionOption
+	if topic := c.topic(name); topic != nil {
+		if topic.Filter != "" {
+			opts = append(opts, broker.WithFilter(topic.Filter))
+		}
+		if topic.DeadLetter {
+			opts = append(opts, broker.WithDeadLetter())
+		}
+		if topic.RateLimit != 0 || topic.Burst != 0 {
+			opts = append(opts, broker.WithRateLimit(topic.RateLimit, topic.Burst))
+		}
+		if topic.Retry != nil {
+			retry = *topic.Retry
+		}
+	}
+
+	if retry.Attempts > 1 {
+		opts = append(opts, broker.WithRetry(retry.Attempts, retry.Backoff))
+	}
+	if breaker := c.Consumer.CircuitBreaker; breaker.Threshold != 0 {
+		opts = append(opts, broker.WithCircuitBreaker(breaker.Threshold, breaker.Cooldown, nil))
+	}
+	return opts
+}
+
+// formatErrors indents the errors joined by validate under a title
+func formatErrors(title string, err error) string {
+	lines := strings.Split(err.Error(), "\n")
+	return title + ":\n  - " + strings.Join(lines, "\n  - ")
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/syl/Go/pkg/examples/queue"
+	"github.com/syl/Go/pkg/examples/queue/inmemory/testutils"
+)
+
+// ConfigTestFixture loads configurations from temporary files and a fake environment
+type ConfigTestFixture struct {
+	T   *testing.T
+	Env map[string]string
+}
+
+func NewConfigTestFixture(t *testing.T) *ConfigTestFixture {
+	return &ConfigTestFixture{T: t, Env: make(map[string]string)}
+}
+
+// Getenv reads the fake environment
+func (f *ConfigTestFixture) Getenv(name string) string {
+	return f.Env[name]
+}
+
+// WriteFile writes a YAML configuration and returns its path
+func (f *ConfigTestFixture) WriteFile(content string) string {
+	f.T.Helper()
+
+	path := filepath.Join(f.T.TempDir(), "queue.yaml")
+	require.NoError(f.T, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+// Load loads the configuration of the YAML content, defaults only when empty
+func (f *ConfigTestFixture) Load(content string) (*config, error) {
+	path := ""
+	if content != "" {
+		path = f.WriteFile(content)
+	}
+	return loadConfig(path, f.Getenv)
+}
+
+// Run runs the command and returns its exit code and outputs
+func (f *ConfigTestFixture) Run(args ...string) (int, string, string) {
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	code := run(args, f.Getenv, stdout, stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestConfig(t *testing.T) {
+	t.Run("Defaults", func(t *testing.T) {
+		fixture := NewConfigTestFixture(t)
+
+		cfg, err := fixture.Load("")
+		require.NoError(t, err)
+		require.NoError(t, cfg.validate())
+		assert.Equal(t, defaultConfig(), cfg)
+		assert.Equal(t, backendMemory, cfg.Backend.Type)
+		assert.Equal(t, 2*time.Second, cfg.Services.Producer.Interval, "Should keep the historical producer interval")
+	})
+
+	t.Run("File", func(t *testing.T) {
+		fixture := NewConfigTestFixture(t)
+
+		cfg, err := fixture.Load(`
+backend:
+  type: file
+  file:
+    path: /tmp/queue.gob
+    format: binary
+topics:
+  - name: orders
+    dead_letter: true
+    filter: "priority = 'high'"
+    retry:
+      attempts: 3
+      backoff: 500ms
+consumer:
+  max_concurrent_handlers: 4
+services:
+  producer:
+    schedule: "*/5 * * * *"
+    timezone: Europe/Paris
+`)
+		require.NoError(t, err)
+		require.NoError(t, cfg.validate())
+
+		assert.Equal(t, fileConfig{Path: "/tmp/queue.gob", Format: "binary", SnapshotInterval: 30 * time.Second}, cfg.Backend.File,
+			"Should keep the defaults of the missing settings")
+		assert.Equal(t, []topicConfig{{
+			Name:       "orders",
+			DeadLetter: true,
+			Filter:     "priority = 'high'",
+			Retry:      &retryConfig{Attempts: 3, Backoff: 500 * time.Millisecond},
+		}}, cfg.Topics)
+		assert.Equal(t, 4, cfg.Consumer.MaxConcurrentHandlers)
+		assert.True(t, cfg.Services.Producer.Enabled)
+		assert.Equal(t, "*/5 * * * *", cfg.Services.Producer.Schedule)
+		assert.Len(t, cfg.subscriptionOptions("orders"), 3, "Should filter, dead-letter and retry orders")
+		assert.Empty(t, cfg.subscriptionOptions("payments"), "Topics without settings should use plain subscriptions")
+	})
+
+	t.Run("UnknownSetting", func(t *testing.T) {
+		fixture := NewConfigTestFixture(t)
+
+		_, err := fixture.Load("consumer:\n  max_concurrency: 4\n")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "field max_concurrency not found")
+
+		_, err = fixture.Load("shutdown_timeout: soon\n")
+		assert.Error(t, err, "Should reject an invalid duration")
+	})
+
+	t.Run("Env", func(t *testing.T) {
+		fixture := NewConfigTestFixture(t)
+		fixture.Env["QUEUE_BACKEND"] = "file"
+		fixture.Env["QUEUE_FILE_PATH"] = "/data/queue.json"
+		fixture.Env["QUEUE_CONSUMER_RETRY_ATTEMPTS"] = "5"
+		fixture.Env["QUEUE_PRODUCER_ENABLED"] = "false"
+		fixture.Env["QUEUE_SHUTDOWN_TIMEOUT"] = "1m"
+
+		cfg, err := fixture.Load("backend:\n  type: memory\n")
+		require.NoError(t, err)
+		require.NoError(t, cfg.validate())
+		assert.Equal(t, backendFile, cfg.Backend.Type, "The environment should override the file")
+		assert.Equal(t, "/data/queue.json", cfg.Backend.File.Path)
+		assert.Equal(t, 5, cfg.Consumer.Retry.Attempts)
+		assert.False(t, cfg.Services.Producer.Enabled)
+		assert.Equal(t, time.Minute, cfg.ShutdownTimeout)
+
+		fixture.Env["QUEUE_PRODUCER_ENABLED"] = "maybe"
+		fixture.Env["QUEUE_SHUTDOWN_TIMEOUT"] = "60"
+		_, err = fixture.Load("")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), `QUEUE_PRODUCER_ENABLED: invalid value "maybe"`)
+		assert.Contains(t, err.Error(), `QUEUE_SHUTDOWN_TIMEOUT: invalid value "60"`)
+	})
+
+	t.Run("Validation", func(t *testing.T) {
+		for _, tc := range []struct {
+			name     string
+			content  string
+			expected []string
+		}{
+			{"UnknownBackend", "backend:\n  type: redis\n", []string{`backend.type: unknown backend "redis"`}},
+			{"SQS", "backend:\n  type: sqs\n", []string{`backend.type: unknown backend "sqs", expected memory or file`}},
+			{"File", "backend:\n  type: file\n  file:\n    path: ''\n    format: xml\n", []string{
+				"backend.file.path is required", `backend.file.format: unknown format "xml"`,
+			}},
+			{"Topics", "topics:\n  - name: orders\n  - name: orders\n    filter: 'priority ='\n  - rate_limit: -1\n", []string{
+				"topics[1].name: duplicate topic orders", "topics[1].filter:", "topics[2].name is required", "topics[2].rate_limit: rate must be positive",
+			}},
+			{"Consumer", "consumer:\n  max_concurrent_handlers: -1\n  retry:\n    attempts: 0\n  circuit_breaker:\n    threshold: 3\n", []string{
+				"consumer.max_concurrent_handlers must not be negative", "consumer.retry.attempts must be at least 1, got 0", "consumer.circuit_breaker: cooldown must be positive",
+			}},
+			{"Services", "services:\n  producer:\n    enabled: false\n    schedule: '* * *'\n    timezone: Mars/Olympus\n  consumer:\n    enabled: false\n", []string{
+				"services: no service is enabled", "services.producer.schedule: invalid cron expression", `services.producer.timezone: unknown time zone "Mars/Olympus"`,
+			}},
+		} {
+			t.Run(tc.name, func(t *testing.T) {
+				cfg, err := NewConfigTestFixture(t).Load(tc.content)
+				require.NoError(t, err)
+
+				err = cfg.validate()
+				require.Error(t, err)
+				for _, expected := range tc.expected {
+					assert.Contains(t, err.Error(), expected)
+				}
+			})
+		}
+	})
+}
+
+func TestRun(t *testing.T) {
+	t.Run("PrintConfig", func(t *testing.T) {
+		fixture := NewConfigTestFixture(t)
+		fixture.Env["QUEUE_BACKEND"] = "file"
+		fixture.Env["QUEUE_FILE_PATH"] = "/var/lib/queue/snapshot.json"
+
+		code, stdout, stderr := fixture.Run("--print-config")
+		assert.Equal(t, 0, code, stderr)
+		assert.Contains(t, stdout, "type: file")
+		assert.Contains(t, stdout, "path: /var/lib/queue/snapshot.json")
+		assert.Contains(t, stdout, "interval: 2s", "Should print durations as strings")
+	})
+
+	t.Run("PrintInvalidConfig", func(t *testing.T) {
+		fixture := NewConfigTestFixture(t)
+		path := fixture.WriteFile("backend:\n  type: file\n  file:\n    format: xml\n")
+
+		code, stdout, stderr := fixture.Run("-config", path, "--print-config")
+		assert.Equal(t, 2, code)
+		assert.Empty(t, stdout, "Should not print a configuration that cannot run")
+		assert.Equal(t, "invalid configuration:\n  - backend.file.format: unknown format \"xml\", expected json or binary\n", stderr)
+	})
+
+	t.Run("InvalidConfig", func(t *testing.T) {
+		fixture := NewConfigTestFixture(t)
+		fixture.Env["QUEUE_CONFIG"] = filepath.Join(t.TempDir(), "missing.yaml")
+
+		code, _, stderr := fixture.Run()
+		assert.Equal(t, 2, code)
+		assert.Contains(t, stderr, "failed to read config")
+	})
+}
+
+func TestNewQueue(t *testing.T) {
+	ctx := context.Background()
+	logger := testutils.CreateLogger("TEST-MAIN")
+
+	t.Run("File", func(t *testing.T) {
+		cfg := defaultConfig()
+		cfg.Backend.Type = backendFile
+		cfg.Backend.File.Path = filepath.Join(t.TempDir(), "queue.json")
+
+		q, err := newQueue(cfg, logger)
+		require.NoError(t, err)
+		require.NoError(t, q.Enqueue(ctx, "orders", &queue.Message{ID: "order-1", Payload: []byte("{}")}))
+		require.NoError(t, q.Close())
+
+		restarted, err := newQueue(cfg, logger)
+		require.NoError(t, err)
+		defer restarted.Close()
+		size, err := restarted.Size(ctx, "orders")
+		require.NoError(t, err)
+		assert.Equal(t, 1, size, "Should restore the pending messages of the previous run")
+	})
+
+	t.Run("UnknownBackend", func(t *testing.T) {
+		cfg := defaultConfig()
+		cfg.Backend.Type = "sqs"
+
+		_, err := newQueue(cfg, logger)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), `unknown backend "sqs"`)
+	})
+
+}
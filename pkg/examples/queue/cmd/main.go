@@ -1,7 +1,15 @@
+// Command cmd runs the example producer and consumer services on a queue backend chosen by configuration.
+//
+//	cmd [-config queue.yaml] [--print-config]
+//
+// Settings come from the defaults, then the YAML file, then the QUEUE_* environment variables (see config).
 package main
 
 import (
 	"context"
+	"flag"
+	"fmt"
+	"io"
 	"log"
 	"os"
 	"os/signal"
@@ -9,72 +17,189 @@ import (
 	"syscall"
 	"time"
 
+	queuepkg "github.com/syl/Go/pkg/examples/queue"
 	"github.com/syl/Go/pkg/examples/queue/broker"
 	"github.com/syl/Go/pkg/examples/queue/example"
 	"github.com/syl/Go/pkg/examples/queue/inmemory"
+	"github.com/syl/Go/pkg/examples/queue/scheduler"
 )
 
 func main() {
-	producerLogger := log.New(os.Stdout, "[PRODUCER] ", log.LstdFlags|log.Lshortfile)
-	consumerLogger := log.New(os.Stdout, "[CONSUMER] ", log.LstdFlags|log.Lshortfile)
-	mainLogger := log.New(os.Stdout, "[MAIN] ", log.LstdFlags|log.Lshortfile)
+	os.Exit(run(os.Args[1:], os.Getenv, os.Stdout, os.Stderr))
+}
+
+// run loads and validates the configuration, then runs the services until interrupted and returns the exit code
+func run(args []string, getenv func(string) string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("cmd", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	configPath := flags.String("config", getenv("QUEUE_CONFIG"), "YAML configuration file, defaults only when empty (env QUEUE_CONFIG)")
+	printConfig := flags.Bool("print-config", false, "print the effective configuration and exit")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	cfg, err := loadConfig(*configPath, getenv)
+	if err != nil {
+		fmt.Fprintln(stderr, formatErrors("invalid configuration", err))
+		return 2
+	}
+
+	if err := cfg.validate(); err != nil {
+		fmt.Fprintln(stderr, formatErrors("invalid configuration", err))
+		return 2
+	}
+	if *printConfig {
+		if err := cfg.print(stdout); err != nil {
+			fmt.Fprintln(stderr, err)
+			return 1
+		}
+		return 0
+	}
+
+	if err := serve(cfg, stdout); err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	return 0
+}
 
-	mainLogger.Println("Starting queue system example...")
+// newQueue creates the queue of the configured backend
+func newQueue(cfg *config, logger *log.Logger) (*inmemory.InMemoryQueue, error) {
+	switch cfg.Backend.Type {
+	case backendMemory:
+		return inmemory.NewInMemoryQueue(), nil
+	case backendFile:
+		file := cfg.Backend.File
+		opts := []inmemory.Option{
+			inmemory.WithSnapshotFile(file.Path, inmemory.SnapshotFormat(file.Format)),
+			inmemory.WithSnapshotOnClose(),
+			inmemory.WithSnapshotErrorHandler(func(err error) {
+				logger.Printf("Failed to write snapshot %s: %v", file.Path, err)
+			}),
+		}
+		if file.SnapshotInterval > 0 {
+			opts = append(opts, inmemory.WithSnapshotInterval(file.SnapshotInterval))
+		}
+
+		q := inmemory.NewInMemoryQueue(opts...)
+		if err := q.LoadSnapshotFile(); err != nil {
+			q.Close()
+			return nil, fmt.Errorf("failed to load snapshot %s: %w", file.Path, err)
+		}
+		return q, nil
+	default:
+		return nil, fmt.Errorf("unknown backend %q", cfg.Backend.Type)
+	}
+}
 
-	queue := inmemory.NewInMemoryQueue()
-	defer queue.Close()
+// configuredConsumer applies the configured topic settings to the subscriptions of the services
+type configuredConsumer struct {
+	*broker.QueueConsumer
+	cfg *config
+}
+
+func (c *configuredConsumer) Subscribe(ctx context.Context, topic string, handler queuepkg.MessageHandler) error {
+	return c.SubscribeWithOptions(ctx, topic, handler, c.cfg.subscriptionOptions(topic)...)
+}
+
+// serve runs the enabled services until SIGINT or SIGTERM, then shuts them down
+func serve(cfg *config, out io.Writer) error {
+	producerLogger := log.New(out, "[PRODUCER] ", log.LstdFlags|log.Lshortfile)
+	consumerLogger := log.New(out, "[CONSUMER] ", log.LstdFlags|log.Lshortfile)
+	mainLogger := log.New(out, "[MAIN] ", log.LstdFlags|log.Lshortfile)
+
+	mainLogger.Printf("Starting queue system example with the %s backend...", cfg.Backend.Type)
+
+	queue, err := newQueue(cfg, mainLogger)
+	if err != nil {
+		return err
+	}
 
 	producer := broker.NewQueueProducer(queue)
-	consumer := broker.NewQueueConsumer(queue)
+	consumerOpts := []broker.ConsumerOption{broker.WithMaxConcurrentHandlers(cfg.Consumer.MaxConcurrentHandlers)}
+	if cfg.Consumer.DrainOnShutdown {
+		consumerOpts = append(consumerOpts, broker.WithDrainOnShutdown())
+	}
+	consumer := broker.NewQueueConsumer(queue, consumerOpts...)
 
-	producerService := example.NewProducerService(producer, producerLogger)
-	consumerService := example.NewConsumerService(consumer, consumerLogger)
+	producerService := example.NewProducerService(producer, producerLogger, example.WithInterval(cfg.Services.Producer.Interval))
+	startProducer, err := producerStart(cfg, producerService, producerLogger)
+	if err != nil {
+		queue.Close()
+		return err
+	}
+	consumerService := example.NewConsumerService(&configuredConsumer{QueueConsumer: consumer, cfg: cfg}, consumerLogger)
 
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+	// The consumer context outlives the producer one so that pending orders are drained on shutdown
+	producerCtx, cancelProducer := context.WithCancel(context.Background())
+	defer cancelProducer()
+	consumerCtx, cancelConsumer := context.WithCancel(context.Background())
+	defer cancelConsumer()
 
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
 
 	var wg sync.WaitGroup
 
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		if err := consumerService.Start(ctx); err != nil && err != context.Canceled {
-			mainLogger.Printf("Consumer service error: %v", err)
-		}
-	}()
+	if cfg.Services.Consumer.Enabled {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := consumerService.Start(consumerCtx); err != nil && err != context.Canceled {
+				mainLogger.Printf("Consumer service error: %v", err)
+			}
+		}()
+	}
 
-	time.Sleep(500 * time.Millisecond)
+	if cfg.Services.Producer.Enabled {
+		time.Sleep(cfg.Services.Producer.StartDelay)
 
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		if err := producerService.Start(ctx); err != nil && err != context.Canceled {
-			mainLogger.Printf("Producer service error: %v", err)
-		}
-	}()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := startProducer(producerCtx); err != nil && err != context.Canceled {
+				mainLogger.Printf("Producer service error: %v", err)
+			}
+		}()
+	}
 
 	mainLogger.Println("Services started. Press Ctrl+C to stop...")
 
 	<-sigChan
 	mainLogger.Println("Shutting down services...")
 
-	cancel()
-
-	done := make(chan struct{})
-	go func() {
-		wg.Wait()
-		close(done)
-	}()
-
-	select {
-	case <-done:
-		mainLogger.Println("All services shut down gracefully")
-	case <-time.After(10 * time.Second):
-		mainLogger.Println("Shutdown timeout reached")
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancelShutdown()
+
+	// Stop producing first, then let the consumer drain its topics. The consumer is stopped by then,
+	// so the queue only waits DrainIdleTimeout for the messages left before saving or dropping them.
+	cancelProducer()
+	steps := []struct {
+		name      string
+		component queuepkg.Shutdowner
+	}{
+		{"producer", producer},
+		{"consumer", consumer},
+		{"queue", queue},
 	}
+	for _, step := range steps {
+		report, err := step.component.Shutdown(shutdownCtx)
+		if err != nil {
+			mainLogger.Printf("Shutdown of %s did not complete: %v", step.name, err)
+		}
+		if report.Interrupted > 0 {
+			mainLogger.Printf("Shutdown of %s interrupted %d in-flight operation(s)", step.name, report.Interrupted)
+		}
+		for topic, n := range report.Dropped {
+			mainLogger.Printf("Shutdown of %s dropped %d message(s) from %s", step.name, n, topic)
+		}
+		for topic, n := range report.Persisted {
+			mainLogger.Printf("Shutdown of %s saved %d message(s) from %s", step.name, n, topic)
+		}
+	}
+
+	cancelConsumer()
+	wg.Wait()
 
 	if err := producerService.Stop(); err != nil {
 		mainLogger.Printf("Error stopping producer service: %v", err)
@@ -85,4 +210,35 @@ func main() {
 	}
 
 	mainLogger.Println("Example completed")
+	return nil
+}
+
+// producerStart returns the loop of the producer service, publishing on the cron schedule when one is configured
+func producerStart(cfg *config, producerService *example.ProducerService, logger *log.Logger) (func(context.Context) error, error) {
+	producer := cfg.Services.Producer
+	if producer.Schedule == "" {
+		return producerService.Start, nil
+	}
+
+	location := time.Local
+	if producer.Timezone != "" {
+		var err error
+		if location, err = time.LoadLocation(producer.Timezone); err != nil {
+			return nil, err
+		}
+	}
+
+	s := scheduler.NewScheduler(scheduler.WithLogger(logger))
+	err := s.Add(scheduler.Job{
+		Name:          "orders",
+		Schedule:      producer.Schedule,
+		Location:      location,
+		Jitter:        producer.Jitter,
+		SkipIfRunning: true,
+		Run:           producerService.PublishOrder,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid producer schedule: %w", err)
+	}
+	return s.Start, nil
 }
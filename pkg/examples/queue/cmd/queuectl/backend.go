@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/syl/Go/pkg/examples/queue"
+	"github.com/syl/Go/pkg/examples/queue/inmemory"
+	"github.com/syl/Go/pkg/examples/queue/remote"
+)
+
+// backend is the queue targeted by the commands
+type backend interface {
+	queue.Queue
+	// Purge removes all pending messages of the topic and returns how many were removed
+	Purge(ctx context.Context, topic string) (int, error)
+}
+
+// ensure that the backends implement the backend interface
+var (
+	_ backend = (*inmemory.InMemoryQueue)(nil)
+	_ backend = (*remote.Client)(nil)
+)
+
+// newBackend connects to the broker server at baseURL, or opens an in-process queue
+// restored from and saved to the snapshot file so that successive commands share it
+func newBackend(baseURL, token, snapshot string) (backend, error) {
+	if baseURL != "" {
+		return remote.NewClient(baseURL, remote.WithToken(token)), nil
+	}
+	if snapshot == "" {
+		return nil, errors.New("either -url or -snapshot is required")
+	}
+
+	// Read the snapshot before creating the queue, closing it would overwrite an unreadable file
+	restored, err := inmemory.ReadSnapshotFile(snapshot, inmemory.SnapshotJSON)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to load snapshot %s: %w", snapshot, err)
+	}
+
+	q := inmemory.NewInMemoryQueue(
+		inmemory.WithSnapshotFile(snapshot, inmemory.SnapshotJSON),
+		inmemory.WithSnapshotOnClose(),
+	)
+	if restored != nil {
+		if err := q.Restore(restored); err != nil {
+			return nil, fmt.Errorf("failed to load snapshot %s: %w", snapshot, err)
+		}
+	}
+	return q, nil
+}
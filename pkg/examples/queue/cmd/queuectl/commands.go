@@ -0,0 +1,349 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/syl/Go/pkg/examples/queue"
+	"github.com/syl/Go/pkg/examples/queue/broker"
+	"github.com/syl/Go/pkg/examples/queue/inmemory"
+)
+
+func newFlagSet(a *app, name string) *flag.FlagSet {
+	fs := flag.NewFlagSet("queuectl "+name, flag.ContinueOnError)
+	fs.SetOutput(a.stderr)
+	return fs
+}
+
+func requireTopic(topic string) error {
+	if topic == "" {
+		return errors.New("-topic is required")
+	}
+	return nil
+}
+
+func runPublish(a *app, args []string) error {
+	fs := newFlagSet(a, "publish")
+	topic := fs.String("topic", "", "topic to publish to")
+	file := fs.String("file", "-", "file to read the payload from, - for stdin")
+	count := fs.Int("count", 1, "number of times the payload is published")
+	headers := headerFlags{}
+	fs.Var(headers, "H", "message header as key=value, can be repeated")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := requireTopic(*topic); err != nil {
+		return err
+	}
+
+	payload, err := readPayload(a.stdin, *file)
+	if err != nil {
+		return err
+	}
+
+	producer := broker.NewQueueProducer(a.backend)
+	defer producer.Close()
+
+	ctx := context.Background()
+	for i := 0; i < *count; i++ {
+		if err := producer.Publish(ctx, *topic, payload, headers); err != nil {
+			return fmt.Errorf("failed to publish message %d: %w", i+1, err)
+		}
+	}
+
+	fmt.Fprintf(a.stdout, "published %d message(s) to %s\n", *count, *topic)
+	return nil
+}
+
+func readPayload(stdin io.Reader, file string) ([]byte, error) {
+	if file == "-" {
+		return io.ReadAll(stdin)
+	}
+	return os.ReadFile(file)
+}
+
+// consumedMessage is the JSON line printed for each consumed message
+// The payload is embedded as is when it holds JSON and as a string otherwise
+type consumedMessage struct {
+	ID        string            `json:"id"`
+	Topic     string            `json:"topic"`
+	Headers   map[string]string `json:"headers,omitempty"`
+	Timestamp time.Time         `json:"timestamp"`
+	Payload   interface{}       `json:"payload"`
+}
+
+func newConsumedMessage(message *queue.Message) consumedMessage {
+	var payload interface{} = string(message.Payload)
+	if json.Valid(message.Payload) {
+		payload = json.RawMessage(message.Payload)
+	}
+	return consumedMessage{
+		ID:        message.ID,
+		Topic:     message.Topic,
+		Headers:   message.Headers,
+		Timestamp: message.Timestamp,
+		Payload:   payload,
+	}
+}
+
+func runConsume(a *app, args []string) error {
+	fs := newFlagSet(a, "consume")
+	topic := fs.String("topic", "", "topic or topic pattern to consume from")
+	max := fs.Int("n", 0, "stop after n messages, 0 to consume until interrupted")
+	timeout := fs.Duration("timeout", 0, "stop after this duration, 0 to consume until interrupted")
+	filterExpr := fs.String("filter", "", "only consume messages whose headers match the filter expression")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := requireTopic(*topic); err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if *timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
+	ctx, done := context.WithCancel(ctx)
+	defer done()
+
+	consumer := broker.NewQueueConsumer(a.backend)
+	defer consumer.Close()
+
+	var opts []broker.SubscriptionOption
+	if *filterExpr != "" {
+		opts = append(opts, broker.WithFilter(*filterExpr))
+	}
+
+	var mu sync.Mutex
+	encoder := json.NewEncoder(a.stdout)
+	received := 0
+	handler := func(ctx context.Context, message *queue.Message) error {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if err := encoder.Encode(newConsumedMessage(message)); err != nil {
+			return err
+		}
+		received++
+		if *max > 0 && received >= *max {
+			done()
+		}
+		return nil
+	}
+
+	if err := consumer.SubscribeWithOptions(ctx, *topic, handler, opts...); err != nil {
+		return err
+	}
+
+	<-ctx.Done()
+	return nil
+}
+
+func runTopics(a *app, args []string) error {
+	fs := newFlagSet(a, "topics")
+	asJSON := fs.Bool("json", false, "print topics as JSON")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	topics, err := a.backend.Topics(ctx)
+	if err != nil {
+		return err
+	}
+	sort.Strings(topics)
+
+	type topicSize struct {
+		Topic string `json:"topic"`
+		Size  int    `json:"size"`
+	}
+	sizes := make([]topicSize, 0, len(topics))
+	for _, topic := range topics {
+		size, err := a.backend.Size(ctx, topic)
+		if err != nil {
+			return err
+		}
+		sizes = append(sizes, topicSize{Topic: topic, Size: size})
+	}
+
+	if *asJSON {
+		return json.NewEncoder(a.stdout).Encode(sizes)
+	}
+
+	w := tabwriter.NewWriter(a.stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TOPIC\tSIZE")
+	for _, s := range sizes {
+		fmt.Fprintf(w, "%s\t%d\n", s.Topic, s.Size)
+	}
+	return w.Flush()
+}
+
+func runSize(a *app, args []string) error {
+	fs := newFlagSet(a, "size")
+	topic := fs.String("topic", "", "topic to measure")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := requireTopic(*topic); err != nil {
+		return err
+	}
+
+	size, err := a.backend.Size(context.Background(), *topic)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(a.stdout, size)
+	return nil
+}
+
+func runPurge(a *app, args []string) error {
+	fs := newFlagSet(a, "purge")
+	topic := fs.String("topic", "", "topic to purge")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := requireTopic(*topic); err != nil {
+		return err
+	}
+
+	purged, err := a.backend.Purge(context.Background(), *topic)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(a.stdout, "purged %d message(s) from %s\n", purged, *topic)
+	return nil
+}
+
+func runBench(a *app, args []string) error {
+	fs := newFlagSet(a, "bench")
+	topic := fs.String("topic", "", "topic used for the benchmark, a new bench-<uuid> topic by default")
+	messages := fs.Int("messages", 1000, "number of messages to publish and consume")
+	batch := fs.Int("batch", inmemory.TopicCapacity, "number of messages published before they are consumed, at most the topic capacity")
+	size := fs.Int("size", 256, "payload size in bytes")
+	producers := fs.Int("producers", 4, "number of concurrent producers")
+	consumers := fs.Int("consumers", 4, "number of concurrent consumers")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *messages <= 0 || *batch <= 0 || *producers <= 0 || *consumers <= 0 {
+		return errors.New("-messages, -batch, -producers and -consumers must be positive")
+	}
+
+	ctx := context.Background()
+	if *topic == "" {
+		// A topic of its own so that messages left by other runs are not counted
+		*topic = "bench-" + uuid.NewString()
+		if inspector, ok := a.backend.(queue.Inspector); ok {
+			defer inspector.DeleteTopic(ctx, *topic)
+		}
+	}
+
+	payload := make([]byte, *size)
+	producer := broker.NewQueueProducer(a.backend)
+	defer producer.Close()
+
+	// Publish and consume in batches, a topic cannot hold more than its capacity
+	var consumed int
+	var publishDuration, consumeDuration time.Duration
+	for sent := 0; sent < *messages; {
+		n := min(*batch, *messages-sent)
+
+		duration, err := benchPublish(ctx, producer, *topic, payload, n, *producers)
+		if err != nil {
+			return fmt.Errorf("publish failed: %w", err)
+		}
+		publishDuration += duration
+		sent += n
+
+		received, duration, err := benchConsume(ctx, a.backend, *topic, n, *consumers)
+		if err != nil {
+			return fmt.Errorf("consume failed: %w", err)
+		}
+		consumeDuration += duration
+		consumed += received
+	}
+
+	w := tabwriter.NewWriter(a.stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "PHASE\tMESSAGES\tDURATION\tMSG/S\tMB/S")
+	printBenchLine(w, "publish", *messages, *size, publishDuration)
+	printBenchLine(w, "consume", consumed, *size, consumeDuration)
+	return w.Flush()
+}
+
+// benchPublish publishes n messages from concurrent producers and returns how long it took
+func benchPublish(ctx context.Context, producer *broker.QueueProducer, topic string, payload []byte, n, producers int) (time.Duration, error) {
+	var published atomic.Int64
+	errs := make(chan error, producers)
+	start := time.Now()
+	var wg sync.WaitGroup
+	for p := 0; p < producers; p++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for published.Add(1) <= int64(n) {
+				if err := producer.Publish(ctx, topic, payload, nil); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	duration := time.Since(start)
+	close(errs)
+	return duration, <-errs
+}
+
+// benchConsume dequeues up to n messages from concurrent consumers and returns how many it got and how long it took
+func benchConsume(ctx context.Context, q queue.Queue, topic string, n, consumers int) (int, time.Duration, error) {
+	var consumed atomic.Int64
+	errs := make(chan error, consumers)
+	start := time.Now()
+	var wg sync.WaitGroup
+	for c := 0; c < consumers; c++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for consumed.Load() < int64(n) {
+				message, err := q.Dequeue(ctx, topic)
+				if err != nil {
+					errs <- err
+					return
+				}
+				if message == nil {
+					return
+				}
+				consumed.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	duration := time.Since(start)
+	close(errs)
+	return int(consumed.Load()), duration, <-errs
+}
+
+func printBenchLine(w io.Writer, phase string, messages, size int, duration time.Duration) {
+	seconds := duration.Seconds()
+	if seconds == 0 {
+		seconds = time.Nanosecond.Seconds()
+	}
+	rate := float64(messages) / seconds
+	fmt.Fprintf(w, "%s\t%d\t%s\t%.0f\t%.2f\n", phase, messages, duration.Round(time.Microsecond), rate, rate*float64(size)/1e6)
+}
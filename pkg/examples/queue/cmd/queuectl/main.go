@@ -0,0 +1,130 @@
+// Command queuectl publishes, consumes and inspects queue topics.
+//
+//	queuectl [-url URL -token TOKEN | -snapshot FILE] <command> [flags]
+//
+// With -url the commands target a broker server (see remote.Client). Without it they run against
+// an in-process in-memory queue restored from and saved to the -snapshot file, so that successive
+// commands see the messages of each other.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// command is a queuectl subcommand
+type command struct {
+	name        string
+	description string
+	run         func(app *app, args []string) error
+}
+
+var commands = []command{
+	{"publish", "publish a message read from a file or stdin", runPublish},
+	{"consume", "tail a topic and print messages as JSON lines", runConsume},
+	{"topics", "list topics with their sizes", runTopics},
+	{"size", "print the number of pending messages of a topic", runSize},
+	{"purge", "remove all pending messages of a topic", runPurge},
+	{"bench", "measure publish and consume throughput", runBench},
+}
+
+// app holds the shared state of a queuectl invocation
+type app struct {
+	backend backend
+	stdin   io.Reader
+	stdout  io.Writer
+	stderr  io.Writer
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// run executes queuectl with the given arguments and returns the exit code
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	global := flag.NewFlagSet("queuectl", flag.ContinueOnError)
+	global.SetOutput(stderr)
+	url := global.String("url", "", "base URL of a broker server, in-process queue when empty")
+	token := global.String("token", os.Getenv("QUEUECTL_TOKEN"), "bearer token for the broker server (env QUEUECTL_TOKEN)")
+	snapshot := global.String("snapshot", os.Getenv("QUEUECTL_SNAPSHOT"), "snapshot file of the in-process queue (env QUEUECTL_SNAPSHOT)")
+	global.Usage = func() { usage(global) }
+
+	if err := global.Parse(args); err != nil {
+		return 2
+	}
+	if global.NArg() == 0 {
+		global.Usage()
+		return 2
+	}
+
+	name := global.Arg(0)
+	var cmd *command
+	for i := range commands {
+		if commands[i].name == name {
+			cmd = &commands[i]
+		}
+	}
+	if cmd == nil {
+		fmt.Fprintf(stderr, "unknown command %q\n", name)
+		global.Usage()
+		return 2
+	}
+
+	b, err := newBackend(*url, *token, *snapshot)
+	if err != nil {
+		fmt.Fprintf(stderr, "queuectl: %v\n", err)
+		return 2
+	}
+
+	a := &app{backend: b, stdin: stdin, stdout: stdout, stderr: stderr}
+	err = cmd.run(a, global.Args()[1:])
+	if closeErr := b.Close(); closeErr != nil {
+		// The in-process queue saves its snapshot on close
+		err = errors.Join(err, fmt.Errorf("failed to close queue: %w", closeErr))
+	}
+	if err != nil {
+		if err == flag.ErrHelp {
+			return 0
+		}
+		fmt.Fprintf(stderr, "queuectl %s: %v\n", name, err)
+		return 1
+	}
+	return 0
+}
+
+func usage(global *flag.FlagSet) {
+	out := global.Output()
+	fmt.Fprintln(out, "Usage: queuectl [global flags] <command> [flags]")
+	fmt.Fprintln(out, "\nCommands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(out, "  %-8s %s\n", cmd.name, cmd.description)
+	}
+	fmt.Fprintln(out, "\nGlobal flags:")
+	global.PrintDefaults()
+}
+
+// headerFlags collects repeated -H key=value flags
+type headerFlags map[string]string
+
+func (h headerFlags) String() string {
+	pairs := make([]string, 0, len(h))
+	for k, v := range h {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (h headerFlags) Set(value string) error {
+	key, val, ok := strings.Cut(value, "=")
+	if !ok || key == "" {
+		return fmt.Errorf("header %q must be key=value", value)
+	}
+	h[key] = val
+	return nil
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/syl/Go/pkg/examples/queue"
+	"github.com/syl/Go/pkg/examples/queue/inmemory"
+)
+
+// CLITestFixture runs queuectl commands against a shared in-memory queue
+type CLITestFixture struct {
+	App    *app
+	Stdout *bytes.Buffer
+	Stderr *bytes.Buffer
+	T      *testing.T
+}
+
+func NewCLITestFixture(t *testing.T, stdin string) *CLITestFixture {
+	t.Helper()
+
+	q := inmemory.NewInMemoryQueue()
+	t.Cleanup(func() { q.Close() })
+
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	return &CLITestFixture{
+		App:    &app{backend: q, stdin: strings.NewReader(stdin), stdout: stdout, stderr: stderr},
+		Stdout: stdout,
+		Stderr: stderr,
+		T:      t,
+	}
+}
+
+// Run executes a command and resets the captured output
+func (f *CLITestFixture) Run(run func(*app, []string) error, args ...string) string {
+	f.T.Helper()
+
+	f.Stdout.Reset()
+	require.NoError(f.T, run(f.App, args), "Command should succeed: %s", f.Stderr.String())
+	return f.Stdout.String()
+}
+
+func TestCommands(t *testing.T) {
+	t.Run("PublishFromStdin", func(t *testing.T) {
+		fixture := NewCLITestFixture(t, `{"order_id":"order-1"}`)
+
+		out := fixture.Run(runPublish, "-topic", "orders", "-H", "source=cli", "-H", "message_type=order", "-count", "2")
+		assert.Equal(t, "published 2 message(s) to orders\n", out)
+
+		msg, err := fixture.App.backend.Dequeue(context.Background(), "orders")
+		require.NoError(t, err)
+		require.NotNil(t, msg)
+		assert.JSONEq(t, `{"order_id":"order-1"}`, string(msg.Payload))
+		assert.Equal(t, map[string]string{"source": "cli", "message_type": "order"}, msg.Headers)
+	})
+
+	t.Run("PublishRequiresTopic", func(t *testing.T) {
+		fixture := NewCLITestFixture(t, "payload")
+		assert.Error(t, runPublish(fixture.App, nil), "Should require a topic")
+	})
+
+	t.Run("InvalidHeader", func(t *testing.T) {
+		fixture := NewCLITestFixture(t, "payload")
+		assert.Error(t, runPublish(fixture.App, []string{"-topic", "orders", "-H", "novalue"}), "Should reject malformed header")
+	})
+
+	t.Run("TopicsSizeAndPurge", func(t *testing.T) {
+		fixture := NewCLITestFixture(t, "payload")
+		fixture.Run(runPublish, "-topic", "orders", "-count", "3")
+
+		out := fixture.Run(runTopics)
+		assert.Contains(t, out, "TOPIC")
+		assert.Regexp(t, `orders\s+3`, out)
+
+		out = fixture.Run(runTopics, "-json")
+		assert.JSONEq(t, `[{"topic":"orders","size":3}]`, out)
+
+		assert.Equal(t, "3\n", fixture.Run(runSize, "-topic", "orders"))
+		assert.Equal(t, "purged 3 message(s) from orders\n", fixture.Run(runPurge, "-topic", "orders"))
+		assert.Equal(t, "0\n", fixture.Run(runSize, "-topic", "orders"))
+	})
+
+	t.Run("Consume", func(t *testing.T) {
+		fixture := NewCLITestFixture(t, `{"order_id":"order-1"}`)
+		fixture.Run(runPublish, "-topic", "orders", "-H", "source=cli")
+		fixture.App.stdin = strings.NewReader("plain text")
+		fixture.Run(runPublish, "-topic", "orders")
+
+		out := fixture.Run(runConsume, "-topic", "orders", "-n", "2", "-timeout", "5s")
+		lines := strings.Split(strings.TrimSpace(out), "\n")
+		require.Len(t, lines, 2, "Should print one JSON line per message")
+
+		var first, second map[string]interface{}
+		require.NoError(t, json.Unmarshal([]byte(lines[0]), &first))
+		require.NoError(t, json.Unmarshal([]byte(lines[1]), &second))
+		assert.Equal(t, map[string]interface{}{"order_id": "order-1"}, first["payload"], "JSON payload should be embedded")
+		assert.Equal(t, "cli", first["headers"].(map[string]interface{})["source"])
+		assert.Equal(t, "plain text", second["payload"], "Text payload should be a string")
+	})
+
+	t.Run("ConsumeTimeout", func(t *testing.T) {
+		fixture := NewCLITestFixture(t, "")
+
+		out := fixture.Run(runConsume, "-topic", "orders", "-timeout", "200ms")
+		assert.Empty(t, out, "Should stop after the timeout without messages")
+	})
+
+	t.Run("Bench", func(t *testing.T) {
+		fixture := NewCLITestFixture(t, "")
+
+		out := fixture.Run(runBench, "-messages", "200", "-size", "16", "-producers", "2", "-consumers", "2")
+		assert.Regexp(t, `publish\s+200`, out)
+		assert.Regexp(t, `consume\s+200`, out)
+	})
+
+	t.Run("BenchAboveTopicCapacity", func(t *testing.T) {
+		fixture := NewCLITestFixture(t, "")
+
+		out := fixture.Run(runBench, "-messages", "2500", "-size", "16")
+		assert.Regexp(t, `publish\s+2500`, out)
+		assert.Regexp(t, `consume\s+2500`, out)
+	})
+
+	t.Run("BenchIgnoresPendingMessages", func(t *testing.T) {
+		fixture := NewCLITestFixture(t, "")
+		require.NoError(t, fixture.App.backend.Enqueue(context.Background(), "bench", &queue.Message{ID: "left-over"}))
+
+		out := fixture.Run(runBench, "-messages", "200", "-size", "16")
+		assert.Regexp(t, `consume\s+200`, out)
+		size, err := fixture.App.backend.Size(context.Background(), "bench")
+		require.NoError(t, err)
+		assert.Equal(t, 1, size, "Should not consume messages of other runs")
+
+		topics, err := fixture.App.backend.Topics(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, []string{"bench"}, topics, "Should delete the topic of the run")
+	})
+}
+
+func TestRun(t *testing.T) {
+	t.Run("UnknownCommand", func(t *testing.T) {
+		var stdout, stderr bytes.Buffer
+		code := run([]string{"explode"}, strings.NewReader(""), &stdout, &stderr)
+		assert.Equal(t, 2, code)
+		assert.Contains(t, stderr.String(), `unknown command "explode"`)
+	})
+
+	t.Run("MissingCommand", func(t *testing.T) {
+		var stdout, stderr bytes.Buffer
+		code := run(nil, strings.NewReader(""), &stdout, &stderr)
+		assert.Equal(t, 2, code)
+		assert.Contains(t, stderr.String(), "Usage: queuectl")
+	})
+
+	t.Run("SnapshotSharedBetweenRuns", func(t *testing.T) {
+		snapshot := filepath.Join(t.TempDir(), "queue.json")
+		var stdout, stderr bytes.Buffer
+		code := run([]string{"-snapshot", snapshot, "publish", "-topic", "orders", "-count", "2"}, strings.NewReader("payload"), &stdout, &stderr)
+		assert.Equal(t, 0, code, stderr.String())
+		assert.Equal(t, "published 2 message(s) to orders\n", stdout.String())
+
+		stdout.Reset()
+		code = run([]string{"-snapshot", snapshot, "consume", "-topic", "orders", "-n", "1", "-timeout", "5s"}, strings.NewReader(""), &stdout, &stderr)
+		assert.Equal(t, 0, code, stderr.String())
+		assert.Contains(t, stdout.String(), `"payload":"payload"`, "Should consume the message published by the previous run")
+
+		stdout.Reset()
+		code = run([]string{"-snapshot", snapshot, "size", "-topic", "orders"}, strings.NewReader(""), &stdout, &stderr)
+		assert.Equal(t, 0, code, stderr.String())
+		assert.Equal(t, "1\n", stdout.String(), "Should keep the message left by the previous runs")
+	})
+
+	t.Run("UnreadableSnapshot", func(t *testing.T) {
+		snapshot := filepath.Join(t.TempDir(), "queue.json")
+		require.NoError(t, os.WriteFile(snapshot, []byte("not json"), 0o600))
+
+		var stdout, stderr bytes.Buffer
+		code := run([]string{"-snapshot", snapshot, "size", "-topic", "orders"}, strings.NewReader(""), &stdout, &stderr)
+		assert.Equal(t, 2, code)
+		assert.Contains(t, stderr.String(), "failed to load snapshot")
+
+		data, err := os.ReadFile(snapshot)
+		require.NoError(t, err)
+		assert.Equal(t, "not json", string(data), "Should not overwrite the snapshot")
+	})
+
+	t.Run("MissingBackend", func(t *testing.T) {
+		var stdout, stderr bytes.Buffer
+		code := run([]string{"publish", "-topic", "orders"}, strings.NewReader("payload"), &stdout, &stderr)
+		assert.Equal(t, 2, code)
+		assert.Contains(t, stderr.String(), "either -url or -snapshot is required")
+	})
+
+	t.Run("Remote", func(t *testing.T) {
+		var mu sync.Mutex
+		var enqueued []queue.Message
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") != "Bearer secret" {
+				w.WriteHeader(http.StatusUnauthorized)
+				w.Write([]byte(`{"message":"missing or malformed jwt"}`))
+				return
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			w.Header().Set("Content-Type", "application/json")
+			switch {
+			case r.Method == http.MethodPost && r.URL.Path == "/topics/orders/messages":
+				var message queue.Message
+				require.NoError(t, json.NewDecoder(r.Body).Decode(&message))
+				enqueued = append(enqueued, message)
+				w.WriteHeader(http.StatusNoContent)
+			case r.Method == http.MethodGet && r.URL.Path == "/topics/orders/size":
+				fmt.Fprintf(w, `{"topic":"orders","size":%d}`, len(enqueued))
+			case r.Method == http.MethodDelete && r.URL.Path == "/topics/orders/messages":
+				fmt.Fprintf(w, `{"topic":"orders","purged":%d}`, len(enqueued))
+				enqueued = nil
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+		defer server.Close()
+
+		remoteRun := func(token string, args ...string) (int, string, string) {
+			var stdout, stderr bytes.Buffer
+			code := run(append([]string{"-url", server.URL, "-token", token}, args...), strings.NewReader("payload"), &stdout, &stderr)
+			return code, stdout.String(), stderr.String()
+		}
+
+		code, stdout, stderr := remoteRun("secret", "publish", "-topic", "orders", "-H", "source=cli")
+		assert.Equal(t, 0, code, stderr)
+		assert.Equal(t, "published 1 message(s) to orders\n", stdout)
+		require.Len(t, enqueued, 1, "Should publish to the broker server")
+		assert.Equal(t, []byte("payload"), enqueued[0].Payload)
+		assert.Equal(t, "cli", enqueued[0].Headers["source"])
+
+		_, stdout, _ = remoteRun("secret", "size", "-topic", "orders")
+		assert.Equal(t, "1\n", stdout)
+
+		_, stdout, _ = remoteRun("secret", "purge", "-topic", "orders")
+		assert.Equal(t, "purged 1 message(s) from orders\n", stdout)
+
+		code, _, stderr = remoteRun("wrong", "size", "-topic", "orders")
+		assert.Equal(t, 1, code)
+		assert.Contains(t, stderr, "missing or malformed jwt")
+	})
+}
@@ -5,14 +5,25 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"sync"
 
 	"github.com/syl/Go/pkg/examples/queue"
 )
 
+// DedupWindow is the number of recently processed orders remembered to skip redeliveries
+const DedupWindow = 10000
+
 // ConsumerService represents a service that consumes messages
+// Orders are processed once: a redelivered order, e.g. by an at-least-once queue, is skipped.
 type ConsumerService struct {
 	consumer queue.Consumer
 	logger   *log.Logger
+
+	mu         sync.Mutex
+	seen       map[string]struct{}
+	recent     []string
+	processed  int
+	duplicates int
 }
 
 // NewConsumerService creates a new consumer service
@@ -20,9 +31,38 @@ func NewConsumerService(consumer queue.Consumer, logger *log.Logger) *ConsumerSe
 	return &ConsumerService{
 		consumer: consumer,
 		logger:   logger,
+		seen:     make(map[string]struct{}),
 	}
 }
 
+// Processed returns the number of orders processed and the number of redeliveries skipped
+func (cs *ConsumerService) Processed() (processed, duplicates int) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	return cs.processed, cs.duplicates
+}
+
+// firstDelivery records the order and reports whether it was not processed before
+func (cs *ConsumerService) firstDelivery(orderID string) bool {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	if _, exists := cs.seen[orderID]; exists {
+		cs.duplicates++
+		return false
+	}
+
+	cs.seen[orderID] = struct{}{}
+	cs.recent = append(cs.recent, orderID)
+	if len(cs.recent) > DedupWindow {
+		delete(cs.seen, cs.recent[0])
+		cs.recent = cs.recent[1:]
+	}
+	cs.processed++
+	return true
+}
+
 // Start begins consuming messages from the orders topic
 func (cs *ConsumerService) Start(ctx context.Context) error {
 	cs.logger.Println("Starting consumer service...")
@@ -48,6 +88,11 @@ func (cs *ConsumerService) handleOrderMessage(ctx context.Context, message *queu
 		return err
 	}
 
+	if !cs.firstDelivery(order.OrderID) {
+		cs.logger.Printf("Skipping order %s, it was already processed", order.OrderID)
+		return nil
+	}
+
 	cs.logger.Printf("Message headers: %v", message.Headers)
 
 	cs.logger.Printf("Processing order: %s", order.OrderID)
@@ -67,22 +67,28 @@ func assertOrderMessage(t *testing.T, msg *queue.Message) {
 
 func TestExampleServices(t *testing.T) {
 	t.Run("ProducerService", func(t *testing.T) {
+		clock := testutils.NewFakeClock(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
 		q := inmemory.NewInMemoryQueue()
 		producer := broker.NewQueueProducer(q)
 		consumer := broker.NewQueueConsumer(q)
 		fixture := testutils.NewServiceFixture(t, q, producer, consumer)
 		
 		producerLogger := testutils.CreateLogger("TEST-PRODUCER-PRODUCER")
-		producerService := NewProducerService(fixture.Producer, producerLogger)
+		producerService := NewProducerService(fixture.Producer, producerLogger, WithClock(clock))
 		t.Cleanup(func() { producerService.Stop() })
 		
 		t.Run("GeneratesMessages", func(t *testing.T) {
-			fixture.RunServiceWithTimeout(producerService.Start, 3*time.Second)
+			stop := fixture.StartService(producerService.Start)
+			clock.BlockUntil(1)
 
-			size, err := fixture.Queue.Size(context.Background(), "orders")
-			require.NoError(t, err)
-			assert.Greater(t, size, 0, "Expected producer to generate messages")
-			t.Logf("Producer generated %d messages", size)
+			clock.Advance(ProduceInterval - time.Millisecond)
+			fixture.AssertQueueSize("orders", 0, "Should not publish before the interval elapsed")
+
+			clock.AdvanceUntil(t, ProduceInterval, func() bool {
+				size, err := fixture.Queue.Size(context.Background(), "orders")
+				return err == nil && size >= 2
+			}, "Expected producer to generate messages")
+			stop()
 		})
 		
 		t.Run("ValidatesMessageFormatAndHeaders", func(t *testing.T) {
@@ -95,9 +101,10 @@ func TestExampleServices(t *testing.T) {
 	})
 
 	t.Run("ConsumerService", func(t *testing.T) {
+		clock := testutils.NewFakeClock(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
 		q := inmemory.NewInMemoryQueue()
 		producer := broker.NewQueueProducer(q)
-		consumer := broker.NewQueueConsumer(q)
+		consumer := broker.NewQueueConsumer(q, broker.WithClock(clock))
 		fixture := testutils.NewServiceFixture(t, q, producer, consumer)
 		
 		consumerLogger := testutils.CreateLogger("TEST-CONSUMER-CONSUMER")
@@ -117,19 +124,21 @@ func TestExampleServices(t *testing.T) {
 		})
 		
 		t.Run("ConsumesMessage", func(t *testing.T) {
-			fixture.RunServiceWithTimeout(consumerService.Start, 2*time.Second)
-
-			size, err := fixture.Queue.Size(context.Background(), "orders")
-			require.NoError(t, err)
-			t.Logf("Queue size after consumer ran: %d", size)
+			stop := fixture.StartService(consumerService.Start)
+			clock.AdvanceUntil(t, broker.PollInterval, func() bool {
+				size, err := fixture.Queue.Size(context.Background(), "orders")
+				return err == nil && size == 0
+			}, "Consumer should process the order")
+			stop()
 		})
 	})
 
 	t.Run("Integration", func(t *testing.T) {
 		t.Run("EndToEndFlow", func(t *testing.T) {
+			clock := testutils.NewFakeClock(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
 			q := inmemory.NewInMemoryQueue()
 			producer := broker.NewQueueProducer(q)
-			consumer := broker.NewQueueConsumer(q)
+			consumer := broker.NewQueueConsumer(q, broker.WithClock(clock))
 			fixture := testutils.NewServiceFixture(t, q, producer, consumer)
 			
 			processedMessages := make(chan string, 10)
@@ -157,13 +166,16 @@ func TestExampleServices(t *testing.T) {
 			})
 			require.NoError(t, err)
 
-			select {
-			case orderID := <-processedMessages:
-				assert.Equal(t, testOrder.OrderID, orderID, "Should process the correct order")
-				t.Logf("Successfully processed order: %s", orderID)
-			case <-time.After(3 * time.Second):
-				t.Fatal("Timeout waiting for message processing")
-			}
+			var orderID string
+			clock.AdvanceUntil(t, broker.PollInterval, func() bool {
+				select {
+				case orderID = <-processedMessages:
+					return true
+				default:
+					return false
+				}
+			}, "Timeout waiting for message processing")
+			assert.Equal(t, testOrder.OrderID, orderID, "Should process the correct order")
 		})
 	})
 }
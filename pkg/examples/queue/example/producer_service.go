@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"sync/atomic"
 	"time"
 
 	"github.com/syl/Go/pkg/examples/queue"
@@ -18,66 +19,100 @@ type OrderData struct {
 	CreatedAt  time.Time `json:"created_at"`
 }
 
+// ProduceInterval is how often the producer service publishes an order by default
+const ProduceInterval = 2 * time.Second
+
 // ProducerService represents a service that produces messages
 type ProducerService struct {
 	producer queue.Producer
 	logger   *log.Logger
+	clock    queue.Clock
+	interval time.Duration
+	orderID  atomic.Int64
+}
+
+// ProducerServiceOption configures a ProducerService created with NewProducerService
+type ProducerServiceOption func(*ProducerService)
+
+// WithClock drives the publishing interval and order timestamps with clock instead of the system clock
+func WithClock(clock queue.Clock) ProducerServiceOption {
+	return func(ps *ProducerService) {
+		ps.clock = clock
+	}
+}
+
+// WithInterval publishes an order every interval instead of every ProduceInterval
+func WithInterval(interval time.Duration) ProducerServiceOption {
+	return func(ps *ProducerService) {
+		ps.interval = interval
+	}
 }
 
 // NewProducerService creates a new producer service
-func NewProducerService(producer queue.Producer, logger *log.Logger) *ProducerService {
-	return &ProducerService{
+func NewProducerService(producer queue.Producer, logger *log.Logger, opts ...ProducerServiceOption) *ProducerService {
+	ps := &ProducerService{
 		producer: producer,
 		logger:   logger,
+		clock:    queue.SystemClock,
+		interval: ProduceInterval,
 	}
+	for _, opt := range opts {
+		opt(ps)
+	}
+	return ps
 }
 
 // Start begins producing messages periodically
+// Use PublishOrder as the run function of a scheduler.Job to publish on a cron schedule instead.
 func (ps *ProducerService) Start(ctx context.Context) error {
 	ps.logger.Println("Starting producer service...")
 
-	ticker := time.NewTicker(2 * time.Second)
+	ticker := ps.clock.NewTicker(ps.interval)
 	defer ticker.Stop()
 
-	orderID := 1
-
 	for {
 		select {
 		case <-ctx.Done():
 			ps.logger.Println("Producer service stopped")
 			return ctx.Err()
-		case <-ticker.C:
-			order := OrderData{
-				OrderID:    fmt.Sprintf("order-%d", orderID),
-				CustomerID: fmt.Sprintf("customer-%d", (orderID%5)+1),
-				Amount:     float64(orderID * 10),
-				CreatedAt:  time.Now(),
-			}
-
-			payload, err := json.Marshal(order)
-			if err != nil {
-				ps.logger.Printf("Failed to marshal order: %v", err)
-				continue
-			}
-
-			headers := map[string]string{
-				"source":      "producer-service",
-				"message_type": "order",
-				"version":     "1.0",
-			}
-
-			if err := ps.producer.Publish(ctx, "orders", payload, headers); err != nil {
-				ps.logger.Printf("Failed to publish order %s: %v", order.OrderID, err)
-			} else {
-				ps.logger.Printf("Published order: %s (Customer: %s, Amount: %.2f)",
-					order.OrderID, order.CustomerID, order.Amount)
-			}
-
-			orderID++
+		case <-ticker.C():
+			ps.PublishOrder(ctx)
 		}
 	}
 }
 
+// PublishOrder publishes the next order to the orders topic
+func (ps *ProducerService) PublishOrder(ctx context.Context) error {
+	orderID := ps.orderID.Add(1)
+	order := OrderData{
+		OrderID:    fmt.Sprintf("order-%d", orderID),
+		CustomerID: fmt.Sprintf("customer-%d", (orderID%5)+1),
+		Amount:     float64(orderID * 10),
+		CreatedAt:  ps.clock.Now(),
+	}
+
+	payload, err := json.Marshal(order)
+	if err != nil {
+		ps.logger.Printf("Failed to marshal order: %v", err)
+		return err
+	}
+
+	headers := map[string]string{
+		"source":      "producer-service",
+		"message_type": "order",
+		"version":     "1.0",
+	}
+
+	if err := ps.producer.Publish(ctx, "orders", payload, headers); err != nil {
+		ps.logger.Printf("Failed to publish order %s: %v", order.OrderID, err)
+		return err
+	}
+
+	ps.logger.Printf("Published order: %s (Customer: %s, Amount: %.2f)",
+		order.OrderID, order.CustomerID, order.Amount)
+	return nil
+}
+
 // Stop stops the producer service
 func (ps *ProducerService) Stop() error {
 	ps.logger.Println("Stopping producer service...")
@@ -0,0 +1,139 @@
+// Package filter evaluates boolean expressions over message headers.
+//
+// Supported syntax:
+//
+//	message_type = 'order' AND source != 'test'
+//	region IN ('eu', 'us') OR NOT (priority = 'low')
+//	trace_id EXISTS
+//
+// Keywords are case-insensitive, values are single or double quoted strings or bare words.
+// A missing header compares as the empty string.
+package filter
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Filter is a parsed header filter expression
+type Filter struct {
+	expression string
+	root       node
+}
+
+// Parse compiles a filter expression
+func Parse(expression string) (*Filter, error) {
+	tokens, err := tokenize(expression)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if !p.done() {
+		return nil, fmt.Errorf("unexpected %q at position %d", p.peek().text, p.peek().pos)
+	}
+
+	return &Filter{expression: expression, root: root}, nil
+}
+
+// MustParse is like Parse but panics on invalid expressions
+func MustParse(expression string) *Filter {
+	f, err := Parse(expression)
+	if err != nil {
+		panic(fmt.Sprintf("filter: %s: %v", expression, err))
+	}
+	return f
+}
+
+// Match reports whether the headers satisfy the filter
+func (f *Filter) Match(headers map[string]string) bool {
+	return f.root.eval(headers)
+}
+
+// String returns the original expression
+func (f *Filter) String() string {
+	return f.expression
+}
+
+// Any returns a filter matching when at least one of the filters matches
+func Any(filters ...*Filter) *Filter {
+	expressions := make([]string, len(filters))
+	nodes := make(orNode, len(filters))
+	for i, f := range filters {
+		expressions[i] = "(" + f.expression + ")"
+		nodes[i] = f.root
+	}
+	return &Filter{expression: strings.Join(expressions, " OR "), root: nodes}
+}
+
+type node interface {
+	eval(headers map[string]string) bool
+}
+
+type orNode []node
+
+func (n orNode) eval(headers map[string]string) bool {
+	for _, child := range n {
+		if child.eval(headers) {
+			return true
+		}
+	}
+	return false
+}
+
+type andNode []node
+
+func (n andNode) eval(headers map[string]string) bool {
+	for _, child := range n {
+		if !child.eval(headers) {
+			return false
+		}
+	}
+	return true
+}
+
+type notNode struct {
+	child node
+}
+
+func (n notNode) eval(headers map[string]string) bool {
+	return !n.child.eval(headers)
+}
+
+type compareNode struct {
+	header string
+	value  string
+	equal  bool
+}
+
+func (n compareNode) eval(headers map[string]string) bool {
+	return (headers[n.header] == n.value) == n.equal
+}
+
+type inNode struct {
+	header string
+	values []string
+}
+
+func (n inNode) eval(headers map[string]string) bool {
+	actual := headers[n.header]
+	for _, value := range n.values {
+		if actual == value {
+			return true
+		}
+	}
+	return false
+}
+
+type existsNode struct {
+	header string
+}
+
+func (n existsNode) eval(headers map[string]string) bool {
+	_, exists := headers[n.header]
+	return exists
+}
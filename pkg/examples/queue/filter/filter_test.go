@@ -0,0 +1,84 @@
+package filter
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFilter(t *testing.T) {
+	headers := map[string]string{
+		"message_type": "order",
+		"source":       "producer-service",
+		"version":      "1.0",
+	}
+
+	t.Run("Match", func(t *testing.T) {
+		cases := []struct {
+			expression string
+			matches    bool
+		}{
+			{"message_type = 'order'", true},
+			{"message_type = 'payment'", false},
+			{"message_type != 'payment'", true},
+			{"message_type <> 'order'", false},
+			{"message_type = order", true},
+			{`source = "producer-service"`, true},
+			{"message_type = 'order' AND source != 'test'", true},
+			{"message_type = 'order' AND source = 'test'", false},
+			{"message_type = 'payment' OR version = 1.0", true},
+			{"NOT message_type = 'order'", false},
+			{"not (message_type = 'payment' or source = 'test')", true},
+			{"message_type = 'payment' OR message_type = 'order' AND source = 'test'", false},
+			{"(message_type = 'payment' OR message_type = 'order') AND source != 'test'", true},
+			{"version IN ('1.0', '2.0')", true},
+			{"version IN ('2.0')", false},
+			{"version EXISTS", true},
+			{"trace_id EXISTS", false},
+			{"NOT trace_id EXISTS", true},
+			{"trace_id = ''", true},
+			{"trace_id != 'abc'", true},
+		}
+
+		for _, c := range cases {
+			f, err := Parse(c.expression)
+			require.NoError(t, err, "Should parse %q", c.expression)
+			assert.Equal(t, c.matches, f.Match(headers), "Match(%q)", c.expression)
+		}
+	})
+
+	t.Run("InvalidExpressions", func(t *testing.T) {
+		invalid := []string{
+			"",
+			"message_type",
+			"message_type =",
+			"message_type = 'order",
+			"message_type == 'order'",
+			"message_type = 'order' AND",
+			"(message_type = 'order'",
+			"message_type = 'order')",
+			"version IN '1.0'",
+			"version IN ('1.0',)",
+			"message_type ! 'order'",
+		}
+
+		for _, expression := range invalid {
+			_, err := Parse(expression)
+			assert.Error(t, err, "Should reject %q", expression)
+		}
+	})
+
+	t.Run("Any", func(t *testing.T) {
+		f := Any(MustParse("message_type = 'payment'"), MustParse("source = 'producer-service'"))
+		assert.True(t, f.Match(headers), "Should match when one filter matches")
+		assert.Equal(t, "(message_type = 'payment') OR (source = 'producer-service')", f.String())
+
+		f = Any(MustParse("message_type = 'payment'"), MustParse("source = 'test'"))
+		assert.False(t, f.Match(headers), "Should not match when no filter matches")
+	})
+
+	t.Run("MustParsePanics", func(t *testing.T) {
+		assert.Panics(t, func() { MustParse("message_type =") }, "Should panic on invalid expression")
+	})
+}
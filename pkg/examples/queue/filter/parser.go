@@ -0,0 +1,246 @@
+package filter
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+type tokenKind int
+
+const (
+	tokenWord tokenKind = iota
+	tokenString
+	tokenEqual
+	tokenNotEqual
+	tokenLeftParen
+	tokenRightParen
+	tokenComma
+	tokenEOF
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+// is reports whether the token is the given case-insensitive keyword
+func (t token) is(keyword string) bool {
+	return t.kind == tokenWord && strings.EqualFold(t.text, keyword)
+}
+
+func tokenize(expression string) ([]token, error) {
+	var tokens []token
+	runes := []rune(expression)
+
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(':
+			tokens = append(tokens, token{tokenLeftParen, "(", i})
+			i++
+		case r == ')':
+			tokens = append(tokens, token{tokenRightParen, ")", i})
+			i++
+		case r == ',':
+			tokens = append(tokens, token{tokenComma, ",", i})
+			i++
+		case r == '=':
+			tokens = append(tokens, token{tokenEqual, "=", i})
+			i++
+		case r == '!' || r == '<':
+			if i+1 >= len(runes) || (r == '!' && runes[i+1] != '=') || (r == '<' && runes[i+1] != '>') {
+				return nil, fmt.Errorf("unexpected %q at position %d", r, i)
+			}
+			tokens = append(tokens, token{tokenNotEqual, string(runes[i : i+2]), i})
+			i += 2
+		case r == '\'' || r == '"':
+			end := i + 1
+			for end < len(runes) && runes[end] != r {
+				end++
+			}
+			if end >= len(runes) {
+				return nil, fmt.Errorf("unterminated string starting at position %d", i)
+			}
+			tokens = append(tokens, token{tokenString, string(runes[i+1 : end]), i})
+			i = end + 1
+		case isWordRune(r):
+			end := i
+			for end < len(runes) && isWordRune(runes[end]) {
+				end++
+			}
+			tokens = append(tokens, token{tokenWord, string(runes[i:end]), i})
+			i = end
+		default:
+			return nil, fmt.Errorf("unexpected %q at position %d", r, i)
+		}
+	}
+
+	return append(tokens, token{tokenEOF, "end of expression", len(runes)}), nil
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune("_-.:/", r)
+}
+
+// parser is a recursive descent parser with NOT binding tighter than AND, and AND tighter than OR
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) done() bool {
+	return p.peek().kind == tokenEOF
+}
+
+func (p *parser) expect(kind tokenKind, what string) (token, error) {
+	t := p.next()
+	if t.kind != kind {
+		return t, fmt.Errorf("expected %s but found %q at position %d", what, t.text, t.pos)
+	}
+	return t, nil
+}
+
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := orNode{left}
+	for p.peek().is("OR") {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, right)
+	}
+
+	if len(nodes) == 1 {
+		return left, nil
+	}
+	return nodes, nil
+}
+
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := andNode{left}
+	for p.peek().is("AND") {
+		p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, right)
+	}
+
+	if len(nodes) == 1 {
+		return left, nil
+	}
+	return nodes, nil
+}
+
+func (p *parser) parseUnary() (node, error) {
+	if p.peek().is("NOT") {
+		p.next()
+		child, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{child}, nil
+	}
+
+	if p.peek().kind == tokenLeftParen {
+		p.next()
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.expect(tokenRightParen, "')'"); err != nil {
+			return nil, err
+		}
+		return inner, nil
+	}
+
+	return p.parseCondition()
+}
+
+func (p *parser) parseCondition() (node, error) {
+	header, err := p.expect(tokenWord, "header name")
+	if err != nil {
+		return nil, err
+	}
+
+	op := p.next()
+	switch {
+	case op.kind == tokenEqual || op.kind == tokenNotEqual:
+		value, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		return compareNode{header: header.text, value: value, equal: op.kind == tokenEqual}, nil
+	case op.is("IN"):
+		values, err := p.parseValueList()
+		if err != nil {
+			return nil, err
+		}
+		return inNode{header: header.text, values: values}, nil
+	case op.is("EXISTS"):
+		return existsNode{header: header.text}, nil
+	default:
+		return nil, fmt.Errorf("expected operator after %q but found %q at position %d", header.text, op.text, op.pos)
+	}
+}
+
+func (p *parser) parseValue() (string, error) {
+	t := p.next()
+	if t.kind != tokenString && t.kind != tokenWord {
+		return "", fmt.Errorf("expected value but found %q at position %d", t.text, t.pos)
+	}
+	return t.text, nil
+}
+
+func (p *parser) parseValueList() ([]string, error) {
+	if _, err := p.expect(tokenLeftParen, "'('"); err != nil {
+		return nil, err
+	}
+
+	var values []string
+	for {
+		value, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+
+		if p.peek().kind != tokenComma {
+			break
+		}
+		p.next()
+	}
+
+	if _, err := p.expect(tokenRightParen, "')'"); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
@@ -0,0 +1,195 @@
+// Package health aggregates the health checks of queues, consumers and other components
+// into the liveness and readiness reports served to probes.
+package health
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/syl/Go/pkg/examples/queue"
+)
+
+// DefaultTimeout bounds each check when the registry has no other timeout
+const DefaultTimeout = 2 * time.Second
+
+// Status is the outcome of a check or of a whole report
+type Status string
+
+const (
+	StatusUp   Status = "up"
+	StatusDown Status = "down"
+)
+
+// Kind tells which probe a check takes part in
+type Kind int
+
+const (
+	// Readiness checks decide whether the process should receive traffic
+	Readiness Kind = iota
+	// Liveness checks decide whether the process must be restarted, they are part of the readiness report too
+	Liveness
+)
+
+// Result is the outcome of a single check
+type Result struct {
+	Name     string        `json:"name"`
+	Status   Status        `json:"status"`
+	Error    string        `json:"error,omitempty"`
+	Duration time.Duration `json:"duration"`
+}
+
+// Report is the outcome of every check of a probe, it is up when all checks are up
+type Report struct {
+	Status Status   `json:"status"`
+	Checks []Result `json:"checks"`
+}
+
+// Up reports whether every check passed
+func (r Report) Up() bool {
+	return r.Status == StatusUp
+}
+
+// CheckFunc adapts a function to the queue.HealthChecker interface
+type CheckFunc func(ctx context.Context) error
+
+// CheckHealth calls f
+func (f CheckFunc) CheckHealth(ctx context.Context) error {
+	return f(ctx)
+}
+
+// Option configures a Registry created with NewRegistry
+type Option func(*Registry)
+
+// WithTimeout bounds how long each check may take, a check exceeding it is down
+func WithTimeout(timeout time.Duration) Option {
+	return func(r *Registry) {
+		r.timeout = timeout
+	}
+}
+
+// Registry holds named health checks
+type Registry struct {
+	mu      sync.RWMutex
+	timeout time.Duration
+	checks  map[string]check
+}
+
+type check struct {
+	kind    Kind
+	checker queue.HealthChecker
+}
+
+// NewRegistry creates an empty registry, an empty registry is always up
+func NewRegistry(opts ...Option) *Registry {
+	r := &Registry{
+		timeout: DefaultTimeout,
+		checks:  make(map[string]check),
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// Register adds a readiness check, replacing any check with the same name
+func (r *Registry) Register(name string, checker queue.HealthChecker) {
+	r.register(name, Readiness, checker)
+}
+
+// RegisterLiveness adds a liveness check, replacing any check with the same name
+func (r *Registry) RegisterLiveness(name string, checker queue.HealthChecker) {
+	r.register(name, Liveness, checker)
+}
+
+// Unregister removes the check with the given name
+func (r *Registry) Unregister(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.checks, name)
+}
+
+func (r *Registry) register(name string, kind Kind, checker queue.HealthChecker) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.checks[name] = check{kind: kind, checker: checker}
+}
+
+// Liveness runs the liveness checks
+func (r *Registry) Liveness(ctx context.Context) Report {
+	return r.run(ctx, func(kind Kind) bool { return kind == Liveness })
+}
+
+// Readiness runs every check
+func (r *Registry) Readiness(ctx context.Context) Report {
+	return r.run(ctx, func(Kind) bool { return true })
+}
+
+// run executes the selected checks concurrently and sorts the results by name
+func (r *Registry) run(ctx context.Context, selected func(Kind) bool) Report {
+	r.mu.RLock()
+	names := make([]string, 0, len(r.checks))
+	checks := make([]check, 0, len(r.checks))
+	for name, c := range r.checks {
+		if selected(c.kind) {
+			names = append(names, name)
+			checks = append(checks, c)
+		}
+	}
+	timeout := r.timeout
+	r.mu.RUnlock()
+
+	report := Report{Status: StatusUp, Checks: make([]Result, len(checks))}
+	var wg sync.WaitGroup
+	for i := range checks {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			report.Checks[i] = runCheck(ctx, names[i], checks[i].checker, timeout)
+		}(i)
+	}
+	wg.Wait()
+
+	sort.Slice(report.Checks, func(i, j int) bool { return report.Checks[i].Name < report.Checks[j].Name })
+	for _, result := range report.Checks {
+		if result.Status == StatusDown {
+			report.Status = StatusDown
+		}
+	}
+	return report
+}
+
+// runCheck calls the checker with a timeout and turns panics and overruns into failures
+func runCheck(ctx context.Context, name string, checker queue.HealthChecker, timeout time.Duration) Result {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				done <- fmt.Errorf("check panicked: %v", r)
+			}
+		}()
+		done <- checker.CheckHealth(ctx)
+	}()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = fmt.Errorf("check did not complete: %w", ctx.Err())
+	}
+
+	result := Result{Name: name, Status: StatusUp, Duration: time.Since(start)}
+	if err != nil {
+		result.Status = StatusDown
+		result.Error = err.Error()
+	}
+	return result
+}
@@ -0,0 +1,117 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/syl/Go/pkg/examples/queue"
+	"github.com/syl/Go/pkg/examples/queue/broker"
+	"github.com/syl/Go/pkg/examples/queue/inmemory"
+)
+
+func TestRegistry(t *testing.T) {
+	ctx := context.Background()
+	failing := CheckFunc(func(ctx context.Context) error { return errors.New("database unreachable") })
+	healthy := CheckFunc(func(ctx context.Context) error { return nil })
+
+	t.Run("EmptyIsUp", func(t *testing.T) {
+		registry := NewRegistry()
+		assert.True(t, registry.Liveness(ctx).Up())
+		assert.True(t, registry.Readiness(ctx).Up())
+	})
+
+	t.Run("ReadinessIncludesLiveness", func(t *testing.T) {
+		registry := NewRegistry()
+		registry.RegisterLiveness("queue", healthy)
+		registry.Register("database", failing)
+
+		live := registry.Liveness(ctx)
+		assert.True(t, live.Up(), "Readiness checks should not affect liveness")
+		require.Len(t, live.Checks, 1)
+		assert.Equal(t, "queue", live.Checks[0].Name)
+
+		ready := registry.Readiness(ctx)
+		assert.Equal(t, StatusDown, ready.Status)
+		require.Len(t, ready.Checks, 2)
+		assert.Equal(t, "database", ready.Checks[0].Name, "Checks should be sorted by name")
+		assert.Equal(t, "database unreachable", ready.Checks[0].Error)
+		assert.Equal(t, StatusUp, ready.Checks[1].Status)
+
+		registry.Unregister("database")
+		assert.True(t, registry.Readiness(ctx).Up())
+	})
+
+	t.Run("Timeout", func(t *testing.T) {
+		registry := NewRegistry(WithTimeout(10 * time.Millisecond))
+		registry.Register("slow", CheckFunc(func(ctx context.Context) error {
+			<-ctx.Done()
+			time.Sleep(time.Second)
+			return nil
+		}))
+
+		report := registry.Readiness(ctx)
+		assert.False(t, report.Up())
+		assert.Contains(t, report.Checks[0].Error, "did not complete")
+	})
+
+	t.Run("Panic", func(t *testing.T) {
+		registry := NewRegistry()
+		registry.Register("broken", CheckFunc(func(ctx context.Context) error { panic("boom") }))
+
+		report := registry.Readiness(ctx)
+		assert.False(t, report.Up())
+		assert.Contains(t, report.Checks[0].Error, "boom")
+	})
+}
+
+func TestComponents(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("InMemoryQueue", func(t *testing.T) {
+		q := inmemory.NewInMemoryQueue()
+		registry := NewRegistry()
+		registry.RegisterLiveness("queue", q)
+		assert.True(t, registry.Liveness(ctx).Up())
+
+		require.NoError(t, q.Close())
+		report := registry.Liveness(ctx)
+		assert.False(t, report.Up())
+		assert.Equal(t, "queue is closed", report.Checks[0].Error)
+	})
+
+	t.Run("ConsumerWithDeadSubscription", func(t *testing.T) {
+		q := queue.NewMock()
+		defer q.Close()
+		consumer := broker.NewQueueConsumer(q)
+		defer consumer.Close()
+
+		require.NoError(t, consumer.Subscribe(ctx, "orders", func(ctx context.Context, message *queue.Message) error {
+			panic("nil order")
+		}))
+		registry := NewRegistry()
+		registry.RegisterLiveness("consumer", consumer)
+		assert.True(t, registry.Liveness(ctx).Up())
+
+		require.NoError(t, broker.NewQueueProducer(q).Publish(ctx, "orders", []byte("o1"), nil))
+		require.Eventually(t, func() bool { return !registry.Liveness(ctx).Up() }, 5*time.Second, 10*time.Millisecond)
+		assert.Contains(t, registry.Liveness(ctx).Checks[0].Error, "subscription orders stopped: handler panicked: nil order")
+	})
+
+	t.Run("ConsumerWithCanceledSubscription", func(t *testing.T) {
+		q := queue.NewMock()
+		defer q.Close()
+		consumer := broker.NewQueueConsumer(q)
+		defer consumer.Close()
+
+		subCtx, cancel := context.WithCancel(ctx)
+		require.NoError(t, consumer.Subscribe(subCtx, "orders", func(ctx context.Context, message *queue.Message) error { return nil }))
+		cancel()
+
+		require.Eventually(t, func() bool { return consumer.CheckHealth(ctx) != nil }, 5*time.Second, 10*time.Millisecond)
+		assert.ErrorIs(t, consumer.CheckHealth(ctx), context.Canceled)
+	})
+}
@@ -0,0 +1,56 @@
+package inmemory
+
+import (
+	"time"
+
+	"github.com/syl/Go/pkg/examples/queue"
+)
+
+// config holds the optional settings of an InMemoryQueue
+type config struct {
+	snapshotPath     string
+	snapshotFormat   SnapshotFormat
+	snapshotOnClose  bool
+	snapshotInterval time.Duration
+	onSnapshotError  func(error)
+	clock            queue.Clock
+}
+
+// Option configures an InMemoryQueue created with NewInMemoryQueue
+type Option func(*config)
+
+// WithSnapshotFile sets the file used by SaveSnapshotFile, LoadSnapshotFile and the automatic snapshots
+func WithSnapshotFile(path string, format SnapshotFormat) Option {
+	return func(c *config) {
+		c.snapshotPath = path
+		c.snapshotFormat = format
+	}
+}
+
+// WithSnapshotOnClose writes a snapshot to the snapshot file when the queue is closed
+func WithSnapshotOnClose() Option {
+	return func(c *config) {
+		c.snapshotOnClose = true
+	}
+}
+
+// WithSnapshotInterval writes a snapshot to the snapshot file every interval until the queue is closed
+func WithSnapshotInterval(interval time.Duration) Option {
+	return func(c *config) {
+		c.snapshotInterval = interval
+	}
+}
+
+// WithSnapshotErrorHandler is called when a periodic snapshot fails, errors are ignored otherwise
+func WithSnapshotErrorHandler(handler func(error)) Option {
+	return func(c *config) {
+		c.onSnapshotError = handler
+	}
+}
+
+// WithClock drives the periodic snapshots and the Shutdown polling with clock instead of the system clock
+func WithClock(clock queue.Clock) Option {
+	return func(c *config) {
+		c.clock = clock
+	}
+}
@@ -2,124 +2,394 @@ package inmemory
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/syl/Go/pkg/examples/queue"
 )
 
+const (
+	// TopicCapacity is the maximum number of pending messages per topic
+	TopicCapacity = 1000
+	// DrainIdleTimeout is how long Shutdown keeps waiting for a topic nobody dequeued from
+	DrainIdleTimeout = time.Second
+)
+
+// ensure that InMemoryQueue supports the inspection operations, message selection, message age, health checks
+// and graceful shutdown
+var (
+	_ queue.Inspector     = (*InMemoryQueue)(nil)
+	_ queue.Selector      = (*InMemoryQueue)(nil)
+	_ queue.AgeReporter   = (*InMemoryQueue)(nil)
+	_ queue.HealthChecker = (*InMemoryQueue)(nil)
+	_ queue.Shutdowner    = (*InMemoryQueue)(nil)
+)
+
 // InMemoryQueue implements the Queue interface using in-memory storage
+// Every topic has its own lock, so operations on a topic do not block those on other topics,
+// and the state of the queue is kept in atomics so that no queue-wide lock is taken on the way.
+// Operations changing a topic check that the queue is open again under the lock of the topic:
+// Close and Restore lock every topic after changing the state, so no operation slips past them.
 type InMemoryQueue struct {
-	mu     sync.RWMutex
-	topics map[string]chan *queue.Message
-	closed bool
+	// mu serializes Close, Restore and Shutdown, operations on topics never take it
+	mu       sync.Mutex
+	topics   *topicMap
+	closed   atomic.Bool
+	draining atomic.Bool
+	config   config
+
+	stopSnapshots chan struct{}
+	snapshotDone  chan struct{}
 }
 
 // NewInMemoryQueue creates a new in-memory queue
-func NewInMemoryQueue() *InMemoryQueue {
-	return &InMemoryQueue{
-		topics: make(map[string]chan *queue.Message),
-		closed: false,
+func NewInMemoryQueue(opts ...Option) *InMemoryQueue {
+	q := &InMemoryQueue{
+		topics: newTopicMap(),
+		config: config{clock: queue.SystemClock},
+	}
+	for _, opt := range opts {
+		opt(&q.config)
 	}
+
+	if q.config.snapshotInterval > 0 && q.config.snapshotPath != "" {
+		q.stopSnapshots = make(chan struct{})
+		q.snapshotDone = make(chan struct{})
+		go q.runSnapshots(q.config.snapshotInterval, q.stopSnapshots)
+	}
+
+	return q
 }
 
 // Enqueue adds a message to the specified topic
 func (q *InMemoryQueue) Enqueue(ctx context.Context, topic string, message *queue.Message) error {
-	q.mu.Lock()
-	defer q.mu.Unlock()
+	if err := q.acceptsMessages(); err != nil {
+		return err
+	}
 
-	if q.closed {
-		return fmt.Errorf("queue is closed")
+	if err := ctx.Err(); err != nil {
+		return err
 	}
 
-	if _, exists := q.topics[topic]; !exists {
-		q.topics[topic] = make(chan *queue.Message, 1000)
+	t := q.topics.lock(topic)
+	defer t.mu.Unlock()
+
+	if err := q.acceptsMessages(); err != nil {
+		return err
 	}
 
-	select {
-	case q.topics[topic] <- message:
-		return nil
-	case <-ctx.Done():
-		return ctx.Err()
-	default:
+	if len(t.messages) >= TopicCapacity {
 		return fmt.Errorf("topic %s queue is full", topic)
 	}
+
+	t.messages = append(t.messages, message)
+	return nil
 }
 
 // Dequeue retrieves a message from the specified topic
 func (q *InMemoryQueue) Dequeue(ctx context.Context, topic string) (*queue.Message, error) {
-	q.mu.RLock()
-	topicChan, exists := q.topics[topic]
-	closed := q.closed
-	q.mu.RUnlock()
+	if err := q.open(); err != nil {
+		return nil, err
+	}
 
-	if closed {
-		return nil, fmt.Errorf("queue is closed")
+	if err := ctx.Err(); err != nil {
+		return nil, err
 	}
 
-	if !exists {
+	t := q.topics.lockExisting(topic)
+	if t == nil {
 		return nil, nil
 	}
+	defer t.mu.Unlock()
 
-	select {
-	case message := <-topicChan:
-		return message, nil
-	case <-ctx.Done():
-		return nil, ctx.Err()
-	default:
+	if err := q.open(); err != nil {
+		return nil, err
+	}
+
+	t.polled = q.config.clock.Now()
+	if len(t.messages) == 0 {
+		return nil, nil
+	}
+
+	message := t.messages[0]
+	t.messages[0] = nil
+	t.messages = t.messages[1:]
+
+	return message, nil
+}
+
+// DequeueMatching removes and returns the first message of the topic accepted by match
+// The messages before it stay at the head of the topic, in order.
+func (q *InMemoryQueue) DequeueMatching(ctx context.Context, topic string, match func(*queue.Message) bool) (*queue.Message, error) {
+	if err := q.open(); err != nil {
+		return nil, err
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	t := q.topics.lockExisting(topic)
+	if t == nil {
 		return nil, nil
 	}
+	defer t.mu.Unlock()
+
+	if err := q.open(); err != nil {
+		return nil, err
+	}
+
+	t.polled = q.config.clock.Now()
+	for i, message := range t.messages {
+		if !match(message) {
+			continue
+		}
+		if i == 0 {
+			t.messages[0] = nil
+			t.messages = t.messages[1:]
+		} else {
+			last := len(t.messages) - 1
+			copy(t.messages[i:], t.messages[i+1:])
+			t.messages[last] = nil
+			t.messages = t.messages[:last]
+		}
+		return message, nil
+	}
+	return nil, nil
 }
 
 // Size returns the number of messages in the specified topic
 func (q *InMemoryQueue) Size(ctx context.Context, topic string) (int, error) {
-	q.mu.RLock()
-	defer q.mu.RUnlock()
+	if err := q.open(); err != nil {
+		return 0, err
+	}
 
-	if q.closed {
-		return 0, fmt.Errorf("queue is closed")
+	t := q.topics.lockExisting(topic)
+	if t == nil {
+		return 0, nil
 	}
+	defer t.mu.Unlock()
+
+	return len(t.messages), nil
+}
 
-	if topicChan, exists := q.topics[topic]; exists {
-		return len(topicChan), nil
+// OldestMessageAge returns how long the message at the head of the topic has been waiting, measured with the queue clock
+func (q *InMemoryQueue) OldestMessageAge(ctx context.Context, topic string) (time.Duration, error) {
+	if err := q.open(); err != nil {
+		return 0, err
 	}
 
-	return 0, nil
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	t := q.topics.lockExisting(topic)
+	if t == nil {
+		return 0, nil
+	}
+	defer t.mu.Unlock()
+
+	if len(t.messages) == 0 {
+		return 0, nil
+	}
+	return queue.MessageAge(q.config.clock, t.messages[0]), nil
+}
+
+// CheckHealth reports an error once the queue is closed or shutting down
+func (q *InMemoryQueue) CheckHealth(ctx context.Context) error {
+	return q.acceptsMessages()
 }
 
 // Topics returns all available topics
 func (q *InMemoryQueue) Topics(ctx context.Context) ([]string, error) {
-	q.mu.RLock()
-	defer q.mu.RUnlock()
+	if err := q.open(); err != nil {
+		return nil, err
+	}
 
-	if q.closed {
-		return nil, fmt.Errorf("queue is closed")
+	topics := q.topics.names()
+	if topics == nil {
+		topics = []string{}
 	}
+	return topics, nil
+}
 
-	topics := make([]string, 0, len(q.topics))
-	for topic := range q.topics {
-		topics = append(topics, topic)
+// Peek returns up to n messages from the head of the topic without removing them
+func (q *InMemoryQueue) Peek(ctx context.Context, topic string, n int) ([]*queue.Message, error) {
+	if n <= 0 {
+		return nil, fmt.Errorf("peek count must be positive")
 	}
 
-	return topics, nil
+	page, err := q.Browse(ctx, topic, 0, n)
+	if err != nil {
+		return nil, err
+	}
+	return page.Messages, nil
+}
+
+// Browse returns a page of messages starting at offset without removing them
+func (q *InMemoryQueue) Browse(ctx context.Context, topic string, offset, limit int) (*queue.Page, error) {
+	if err := q.open(); err != nil {
+		return nil, err
+	}
+
+	t := q.topics.lockExisting(topic)
+	if t == nil {
+		return queue.NewPage(nil, offset, limit)
+	}
+	defer t.mu.Unlock()
+
+	return queue.NewPage(t.messages, offset, limit)
+}
+
+// Purge removes all messages from the topic and returns how many were removed
+func (q *InMemoryQueue) Purge(ctx context.Context, topic string) (int, error) {
+	if err := q.open(); err != nil {
+		return 0, err
+	}
+
+	t := q.topics.lockExisting(topic)
+	if t == nil {
+		return 0, nil
+	}
+	defer t.mu.Unlock()
+
+	if err := q.open(); err != nil {
+		return 0, err
+	}
+
+	purged := len(t.messages)
+	t.messages = nil
+	return purged, nil
+}
+
+// DeleteTopic removes the topic and all its messages
+func (q *InMemoryQueue) DeleteTopic(ctx context.Context, topic string) error {
+	if err := q.open(); err != nil {
+		return err
+	}
+
+	if !q.topics.delete(topic) {
+		return fmt.Errorf("%w: %s", queue.ErrTopicNotFound, topic)
+	}
+	return nil
+}
+
+// Shutdown stops accepting messages and waits until ctx is done for consumers to empty their topics.
+// Only topics dequeued from within DrainIdleTimeout are waited for, so that the messages nobody consumes,
+// such as dead letters or messages left after the consumers stopped, do not hold the shutdown until the deadline.
+// Messages still pending are written to the snapshot file with WithSnapshotOnClose, and dropped otherwise.
+// The queue is closed afterwards.
+func (q *InMemoryQueue) Shutdown(ctx context.Context) (queue.ShutdownReport, error) {
+	q.mu.Lock()
+	if q.closed.Load() {
+		q.mu.Unlock()
+		return queue.ShutdownReport{}, nil
+	}
+	q.draining.Store(true)
+	q.mu.Unlock()
+
+	ticker := q.config.clock.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+
+	var err error
+	for err == nil && q.consumed() {
+		select {
+		case <-ctx.Done():
+			err = ctx.Err()
+		case <-ticker.C():
+		}
+	}
+
+	report := queue.ShutdownReport{}
+	if pending := q.pending(); len(pending) > 0 {
+		if q.config.snapshotOnClose && q.config.snapshotPath != "" {
+			report.Persisted = pending
+		} else {
+			report.Dropped = pending
+		}
+	}
+
+	if closeErr := q.Close(); closeErr != nil {
+		// The snapshot could not be written, so the pending messages are lost
+		if report.Persisted != nil {
+			report.Dropped, report.Persisted = report.Persisted, nil
+		}
+		return report, errors.Join(err, closeErr)
+	}
+	return report, err
+}
+
+// pending returns the number of messages of every non-empty topic
+func (q *InMemoryQueue) pending() map[string]int {
+	pending := make(map[string]int)
+	q.topics.each(func(topic string, t *topicQueue) {
+		if len(t.messages) > 0 {
+			pending[topic] = len(t.messages)
+		}
+	})
+	return pending
+}
+
+// consumed reports whether a topic still holds messages and was dequeued from within DrainIdleTimeout
+func (q *InMemoryQueue) consumed() bool {
+	consumed := false
+	now := q.config.clock.Now()
+	q.topics.each(func(topic string, t *topicQueue) {
+		if len(t.messages) > 0 && now.Sub(t.polled) < DrainIdleTimeout {
+			consumed = true
+		}
+	})
+	return consumed
+}
+
+// open returns an error once the queue is closed
+func (q *InMemoryQueue) open() error {
+	if q.closed.Load() {
+		return fmt.Errorf("queue is closed")
+	}
+	return nil
+}
+
+// acceptsMessages returns an error once the queue is closed or shutting down
+func (q *InMemoryQueue) acceptsMessages() error {
+	if err := q.open(); err != nil {
+		return err
+	}
+	if q.draining.Load() {
+		return fmt.Errorf("queue is shutting down")
+	}
+	return nil
 }
 
 // Close closes the queue and releases resources
+// With WithSnapshotOnClose the pending messages are written to the snapshot file first.
 func (q *InMemoryQueue) Close() error {
 	q.mu.Lock()
-	defer q.mu.Unlock()
-
-	if q.closed {
+	if q.closed.Load() {
+		q.mu.Unlock()
 		return nil
 	}
 
-	q.closed = true
-
-	for _, ch := range q.topics {
-		close(ch)
+	// Operations that locked a topic before it is visited below are part of the snapshot,
+	// those locking it afterwards see the queue closed
+	q.closed.Store(true)
+	var snapshot *Snapshot
+	if q.config.snapshotOnClose && q.config.snapshotPath != "" {
+		snapshot = q.snapshotTopics()
 	}
+	q.topics.replace(nil)
+	q.mu.Unlock()
 
-	q.topics = make(map[string]chan *queue.Message)
+	if q.stopSnapshots != nil {
+		close(q.stopSnapshots)
+		<-q.snapshotDone
+	}
 
+	if snapshot != nil {
+		return WriteSnapshotFile(q.config.snapshotPath, q.config.snapshotFormat, snapshot)
+	}
 	return nil
 }
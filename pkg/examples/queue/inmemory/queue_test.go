@@ -1,7 +1,10 @@
 package inmemory
 
 import (
+	"context"
 	"fmt"
+	"path/filepath"
+	"sync"
 	"testing"
 	"time"
 
@@ -9,141 +12,197 @@ import (
 	"github.com/stretchr/testify/require"
 	"github.com/syl/Go/pkg/examples/queue"
 	"github.com/syl/Go/pkg/examples/queue/inmemory/testutils"
+	"github.com/syl/Go/pkg/examples/queue/queuetest"
 )
 
-func assertQueueOperationErrors(t *testing.T, fixture *testutils.BaseFixture, topic string, msg *queue.Message) {
-	t.Helper()
-	
-	err := fixture.Queue.Enqueue(fixture.Ctx, topic, msg)
-	assert.Error(t, err, "Should error when enqueueing to closed queue")
-
-	_, err = fixture.Queue.Dequeue(fixture.Ctx, topic)
-	assert.Error(t, err, "Should error when dequeueing from closed queue")
-
-	_, err = fixture.Queue.Size(fixture.Ctx, topic)
-	assert.Error(t, err, "Should error when getting size of closed queue")
-
-	_, err = fixture.Queue.Topics(fixture.Ctx)
-	assert.Error(t, err, "Should error when getting topics of closed queue")
+func TestConformance(t *testing.T) {
+	queuetest.Run(t, func(t *testing.T) queue.Queue {
+		return NewInMemoryQueue()
+	})
 }
 
 func TestInMemoryQueue(t *testing.T) {
-	t.Run("EnqueueDequeue", func(t *testing.T) {
+	t.Run("TopicCapacity", func(t *testing.T) {
 		q := NewInMemoryQueue()
 		fixture := testutils.NewBaseFixture(t, q)
-		topic := "test-topic"
-
-		msg := fixture.CreateMessage("test-id", topic, []byte("test payload"))
 
-		err := fixture.Queue.Enqueue(fixture.Ctx, topic, msg)
-		require.NoError(t, err, "Should enqueue message successfully")
-
-		fixture.AssertQueueSize(topic, 1, "Queue should contain exactly one message")
-
-		dequeuedMsg, err := fixture.Queue.Dequeue(fixture.Ctx, topic)
-		require.NoError(t, err, "Should dequeue message")
-		require.NotNil(t, dequeuedMsg, "Message should not be nil")
+		for i := 0; i < TopicCapacity; i++ {
+			require.NoError(t, q.Enqueue(fixture.Ctx, "orders", fixture.CreateMessage(fmt.Sprint(i), "orders", nil)))
+		}
 
-		assert.Equal(t, msg.ID, dequeuedMsg.ID, "Message ID should match")
-		assert.Equal(t, msg.Payload, dequeuedMsg.Payload, "Payload should match")
-		assert.Equal(t, msg.Headers, dequeuedMsg.Headers, "Headers should match")
+		err := q.Enqueue(fixture.Ctx, "orders", fixture.CreateMessage("overflow", "orders", nil))
+		assert.Error(t, err, "Should reject messages once the topic is full")
+		fixture.AssertQueueSize("payments", 0, "Other topics should accept messages")
 	})
 
-	t.Run("DequeueEmpty", func(t *testing.T) {
+	t.Run("ConcurrentTopics", func(t *testing.T) {
 		q := NewInMemoryQueue()
 		fixture := testutils.NewBaseFixture(t, q)
-		topic := "empty-topic"
-
-		msg, err := fixture.Queue.Dequeue(fixture.Ctx, topic)
-		require.NoError(t, err, "Should not error when dequeuing from empty queue")
-		assert.Nil(t, msg, "Should return nil message for empty queue")
+		const producers, topics, perTopic = 8, 40, 25
+
+		var wg sync.WaitGroup
+		for p := 0; p < producers; p++ {
+			wg.Add(1)
+			go func(p int) {
+				defer wg.Done()
+				for i := 0; i < topics*perTopic; i++ {
+					topic := fmt.Sprintf("orders.%d", (p+i)%topics)
+					assert.NoError(t, q.Enqueue(fixture.Ctx, topic, fixture.CreateMessage(fmt.Sprint(p, i), topic, nil)))
+				}
+			}(p)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				_, err := q.Topics(fixture.Ctx)
+				assert.NoError(t, err)
+			}
+		}()
+		wg.Wait()
+
+		names, err := q.Topics(fixture.Ctx)
+		require.NoError(t, err)
+		assert.Len(t, names, topics, "Every topic should be created once")
+		for _, topic := range names {
+			fixture.AssertQueueSize(topic, producers*perTopic, "No message should be lost")
+		}
 	})
 
-	t.Run("Topics", func(t *testing.T) {
+	t.Run("EnqueueAfterDeleteTopic", func(t *testing.T) {
 		q := NewInMemoryQueue()
 		fixture := testutils.NewBaseFixture(t, q)
 
-		t.Run("InitiallyEmpty", func(t *testing.T) {
-			topics, err := fixture.Queue.Topics(fixture.Ctx)
-			require.NoError(t, err, "Should get topics successfully")
-			assert.Empty(t, topics, "Should have no topics initially")
-		})
+		require.NoError(t, q.Enqueue(fixture.Ctx, "orders", fixture.CreateMessage("1", "orders", nil)))
+		require.NoError(t, q.DeleteTopic(fixture.Ctx, "orders"))
+		fixture.AssertQueueSize("orders", 0, "Deleting a topic should drop its messages")
 
-		t.Run("AfterEnqueue", func(t *testing.T) {
-			msg1 := fixture.CreateMessage("1", "topic1", []byte("payload1"))
-			msg2 := fixture.CreateMessage("2", "topic2", []byte("payload2"))
+		require.NoError(t, q.Enqueue(fixture.Ctx, "orders", fixture.CreateMessage("2", "orders", nil)))
+		fixture.AssertQueueSize("orders", 1, "Enqueue should create the topic again")
+	})
 
-			err := fixture.Queue.Enqueue(fixture.Ctx, "topic1", msg1)
-			require.NoError(t, err, "Should enqueue to topic1")
+	t.Run("Shutdown", func(t *testing.T) {
+		t.Run("WaitsForConsumers", func(t *testing.T) {
+			clock := testutils.NewFakeClock(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+			q := NewInMemoryQueue(WithClock(clock))
+			fixture := testutils.NewBaseFixture(t, q)
+			require.NoError(t, q.Enqueue(fixture.Ctx, "orders", fixture.CreateMessage("o1", "orders", []byte("o1"))))
+			require.NoError(t, q.Enqueue(fixture.Ctx, "orders", fixture.CreateMessage("o2", "orders", []byte("o2"))))
+			_, err := q.Dequeue(fixture.Ctx, "orders")
+			require.NoError(t, err)
+
+			done := shutdownAsync(q, fixture.Ctx)
+			clock.BlockUntil(1)
+			clock.Advance(DrainIdleTimeout / 2)
+			select {
+			case <-done:
+				t.Fatal("Should wait while a consumer empties the topic")
+			case <-time.After(10 * time.Millisecond):
+			}
 
-			err = fixture.Queue.Enqueue(fixture.Ctx, "topic2", msg2)
-			require.NoError(t, err, "Should enqueue to topic2")
+			_, err = q.Dequeue(fixture.Ctx, "orders")
+			require.NoError(t, err)
+			clock.Advance(10 * time.Millisecond)
 
-			topics, err := fixture.Queue.Topics(fixture.Ctx)
-			require.NoError(t, err, "Should get topics successfully")
-			assert.Len(t, topics, 2, "Should have exactly 2 topics")
+			res := <-done
+			require.NoError(t, res.err, "Should complete once the topic is empty")
+			assert.Zero(t, res.report.DroppedTotal())
 
-			fixture.AssertTopicsContain("topic1", "topic2")
+			_, err = q.Size(fixture.Ctx, "orders")
+			assert.Error(t, err, "Queue should be closed after shutdown")
 		})
-	})
 
-	t.Run("Close", func(t *testing.T) {
-		q := NewInMemoryQueue()
-		fixture := testutils.NewBaseFixture(t, q)
-		topic := "test-topic"
-
-		msg := fixture.CreateMessage("test", topic, []byte("test"))
-		err := fixture.Queue.Enqueue(fixture.Ctx, topic, msg)
-		require.NoError(t, err, "Should enqueue message before closing")
+		t.Run("DoesNotWaitForIdleTopics", func(t *testing.T) {
+			clock := testutils.NewFakeClock(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+			q := NewInMemoryQueue(WithClock(clock))
+			fixture := testutils.NewBaseFixture(t, q)
+			require.NoError(t, q.Enqueue(fixture.Ctx, "orders.dlq", fixture.CreateMessage("o1", "orders.dlq", []byte("o1"))))
 
-		err = fixture.Queue.Close()
-		require.NoError(t, err, "Should close queue successfully")
+			res := <-shutdownAsync(q, fixture.Ctx)
+			require.NoError(t, res.err, "Should not wait for a topic nobody consumes")
+			assert.Equal(t, map[string]int{"orders.dlq": 1}, res.report.Dropped)
+		})
 
-		t.Run("OperationsAfterClose", func(t *testing.T) {
-			assertQueueOperationErrors(t, fixture, topic, msg)
+		t.Run("StopsWaitingWhenConsumersStop", func(t *testing.T) {
+			clock := testutils.NewFakeClock(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+			q := NewInMemoryQueue(WithClock(clock))
+			fixture := testutils.NewBaseFixture(t, q)
+			require.NoError(t, q.Enqueue(fixture.Ctx, "orders", fixture.CreateMessage("o1", "orders", []byte("o1"))))
+			require.NoError(t, q.Enqueue(fixture.Ctx, "orders", fixture.CreateMessage("o2", "orders", []byte("o2"))))
+			_, err := q.Dequeue(fixture.Ctx, "orders")
+			require.NoError(t, err)
+
+			done := shutdownAsync(q, fixture.Ctx)
+			clock.BlockUntil(1)
+			clock.Advance(DrainIdleTimeout)
+
+			res := <-done
+			require.NoError(t, res.err, "Should stop waiting once the topic is idle")
+			assert.Equal(t, map[string]int{"orders": 1}, res.report.Dropped)
 		})
-	})
 
-	t.Run("Concurrent", func(t *testing.T) {
-		q := NewInMemoryQueue()
-		fixture := testutils.NewBaseFixture(t, q)
-		topic := "concurrent-topic"
-		numProducers := 5
-		numMessages := 10
-
-		done := make(chan struct{})
-		for i := 0; i < numProducers; i++ {
-			go func(producerID int) {
-				for j := 0; j < numMessages; j++ {
-					msg := &queue.Message{
-						ID:        fmt.Sprintf("producer-%d-msg-%d", producerID, j),
-						Topic:     topic,
-						Payload:   []byte(fmt.Sprintf("payload from producer %d, message %d", producerID, j)),
-						Timestamp: time.Now(),
-					}
-					fixture.Queue.Enqueue(fixture.Ctx, topic, msg)
-				}
-				done <- struct{}{}
-			}(i)
-		}
+		t.Run("RejectsNewMessages", func(t *testing.T) {
+			q := NewInMemoryQueue()
+			fixture := testutils.NewBaseFixture(t, q)
+			require.NoError(t, q.Enqueue(fixture.Ctx, "orders", fixture.CreateMessage("o1", "orders", []byte("o1"))))
+			require.NoError(t, q.Enqueue(fixture.Ctx, "orders", fixture.CreateMessage("o2", "orders", []byte("o2"))))
+			// A consumer is active on the topic
+			_, err := q.Dequeue(fixture.Ctx, "orders")
+			require.NoError(t, err)
+
+			ctx, cancel := context.WithCancel(fixture.Ctx)
+			go func() {
+				assert.Eventually(t, func() bool {
+					return q.Enqueue(fixture.Ctx, "orders", fixture.CreateMessage("o2", "orders", nil)) != nil
+				}, time.Second, 5*time.Millisecond, "Should reject messages while shutting down")
+				cancel()
+			}()
+
+			report, err := q.Shutdown(ctx)
+			assert.ErrorIs(t, err, context.Canceled)
+			assert.Equal(t, map[string]int{"orders": 1}, report.Dropped, "Should report dropped messages")
+		})
 
-		for i := 0; i < numProducers; i++ {
-			<-done
-		}
+		t.Run("PersistsPendingMessages", func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "queue.json")
+			q := NewInMemoryQueue(WithSnapshotFile(path, SnapshotJSON), WithSnapshotOnClose())
+			fixture := testutils.NewBaseFixture(t, q)
+			require.NoError(t, q.Enqueue(fixture.Ctx, "orders", fixture.CreateMessage("o1", "orders", []byte("o1"))))
+			require.NoError(t, q.Enqueue(fixture.Ctx, "orders", fixture.CreateMessage("o2", "orders", []byte("o2"))))
+			// A consumer is active on the topic
+			_, err := q.Dequeue(fixture.Ctx, "orders")
+			require.NoError(t, err)
+
+			ctx, cancel := context.WithTimeout(fixture.Ctx, 20*time.Millisecond)
+			defer cancel()
+
+			report, err := q.Shutdown(ctx)
+			assert.ErrorIs(t, err, context.DeadlineExceeded)
+			assert.Equal(t, map[string]int{"orders": 1}, report.Persisted)
+			assert.Zero(t, report.DroppedTotal())
+
+			restored := NewInMemoryQueue(WithSnapshotFile(path, SnapshotJSON))
+			defer restored.Close()
+			require.NoError(t, restored.LoadSnapshotFile())
+			size, err := restored.Size(fixture.Ctx, "orders")
+			require.NoError(t, err)
+			assert.Equal(t, 1, size, "Pending message should be restored")
+		})
+	})
 
-		expectedSize := numProducers * numMessages
-		fixture.AssertQueueSize(topic, expectedSize, "Should have correct number of messages")
+}
 
-		messagesReceived := 0
-		for {
-			msg, err := fixture.Queue.Dequeue(fixture.Ctx, topic)
-			require.NoError(t, err, "Should dequeue messages without error")
-			if msg == nil {
-				break
-			}
-			messagesReceived++
-		}
+type shutdownResult struct {
+	report queue.ShutdownReport
+	err    error
+}
 
-		assert.Equal(t, expectedSize, messagesReceived, "Should receive all messages")
-	})
+// shutdownAsync shuts the queue down in the background and returns the channel receiving the result
+func shutdownAsync(q *InMemoryQueue, ctx context.Context) <-chan shutdownResult {
+	done := make(chan shutdownResult, 1)
+	go func() {
+		report, err := q.Shutdown(ctx)
+		done <- shutdownResult{report, err}
+	}()
+	return done
 }
@@ -0,0 +1,219 @@
+package inmemory
+
+import (
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/syl/Go/pkg/examples/queue"
+)
+
+// SnapshotVersion is the version of the snapshot layout written by this package
+const SnapshotVersion = 1
+
+// SnapshotFormat is the encoding of a snapshot file
+type SnapshotFormat string
+
+const (
+	// SnapshotJSON encodes snapshots as indented JSON, payloads are base64 encoded
+	SnapshotJSON SnapshotFormat = "json"
+	// SnapshotBinary encodes snapshots with encoding/gob
+	SnapshotBinary SnapshotFormat = "binary"
+)
+
+// ErrNoSnapshotFile is returned when a snapshot file operation is used without WithSnapshotFile
+var ErrNoSnapshotFile = errors.New("no snapshot file configured")
+
+// Snapshot is the state of all topics and their pending messages at a point in time
+type Snapshot struct {
+	Version   int             `json:"version"`
+	CreatedAt time.Time       `json:"created_at"`
+	Topics    []TopicSnapshot `json:"topics"`
+}
+
+// TopicSnapshot holds the pending messages of a topic in delivery order
+type TopicSnapshot struct {
+	Topic    string           `json:"topic"`
+	Messages []*queue.Message `json:"messages"`
+}
+
+// Snapshot returns a copy of all topics and pending messages, sorted by topic name
+// Each topic is copied atomically, producers of other topics keep running meanwhile.
+func (q *InMemoryQueue) Snapshot() (*Snapshot, error) {
+	if err := q.open(); err != nil {
+		return nil, err
+	}
+
+	return q.snapshotTopics(), nil
+}
+
+func (q *InMemoryQueue) snapshotTopics() *Snapshot {
+	snapshot := &Snapshot{
+		Version:   SnapshotVersion,
+		CreatedAt: q.config.clock.Now(),
+		Topics:    []TopicSnapshot{},
+	}
+
+	q.topics.each(func(topic string, t *topicQueue) {
+		clones := make([]*queue.Message, len(t.messages))
+		for i, message := range t.messages {
+			clones[i] = message.Clone()
+		}
+		snapshot.Topics = append(snapshot.Topics, TopicSnapshot{Topic: topic, Messages: clones})
+	})
+
+	sort.Slice(snapshot.Topics, func(i, j int) bool {
+		return snapshot.Topics[i].Topic < snapshot.Topics[j].Topic
+	})
+	return snapshot
+}
+
+// Restore replaces all topics and pending messages with the content of the snapshot
+func (q *InMemoryQueue) Restore(snapshot *Snapshot) error {
+	if snapshot.Version != SnapshotVersion {
+		return fmt.Errorf("unsupported snapshot version %d", snapshot.Version)
+	}
+
+	topics := make(map[string][]*queue.Message, len(snapshot.Topics))
+	for _, t := range snapshot.Topics {
+		if len(t.Messages) > TopicCapacity {
+			return fmt.Errorf("topic %s has %d messages, capacity is %d", t.Topic, len(t.Messages), TopicCapacity)
+		}
+		messages := make([]*queue.Message, len(t.Messages))
+		for i, message := range t.Messages {
+			if message == nil {
+				return fmt.Errorf("topic %s has a null message at index %d", t.Topic, i)
+			}
+			messages[i] = message.Clone()
+		}
+		topics[t.Topic] = messages
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if err := q.open(); err != nil {
+		return err
+	}
+
+	q.topics.replace(topics)
+	return nil
+}
+
+// SaveSnapshotFile writes a snapshot to the file configured with WithSnapshotFile
+func (q *InMemoryQueue) SaveSnapshotFile() error {
+	if q.config.snapshotPath == "" {
+		return ErrNoSnapshotFile
+	}
+
+	snapshot, err := q.Snapshot()
+	if err != nil {
+		return err
+	}
+	return WriteSnapshotFile(q.config.snapshotPath, q.config.snapshotFormat, snapshot)
+}
+
+// LoadSnapshotFile restores the queue from the file configured with WithSnapshotFile
+// A missing file leaves the queue untouched, so the first start of a service begins empty.
+func (q *InMemoryQueue) LoadSnapshotFile() error {
+	if q.config.snapshotPath == "" {
+		return ErrNoSnapshotFile
+	}
+
+	snapshot, err := ReadSnapshotFile(q.config.snapshotPath, q.config.snapshotFormat)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return q.Restore(snapshot)
+}
+
+// runSnapshots writes periodic snapshots until stop is closed
+func (q *InMemoryQueue) runSnapshots(interval time.Duration, stop <-chan struct{}) {
+	defer close(q.snapshotDone)
+
+	ticker := q.config.clock.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C():
+			if err := q.SaveSnapshotFile(); err != nil && q.config.onSnapshotError != nil {
+				q.config.onSnapshotError(err)
+			}
+		}
+	}
+}
+
+// WriteSnapshot encodes the snapshot to w in the given format
+func WriteSnapshot(w io.Writer, format SnapshotFormat, snapshot *Snapshot) error {
+	switch format {
+	case SnapshotJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(snapshot)
+	case SnapshotBinary:
+		return gob.NewEncoder(w).Encode(snapshot)
+	default:
+		return fmt.Errorf("unknown snapshot format %q", format)
+	}
+}
+
+// ReadSnapshot decodes a snapshot in the given format from r
+func ReadSnapshot(r io.Reader, format SnapshotFormat) (*Snapshot, error) {
+	var snapshot Snapshot
+	var err error
+
+	switch format {
+	case SnapshotJSON:
+		err = json.NewDecoder(r).Decode(&snapshot)
+	case SnapshotBinary:
+		err = gob.NewDecoder(r).Decode(&snapshot)
+	default:
+		return nil, fmt.Errorf("unknown snapshot format %q", format)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode snapshot: %w", err)
+	}
+	return &snapshot, nil
+}
+
+// WriteSnapshotFile atomically replaces the file at path with the encoded snapshot
+func WriteSnapshotFile(path string, format SnapshotFormat, snapshot *Snapshot) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := WriteSnapshot(tmp, format, snapshot); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// ReadSnapshotFile decodes the snapshot stored at path
+func ReadSnapshotFile(path string, format SnapshotFormat) (*Snapshot, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return ReadSnapshot(f, format)
+}
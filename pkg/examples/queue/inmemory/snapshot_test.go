@@ -0,0 +1,203 @@
+package inmemory
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/syl/Go/pkg/examples/queue"
+	"github.com/syl/Go/pkg/examples/queue/inmemory/testutils"
+)
+
+func snapshotMessage(id, topic string, timestamp time.Time) *queue.Message {
+	return &queue.Message{
+		ID:        id,
+		Topic:     topic,
+		Payload:   []byte("payload " + id),
+		Headers:   map[string]string{"id": id},
+		Timestamp: timestamp,
+	}
+}
+
+// fillQueue enqueues messages on two topics and returns them in delivery order
+func fillQueue(t *testing.T, q *InMemoryQueue) map[string][]*queue.Message {
+	t.Helper()
+
+	base := time.Date(2024, 1, 2, 3, 4, 5, 6, time.UTC)
+	messages := map[string][]*queue.Message{
+		"orders":   {snapshotMessage("o1", "orders", base), snapshotMessage("o2", "orders", base.Add(time.Second)), snapshotMessage("o3", "orders", base.Add(2*time.Second))},
+		"payments": {snapshotMessage("p1", "payments", base.Add(time.Minute))},
+	}
+	for topic, msgs := range messages {
+		for _, msg := range msgs {
+			require.NoError(t, q.Enqueue(context.Background(), topic, msg))
+		}
+	}
+	return messages
+}
+
+func assertDrains(t *testing.T, q *InMemoryQueue, expected map[string][]*queue.Message) {
+	t.Helper()
+
+	for topic, msgs := range expected {
+		for _, want := range msgs {
+			got, err := q.Dequeue(context.Background(), topic)
+			require.NoError(t, err)
+			require.NotNil(t, got, "Should restore message %s", want.ID)
+			assert.Equal(t, want.ID, got.ID, "Order should be preserved")
+			assert.Equal(t, want.Payload, got.Payload)
+			assert.Equal(t, want.Headers, got.Headers)
+			assert.True(t, want.Timestamp.Equal(got.Timestamp), "Timestamp should be preserved")
+		}
+		size, err := q.Size(context.Background(), topic)
+		require.NoError(t, err)
+		assert.Equal(t, 0, size, "No extra messages should be restored")
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	for _, format := range []SnapshotFormat{SnapshotJSON, SnapshotBinary} {
+		t.Run("RoundTrip_"+string(format), func(t *testing.T) {
+			source := NewInMemoryQueue()
+			defer source.Close()
+			expected := fillQueue(t, source)
+
+			snapshot, err := source.Snapshot()
+			require.NoError(t, err)
+			assert.Equal(t, "orders", snapshot.Topics[0].Topic, "Topics should be sorted")
+
+			var buf bytes.Buffer
+			require.NoError(t, WriteSnapshot(&buf, format, snapshot))
+			decoded, err := ReadSnapshot(&buf, format)
+			require.NoError(t, err)
+
+			target := NewInMemoryQueue()
+			defer target.Close()
+			require.NoError(t, target.Restore(decoded))
+
+			assertDrains(t, target, expected)
+		})
+	}
+
+	t.Run("SnapshotIsACopy", func(t *testing.T) {
+		q := NewInMemoryQueue()
+		defer q.Close()
+		fillQueue(t, q)
+
+		snapshot, err := q.Snapshot()
+		require.NoError(t, err)
+		snapshot.Topics[0].Messages[0].Headers["id"] = "changed"
+
+		msg, err := q.Dequeue(context.Background(), "orders")
+		require.NoError(t, err)
+		assert.Equal(t, "o1", msg.Headers["id"], "Queue should not share messages with the snapshot")
+	})
+
+	t.Run("RestoreReplacesTopics", func(t *testing.T) {
+		q := NewInMemoryQueue()
+		defer q.Close()
+		require.NoError(t, q.Enqueue(context.Background(), "stale", snapshotMessage("s1", "stale", time.Now())))
+
+		require.NoError(t, q.Restore(&Snapshot{Version: SnapshotVersion}))
+
+		topics, err := q.Topics(context.Background())
+		require.NoError(t, err)
+		assert.Empty(t, topics, "Restore should replace existing topics")
+	})
+
+	t.Run("RestoreRejectsUnknownVersion", func(t *testing.T) {
+		q := NewInMemoryQueue()
+		defer q.Close()
+
+		assert.Error(t, q.Restore(&Snapshot{Version: SnapshotVersion + 1}))
+	})
+
+	t.Run("RestoreRejectsNullMessages", func(t *testing.T) {
+		q := NewInMemoryQueue()
+		defer q.Close()
+		require.NoError(t, q.Enqueue(context.Background(), "kept", snapshotMessage("k1", "kept", time.Now())))
+
+		snapshot, err := ReadSnapshot(strings.NewReader(`{"version":1,"topics":[{"topic":"orders","messages":[null]}]}`), SnapshotJSON)
+		require.NoError(t, err)
+
+		assert.Error(t, q.Restore(snapshot))
+		size, err := q.Size(context.Background(), "kept")
+		require.NoError(t, err)
+		assert.Equal(t, 1, size, "A rejected snapshot should leave the queue unchanged")
+	})
+
+	t.Run("UnknownFormat", func(t *testing.T) {
+		var buf bytes.Buffer
+		assert.Error(t, WriteSnapshot(&buf, "xml", &Snapshot{}))
+		_, err := ReadSnapshot(&buf, "xml")
+		assert.Error(t, err)
+	})
+
+	t.Run("FileOnDemand", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "queue.json")
+
+		source := NewInMemoryQueue(WithSnapshotFile(path, SnapshotJSON))
+		defer source.Close()
+		expected := fillQueue(t, source)
+		require.NoError(t, source.SaveSnapshotFile())
+
+		target := NewInMemoryQueue(WithSnapshotFile(path, SnapshotJSON))
+		defer target.Close()
+		require.NoError(t, target.LoadSnapshotFile())
+
+		assertDrains(t, target, expected)
+	})
+
+	t.Run("MissingFile", func(t *testing.T) {
+		q := NewInMemoryQueue(WithSnapshotFile(filepath.Join(t.TempDir(), "missing.bin"), SnapshotBinary))
+		defer q.Close()
+
+		assert.NoError(t, q.LoadSnapshotFile(), "A missing snapshot should start an empty queue")
+	})
+
+	t.Run("NoFileConfigured", func(t *testing.T) {
+		q := NewInMemoryQueue()
+		defer q.Close()
+
+		assert.ErrorIs(t, q.SaveSnapshotFile(), ErrNoSnapshotFile)
+		assert.ErrorIs(t, q.LoadSnapshotFile(), ErrNoSnapshotFile)
+	})
+
+	t.Run("OnClose", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "queue.bin")
+
+		source := NewInMemoryQueue(WithSnapshotFile(path, SnapshotBinary), WithSnapshotOnClose())
+		expected := fillQueue(t, source)
+		require.NoError(t, source.Close())
+
+		target := NewInMemoryQueue(WithSnapshotFile(path, SnapshotBinary))
+		defer target.Close()
+		require.NoError(t, target.LoadSnapshotFile())
+
+		assertDrains(t, target, expected)
+	})
+
+	t.Run("Periodic", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "queue.json")
+
+		clock := testutils.NewFakeClock(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+		q := NewInMemoryQueue(WithSnapshotFile(path, SnapshotJSON), WithSnapshotInterval(time.Minute), WithClock(clock))
+		defer q.Close()
+		fillQueue(t, q)
+
+		clock.BlockUntil(1)
+		clock.Advance(59 * time.Second)
+		_, err := ReadSnapshotFile(path, SnapshotJSON)
+		require.Error(t, err, "Should not write a snapshot before the interval elapsed")
+
+		clock.AdvanceUntil(t, time.Minute, func() bool {
+			snapshot, err := ReadSnapshotFile(path, SnapshotJSON)
+			return err == nil && len(snapshot.Topics) == 2
+		}, "Should write snapshots periodically")
+	})
+}
@@ -0,0 +1,170 @@
+package testutils
+
+import (
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/syl/Go/pkg/examples/queue"
+)
+
+// ensure that FakeClock implements the Clock interface
+var _ queue.Clock = (*FakeClock)(nil)
+
+// FakeClock is a queue.Clock whose time only moves when the test calls Advance
+// Tickers and timers fire during Advance, in the order of their due time.
+type FakeClock struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	now     time.Time
+	waiters []*fakeWaiter
+}
+
+// fakeWaiter is a pending ticker or timer, period is zero for timers
+type fakeWaiter struct {
+	clock  *FakeClock
+	due    time.Time
+	period time.Duration
+	c      chan time.Time
+}
+
+// NewFakeClock creates a fake clock set to start
+func NewFakeClock(start time.Time) *FakeClock {
+	c := &FakeClock{now: start}
+	c.cond = sync.NewCond(&c.mu)
+	return c
+}
+
+// Now returns the fake time
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.now
+}
+
+// Since returns the fake time elapsed since t
+func (c *FakeClock) Since(t time.Time) time.Duration {
+	return c.Now().Sub(t)
+}
+
+// NewTicker creates a ticker firing every d of fake time
+func (c *FakeClock) NewTicker(d time.Duration) queue.Ticker {
+	if d <= 0 {
+		panic("non-positive interval for NewTicker")
+	}
+	return fakeTicker{c.add(d, d)}
+}
+
+// NewTimer creates a timer firing once d of fake time elapsed
+func (c *FakeClock) NewTimer(d time.Duration) queue.Timer {
+	return fakeTimer{c.add(d, 0)}
+}
+
+// Advance moves the time forward by d and fires the tickers and timers that became due
+// Like their package time counterparts, tickers drop ticks while their channel is full.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	end := c.now.Add(d)
+	for {
+		sort.SliceStable(c.waiters, func(i, j int) bool { return c.waiters[i].due.Before(c.waiters[j].due) })
+		if len(c.waiters) == 0 || c.waiters[0].due.After(end) {
+			break
+		}
+
+		w := c.waiters[0]
+		c.now = w.due
+		select {
+		case w.c <- c.now:
+		default:
+		}
+
+		if w.period > 0 {
+			w.due = w.due.Add(w.period)
+		} else {
+			c.waiters = c.waiters[1:]
+		}
+	}
+	c.now = end
+}
+
+// AdvanceUntil advances the time by step until condition holds, letting the goroutines woken by each step run
+// The test fails when condition does not hold within DefaultTestTimeout of real time.
+func (c *FakeClock) AdvanceUntil(t testing.TB, step time.Duration, condition func() bool, msgAndArgs ...interface{}) {
+	t.Helper()
+
+	deadline := time.Now().Add(DefaultTestTimeout)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal(append([]interface{}{"Condition never satisfied: "}, msgAndArgs...)...)
+		}
+		c.Advance(step)
+		// Yield to the woken goroutines, the fake time does not move meanwhile
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// Waiters returns the number of pending tickers and timers
+func (c *FakeClock) Waiters() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.waiters)
+}
+
+// BlockUntil waits until at least n tickers and timers are pending
+// Tests use it to make sure a goroutine created its ticker before advancing the time.
+func (c *FakeClock) BlockUntil(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for len(c.waiters) < n {
+		c.cond.Wait()
+	}
+}
+
+func (c *FakeClock) add(d, period time.Duration) *fakeWaiter {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	w := &fakeWaiter{clock: c, due: c.now.Add(d), period: period, c: make(chan time.Time, 1)}
+	c.waiters = append(c.waiters, w)
+	c.cond.Broadcast()
+	return w
+}
+
+// remove drops the waiter and reports whether it was pending
+func (c *FakeClock) remove(w *fakeWaiter) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for i, pending := range c.waiters {
+		if pending == w {
+			c.waiters = append(c.waiters[:i], c.waiters[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// C returns the channel receiving the ticks
+func (w *fakeWaiter) C() <-chan time.Time {
+	return w.c
+}
+
+type fakeTicker struct{ *fakeWaiter }
+
+// Stop cancels the ticker
+func (t fakeTicker) Stop() {
+	t.clock.remove(t.fakeWaiter)
+}
+
+type fakeTimer struct{ *fakeWaiter }
+
+// Stop cancels the timer, it reports whether the timer was stopped before firing
+func (t fakeTimer) Stop() bool {
+	return t.clock.remove(t.fakeWaiter)
+}
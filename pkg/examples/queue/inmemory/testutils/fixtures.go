@@ -150,6 +150,5 @@ func CreateLogger(prefix string) *log.Logger {
 
 // Constants for common test values
 const (
-	ConsumerStartupDelay = 200 * time.Millisecond
-	DefaultTestTimeout   = 5 * time.Second
+	DefaultTestTimeout = 5 * time.Second
 )
@@ -60,6 +60,23 @@ func (f *ServiceFixture) RunServiceWithTimeout(serviceFunc func(context.Context)
 	wg.Wait()
 }
 
+// StartService runs a service function in the background until the returned stop function is called
+func (f *ServiceFixture) StartService(serviceFunc func(context.Context) error) (stop func()) {
+	f.T.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- serviceFunc(ctx)
+	}()
+
+	return func() {
+		cancel()
+		err := <-done
+		assert.True(f.T, err == nil || err == context.Canceled, "Service should stop gracefully")
+	}
+}
+
 // AssertMessageHeaders validates that a message has expected headers
 func (f *ServiceFixture) AssertMessageHeaders(msg *queue.Message, expectedHeaders map[string]string) {
 	f.T.Helper()
@@ -0,0 +1,176 @@
+package inmemory
+
+import (
+	"hash/maphash"
+	"sync"
+	"time"
+
+	"github.com/syl/Go/pkg/examples/queue"
+)
+
+// shardCount is the number of independently locked partitions of the topic map
+const shardCount = 32
+
+// topicQueue holds the pending messages of a topic behind its own lock
+type topicQueue struct {
+	mu       sync.Mutex
+	messages []*queue.Message
+	// polled is the last time a consumer dequeued from the topic, Shutdown only waits for polled topics
+	polled time.Time
+	// deleted is set once the topic was removed from the map, holders of a stale pointer must look it up again
+	deleted bool
+}
+
+// topicShard is a partition of the topic map
+type topicShard struct {
+	mu     sync.RWMutex
+	topics map[string]*topicQueue
+}
+
+// topicMap spreads the topics over shards, so that creating a topic only write locks its own shard
+// and listing the topics only read locks one shard at a time
+type topicMap struct {
+	seed   maphash.Seed
+	shards [shardCount]topicShard
+}
+
+func newTopicMap() *topicMap {
+	m := &topicMap{seed: maphash.MakeSeed()}
+	for i := range m.shards {
+		m.shards[i].topics = make(map[string]*topicQueue)
+	}
+	return m
+}
+
+func (m *topicMap) shard(topic string) *topicShard {
+	return &m.shards[maphash.String(m.seed, topic)%shardCount]
+}
+
+// get returns the topic, or nil when it does not exist
+func (m *topicMap) get(topic string) *topicQueue {
+	s := m.shard(topic)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.topics[topic]
+}
+
+// lock returns the locked topic, creating it on first use
+// Only the first producer of a topic takes the write lock of its shard.
+func (m *topicMap) lock(topic string) *topicQueue {
+	for {
+		t := m.get(topic)
+		if t == nil {
+			s := m.shard(topic)
+			s.mu.Lock()
+			if t = s.topics[topic]; t == nil {
+				t = &topicQueue{}
+				s.topics[topic] = t
+			}
+			s.mu.Unlock()
+		}
+
+		t.mu.Lock()
+		if !t.deleted {
+			return t
+		}
+		// Deleted between the lookup and the lock, the next lookup creates it again
+		t.mu.Unlock()
+	}
+}
+
+// lockExisting returns the locked topic, or nil when it does not exist
+func (m *topicMap) lockExisting(topic string) *topicQueue {
+	t := m.get(topic)
+	if t == nil {
+		return nil
+	}
+
+	t.mu.Lock()
+	if t.deleted {
+		t.mu.Unlock()
+		return nil
+	}
+	return t
+}
+
+// delete removes the topic and drops its messages, it reports whether the topic existed
+func (m *topicMap) delete(topic string) bool {
+	s := m.shard(topic)
+	s.mu.Lock()
+	t, exists := s.topics[topic]
+	delete(s.topics, topic)
+	s.mu.Unlock()
+
+	if !exists {
+		return false
+	}
+
+	t.mu.Lock()
+	t.deleted = true
+	t.messages = nil
+	t.mu.Unlock()
+	return true
+}
+
+// replace drops every topic and its messages and adds the given topics instead
+// It holds every shard meanwhile, so that no operation sees a mix of the former and the new topics.
+func (m *topicMap) replace(topics map[string][]*queue.Message) {
+	for i := range m.shards {
+		m.shards[i].mu.Lock()
+	}
+	defer func() {
+		for i := range m.shards {
+			m.shards[i].mu.Unlock()
+		}
+	}()
+
+	for i := range m.shards {
+		s := &m.shards[i]
+		for _, t := range s.topics {
+			t.mu.Lock()
+			t.deleted = true
+			t.messages = nil
+			t.mu.Unlock()
+		}
+		s.topics = make(map[string]*topicQueue)
+	}
+	for topic, messages := range topics {
+		m.shard(topic).topics[topic] = &topicQueue{messages: messages}
+	}
+}
+
+// names returns the name of every topic
+func (m *topicMap) names() []string {
+	var names []string
+	for i := range m.shards {
+		s := &m.shards[i]
+		s.mu.RLock()
+		for topic := range s.topics {
+			names = append(names, topic)
+		}
+		s.mu.RUnlock()
+	}
+	return names
+}
+
+// each calls fn with every topic while holding the lock of that topic only
+func (m *topicMap) each(fn func(topic string, t *topicQueue)) {
+	for i := range m.shards {
+		s := &m.shards[i]
+		s.mu.RLock()
+		topics := make(map[string]*topicQueue, len(s.topics))
+		for topic, t := range s.topics {
+			topics[topic] = t
+		}
+		s.mu.RUnlock()
+
+		for topic, t := range topics {
+			t.mu.Lock()
+			if !t.deleted {
+				fn(topic, t)
+			}
+			t.mu.Unlock()
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package nats
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/syl/Go/pkg/examples/queue"
+	"github.com/syl/Go/pkg/examples/queue/broker"
+)
+
+const (
+	// DefaultMaxDeliver is how many times a message is handed to a failing handler before it is dead-lettered
+	DefaultMaxDeliver = 5
+	// DefaultRetryDelay is how long JetStream waits before delivering a failed message again
+	DefaultRetryDelay = time.Second
+)
+
+// ensure that Consumer implements the Consumer interface
+var _ queue.Consumer = (*Consumer)(nil)
+
+// Consumer implements the Consumer interface with the durable JetStream consumers of a Queue
+// A message is acknowledged when its handler succeeds. When the handler fails JetStream delivers it again
+// after the retry delay, and after the last attempt it is moved to the dead-letter topic (see broker.DeadLetterTopic).
+// Messages whose handler did not finish within the ack wait of the queue are delivered again.
+type Consumer struct {
+	queue      *Queue
+	maxDeliver int
+	retryDelay time.Duration
+
+	mu            sync.Mutex
+	subscriptions map[string]*subscription
+	closed        bool
+}
+
+// subscription is an active subscription to a topic
+type subscription struct {
+	topic   string
+	handler queue.MessageHandler
+	consume jetstream.ConsumeContext
+	ctx     context.Context
+	cancel  context.CancelFunc
+
+	// mu is held while the handler runs, so stopping waits for it
+	mu      sync.Mutex
+	stopped bool
+}
+
+// ConsumerOption configures a Consumer created with NewConsumer
+type ConsumerOption func(*Consumer)
+
+// WithMaxDeliver changes how many times a message is handed to a failing handler before it is dead-lettered
+func WithMaxDeliver(n int) ConsumerOption {
+	return func(c *Consumer) {
+		c.maxDeliver = n
+	}
+}
+
+// WithRetryDelay changes how long JetStream waits before delivering a failed message again
+func WithRetryDelay(d time.Duration) ConsumerOption {
+	return func(c *Consumer) {
+		c.retryDelay = d
+	}
+}
+
+// NewConsumer creates a consumer of the topics of q
+func NewConsumer(q *Queue, opts ...ConsumerOption) *Consumer {
+	c := &Consumer{
+		queue:         q,
+		maxDeliver:    DefaultMaxDeliver,
+		retryDelay:    DefaultRetryDelay,
+		subscriptions: make(map[string]*subscription),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Subscribe starts consuming messages from the specified topic
+func (c *Consumer) Subscribe(ctx context.Context, topic string, handler queue.MessageHandler) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return fmt.Errorf("consumer is closed")
+	}
+
+	if _, exists := c.subscriptions[topic]; exists {
+		return fmt.Errorf("already subscribed to topic: %s", topic)
+	}
+
+	consumer, err := c.queue.consumer(ctx, topic)
+	if err != nil {
+		return err
+	}
+
+	subCtx, cancel := context.WithCancel(ctx)
+	sub := &subscription{topic: topic, handler: handler, ctx: subCtx, cancel: cancel}
+
+	// Buffer a single message so the others stay available to competing consumers
+	sub.consume, err = consumer.Consume(func(msg jetstream.Msg) {
+		c.handle(sub, msg)
+	}, jetstream.PullMaxMessages(1))
+	if err != nil {
+		cancel()
+		return fmt.Errorf("failed to consume %s: %w", topic, err)
+	}
+
+	c.subscriptions[topic] = sub
+	return nil
+}
+
+// Unsubscribe stops consuming messages from the specified topic and waits for the running handler
+func (c *Consumer) Unsubscribe(ctx context.Context, topic string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	sub, exists := c.subscriptions[topic]
+	if !exists {
+		return fmt.Errorf("not subscribed to topic: %s", topic)
+	}
+
+	sub.stop()
+	delete(c.subscriptions, topic)
+	return nil
+}
+
+// Close stops every subscription, the queue stays open
+func (c *Consumer) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+
+	for _, sub := range c.subscriptions {
+		sub.stop()
+	}
+	c.subscriptions = make(map[string]*subscription)
+	return nil
+}
+
+// stop stops fetching messages and waits for the running handler
+func (s *subscription) stop() {
+	s.consume.Stop()
+	s.cancel()
+
+	s.mu.Lock()
+	s.stopped = true
+	s.mu.Unlock()
+}
+
+// handle hands a message to the handler and acknowledges, retries or dead-letters it
+func (c *Consumer) handle(sub *subscription, msg jetstream.Msg) {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+
+	if sub.stopped || sub.ctx.Err() != nil {
+		// Give the message back to the other consumers of the topic
+		msg.Nak()
+		return
+	}
+
+	message := decode(sub.topic, msg)
+	err := call(sub.ctx, sub.handler, message)
+	if err == nil {
+		msg.Ack()
+		return
+	}
+
+	metadata, metaErr := msg.Metadata()
+	if metaErr != nil || int(metadata.NumDelivered) < c.maxDeliver {
+		msg.NakWithDelay(c.retryDelay)
+		return
+	}
+
+	if err := c.deadLetter(sub.ctx, message, err); err != nil {
+		msg.NakWithDelay(c.retryDelay)
+		return
+	}
+	msg.Ack()
+}
+
+// deadLetter moves a message whose handler kept failing to the dead-letter topic
+func (c *Consumer) deadLetter(ctx context.Context, message *queue.Message, reason error) error {
+	failed := message.Clone()
+	if failed.Headers == nil {
+		failed.Headers = make(map[string]string)
+	}
+	failed.Headers[broker.HeaderDeadLetterReason] = reason.Error()
+
+	topic := broker.DeadLetterTopic(message.Topic)
+	failed.Topic = topic
+	return c.queue.Enqueue(context.WithoutCancel(ctx), topic, failed)
+}
+
+// call runs the handler and turns a panic into an error
+func call(ctx context.Context, handler queue.MessageHandler, message *queue.Message) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("handler panicked: %v", r)
+		}
+	}()
+	return handler(ctx, message)
+}
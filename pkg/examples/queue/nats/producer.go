@@ -0,0 +1,84 @@
+package nats
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	natsgo "github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/syl/Go/pkg/examples/queue"
+)
+
+// DefaultPublishRetries is how many times a publish without acknowledgement from JetStream is retried
+const DefaultPublishRetries = 3
+
+// ensure that Producer implements the Producer interface
+var _ queue.Producer = (*Producer)(nil)
+
+// Producer implements the Producer interface by publishing to the stream of a Queue
+// Messages carry their ID as Nats-Msg-Id, so JetStream stores a message retried after a lost acknowledgement once.
+type Producer struct {
+	queue   *Queue
+	retries int
+	closed  atomic.Bool
+}
+
+// ProducerOption configures a Producer created with NewProducer
+type ProducerOption func(*Producer)
+
+// WithPublishRetries changes how many times a publish that timed out or found no server is retried
+func WithPublishRetries(n int) ProducerOption {
+	return func(p *Producer) {
+		p.retries = n
+	}
+}
+
+// NewProducer creates a producer publishing to the topics of q
+func NewProducer(q *Queue, opts ...ProducerOption) *Producer {
+	p := &Producer{queue: q, retries: DefaultPublishRetries}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
+}
+
+// Publish sends a message to the specified topic and waits until JetStream stored it
+func (p *Producer) Publish(ctx context.Context, topic string, payload []byte, headers map[string]string) error {
+	if p.closed.Load() {
+		return errors.New("producer is closed")
+	}
+
+	message := &queue.Message{
+		ID:        uuid.New().String(),
+		Topic:     topic,
+		Payload:   payload,
+		Headers:   headers,
+		Timestamp: time.Now(),
+	}
+
+	var err error
+	for attempt := 0; attempt <= p.retries; attempt++ {
+		err = p.queue.publish(ctx, topic, message, jetstream.WithMsgID(message.ID))
+		if err == nil || ctx.Err() != nil || !retryable(err) {
+			return err
+		}
+	}
+	return err
+}
+
+// Close closes the producer, the queue stays open
+func (p *Producer) Close() error {
+	p.closed.Store(true)
+	return nil
+}
+
+// retryable reports whether the publish may have failed before JetStream acknowledged it
+func retryable(err error) bool {
+	return errors.Is(err, natsgo.ErrTimeout) ||
+		errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, natsgo.ErrNoResponders) ||
+		errors.Is(err, jetstream.ErrNoStreamResponse)
+}
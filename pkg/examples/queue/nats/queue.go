@@ -0,0 +1,334 @@
+// Package nats implements queue.Queue, queue.Producer and queue.Consumer on top of NATS JetStream.
+//
+// Every topic is a subject of one work-queue stream: messages are persisted by JetStream and removed once acknowledged.
+// Each topic has a durable consumer shared by Dequeue and every Consumer subscribed to it, in this process or another,
+// so they compete for the messages like they do on an in-memory queue.
+//
+//	s, _ := nats.StartServer(dir)
+//	nc, _ := nats.Connect(s)
+//	q, _ := nats.NewQueue(ctx, nc)
+//	producer := nats.NewProducer(q)
+//	consumer := nats.NewConsumer(q, nats.WithMaxDeliver(3))
+package nats
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	natsgo "github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/syl/Go/pkg/examples/queue"
+)
+
+const (
+	// DefaultStream is the name of the stream holding the topics
+	DefaultStream = "QUEUE"
+	// DefaultAckWait is how long a delivered message stays invisible before JetStream delivers it again
+	DefaultAckWait = 30 * time.Second
+
+	// HeaderMessageID and HeaderTimestamp carry the ID and timestamp of a queue.Message in the NATS headers,
+	// the other headers of the message are sent as they are
+	HeaderMessageID = "Queue-Message-Id"
+	HeaderTimestamp = "Queue-Timestamp"
+)
+
+// ensure that Queue implements the Queue interface and health checks
+var (
+	_ queue.Queue         = (*Queue)(nil)
+	_ queue.HealthChecker = (*Queue)(nil)
+)
+
+// Queue implements the Queue interface with a JetStream work-queue stream
+type Queue struct {
+	conn    *natsgo.Conn
+	js      jetstream.JetStream
+	stream  jetstream.Stream
+	name    string
+	storage jetstream.StorageType
+	ackWait time.Duration
+
+	mu        sync.Mutex
+	consumers map[string]jetstream.Consumer
+	closed    atomic.Bool
+
+	// infoMu serializes the stream info requests, the stream caches the last info without locking
+	infoMu sync.Mutex
+}
+
+// Option configures a Queue created with NewQueue
+type Option func(*Queue)
+
+// WithStream stores the topics in the named stream instead of DefaultStream, as subjects "<name>.<topic>"
+func WithStream(name string) Option {
+	return func(q *Queue) {
+		q.name = name
+	}
+}
+
+// WithMemoryStorage keeps the messages in memory instead of files, they are lost when the server stops
+func WithMemoryStorage() Option {
+	return func(q *Queue) {
+		q.storage = jetstream.MemoryStorage
+	}
+}
+
+// WithAckWait changes how long a message delivered to a Consumer waits for its acknowledgement before it is redelivered
+// The durable consumers are shared, every queue on the stream should use the same ack wait.
+func WithAckWait(d time.Duration) Option {
+	return func(q *Queue) {
+		q.ackWait = d
+	}
+}
+
+// NewQueue creates the stream of the queue unless it exists and returns a queue using it
+// The connection is not closed with the queue.
+func NewQueue(ctx context.Context, nc *natsgo.Conn, opts ...Option) (*Queue, error) {
+	q := &Queue{
+		conn:      nc,
+		name:      DefaultStream,
+		storage:   jetstream.FileStorage,
+		ackWait:   DefaultAckWait,
+		consumers: make(map[string]jetstream.Consumer),
+	}
+	for _, opt := range opts {
+		opt(q)
+	}
+
+	js, err := jetstream.New(nc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open jetstream: %w", err)
+	}
+	q.js = js
+
+	q.stream, err = js.CreateOrUpdateStream(ctx, jetstream.StreamConfig{
+		Name:      q.name,
+		Subjects:  []string{q.name + ".>"},
+		Retention: jetstream.WorkQueuePolicy,
+		Storage:   q.storage,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create stream %s: %w", q.name, err)
+	}
+	return q, nil
+}
+
+// Enqueue adds a message to the specified topic and waits until JetStream stored it
+func (q *Queue) Enqueue(ctx context.Context, topic string, message *queue.Message) error {
+	return q.publish(ctx, topic, message)
+}
+
+// publish stores a message, the options are passed to JetStream
+func (q *Queue) publish(ctx context.Context, topic string, message *queue.Message, opts ...jetstream.PublishOpt) error {
+	if err := q.check(ctx); err != nil {
+		return err
+	}
+	subject, err := q.subject(topic)
+	if err != nil {
+		return err
+	}
+
+	if _, err := q.js.PublishMsg(ctx, encode(subject, message), opts...); err != nil {
+		return fmt.Errorf("failed to publish to %s: %w", topic, err)
+	}
+	return nil
+}
+
+// Dequeue retrieves and acknowledges the next message of the topic
+// Returns nil without waiting if no message is available.
+func (q *Queue) Dequeue(ctx context.Context, topic string) (*queue.Message, error) {
+	if err := q.check(ctx); err != nil {
+		return nil, err
+	}
+	consumer, err := q.consumer(ctx, topic)
+	if err != nil {
+		return nil, err
+	}
+
+	batch, err := consumer.FetchNoWait(1)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch from %s: %w", topic, err)
+	}
+	for msg := range batch.Messages() {
+		message := decode(topic, msg)
+		if err := msg.DoubleAck(ctx); err != nil {
+			return nil, fmt.Errorf("failed to acknowledge %s: %w", message.ID, err)
+		}
+		return message, nil
+	}
+	if err := batch.Error(); err != nil {
+		return nil, fmt.Errorf("failed to fetch from %s: %w", topic, err)
+	}
+	return nil, nil
+}
+
+// Size returns the number of messages stored in the specified topic,
+// including the messages delivered to a Consumer and not acknowledged yet
+func (q *Queue) Size(ctx context.Context, topic string) (int, error) {
+	if err := q.check(ctx); err != nil {
+		return 0, err
+	}
+	subject, err := q.subject(topic)
+	if err != nil {
+		return 0, err
+	}
+
+	subjects, err := q.subjects(ctx, subject)
+	if err != nil {
+		return 0, err
+	}
+	return int(subjects[subject]), nil
+}
+
+// Topics returns the topics holding messages
+func (q *Queue) Topics(ctx context.Context) ([]string, error) {
+	if err := q.check(ctx); err != nil {
+		return nil, err
+	}
+
+	subjects, err := q.subjects(ctx, q.name+".>")
+	if err != nil {
+		return nil, err
+	}
+
+	topics := make([]string, 0, len(subjects))
+	for subject := range subjects {
+		topics = append(topics, strings.TrimPrefix(subject, q.name+"."))
+	}
+	sort.Strings(topics)
+	return topics, nil
+}
+
+// CheckHealth reports an error when the queue is closed or the connection to the server is not established
+func (q *Queue) CheckHealth(ctx context.Context) error {
+	if q.closed.Load() {
+		return errors.New("queue is closed")
+	}
+	if status := q.conn.Status(); status != natsgo.CONNECTED {
+		return fmt.Errorf("nats connection is %s", status)
+	}
+	return nil
+}
+
+// Close closes the queue, the messages stay in the stream
+func (q *Queue) Close() error {
+	q.closed.Store(true)
+	return nil
+}
+
+// check returns the error of operations on a closed queue or with a done context
+func (q *Queue) check(ctx context.Context) error {
+	if q.closed.Load() {
+		return errors.New("queue is closed")
+	}
+	return ctx.Err()
+}
+
+// subjects returns the number of messages of the stream subjects matching filter
+func (q *Queue) subjects(ctx context.Context, filter string) (map[string]uint64, error) {
+	q.infoMu.Lock()
+	defer q.infoMu.Unlock()
+
+	info, err := q.stream.Info(ctx, jetstream.WithSubjectFilter(filter))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get stream info: %w", err)
+	}
+	return info.State.Subjects, nil
+}
+
+// subject returns the subject of a topic, topics are dot-separated tokens without wildcards or whitespace
+func (q *Queue) subject(topic string) (string, error) {
+	if topic == "" || strings.ContainsAny(topic, "*> \t\r\n") {
+		return "", fmt.Errorf("invalid topic %q", topic)
+	}
+	for _, token := range strings.Split(topic, ".") {
+		if token == "" {
+			return "", fmt.Errorf("invalid topic %q", topic)
+		}
+	}
+	return q.name + "." + topic, nil
+}
+
+// consumer returns the durable consumer of the topic, creating it on first use
+func (q *Queue) consumer(ctx context.Context, topic string) (jetstream.Consumer, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if consumer, ok := q.consumers[topic]; ok {
+		return consumer, nil
+	}
+
+	subject, err := q.subject(topic)
+	if err != nil {
+		return nil, err
+	}
+
+	// Durable names cannot hold dots, the hex form keeps distinct topics distinct
+	consumer, err := q.stream.CreateOrUpdateConsumer(ctx, jetstream.ConsumerConfig{
+		Durable:       "topic_" + hex.EncodeToString([]byte(topic)),
+		FilterSubject: subject,
+		AckPolicy:     jetstream.AckExplicitPolicy,
+		AckWait:       q.ackWait,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create consumer of %s: %w", topic, err)
+	}
+
+	q.consumers[topic] = consumer
+	return consumer, nil
+}
+
+// encode converts a message to a NATS message with its headers
+func encode(subject string, message *queue.Message) *natsgo.Msg {
+	msg := natsgo.NewMsg(subject)
+	msg.Data = message.Payload
+	for key, value := range message.Headers {
+		msg.Header[key] = []string{value}
+	}
+	msg.Header[HeaderMessageID] = []string{message.ID}
+	if !message.Timestamp.IsZero() {
+		msg.Header[HeaderTimestamp] = []string{message.Timestamp.Format(time.RFC3339Nano)}
+	}
+	return msg
+}
+
+// decode converts a JetStream message back to a queue message
+// Messages published without the queue headers get the stream sequence as ID and the time JetStream stored them.
+func decode(topic string, msg jetstream.Msg) *queue.Message {
+	message := &queue.Message{
+		Topic:   topic,
+		Payload: msg.Data(),
+	}
+
+	for key, values := range msg.Headers() {
+		switch {
+		case key == HeaderMessageID:
+			message.ID = values[0]
+		case key == HeaderTimestamp:
+			message.Timestamp, _ = time.Parse(time.RFC3339Nano, values[0])
+		case strings.HasPrefix(key, "Nats-"):
+			// JetStream headers such as Nats-Msg-Id are not part of the message
+		default:
+			if message.Headers == nil {
+				message.Headers = make(map[string]string)
+			}
+			message.Headers[key] = values[0]
+		}
+	}
+
+	if metadata, err := msg.Metadata(); err == nil {
+		if message.ID == "" {
+			message.ID = fmt.Sprintf("%s-%d", metadata.Stream, metadata.Sequence.Stream)
+		}
+		if message.Timestamp.IsZero() {
+			message.Timestamp = metadata.Timestamp
+		}
+	}
+	return message
+}
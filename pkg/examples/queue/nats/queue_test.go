@@ -0,0 +1,313 @@
+package nats
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/syl/Go/pkg/examples/queue"
+	"github.com/syl/Go/pkg/examples/queue/broker"
+	"github.com/syl/Go/pkg/examples/queue/queuetest"
+)
+
+// NATSTestFixture runs an embedded JetStream server storing its streams in a temporary directory
+type NATSTestFixture struct {
+	T      *testing.T
+	Ctx    context.Context
+	Dir    string
+	Server *server.Server
+}
+
+func NewNATSTestFixture(t *testing.T) *NATSTestFixture {
+	t.Helper()
+
+	f := &NATSTestFixture{T: t, Ctx: context.Background(), Dir: t.TempDir()}
+	f.Start()
+	return f
+}
+
+// Start starts the server, the streams of a previous server of the fixture are recovered
+func (f *NATSTestFixture) Start() {
+	f.T.Helper()
+
+	s, err := StartServer(f.Dir)
+	require.NoError(f.T, err, "Should start the server")
+	f.Server = s
+	f.T.Cleanup(s.Shutdown)
+}
+
+// Restart stops the server and starts a new one on the same directory
+func (f *NATSTestFixture) Restart() {
+	f.T.Helper()
+
+	f.Server.Shutdown()
+	f.Server.WaitForShutdown()
+	f.Start()
+}
+
+// NewQueue opens a connection of its own and a queue on it
+func (f *NATSTestFixture) NewQueue(opts ...Option) *Queue {
+	f.T.Helper()
+
+	nc, err := Connect(f.Server)
+	require.NoError(f.T, err, "Should connect")
+	q, err := NewQueue(f.Ctx, nc, opts...)
+	require.NoError(f.T, err, "Should create the queue")
+
+	f.T.Cleanup(func() {
+		q.Close()
+		nc.Close()
+	})
+	return q
+}
+
+// WaitForSize waits until the topic holds n messages
+func (f *NATSTestFixture) WaitForSize(q *Queue, topic string, n int) {
+	f.T.Helper()
+
+	require.Eventually(f.T, func() bool {
+		size, err := q.Size(f.Ctx, topic)
+		return err == nil && size == n
+	}, 5*time.Second, 10*time.Millisecond, "Topic %s should hold %d messages", topic, n)
+}
+
+func TestConformance(t *testing.T) {
+	queuetest.Run(t, func(t *testing.T) queue.Queue {
+		return NewNATSTestFixture(t).NewQueue()
+	})
+}
+
+func TestQueue(t *testing.T) {
+	t.Run("Persistence", func(t *testing.T) {
+		fixture := NewNATSTestFixture(t)
+		q := fixture.NewQueue()
+		require.NoError(t, q.Enqueue(fixture.Ctx, "orders", queuetest.NewMessage("o1", "orders")))
+		require.NoError(t, q.Enqueue(fixture.Ctx, "orders", queuetest.NewMessage("o2", "orders")))
+
+		fixture.Restart()
+		q = fixture.NewQueue()
+
+		message, err := q.Dequeue(fixture.Ctx, "orders")
+		require.NoError(t, err)
+		require.NotNil(t, message, "Messages should survive a restart")
+		assert.Equal(t, "o1", message.ID)
+		assert.Equal(t, map[string]string{"id": "o1"}, message.Headers)
+		fixture.WaitForSize(q, "orders", 1)
+	})
+
+	t.Run("SharedAcrossConnections", func(t *testing.T) {
+		fixture := NewNATSTestFixture(t)
+		first, second := fixture.NewQueue(), fixture.NewQueue()
+		require.NoError(t, first.Enqueue(fixture.Ctx, "orders", queuetest.NewMessage("o1", "orders")))
+
+		message, err := second.Dequeue(fixture.Ctx, "orders")
+		require.NoError(t, err)
+		require.NotNil(t, message, "Queues on the same stream should share topics")
+		assert.Equal(t, "o1", message.ID)
+
+		message, err = first.Dequeue(fixture.Ctx, "orders")
+		require.NoError(t, err)
+		assert.Nil(t, message, "A message should only be dequeued once")
+	})
+
+	t.Run("NestedTopics", func(t *testing.T) {
+		fixture := NewNATSTestFixture(t)
+		q := fixture.NewQueue()
+		require.NoError(t, q.Enqueue(fixture.Ctx, "orders", queuetest.NewMessage("o1", "orders")))
+		require.NoError(t, q.Enqueue(fixture.Ctx, "orders.dlq", queuetest.NewMessage("o2", "orders.dlq")))
+
+		assert.Equal(t, 1, mustSize(t, q, "orders"), "Should not count the messages of nested topics")
+		topics, err := q.Topics(fixture.Ctx)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"orders", "orders.dlq"}, topics)
+	})
+
+	t.Run("InvalidTopic", func(t *testing.T) {
+		fixture := NewNATSTestFixture(t)
+		q := fixture.NewQueue()
+
+		for _, topic := range []string{"", "orders.*", "orders.>", "new orders", "orders..eu"} {
+			assert.Error(t, q.Enqueue(fixture.Ctx, topic, queuetest.NewMessage("o1", topic)), "Should reject %q", topic)
+		}
+	})
+
+	t.Run("Health", func(t *testing.T) {
+		fixture := NewNATSTestFixture(t)
+		q := fixture.NewQueue()
+		assert.NoError(t, q.CheckHealth(fixture.Ctx))
+
+		q.conn.Close()
+		assert.Error(t, q.CheckHealth(fixture.Ctx), "Should report a closed connection")
+	})
+}
+
+func TestProducer(t *testing.T) {
+	fixture := NewNATSTestFixture(t)
+	q := fixture.NewQueue()
+	producer := NewProducer(q)
+
+	require.NoError(t, producer.Publish(fixture.Ctx, "orders", []byte("o1"), map[string]string{"region": "eu"}))
+
+	message, err := q.Dequeue(fixture.Ctx, "orders")
+	require.NoError(t, err)
+	require.NotNil(t, message)
+	assert.NotEmpty(t, message.ID)
+	assert.Equal(t, []byte("o1"), message.Payload)
+	assert.Equal(t, map[string]string{"region": "eu"}, message.Headers, "Should not expose the JetStream headers")
+	assert.WithinDuration(t, time.Now(), message.Timestamp, time.Minute)
+
+	require.NoError(t, producer.Close())
+	assert.Error(t, producer.Publish(fixture.Ctx, "orders", []byte("o2"), nil), "Publish should fail after close")
+}
+
+// recorder collects the messages handed to a handler
+type recorder struct {
+	mu       sync.Mutex
+	messages []*queue.Message
+}
+
+func (r *recorder) handle(ctx context.Context, message *queue.Message) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.messages = append(r.messages, message)
+	return nil
+}
+
+func (r *recorder) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return len(r.messages)
+}
+
+func TestConsumer(t *testing.T) {
+	t.Run("Subscribe", func(t *testing.T) {
+		fixture := NewNATSTestFixture(t)
+		q := fixture.NewQueue()
+		consumer := NewConsumer(q)
+		defer consumer.Close()
+		var received recorder
+
+		require.NoError(t, consumer.Subscribe(fixture.Ctx, "orders", received.handle))
+		assert.Error(t, consumer.Subscribe(fixture.Ctx, "orders", received.handle), "Should reject a second subscription")
+		require.NoError(t, NewProducer(q).Publish(fixture.Ctx, "orders", []byte("o1"), nil))
+
+		require.Eventually(t, func() bool { return received.count() == 1 }, 5*time.Second, 10*time.Millisecond)
+		assert.Equal(t, "orders", received.messages[0].Topic)
+		fixture.WaitForSize(q, "orders", 0)
+	})
+
+	t.Run("RetriesThenDeadLetters", func(t *testing.T) {
+		fixture := NewNATSTestFixture(t)
+		q := fixture.NewQueue()
+		consumer := NewConsumer(q, WithMaxDeliver(3), WithRetryDelay(10*time.Millisecond))
+		defer consumer.Close()
+
+		var mu sync.Mutex
+		attempts := 0
+		require.NoError(t, consumer.Subscribe(fixture.Ctx, "orders", func(ctx context.Context, message *queue.Message) error {
+			mu.Lock()
+			defer mu.Unlock()
+			attempts++
+			if attempts == 1 {
+				panic("boom")
+			}
+			return errors.New("payment service unavailable")
+		}))
+		require.NoError(t, q.Enqueue(fixture.Ctx, "orders", queuetest.NewMessage("o1", "orders")))
+
+		fixture.WaitForSize(q, broker.DeadLetterTopic("orders"), 1)
+		mu.Lock()
+		assert.Equal(t, 3, attempts, "Should hand the message to the handler WithMaxDeliver times")
+		mu.Unlock()
+		fixture.WaitForSize(q, "orders", 0)
+
+		failed, err := q.Dequeue(fixture.Ctx, broker.DeadLetterTopic("orders"))
+		require.NoError(t, err)
+		require.NotNil(t, failed)
+		assert.Equal(t, "o1", failed.ID)
+		assert.Equal(t, "payment service unavailable", failed.Headers[broker.HeaderDeadLetterReason])
+	})
+
+	t.Run("RedeliversAfterAckWait", func(t *testing.T) {
+		fixture := NewNATSTestFixture(t)
+		q := fixture.NewQueue(WithAckWait(200 * time.Millisecond))
+		slow, fast := NewConsumer(q), NewConsumer(fixture.NewQueue(WithAckWait(200*time.Millisecond)))
+		defer fast.Close()
+
+		started := make(chan struct{})
+		release := make(chan struct{})
+		require.NoError(t, slow.Subscribe(fixture.Ctx, "orders", func(ctx context.Context, message *queue.Message) error {
+			close(started)
+			<-release
+			return nil
+		}))
+		require.NoError(t, q.Enqueue(fixture.Ctx, "orders", queuetest.NewMessage("o1", "orders")))
+		<-started
+
+		var received recorder
+		require.NoError(t, fast.Subscribe(fixture.Ctx, "orders", received.handle))
+		require.Eventually(t, func() bool { return received.count() == 1 }, 5*time.Second, 10*time.Millisecond,
+			"A message not acknowledged within the ack wait should be delivered again")
+		assert.Equal(t, "o1", received.messages[0].ID)
+
+		close(release)
+		slow.Close()
+	})
+
+	t.Run("CompetingConsumers", func(t *testing.T) {
+		fixture := NewNATSTestFixture(t)
+		q := fixture.NewQueue()
+		var first, second recorder
+		for _, r := range []*recorder{&first, &second} {
+			consumer := NewConsumer(fixture.NewQueue())
+			defer consumer.Close()
+			require.NoError(t, consumer.Subscribe(fixture.Ctx, "orders", r.handle))
+		}
+
+		for i := 0; i < 20; i++ {
+			require.NoError(t, q.Enqueue(fixture.Ctx, "orders", queuetest.NewMessage(fmt.Sprintf("o%d", i), "orders")))
+		}
+
+		require.Eventually(t, func() bool { return first.count()+second.count() == 20 }, 5*time.Second, 10*time.Millisecond)
+		seen := make(map[string]bool)
+		for _, message := range append(first.messages, second.messages...) {
+			assert.False(t, seen[message.ID], "Message %s should be handled once", message.ID)
+			seen[message.ID] = true
+		}
+	})
+
+	t.Run("Unsubscribe", func(t *testing.T) {
+		fixture := NewNATSTestFixture(t)
+		q := fixture.NewQueue()
+		consumer := NewConsumer(q)
+		var received recorder
+
+		require.NoError(t, consumer.Subscribe(fixture.Ctx, "orders", received.handle))
+		require.NoError(t, consumer.Unsubscribe(fixture.Ctx, "orders"))
+		assert.Error(t, consumer.Unsubscribe(fixture.Ctx, "orders"), "Should fail when not subscribed")
+
+		require.NoError(t, q.Enqueue(fixture.Ctx, "orders", queuetest.NewMessage("o1", "orders")))
+		time.Sleep(100 * time.Millisecond)
+		assert.Zero(t, received.count(), "Should not handle messages after unsubscribing")
+		assert.Equal(t, 1, mustSize(t, q, "orders"))
+
+		require.NoError(t, consumer.Close())
+		assert.Error(t, consumer.Subscribe(fixture.Ctx, "orders", received.handle), "Subscribe should fail after close")
+	})
+}
+
+func mustSize(t *testing.T, q *Queue, topic string) int {
+	t.Helper()
+
+	size, err := q.Size(context.Background(), topic)
+	require.NoError(t, err)
+	return size
+}
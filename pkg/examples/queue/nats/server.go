@@ -0,0 +1,57 @@
+package nats
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	natsgo "github.com/nats-io/nats.go"
+)
+
+// startTimeout bounds how long StartServer waits for the server to accept connections
+const startTimeout = 10 * time.Second
+
+// ServerOption configures an embedded server started with StartServer
+type ServerOption func(*server.Options)
+
+// WithListen also accepts TCP clients on host:port, a port of -1 picks a free port (see server.ClientURL)
+func WithListen(host string, port int) ServerOption {
+	return func(o *server.Options) {
+		o.Host = host
+		o.Port = port
+		o.DontListen = false
+	}
+}
+
+// StartServer starts an embedded NATS server with JetStream persisting its streams in storeDir
+// By default the server only accepts in-process connections opened with Connect.
+func StartServer(storeDir string, opts ...ServerOption) (*server.Server, error) {
+	options := &server.Options{
+		ServerName: "queue",
+		JetStream:  true,
+		StoreDir:   storeDir,
+		DontListen: true,
+		NoSigs:     true,
+		NoLog:      true,
+	}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	s, err := server.NewServer(options)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create nats server: %w", err)
+	}
+
+	s.Start()
+	if !s.ReadyForConnections(startTimeout) {
+		s.Shutdown()
+		return nil, fmt.Errorf("nats server not ready after %s", startTimeout)
+	}
+	return s, nil
+}
+
+// Connect opens an in-process connection to an embedded server
+func Connect(s *server.Server, opts ...natsgo.Option) (*natsgo.Conn, error) {
+	return natsgo.Connect(s.ClientURL(), append(opts, natsgo.InProcessServer(s))...)
+}
@@ -3,9 +3,13 @@ package queue
 
 import (
 	"context"
+	"errors"
 	"time"
 )
 
+// ErrTopicNotFound is returned by operations that require an existing topic
+var ErrTopicNotFound = errors.New("topic not found")
+
 // Message represents a message in the queue
 type Message struct {
 	ID        string            `json:"id"`
@@ -15,6 +19,18 @@ type Message struct {
 	Timestamp time.Time         `json:"timestamp"`
 }
 
+// Clone returns a copy of the message that can be modified without affecting the original
+func (m *Message) Clone() *Message {
+	clone := *m
+	if m.Headers != nil {
+		clone.Headers = make(map[string]string, len(m.Headers))
+		for k, v := range m.Headers {
+			clone.Headers[k] = v
+		}
+	}
+	return &clone
+}
+
 // Queue interface defines the basic queue operations
 type Queue interface {
 	// Enqueue adds a message to the specified topic
@@ -34,6 +50,77 @@ type Queue interface {
 	Close() error
 }
 
+// Inspector interface defines operations to look into and clean up topics without consuming them
+type Inspector interface {
+	// Peek returns up to n messages from the head of the topic without removing them
+	Peek(ctx context.Context, topic string, n int) ([]*Message, error)
+
+	// Browse returns a page of up to limit messages starting at offset without removing them
+	Browse(ctx context.Context, topic string, offset, limit int) (*Page, error)
+
+	// Purge removes all messages from the topic and returns how many were removed
+	Purge(ctx context.Context, topic string) (int, error)
+
+	// DeleteTopic removes the topic and all its messages
+	DeleteTopic(ctx context.Context, topic string) error
+}
+
+// Selector is implemented by queues that can take a message out of the middle of a topic
+type Selector interface {
+	// DequeueMatching removes and returns the first message of the topic accepted by match,
+	// the messages before it stay in place. Returns nil if no message is accepted.
+	// match is called with the topic locked and must not use the queue.
+	DequeueMatching(ctx context.Context, topic string, match func(*Message) bool) (*Message, error)
+}
+
+// AgeReporter is implemented by queues that can tell how long the pending messages have been waiting
+type AgeReporter interface {
+	// OldestMessageAge returns how long the message at the head of the topic has been waiting, zero for an empty topic
+	OldestMessageAge(ctx context.Context, topic string) (time.Duration, error)
+}
+
+// MessageAge returns how long ago the message was created according to clock, zero when it has no timestamp
+func MessageAge(clock Clock, message *Message) time.Duration {
+	if message == nil || message.Timestamp.IsZero() {
+		return 0
+	}
+	return max(clock.Since(message.Timestamp), 0)
+}
+
+// Page is a window of messages returned by Inspector.Browse
+type Page struct {
+	Messages   []*Message `json:"messages"`
+	Offset     int        `json:"offset"`
+	NextOffset int        `json:"next_offset"`
+	HasMore    bool       `json:"has_more"`
+	Total      int        `json:"total"`
+}
+
+// NewPage slices a page out of the messages of a topic
+func NewPage(messages []*Message, offset, limit int) (*Page, error) {
+	if offset < 0 {
+		return nil, errors.New("offset must not be negative")
+	}
+	if limit <= 0 {
+		return nil, errors.New("limit must be positive")
+	}
+
+	start := min(offset, len(messages))
+	end := min(offset+limit, len(messages))
+
+	page := &Page{
+		Messages:   make([]*Message, 0, end-start),
+		Offset:     offset,
+		NextOffset: end,
+		HasMore:    end < len(messages),
+		Total:      len(messages),
+	}
+	for _, message := range messages[start:end] {
+		page.Messages = append(page.Messages, message.Clone())
+	}
+	return page, nil
+}
+
 // Producer interface defines message publishing operations
 type Producer interface {
 	// Publish sends a message to the specified topic
@@ -59,3 +146,35 @@ type Consumer interface {
 	Close() error
 }
 
+
+// ShutdownReport describes the work a component could not finish before the shutdown deadline
+type ShutdownReport struct {
+	// Dropped is the number of pending messages per topic that were discarded
+	Dropped map[string]int
+	// Persisted is the number of pending messages per topic that were saved for the next start
+	Persisted map[string]int
+	// Interrupted is the number of handlers or publishes still running when the deadline hit
+	Interrupted int
+}
+
+// DroppedTotal returns the number of discarded messages across all topics
+func (r ShutdownReport) DroppedTotal() int {
+	total := 0
+	for _, n := range r.Dropped {
+		total += n
+	}
+	return total
+}
+
+// Shutdowner is implemented by queues, producers and consumers that can stop gracefully
+type Shutdowner interface {
+	// Shutdown stops accepting new work and waits for the work in progress until ctx is done
+	// The report lists what was left unfinished, the error is the context error when the deadline hit.
+	Shutdown(ctx context.Context) (ShutdownReport, error)
+}
+
+// HealthChecker is implemented by queues, consumers and other components that can report whether they work
+type HealthChecker interface {
+	// CheckHealth returns nil when the component is healthy and an error describing the problem otherwise
+	CheckHealth(ctx context.Context) error
+}
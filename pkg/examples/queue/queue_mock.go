@@ -3,13 +3,23 @@ package queue
 import (
 	"context"
 	"errors"
+	"fmt"
 	"sync"
+	"time"
+)
+
+// ensure that Mock supports the inspection operations, message selection, message age and health checks
+var (
+	_ Inspector     = (*Mock)(nil)
+	_ Selector      = (*Mock)(nil)
+	_ AgeReporter   = (*Mock)(nil)
+	_ HealthChecker = (*Mock)(nil)
 )
 
 // Mock is a simple in-memory queue implementation for testing
 // It implements the Queue interface and can be used by any package for testing
 type Mock struct {
-	topics map[string]chan *Message
+	topics map[string][]*Message
 	closed bool
 	mutex  sync.RWMutex
 }
@@ -17,7 +27,7 @@ type Mock struct {
 // NewMock creates a new mock queue for testing
 func NewMock() *Mock {
 	return &Mock{
-		topics: make(map[string]chan *Message),
+		topics: make(map[string][]*Message),
 		closed: false,
 	}
 }
@@ -31,41 +41,57 @@ func (q *Mock) Enqueue(ctx context.Context, topic string, message *Message) erro
 		return errors.New("queue is closed")
 	}
 
-	if _, exists := q.topics[topic]; !exists {
-		q.topics[topic] = make(chan *Message, 100)
+	if err := ctx.Err(); err != nil {
+		return err
 	}
 
-	select {
-	case q.topics[topic] <- message:
-		return nil
-	case <-ctx.Done():
-		return ctx.Err()
-	}
+	q.topics[topic] = append(q.topics[topic], message)
+	return nil
 }
 
 // Dequeue retrieves a message from the specified topic
 func (q *Mock) Dequeue(ctx context.Context, topic string) (*Message, error) {
-	q.mutex.RLock()
-	ch, exists := q.topics[topic]
-	closed := q.closed
-	q.mutex.RUnlock()
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
 
-	if closed {
+	if q.closed {
 		return nil, errors.New("queue is closed")
 	}
 
-	if !exists {
-		return nil, nil
+	if err := ctx.Err(); err != nil {
+		return nil, err
 	}
 
-	select {
-	case msg := <-ch:
-		return msg, nil
-	case <-ctx.Done():
-		return nil, ctx.Err()
-	default:
+	messages := q.topics[topic]
+	if len(messages) == 0 {
 		return nil, nil
 	}
+
+	q.topics[topic] = messages[1:]
+	return messages[0], nil
+}
+
+// DequeueMatching removes and returns the first message of the topic accepted by match
+func (q *Mock) DequeueMatching(ctx context.Context, topic string, match func(*Message) bool) (*Message, error) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if q.closed {
+		return nil, errors.New("queue is closed")
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	messages := q.topics[topic]
+	for i, message := range messages {
+		if match(message) {
+			q.topics[topic] = append(messages[:i:i], messages[i+1:]...)
+			return message, nil
+		}
+	}
+	return nil, nil
 }
 
 // Size returns the number of messages in the specified topic
@@ -77,10 +103,7 @@ func (q *Mock) Size(ctx context.Context, topic string) (int, error) {
 		return 0, errors.New("queue is closed")
 	}
 
-	if ch, exists := q.topics[topic]; exists {
-		return len(ch), nil
-	}
-	return 0, nil
+	return len(q.topics[topic]), nil
 }
 
 // Topics returns a list of all topics that have been created
@@ -99,7 +122,98 @@ func (q *Mock) Topics(ctx context.Context) ([]string, error) {
 	return topics, nil
 }
 
-// Close closes the mock queue and all its topic channels
+// OldestMessageAge returns how long the message at the head of the topic has been waiting
+func (q *Mock) OldestMessageAge(ctx context.Context, topic string) (time.Duration, error) {
+	q.mutex.RLock()
+	defer q.mutex.RUnlock()
+
+	if q.closed {
+		return 0, errors.New("queue is closed")
+	}
+
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	messages := q.topics[topic]
+	if len(messages) == 0 {
+		return 0, nil
+	}
+	return MessageAge(SystemClock, messages[0]), nil
+}
+
+// CheckHealth reports an error once the queue is closed
+func (q *Mock) CheckHealth(ctx context.Context) error {
+	q.mutex.RLock()
+	defer q.mutex.RUnlock()
+
+	if q.closed {
+		return errors.New("queue is closed")
+	}
+	return nil
+}
+
+// Peek returns up to n messages from the head of the topic without removing them
+func (q *Mock) Peek(ctx context.Context, topic string, n int) ([]*Message, error) {
+	if n <= 0 {
+		return nil, errors.New("peek count must be positive")
+	}
+
+	page, err := q.Browse(ctx, topic, 0, n)
+	if err != nil {
+		return nil, err
+	}
+	return page.Messages, nil
+}
+
+// Browse returns a page of messages starting at offset without removing them
+func (q *Mock) Browse(ctx context.Context, topic string, offset, limit int) (*Page, error) {
+	q.mutex.RLock()
+	defer q.mutex.RUnlock()
+
+	if q.closed {
+		return nil, errors.New("queue is closed")
+	}
+
+	return NewPage(q.topics[topic], offset, limit)
+}
+
+// Purge removes all messages from the topic and returns how many were removed
+func (q *Mock) Purge(ctx context.Context, topic string) (int, error) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if q.closed {
+		return 0, errors.New("queue is closed")
+	}
+
+	messages, exists := q.topics[topic]
+	if !exists {
+		return 0, nil
+	}
+
+	q.topics[topic] = nil
+	return len(messages), nil
+}
+
+// DeleteTopic removes the topic and all its messages
+func (q *Mock) DeleteTopic(ctx context.Context, topic string) error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if q.closed {
+		return errors.New("queue is closed")
+	}
+
+	if _, exists := q.topics[topic]; !exists {
+		return fmt.Errorf("%w: %s", ErrTopicNotFound, topic)
+	}
+
+	delete(q.topics, topic)
+	return nil
+}
+
+// Close closes the mock queue and drops all pending messages
 func (q *Mock) Close() error {
 	q.mutex.Lock()
 	defer q.mutex.Unlock()
@@ -109,8 +223,6 @@ func (q *Mock) Close() error {
 	}
 
 	q.closed = true
-	for _, ch := range q.topics {
-		close(ch)
-	}
+	q.topics = make(map[string][]*Message)
 	return nil
 }
@@ -0,0 +1,479 @@
+// Package queuetest provides a conformance suite that every queue.Queue implementation should pass.
+//
+// A backend runs the suite from its own tests with a factory creating an empty queue:
+//
+//	func TestConformance(t *testing.T) {
+//		queuetest.Run(t, func(t *testing.T) queue.Queue {
+//			return inmemory.NewInMemoryQueue()
+//		})
+//	}
+//
+// Queues implementing queue.Inspector, queue.Selector or queue.AgeReporter are also checked for those operations.
+package queuetest
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/syl/Go/pkg/examples/queue"
+)
+
+// Factory creates an empty queue for a single test, the suite closes it when the test ends
+type Factory func(t *testing.T) queue.Queue
+
+// Options tunes the suite for backends with different limits
+type Options struct {
+	// Producers and Consumers are the goroutines used by the concurrency tests
+	Producers int
+	Consumers int
+	// MessagesPerProducer is the number of messages each producer enqueues, it must fit in a topic
+	MessagesPerProducer int
+	// StressTopics is the number of topics shared by the goroutines of the stress test
+	StressTopics int
+	// Timeout bounds how long the concurrency tests wait for every message
+	Timeout time.Duration
+}
+
+// DefaultOptions fits backends with a topic capacity of at least 500 messages
+var DefaultOptions = Options{
+	Producers:           5,
+	Consumers:           5,
+	MessagesPerProducer: 100,
+	StressTopics:        4,
+	Timeout:             10 * time.Second,
+}
+
+// Run runs the conformance suite with DefaultOptions
+func Run(t *testing.T, factory Factory) {
+	RunWithOptions(t, factory, DefaultOptions)
+}
+
+// RunWithOptions runs the conformance suite against queues created by factory
+func RunWithOptions(t *testing.T, factory Factory, opts Options) {
+	s := &suite{factory: factory, opts: opts}
+
+	t.Run("EnqueueDequeue", s.testEnqueueDequeue)
+	t.Run("DequeueEmpty", s.testDequeueEmpty)
+	t.Run("FIFO", s.testFIFO)
+	t.Run("Size", s.testSize)
+	t.Run("Topics", s.testTopics)
+	t.Run("TopicIsolation", s.testTopicIsolation)
+	t.Run("Close", s.testClose)
+	t.Run("ContextCancellation", s.testContextCancellation)
+	t.Run("ConcurrentEnqueueDequeue", s.testConcurrent)
+	t.Run("Stress", s.testStress)
+	t.Run("Inspector", s.testInspector)
+	t.Run("Selector", s.testSelector)
+	t.Run("OldestMessageAge", s.testOldestMessageAge)
+}
+
+type suite struct {
+	factory Factory
+	opts    Options
+}
+
+// newQueue creates a queue closed at the end of the test
+func (s *suite) newQueue(t *testing.T) queue.Queue {
+	t.Helper()
+
+	q := s.factory(t)
+	t.Cleanup(func() { q.Close() })
+	return q
+}
+
+// NewMessage creates a test message with a header and the current time
+func NewMessage(id, topic string) *queue.Message {
+	return &queue.Message{
+		ID:        id,
+		Topic:     topic,
+		Payload:   []byte("payload " + id),
+		Headers:   map[string]string{"id": id},
+		Timestamp: time.Now(),
+	}
+}
+
+// enqueue adds messages with the given ids to the topic
+func enqueue(t *testing.T, q queue.Queue, topic string, ids ...string) {
+	t.Helper()
+
+	for _, id := range ids {
+		require.NoError(t, q.Enqueue(context.Background(), topic, NewMessage(id, topic)), "Should enqueue %s", id)
+	}
+}
+
+// drain dequeues every message of the topic and returns their ids
+func drain(t *testing.T, q queue.Queue, topic string) []string {
+	t.Helper()
+
+	var ids []string
+	for {
+		msg, err := q.Dequeue(context.Background(), topic)
+		require.NoError(t, err, "Should dequeue from %s", topic)
+		if msg == nil {
+			return ids
+		}
+		ids = append(ids, msg.ID)
+	}
+}
+
+func assertSize(t *testing.T, q queue.Queue, topic string, expected int, msgAndArgs ...interface{}) {
+	t.Helper()
+
+	size, err := q.Size(context.Background(), topic)
+	require.NoError(t, err, "Should get size of %s", topic)
+	assert.Equal(t, expected, size, msgAndArgs...)
+}
+
+func (s *suite) testEnqueueDequeue(t *testing.T) {
+	q := s.newQueue(t)
+	ctx := context.Background()
+	msg := NewMessage("msg-1", "orders")
+
+	require.NoError(t, q.Enqueue(ctx, "orders", msg), "Should enqueue message")
+	assertSize(t, q, "orders", 1, "Topic should hold one message")
+
+	dequeued, err := q.Dequeue(ctx, "orders")
+	require.NoError(t, err, "Should dequeue message")
+	require.NotNil(t, dequeued, "Message should not be nil")
+
+	assert.Equal(t, msg.ID, dequeued.ID, "Message ID should match")
+	assert.Equal(t, msg.Topic, dequeued.Topic, "Message topic should match")
+	assert.Equal(t, msg.Payload, dequeued.Payload, "Message payload should match")
+	assert.Equal(t, msg.Headers, dequeued.Headers, "Message headers should match")
+	assert.WithinDuration(t, msg.Timestamp, dequeued.Timestamp, time.Millisecond, "Message timestamp should match")
+	assertSize(t, q, "orders", 0, "Topic should be empty after dequeue")
+}
+
+func (s *suite) testDequeueEmpty(t *testing.T) {
+	q := s.newQueue(t)
+	ctx := context.Background()
+
+	msg, err := q.Dequeue(ctx, "never-used")
+	require.NoError(t, err, "Should not error for an unknown topic")
+	assert.Nil(t, msg, "Should return nil for an unknown topic")
+
+	enqueue(t, q, "orders", "o1")
+	drain(t, q, "orders")
+
+	msg, err = q.Dequeue(ctx, "orders")
+	require.NoError(t, err, "Should not error for an empty topic")
+	assert.Nil(t, msg, "Should return nil for an empty topic")
+}
+
+func (s *suite) testFIFO(t *testing.T) {
+	q := s.newQueue(t)
+
+	ids := make([]string, 20)
+	for i := range ids {
+		ids[i] = fmt.Sprintf("msg-%02d", i)
+	}
+	enqueue(t, q, "orders", ids...)
+
+	assert.Equal(t, ids, drain(t, q, "orders"), "Messages should be dequeued in enqueue order")
+}
+
+func (s *suite) testSize(t *testing.T) {
+	q := s.newQueue(t)
+
+	assertSize(t, q, "orders", 0, "Unknown topic should have size 0")
+
+	enqueue(t, q, "orders", "o1", "o2", "o3", "o4", "o5")
+	assertSize(t, q, "orders", 5, "Should count every message")
+
+	_, err := q.Dequeue(context.Background(), "orders")
+	require.NoError(t, err)
+	assertSize(t, q, "orders", 4, "Dequeue should decrease the size")
+}
+
+func (s *suite) testTopics(t *testing.T) {
+	q := s.newQueue(t)
+
+	topics, err := q.Topics(context.Background())
+	require.NoError(t, err, "Should list topics")
+	assert.Empty(t, topics, "Should have no topics initially")
+
+	enqueue(t, q, "topic1", "m1")
+	enqueue(t, q, "topic2", "m2")
+	enqueue(t, q, "topic3", "m3")
+
+	topics, err = q.Topics(context.Background())
+	require.NoError(t, err, "Should list topics")
+	sort.Strings(topics)
+	assert.Equal(t, []string{"topic1", "topic2", "topic3"}, topics, "Should list every topic with messages")
+}
+
+func (s *suite) testTopicIsolation(t *testing.T) {
+	q := s.newQueue(t)
+
+	enqueue(t, q, "orders", "o1", "o2")
+	enqueue(t, q, "payments", "p1")
+
+	assert.Equal(t, []string{"p1"}, drain(t, q, "payments"), "Should only return messages of the topic")
+	assertSize(t, q, "orders", 2, "Other topics should be untouched")
+	assert.Equal(t, []string{"o1", "o2"}, drain(t, q, "orders"))
+}
+
+func (s *suite) testClose(t *testing.T) {
+	q := s.factory(t)
+	ctx := context.Background()
+	enqueue(t, q, "orders", "o1")
+
+	require.NoError(t, q.Close(), "Should close")
+
+	assert.Error(t, q.Enqueue(ctx, "orders", NewMessage("o2", "orders")), "Enqueue should fail after close")
+	_, err := q.Dequeue(ctx, "orders")
+	assert.Error(t, err, "Dequeue should fail after close")
+	_, err = q.Size(ctx, "orders")
+	assert.Error(t, err, "Size should fail after close")
+	_, err = q.Topics(ctx)
+	assert.Error(t, err, "Topics should fail after close")
+
+	assert.NoError(t, q.Close(), "Closing twice should be safe")
+}
+
+func (s *suite) testContextCancellation(t *testing.T) {
+	q := s.newQueue(t)
+	enqueue(t, q, "orders", "o1")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := q.Enqueue(ctx, "orders", NewMessage("o2", "orders"))
+	assert.ErrorIs(t, err, context.Canceled, "Enqueue should return the context error")
+
+	_, err = q.Dequeue(ctx, "orders")
+	assert.ErrorIs(t, err, context.Canceled, "Dequeue should return the context error")
+
+	assertSize(t, q, "orders", 1, "Canceled operations should not change the topic")
+}
+
+func (s *suite) testConcurrent(t *testing.T) {
+	q := s.newQueue(t)
+	ctx := context.Background()
+	total := s.opts.Producers * s.opts.MessagesPerProducer
+
+	var producers sync.WaitGroup
+	for p := 0; p < s.opts.Producers; p++ {
+		producers.Add(1)
+		go func(p int) {
+			defer producers.Done()
+			for i := 0; i < s.opts.MessagesPerProducer; i++ {
+				assert.NoError(t, q.Enqueue(ctx, "orders", NewMessage(fmt.Sprintf("p%d-%d", p, i), "orders")))
+			}
+		}(p)
+	}
+
+	var mu sync.Mutex
+	received := make(map[string]int, total)
+	deadline := time.Now().Add(s.opts.Timeout)
+
+	var consumers sync.WaitGroup
+	for c := 0; c < s.opts.Consumers; c++ {
+		consumers.Add(1)
+		go func() {
+			defer consumers.Done()
+			for time.Now().Before(deadline) {
+				mu.Lock()
+				done := len(received) == total
+				mu.Unlock()
+				if done {
+					return
+				}
+
+				msg, err := q.Dequeue(ctx, "orders")
+				if !assert.NoError(t, err) {
+					return
+				}
+				if msg == nil {
+					time.Sleep(time.Millisecond)
+					continue
+				}
+
+				mu.Lock()
+				received[msg.ID]++
+				mu.Unlock()
+			}
+		}()
+	}
+
+	producers.Wait()
+	consumers.Wait()
+
+	assert.Len(t, received, total, "Every message should be dequeued")
+	for id, n := range received {
+		assert.Equal(t, 1, n, "Message %s should be dequeued exactly once", id)
+	}
+}
+
+func (s *suite) testStress(t *testing.T) {
+	q := s.newQueue(t)
+	ctx := context.Background()
+	workers := s.opts.Producers + s.opts.Consumers
+
+	var enqueued atomic.Int64
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < s.opts.MessagesPerProducer; i++ {
+				topic := fmt.Sprintf("stress-%d", (w+i)%s.opts.StressTopics)
+				switch i % 4 {
+				case 0, 1:
+					if assert.NoError(t, q.Enqueue(ctx, topic, NewMessage(fmt.Sprintf("w%d-%d", w, i), topic))) {
+						enqueued.Add(1)
+					}
+				case 2:
+					_, err := q.Dequeue(ctx, topic)
+					assert.NoError(t, err)
+				case 3:
+					_, err := q.Size(ctx, topic)
+					assert.NoError(t, err)
+					_, err = q.Topics(ctx)
+					assert.NoError(t, err)
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	remaining := 0
+	for topic := 0; topic < s.opts.StressTopics; topic++ {
+		remaining += len(drain(t, q, fmt.Sprintf("stress-%d", topic)))
+	}
+	assert.LessOrEqual(t, int64(remaining), enqueued.Load(), "Should never return more messages than enqueued")
+}
+
+func (s *suite) testInspector(t *testing.T) {
+	q := s.newQueue(t)
+	inspector, ok := q.(queue.Inspector)
+	if !ok {
+		t.Skip("queue does not implement queue.Inspector")
+	}
+	ctx := context.Background()
+	enqueue(t, q, "orders", "o0", "o1", "o2", "o3", "o4")
+
+	t.Run("Peek", func(t *testing.T) {
+		messages, err := inspector.Peek(ctx, "orders", 2)
+		require.NoError(t, err, "Should peek")
+		require.Len(t, messages, 2, "Should return the requested number of messages")
+		assert.Equal(t, "o0", messages[0].ID, "Should peek from the head")
+		assert.Equal(t, "o1", messages[1].ID, "Should keep FIFO order")
+
+		messages[0].Headers["id"] = "changed"
+		messages, err = inspector.Peek(ctx, "orders", 10)
+		require.NoError(t, err, "Should peek more than available")
+		assert.Len(t, messages, 5, "Should return every message")
+		assert.Equal(t, "o0", messages[0].Headers["id"], "Peeked messages should be copies")
+		assertSize(t, q, "orders", 5, "Peek should not remove messages")
+
+		_, err = inspector.Peek(ctx, "orders", 0)
+		assert.Error(t, err, "Should reject a non-positive count")
+	})
+
+	t.Run("Browse", func(t *testing.T) {
+		page, err := inspector.Browse(ctx, "orders", 0, 2)
+		require.NoError(t, err, "Should browse the first page")
+		require.Len(t, page.Messages, 2)
+		assert.Equal(t, "o0", page.Messages[0].ID)
+		assert.True(t, page.HasMore, "First page should have more")
+		assert.Equal(t, 5, page.Total, "Should report the total")
+
+		page, err = inspector.Browse(ctx, "orders", page.NextOffset, 4)
+		require.NoError(t, err, "Should browse the next page")
+		require.Len(t, page.Messages, 3, "Should return the remaining messages")
+		assert.Equal(t, "o2", page.Messages[0].ID)
+		assert.False(t, page.HasMore, "Last page should not have more")
+
+		page, err = inspector.Browse(ctx, "orders", 10, 2)
+		require.NoError(t, err, "Should browse past the end")
+		assert.Empty(t, page.Messages)
+
+		_, err = inspector.Browse(ctx, "orders", -1, 2)
+		assert.Error(t, err, "Should reject a negative offset")
+	})
+
+	t.Run("Purge", func(t *testing.T) {
+		removed, err := inspector.Purge(ctx, "orders")
+		require.NoError(t, err, "Should purge")
+		assert.Equal(t, 5, removed, "Should report removed messages")
+		assertSize(t, q, "orders", 0, "Topic should be empty after purge")
+
+		removed, err = inspector.Purge(ctx, "unknown")
+		require.NoError(t, err, "Should purge an unknown topic")
+		assert.Zero(t, removed)
+	})
+
+	t.Run("DeleteTopic", func(t *testing.T) {
+		require.NoError(t, inspector.DeleteTopic(ctx, "orders"), "Should delete the topic")
+
+		topics, err := q.Topics(ctx)
+		require.NoError(t, err)
+		assert.NotContains(t, topics, "orders", "Deleted topic should not be listed")
+
+		assert.ErrorIs(t, inspector.DeleteTopic(ctx, "orders"), queue.ErrTopicNotFound, "Should fail for an unknown topic")
+	})
+}
+
+func (s *suite) testSelector(t *testing.T) {
+	q := s.newQueue(t)
+	selector, ok := q.(queue.Selector)
+	if !ok {
+		t.Skip("queue does not implement queue.Selector")
+	}
+	ctx := context.Background()
+	enqueue(t, q, "orders", "o0", "o1", "o2", "o3")
+
+	isID := func(id string) func(*queue.Message) bool {
+		return func(message *queue.Message) bool { return message.ID == id }
+	}
+
+	message, err := selector.DequeueMatching(ctx, "orders", isID("o2"))
+	require.NoError(t, err, "Should dequeue a matching message")
+	require.NotNil(t, message)
+	assert.Equal(t, "o2", message.ID, "Should take the matching message")
+
+	message, err = selector.DequeueMatching(ctx, "orders", isID("missing"))
+	require.NoError(t, err)
+	assert.Nil(t, message, "Should return nothing when no message matches")
+
+	message, err = selector.DequeueMatching(ctx, "unknown", isID("o0"))
+	require.NoError(t, err)
+	assert.Nil(t, message, "Should return nothing for an unknown topic")
+
+	assert.Equal(t, []string{"o0", "o1", "o3"}, drain(t, q, "orders"), "Other messages should keep their order")
+}
+
+func (s *suite) testOldestMessageAge(t *testing.T) {
+	q := s.newQueue(t)
+	reporter, ok := q.(queue.AgeReporter)
+	if !ok {
+		t.Skip("queue does not implement queue.AgeReporter")
+	}
+	ctx := context.Background()
+
+	age, err := reporter.OldestMessageAge(ctx, "orders")
+	require.NoError(t, err, "Should report the age of an empty topic")
+	assert.Zero(t, age, "Empty topic should have no age")
+
+	old := NewMessage("old", "orders")
+	old.Timestamp = time.Now().Add(-time.Hour)
+	require.NoError(t, q.Enqueue(ctx, "orders", old))
+	enqueue(t, q, "orders", "new")
+
+	age, err = reporter.OldestMessageAge(ctx, "orders")
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, age, time.Hour, "Should report the age of the head message")
+
+	drain(t, q, "orders")
+	age, err = reporter.OldestMessageAge(ctx, "orders")
+	require.NoError(t, err)
+	assert.Zero(t, age, "Drained topic should have no age")
+}
@@ -0,0 +1,273 @@
+// Package remote implements queue.Queue on top of the HTTP+JSON API of a broker server,
+// so that producers and consumers of several processes share one queue:
+//
+//	q := remote.NewClient("http://broker:8080", remote.WithWait(time.Second))
+//	producer := broker.NewQueueProducer(q)
+//	consumer := broker.NewQueueConsumer(q)
+//
+// Receive and Ack give at-least-once delivery: a received message that is not acknowledged within
+// the visibility timeout of the server is delivered again. Dequeue receives and acknowledges the message,
+// so a dequeue interrupted before the acknowledgement leaves the message to be delivered again.
+package remote
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/syl/Go/pkg/examples/queue"
+)
+
+// DefaultTimeout bounds each request, on top of the long-poll wait of dequeues
+const DefaultTimeout = 30 * time.Second
+
+// ErrReceiptNotFound is returned by Ack when the receipt is unknown or its visibility timeout expired
+var ErrReceiptNotFound = errors.New("receipt not found")
+
+// ensure that Client implements the Queue interface and health checks
+var (
+	_ queue.Queue         = (*Client)(nil)
+	_ queue.HealthChecker = (*Client)(nil)
+)
+
+// DequeueResponse is the body returned by the server for a dequeued message
+type DequeueResponse struct {
+	Message *queue.Message `json:"message"`
+	// Receipt acknowledges the message, it is empty when the message was acknowledged on dequeue
+	Receipt string `json:"receipt,omitempty"`
+}
+
+// SizeResponse is the body returned by the server for the size of a topic
+type SizeResponse struct {
+	Topic string `json:"topic"`
+	Size  int    `json:"size"`
+}
+
+// PurgeResponse is the body returned by the server for a purged topic
+type PurgeResponse struct {
+	Topic  string `json:"topic"`
+	Purged int    `json:"purged"`
+}
+
+// TopicsResponse is the body returned by the server for the list of topics
+type TopicsResponse struct {
+	Topics []string `json:"topics"`
+}
+
+// Delivery is a received message waiting for its acknowledgement
+type Delivery struct {
+	Message *queue.Message
+	Receipt string
+}
+
+// Option configures a Client created with NewClient
+type Option func(*Client)
+
+// WithToken sends the token as a bearer token with every request
+func WithToken(token string) Option {
+	return func(c *Client) {
+		c.token = token
+	}
+}
+
+// WithHTTPClient replaces the HTTP client, its timeout must exceed the dequeue wait
+func WithHTTPClient(client *http.Client) Option {
+	return func(c *Client) {
+		c.client = client
+	}
+}
+
+// WithWait makes Dequeue long-poll the server up to wait for a message instead of returning nil at once
+func WithWait(wait time.Duration) Option {
+	return func(c *Client) {
+		c.wait = wait
+	}
+}
+
+// Client is a queue.Queue backed by a remote broker server
+// Closing the client does not close the queue of the server.
+type Client struct {
+	baseURL string
+	token   string
+	client  *http.Client
+	wait    time.Duration
+	closed  atomic.Bool
+}
+
+// NewClient creates a client for the broker server at baseURL
+func NewClient(baseURL string, opts ...Option) *Client {
+	c := &Client{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		client:  &http.Client{Timeout: DefaultTimeout},
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Enqueue adds a message to the topic on the server
+func (c *Client) Enqueue(ctx context.Context, topic string, message *queue.Message) error {
+	return c.do(ctx, http.MethodPost, topicPath(topic, "messages"), message, nil)
+}
+
+// Dequeue removes the next message of the topic from the server, it returns nil when the topic stays empty
+// The message is acknowledged once received, when that fails the server delivers it again after its visibility timeout.
+func (c *Client) Dequeue(ctx context.Context, topic string) (*queue.Message, error) {
+	delivery, err := c.Receive(ctx, topic, c.wait)
+	if err != nil || delivery == nil {
+		return nil, err
+	}
+	if err := c.Ack(ctx, delivery.Receipt); err != nil {
+		return nil, fmt.Errorf("failed to acknowledge message %s: %w", delivery.Message.ID, err)
+	}
+	return delivery.Message, nil
+}
+
+// Receive waits up to wait for the next message of the topic, it returns nil when the topic stays empty
+// The message must be acknowledged with Ack before the visibility timeout of the server expires.
+func (c *Client) Receive(ctx context.Context, topic string, wait time.Duration) (*Delivery, error) {
+	return c.receive(ctx, topic, wait)
+}
+
+// Ack acknowledges a received message so that it is not delivered again
+func (c *Client) Ack(ctx context.Context, receipt string) error {
+	err := c.do(ctx, http.MethodPost, "/ack/"+url.PathEscape(receipt), nil, nil)
+	var statusErr *statusError
+	if errors.As(err, &statusErr) && statusErr.code == http.StatusNotFound {
+		return fmt.Errorf("%w: %s", ErrReceiptNotFound, receipt)
+	}
+	return err
+}
+
+// Size returns the number of pending messages of the topic, received messages waiting for an Ack are not counted
+func (c *Client) Size(ctx context.Context, topic string) (int, error) {
+	var resp SizeResponse
+	err := c.do(ctx, http.MethodGet, topicPath(topic, "size"), nil, &resp)
+	return resp.Size, err
+}
+
+// Purge removes the pending messages of the topic and returns how many were removed
+// Received messages waiting for an Ack are not removed.
+func (c *Client) Purge(ctx context.Context, topic string) (int, error) {
+	var resp PurgeResponse
+	err := c.do(ctx, http.MethodDelete, topicPath(topic, "messages"), nil, &resp)
+	return resp.Purged, err
+}
+
+// Topics returns the topics of the server
+func (c *Client) Topics(ctx context.Context) ([]string, error) {
+	var resp TopicsResponse
+	if err := c.do(ctx, http.MethodGet, "/topics", nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Topics, nil
+}
+
+// CheckHealth reports an error when the server is unreachable or its queue is unhealthy
+func (c *Client) CheckHealth(ctx context.Context) error {
+	return c.do(ctx, http.MethodGet, "/health", nil, nil)
+}
+
+// Close makes every further operation fail, the queue of the server is left untouched
+func (c *Client) Close() error {
+	c.closed.Store(true)
+	c.client.CloseIdleConnections()
+	return nil
+}
+
+func (c *Client) receive(ctx context.Context, topic string, wait time.Duration) (*Delivery, error) {
+	path := topicPath(topic, "dequeue")
+	if wait > 0 {
+		path += "?" + url.Values{"wait": {wait.String()}}.Encode()
+	}
+
+	var resp DequeueResponse
+	if err := c.do(ctx, http.MethodPost, path, nil, &resp); err != nil {
+		return nil, err
+	}
+	if resp.Message == nil {
+		return nil, nil
+	}
+	return &Delivery{Message: resp.Message, Receipt: resp.Receipt}, nil
+}
+
+func topicPath(topic, operation string) string {
+	return "/topics/" + url.PathEscape(topic) + "/" + operation
+}
+
+// statusError is an error answered by the server
+type statusError struct {
+	method, path string
+	code         int
+	message      string
+}
+
+func (e *statusError) Error() string {
+	if e.message == "" {
+		return fmt.Sprintf("%s %s: unexpected status %d", e.method, e.path, e.code)
+	}
+	return fmt.Sprintf("%s %s: %s (%d)", e.method, e.path, e.message, e.code)
+}
+
+// do sends in as JSON and decodes the JSON response into out, a response without content leaves out untouched
+func (c *Client) do(ctx context.Context, method, path string, in, out interface{}) error {
+	if c.closed.Load() {
+		return fmt.Errorf("queue is closed")
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, body)
+	if err != nil {
+		return err
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		var apiErr struct {
+			Message string `json:"message"`
+		}
+		_ = json.Unmarshal(data, &apiErr)
+		return &statusError{method: method, path: path, code: resp.StatusCode, message: apiErr.Message}
+	}
+
+	if out == nil || resp.StatusCode == http.StatusNoContent || len(data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(data, out)
+}
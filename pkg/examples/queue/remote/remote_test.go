@@ -0,0 +1,235 @@
+package remote
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/syl/Go/pkg/examples/queue"
+)
+
+// RemoteTestFixture runs a fake broker server holding the messages of one topic in memory
+type RemoteTestFixture struct {
+	T      *testing.T
+	Ctx    context.Context
+	Server *httptest.Server
+
+	mu       sync.Mutex
+	Messages []*queue.Message
+	Leases   map[string]*queue.Message
+	Requests []string
+	// FailAck answers the acknowledgements with an internal error
+	FailAck bool
+}
+
+func NewRemoteTestFixture(t *testing.T) *RemoteTestFixture {
+	f := &RemoteTestFixture{T: t, Ctx: context.Background(), Leases: make(map[string]*queue.Message)}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(f.Server.Close)
+	return f
+}
+
+// NewClient creates a client of the fake server
+func (f *RemoteTestFixture) NewClient(opts ...Option) *Client {
+	client := NewClient(f.Server.URL+"/", opts...)
+	f.T.Cleanup(func() { client.Close() })
+	return client
+}
+
+func (f *RemoteTestFixture) serve(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.Requests = append(f.Requests, r.Method+" "+r.URL.RequestURI()+" "+r.Header.Get("Authorization"))
+
+	w.Header().Set("Content-Type", "application/json")
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/topics/orders/messages":
+		var message queue.Message
+		if err := json.NewDecoder(r.Body).Decode(&message); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, `{"message":%q}`, err.Error())
+			return
+		}
+		f.Messages = append(f.Messages, &message)
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodPost && r.URL.Path == "/topics/orders/dequeue":
+		if len(f.Messages) == 0 {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		message := f.Messages[0]
+		f.Messages = f.Messages[1:]
+		resp := DequeueResponse{Message: message}
+		if r.URL.Query().Get("ack") != "true" {
+			resp.Receipt = "receipt-" + message.ID
+			f.Leases[resp.Receipt] = message
+		}
+		json.NewEncoder(w).Encode(resp)
+	case r.Method == http.MethodPost && strings.HasPrefix(r.URL.Path, "/ack/"):
+		receipt := strings.TrimPrefix(r.URL.Path, "/ack/")
+		if f.FailAck {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if _, exists := f.Leases[receipt]; !exists {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"message":"receipt not found"}`)
+			return
+		}
+		delete(f.Leases, receipt)
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodGet && r.URL.Path == "/topics/orders/size":
+		json.NewEncoder(w).Encode(SizeResponse{Topic: "orders", Size: len(f.Messages)})
+	case r.Method == http.MethodDelete && r.URL.Path == "/topics/orders/messages":
+		json.NewEncoder(w).Encode(PurgeResponse{Topic: "orders", Purged: len(f.Messages)})
+		f.Messages = nil
+	case r.Method == http.MethodGet && r.URL.Path == "/topics":
+		json.NewEncoder(w).Encode(TopicsResponse{Topics: []string{"orders"}})
+	case r.Method == http.MethodGet && r.URL.Path == "/health":
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprint(w, `{"message":"queue is closed"}`)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// Leased returns the ids of the received messages waiting for an acknowledgement
+func (f *RemoteTestFixture) Leased() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var ids []string
+	for _, message := range f.Leases {
+		ids = append(ids, message.ID)
+	}
+	return ids
+}
+
+func TestClient(t *testing.T) {
+	t.Run("EnqueueAndDequeue", func(t *testing.T) {
+		fixture := NewRemoteTestFixture(t)
+		client := fixture.NewClient(WithWait(time.Second))
+
+		require.NoError(t, client.Enqueue(fixture.Ctx, "orders", &queue.Message{ID: "o1", Payload: []byte("order")}))
+
+		message, err := client.Dequeue(fixture.Ctx, "orders")
+		require.NoError(t, err)
+		require.NotNil(t, message)
+		assert.Equal(t, "o1", message.ID)
+		assert.Equal(t, []byte("order"), message.Payload)
+		assert.Empty(t, fixture.Leased(), "Dequeue should acknowledge the received message")
+		assert.Equal(t, []string{
+			"POST /topics/orders/messages ",
+			"POST /topics/orders/dequeue?wait=1s ",
+			"POST /ack/receipt-o1 ",
+		}, fixture.Requests, "Dequeue should lease the message and acknowledge it")
+
+		message, err = client.Dequeue(fixture.Ctx, "orders")
+		require.NoError(t, err)
+		assert.Nil(t, message, "Should return nothing for an empty topic")
+	})
+
+	t.Run("DequeueAckFails", func(t *testing.T) {
+		fixture := NewRemoteTestFixture(t)
+		client := fixture.NewClient()
+		require.NoError(t, client.Enqueue(fixture.Ctx, "orders", &queue.Message{ID: "o1"}))
+		fixture.FailAck = true
+
+		message, err := client.Dequeue(fixture.Ctx, "orders")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "failed to acknowledge message o1")
+		assert.Nil(t, message)
+		assert.Equal(t, []string{"o1"}, fixture.Leased(), "The message should stay leased to be delivered again")
+	})
+
+	t.Run("ReceiveAndAck", func(t *testing.T) {
+		fixture := NewRemoteTestFixture(t)
+		client := fixture.NewClient()
+		require.NoError(t, client.Enqueue(fixture.Ctx, "orders", &queue.Message{ID: "o1"}))
+
+		delivery, err := client.Receive(fixture.Ctx, "orders", 0)
+		require.NoError(t, err)
+		require.NotNil(t, delivery)
+		assert.Equal(t, "o1", delivery.Message.ID)
+		assert.Equal(t, "receipt-o1", delivery.Receipt)
+
+		require.NoError(t, client.Ack(fixture.Ctx, delivery.Receipt))
+		assert.ErrorIs(t, client.Ack(fixture.Ctx, delivery.Receipt), ErrReceiptNotFound, "Should only ack once")
+	})
+
+	t.Run("SizeTopicsAndPurge", func(t *testing.T) {
+		fixture := NewRemoteTestFixture(t)
+		client := fixture.NewClient()
+		for _, id := range []string{"o1", "o2"} {
+			require.NoError(t, client.Enqueue(fixture.Ctx, "orders", &queue.Message{ID: id}))
+		}
+
+		size, err := client.Size(fixture.Ctx, "orders")
+		require.NoError(t, err)
+		assert.Equal(t, 2, size)
+
+		topics, err := client.Topics(fixture.Ctx)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"orders"}, topics)
+
+		purged, err := client.Purge(fixture.Ctx, "orders")
+		require.NoError(t, err)
+		assert.Equal(t, 2, purged)
+
+		size, err = client.Size(fixture.Ctx, "orders")
+		require.NoError(t, err)
+		assert.Zero(t, size)
+	})
+
+	t.Run("Token", func(t *testing.T) {
+		fixture := NewRemoteTestFixture(t)
+		client := fixture.NewClient(WithToken("secret"))
+
+		_, err := client.Size(fixture.Ctx, "orders")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"GET /topics/orders/size Bearer secret"}, fixture.Requests)
+	})
+
+	t.Run("StatusErrors", func(t *testing.T) {
+		fixture := NewRemoteTestFixture(t)
+		client := fixture.NewClient()
+
+		err := client.CheckHealth(fixture.Ctx)
+		require.Error(t, err)
+		assert.Equal(t, "GET /health: queue is closed (503)", err.Error())
+
+		_, err = client.Size(fixture.Ctx, "unknown")
+		require.Error(t, err)
+		assert.Equal(t, "GET /topics/unknown/size: unexpected status 404", err.Error())
+	})
+
+	t.Run("Closed", func(t *testing.T) {
+		fixture := NewRemoteTestFixture(t)
+		client := fixture.NewClient()
+		require.NoError(t, client.Close())
+
+		err := client.Enqueue(fixture.Ctx, "orders", &queue.Message{ID: "o1"})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "queue is closed")
+		assert.Empty(t, fixture.Requests, "A closed client should not reach the server")
+	})
+
+	t.Run("CancelledContext", func(t *testing.T) {
+		fixture := NewRemoteTestFixture(t)
+		client := fixture.NewClient()
+		ctx, cancel := context.WithCancel(fixture.Ctx)
+		cancel()
+
+		_, err := client.Dequeue(ctx, "orders")
+		assert.ErrorIs(t, err, context.Canceled)
+		assert.Empty(t, fixture.Requests)
+	})
+}
@@ -0,0 +1,155 @@
+// Package router republishes messages to different topics based on header filter rules
+package router
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/syl/Go/pkg/examples/queue"
+	"github.com/syl/Go/pkg/examples/queue/broker"
+	"github.com/syl/Go/pkg/examples/queue/filter"
+	"gopkg.in/yaml.v3"
+)
+
+// HeaderRoutedFrom is set on republished messages with the source topic
+const HeaderRoutedFrom = "routed-from"
+
+// Rule sends messages matching Filter to Topic
+type Rule struct {
+	Name   string `yaml:"name"`
+	Filter string `yaml:"filter"`
+	Topic  string `yaml:"topic"`
+}
+
+// Config describes the routing of a source topic
+//
+//	source: orders
+//	default_topic: orders.unrouted
+//	rules:
+//	  - name: high-priority
+//	    filter: "priority = 'high'"
+//	    topic: orders.priority
+type Config struct {
+	Source       string `yaml:"source"`
+	DefaultTopic string `yaml:"default_topic"`
+	Rules        []Rule `yaml:"rules"`
+}
+
+// LoadConfig reads a routing configuration from a YAML file
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read router config: %w", err)
+	}
+	return ParseConfig(data)
+}
+
+// ParseConfig parses a YAML routing configuration
+func ParseConfig(data []byte) (*Config, error) {
+	var config Config
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse router config: %w", err)
+	}
+	return &config, nil
+}
+
+type compiledRule struct {
+	Rule
+	filter *filter.Filter
+}
+
+// Router consumes a source topic and republishes each message to the topics of every matching rule.
+// Messages matching no rule go to the default topic, or stay on the source topic
+// for other subscribers when no default topic is configured, the matching messages behind them are
+// still routed (see broker.WithFilter).
+type Router struct {
+	config   Config
+	rules    []compiledRule
+	consumer *broker.QueueConsumer
+	producer queue.Producer
+}
+
+// New validates the configuration and creates a router
+func New(config *Config, consumer *broker.QueueConsumer, producer queue.Producer) (*Router, error) {
+	if config.Source == "" {
+		return nil, errors.New("router source topic is required")
+	}
+
+	rules := make([]compiledRule, 0, len(config.Rules))
+	for i, rule := range config.Rules {
+		if rule.Topic == "" {
+			return nil, fmt.Errorf("rule %d (%s) has no topic", i, rule.Name)
+		}
+		if rule.Topic == config.Source {
+			return nil, fmt.Errorf("rule %d (%s) routes back to the source topic %s", i, rule.Name, rule.Topic)
+		}
+		f, err := filter.Parse(rule.Filter)
+		if err != nil {
+			return nil, fmt.Errorf("rule %d (%s) has an invalid filter: %w", i, rule.Name, err)
+		}
+		rules = append(rules, compiledRule{Rule: rule, filter: f})
+	}
+
+	return &Router{
+		config:   *config,
+		rules:    rules,
+		consumer: consumer,
+		producer: producer,
+	}, nil
+}
+
+// Start subscribes to the source topic
+func (r *Router) Start(ctx context.Context) error {
+	var opts []broker.SubscriptionOption
+	if r.config.DefaultTopic == "" {
+		if len(r.rules) == 0 {
+			return errors.New("router has no rules and no default topic")
+		}
+		filters := make([]*filter.Filter, len(r.rules))
+		for i, rule := range r.rules {
+			filters[i] = rule.filter
+		}
+		opts = append(opts, broker.WithFilter(filter.Any(filters...).String()))
+	}
+
+	return r.consumer.SubscribeWithOptions(ctx, r.config.Source, r.handle, opts...)
+}
+
+// Stop unsubscribes from the source topic
+func (r *Router) Stop(ctx context.Context) error {
+	return r.consumer.Unsubscribe(ctx, r.config.Source)
+}
+
+// Route returns the destination topics of a message
+func (r *Router) Route(message *queue.Message) []string {
+	var topics []string
+	for _, rule := range r.rules {
+		if rule.filter.Match(message.Headers) {
+			topics = append(topics, rule.Topic)
+		}
+	}
+
+	if len(topics) == 0 && r.config.DefaultTopic != "" {
+		topics = append(topics, r.config.DefaultTopic)
+	}
+	return topics
+}
+
+// handle republishes a message to all its destinations
+func (r *Router) handle(ctx context.Context, message *queue.Message) error {
+	var errs []error
+	for _, topic := range r.Route(message) {
+		headers := make(map[string]string, len(message.Headers)+1)
+		for k, v := range message.Headers {
+			headers[k] = v
+		}
+		headers[HeaderRoutedFrom] = r.config.Source
+
+		if err := r.producer.Publish(ctx, topic, message.Payload, headers); err != nil {
+			errs = append(errs, fmt.Errorf("failed to route message %s to %s: %w", message.ID, topic, err))
+		}
+	}
+	return errors.Join(errs...)
+}
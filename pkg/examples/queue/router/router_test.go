@@ -0,0 +1,169 @@
+package router
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/syl/Go/pkg/examples/queue"
+	"github.com/syl/Go/pkg/examples/queue/broker"
+)
+
+const routingYAML = `
+source: orders
+default_topic: orders.unrouted
+rules:
+  - name: high-priority
+    filter: "priority = 'high'"
+    topic: orders.priority
+  - name: europe
+    filter: "region IN ('fr', 'de')"
+    topic: orders.eu
+`
+
+// RouterTestFixture wires a router over a mock queue
+type RouterTestFixture struct {
+	Queue    queue.Queue
+	Producer queue.Producer
+	Consumer *broker.QueueConsumer
+	Ctx      context.Context
+	T        *testing.T
+}
+
+func NewRouterTestFixture(t *testing.T) *RouterTestFixture {
+	t.Helper()
+
+	q := queue.NewMock()
+	producer := broker.NewQueueProducer(q)
+	consumer := broker.NewQueueConsumer(q)
+
+	t.Cleanup(func() {
+		consumer.Close()
+		producer.Close()
+		q.Close()
+	})
+
+	return &RouterTestFixture{
+		Queue:    q,
+		Producer: producer,
+		Consumer: consumer,
+		Ctx:      context.Background(),
+		T:        t,
+	}
+}
+
+// AssertEventuallyQueued waits until the topic holds the expected number of messages
+func (f *RouterTestFixture) AssertEventuallyQueued(topic string, expected int) {
+	f.T.Helper()
+
+	assert.Eventually(f.T, func() bool {
+		size, err := f.Queue.Size(f.Ctx, topic)
+		return err == nil && size == expected
+	}, 5*time.Second, 50*time.Millisecond, "Topic %s should hold %d messages", topic, expected)
+}
+
+// AssertQueueEmpty waits until the topic is drained
+func (f *RouterTestFixture) AssertQueueEmpty(topic string) {
+	f.T.Helper()
+	f.AssertEventuallyQueued(topic, 0)
+}
+
+func TestConfig(t *testing.T) {
+	t.Run("LoadConfig", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "routes.yaml")
+		require.NoError(t, os.WriteFile(path, []byte(routingYAML), 0o600))
+
+		config, err := LoadConfig(path)
+		require.NoError(t, err, "Should load config")
+		assert.Equal(t, "orders", config.Source)
+		assert.Equal(t, "orders.unrouted", config.DefaultTopic)
+		require.Len(t, config.Rules, 2)
+		assert.Equal(t, Rule{Name: "europe", Filter: "region IN ('fr', 'de')", Topic: "orders.eu"}, config.Rules[1])
+	})
+
+	t.Run("MissingFile", func(t *testing.T) {
+		_, err := LoadConfig(filepath.Join(t.TempDir(), "missing.yaml"))
+		assert.Error(t, err, "Should fail on missing file")
+	})
+
+	t.Run("Validation", func(t *testing.T) {
+		cases := map[string]*Config{
+			"MissingSource": {Rules: []Rule{{Filter: "a = b", Topic: "x"}}},
+			"MissingTopic":  {Source: "orders", Rules: []Rule{{Filter: "a = b"}}},
+			"LoopToSource":  {Source: "orders", Rules: []Rule{{Filter: "a = b", Topic: "orders"}}},
+			"InvalidFilter": {Source: "orders", Rules: []Rule{{Filter: "a =", Topic: "x"}}},
+		}
+
+		for name, config := range cases {
+			_, err := New(config, nil, nil)
+			assert.Error(t, err, "Should reject config %s", name)
+		}
+	})
+}
+
+func TestRouter(t *testing.T) {
+	t.Run("Route", func(t *testing.T) {
+		config, err := ParseConfig([]byte(routingYAML))
+		require.NoError(t, err)
+		r, err := New(config, nil, nil)
+		require.NoError(t, err)
+
+		assert.Equal(t, []string{"orders.priority"}, r.Route(&queue.Message{Headers: map[string]string{"priority": "high"}}))
+		assert.Equal(t, []string{"orders.priority", "orders.eu"}, r.Route(&queue.Message{Headers: map[string]string{"priority": "high", "region": "fr"}}))
+		assert.Equal(t, []string{"orders.unrouted"}, r.Route(&queue.Message{Headers: map[string]string{"region": "us"}}))
+	})
+
+	t.Run("RepublishesMessages", func(t *testing.T) {
+		fixture := NewRouterTestFixture(t)
+		config, err := ParseConfig([]byte(routingYAML))
+		require.NoError(t, err)
+		r, err := New(config, fixture.Consumer, fixture.Producer)
+		require.NoError(t, err)
+
+		require.NoError(t, r.Start(fixture.Ctx), "Should start router")
+
+		require.NoError(t, fixture.Producer.Publish(fixture.Ctx, "orders", []byte("urgent"), map[string]string{"priority": "high"}))
+		require.NoError(t, fixture.Producer.Publish(fixture.Ctx, "orders", []byte("us"), map[string]string{"region": "us"}))
+
+		fixture.AssertEventuallyQueued("orders.priority", 1)
+		fixture.AssertEventuallyQueued("orders.unrouted", 1)
+		fixture.AssertQueueEmpty("orders")
+
+		msg, err := fixture.Queue.Dequeue(fixture.Ctx, "orders.priority")
+		require.NoError(t, err)
+		require.NotNil(t, msg)
+		assert.Equal(t, []byte("urgent"), msg.Payload, "Payload should be kept")
+		assert.Equal(t, "high", msg.Headers["priority"], "Headers should be kept")
+		assert.Equal(t, "orders", msg.Headers[HeaderRoutedFrom], "Should record the source topic")
+
+		require.NoError(t, r.Stop(fixture.Ctx), "Should stop router")
+	})
+
+	t.Run("LeavesUnmatchedWithoutDefaultTopic", func(t *testing.T) {
+		fixture := NewRouterTestFixture(t)
+		r, err := New(&Config{
+			Source: "orders",
+			Rules:  []Rule{{Name: "high", Filter: "priority = 'high'", Topic: "orders.priority"}},
+		}, fixture.Consumer, fixture.Producer)
+		require.NoError(t, err)
+
+		require.NoError(t, fixture.Producer.Publish(fixture.Ctx, "orders", []byte("low"), map[string]string{"priority": "low"}))
+		require.NoError(t, fixture.Producer.Publish(fixture.Ctx, "orders", []byte("high"), map[string]string{"priority": "high"}))
+
+		require.NoError(t, r.Start(fixture.Ctx), "Should start router")
+
+		fixture.AssertEventuallyQueued("orders.priority", 1)
+		fixture.AssertEventuallyQueued("orders", 1)
+
+		msg, err := fixture.Queue.Dequeue(fixture.Ctx, "orders")
+		require.NoError(t, err)
+		require.NotNil(t, msg, "Unmatched message should stay on the source topic")
+		assert.Equal(t, []byte("low"), msg.Payload)
+
+		require.NoError(t, r.Stop(fixture.Ctx))
+	})
+}
@@ -0,0 +1,226 @@
+// Package rpc provides request/reply messaging on top of the queue interfaces
+package rpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/syl/Go/pkg/examples/queue"
+)
+
+const (
+	// HeaderReplyTo holds the topic the reply must be published to
+	HeaderReplyTo = "reply-to"
+	// HeaderCorrelationID links a reply to its request
+	HeaderCorrelationID = "correlation-id"
+	// HeaderError is set on replies when the responder handler failed
+	HeaderError = "error"
+
+	// DefaultTimeout is how long a request waits for its reply
+	DefaultTimeout = 5 * time.Second
+)
+
+// ErrTimeout is returned when no reply arrived before the request timeout
+var ErrTimeout = errors.New("request timed out waiting for reply")
+
+// ReplyError is returned by Request when the responder handler failed
+type ReplyError struct {
+	Message string
+}
+
+func (e *ReplyError) Error() string {
+	return "responder error: " + e.Message
+}
+
+// Requester publishes requests and waits for the matching replies
+type Requester struct {
+	producer   queue.Producer
+	consumer   queue.Consumer
+	replyTopic string
+	timeout    time.Duration
+
+	mu         sync.Mutex
+	pending    map[string]chan *queue.Message
+	subscribed bool
+	closed     bool
+}
+
+// RequesterOption configures a Requester
+type RequesterOption func(*Requester)
+
+// WithReplyTopic sets the topic replies are consumed from
+func WithReplyTopic(topic string) RequesterOption {
+	return func(r *Requester) {
+		r.replyTopic = topic
+	}
+}
+
+// WithTimeout sets how long a request waits for its reply
+func WithTimeout(timeout time.Duration) RequesterOption {
+	return func(r *Requester) {
+		r.timeout = timeout
+	}
+}
+
+// NewRequester creates a requester that listens for replies on its own topic
+func NewRequester(producer queue.Producer, consumer queue.Consumer, opts ...RequesterOption) *Requester {
+	r := &Requester{
+		producer:   producer,
+		consumer:   consumer,
+		replyTopic: "replies." + uuid.New().String(),
+		timeout:    DefaultTimeout,
+		pending:    make(map[string]chan *queue.Message),
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// ReplyTopic returns the topic replies are consumed from
+func (r *Requester) ReplyTopic() string {
+	return r.replyTopic
+}
+
+// Request publishes the payload to the topic and blocks until the reply arrives,
+// the timeout expires or the context is done
+func (r *Requester) Request(ctx context.Context, topic string, payload []byte, headers map[string]string) (*queue.Message, error) {
+	correlationID := uuid.New().String()
+	replyChan, err := r.register(ctx, correlationID)
+	if err != nil {
+		return nil, err
+	}
+	defer r.unregister(correlationID)
+
+	requestHeaders := make(map[string]string, len(headers)+2)
+	for k, v := range headers {
+		requestHeaders[k] = v
+	}
+	requestHeaders[HeaderReplyTo] = r.replyTopic
+	requestHeaders[HeaderCorrelationID] = correlationID
+
+	if err := r.producer.Publish(ctx, topic, payload, requestHeaders); err != nil {
+		return nil, fmt.Errorf("failed to publish request: %w", err)
+	}
+
+	timer := time.NewTimer(r.timeout)
+	defer timer.Stop()
+
+	select {
+	case reply := <-replyChan:
+		if reason, failed := reply.Headers[HeaderError]; failed {
+			return reply, &ReplyError{Message: reason}
+		}
+		return reply, nil
+	case <-timer.C:
+		return nil, ErrTimeout
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Close stops listening for replies
+func (r *Requester) Close() error {
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return nil
+	}
+	r.closed = true
+	subscribed := r.subscribed
+	r.mu.Unlock()
+
+	if !subscribed {
+		return nil
+	}
+	// Unsubscribe waits for the reply being handled, which needs the lock
+	return r.consumer.Unsubscribe(context.Background(), r.replyTopic)
+}
+
+// register subscribes to the reply topic on first use and tracks the pending request
+func (r *Requester) register(ctx context.Context, correlationID string) (chan *queue.Message, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		return nil, fmt.Errorf("requester is closed")
+	}
+
+	if !r.subscribed {
+		// Replies must outlive the context of the first request
+		if err := r.consumer.Subscribe(context.WithoutCancel(ctx), r.replyTopic, r.handleReply); err != nil {
+			return nil, fmt.Errorf("failed to subscribe to reply topic %s: %w", r.replyTopic, err)
+		}
+		r.subscribed = true
+	}
+
+	replyChan := make(chan *queue.Message, 1)
+	r.pending[correlationID] = replyChan
+	return replyChan, nil
+}
+
+func (r *Requester) unregister(correlationID string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.pending, correlationID)
+}
+
+// handleReply routes a reply to the request waiting for it
+// Replies for requests that already timed out are dropped
+func (r *Requester) handleReply(ctx context.Context, message *queue.Message) error {
+	r.mu.Lock()
+	replyChan, exists := r.pending[message.Headers[HeaderCorrelationID]]
+	r.mu.Unlock()
+
+	if exists {
+		select {
+		case replyChan <- message:
+		default:
+		}
+	}
+	return nil
+}
+
+// ReplyHandler handles a request and returns the reply payload
+type ReplyHandler func(ctx context.Context, message *queue.Message) ([]byte, error)
+
+// Responder turns a ReplyHandler into a queue.MessageHandler that publishes replies
+type Responder struct {
+	producer queue.Producer
+	handler  ReplyHandler
+}
+
+// NewResponder creates a responder publishing replies with the given producer
+func NewResponder(producer queue.Producer, handler ReplyHandler) *Responder {
+	return &Responder{
+		producer: producer,
+		handler:  handler,
+	}
+}
+
+// Handle implements queue.MessageHandler
+// Requests without a reply-to header are handled without sending a reply
+func (r *Responder) Handle(ctx context.Context, message *queue.Message) error {
+	payload, handlerErr := r.handler(ctx, message)
+
+	replyTo := message.Headers[HeaderReplyTo]
+	if replyTo == "" {
+		return handlerErr
+	}
+
+	headers := map[string]string{
+		HeaderCorrelationID: message.Headers[HeaderCorrelationID],
+	}
+	if handlerErr != nil {
+		headers[HeaderError] = handlerErr.Error()
+	}
+
+	if err := r.producer.Publish(ctx, replyTo, payload, headers); err != nil {
+		return fmt.Errorf("failed to publish reply to %s: %w", replyTo, err)
+	}
+	return handlerErr
+}
@@ -0,0 +1,196 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/syl/Go/pkg/examples/queue"
+	"github.com/syl/Go/pkg/examples/queue/broker"
+)
+
+// RPCTestFixture wires a requester and a responder over a shared queue
+type RPCTestFixture struct {
+	Queue     queue.Queue
+	Producer  queue.Producer
+	Consumer  queue.Consumer
+	Requester *Requester
+	Ctx       context.Context
+}
+
+func NewRPCTestFixture(t *testing.T, opts ...RequesterOption) *RPCTestFixture {
+	t.Helper()
+
+	q := queue.NewMock()
+	producer := broker.NewQueueProducer(q)
+	consumer := broker.NewQueueConsumer(q)
+	requester := NewRequester(producer, broker.NewQueueConsumer(q), opts...)
+
+	t.Cleanup(func() {
+		requester.Close()
+		consumer.Close()
+		producer.Close()
+		q.Close()
+	})
+
+	return &RPCTestFixture{
+		Queue:     q,
+		Producer:  producer,
+		Consumer:  consumer,
+		Requester: requester,
+		Ctx:       context.Background(),
+	}
+}
+
+// inFlightConsumer delivers a reply when unsubscribed and waits for its handler,
+// like a consumer whose subscription is handling a message while being stopped
+type inFlightConsumer struct {
+	queue.Consumer
+	handler queue.MessageHandler
+	reply   *queue.Message
+}
+
+func (c *inFlightConsumer) Subscribe(ctx context.Context, topic string, handler queue.MessageHandler) error {
+	c.handler = handler
+	return nil
+}
+
+func (c *inFlightConsumer) Unsubscribe(ctx context.Context, topic string) error {
+	done := make(chan error)
+	go func() { done <- c.handler(ctx, c.reply) }()
+	return <-done
+}
+
+func TestRequestReply(t *testing.T) {
+	t.Run("ReceivesReply", func(t *testing.T) {
+		fixture := NewRPCTestFixture(t)
+		responder := NewResponder(fixture.Producer, func(ctx context.Context, message *queue.Message) ([]byte, error) {
+			return []byte(strings.ToUpper(string(message.Payload))), nil
+		})
+		require.NoError(t, fixture.Consumer.Subscribe(fixture.Ctx, "upper", responder.Handle))
+
+		reply, err := fixture.Requester.Request(fixture.Ctx, "upper", []byte("hello"), map[string]string{"key": "value"})
+		require.NoError(t, err, "Should receive reply")
+		assert.Equal(t, []byte("HELLO"), reply.Payload, "Reply payload should match")
+		assert.NotEmpty(t, reply.Headers[HeaderCorrelationID], "Reply should carry the correlation id")
+	})
+
+	t.Run("RequestHeaders", func(t *testing.T) {
+		fixture := NewRPCTestFixture(t, WithReplyTopic("my-replies"))
+		requests := make(chan *queue.Message, 1)
+		responder := NewResponder(fixture.Producer, func(ctx context.Context, message *queue.Message) ([]byte, error) {
+			requests <- message
+			return nil, nil
+		})
+		require.NoError(t, fixture.Consumer.Subscribe(fixture.Ctx, "echo", responder.Handle))
+
+		_, err := fixture.Requester.Request(fixture.Ctx, "echo", []byte("ping"), map[string]string{"key": "value"})
+		require.NoError(t, err, "Should receive reply")
+
+		request := <-requests
+		assert.Equal(t, "my-replies", request.Headers[HeaderReplyTo], "Should set reply-to header")
+		assert.NotEmpty(t, request.Headers[HeaderCorrelationID], "Should set correlation-id header")
+		assert.Equal(t, "value", request.Headers["key"], "Should keep caller headers")
+	})
+
+	t.Run("ConcurrentRequests", func(t *testing.T) {
+		fixture := NewRPCTestFixture(t)
+		responder := NewResponder(fixture.Producer, func(ctx context.Context, message *queue.Message) ([]byte, error) {
+			return message.Payload, nil
+		})
+		require.NoError(t, fixture.Consumer.Subscribe(fixture.Ctx, "echo", responder.Handle))
+
+		payloads := []string{"a", "b", "c", "d"}
+		errs := make(chan error, len(payloads))
+		for _, payload := range payloads {
+			go func(payload string) {
+				reply, err := fixture.Requester.Request(fixture.Ctx, "echo", []byte(payload), nil)
+				if err == nil && string(reply.Payload) != payload {
+					err = errors.New("reply " + string(reply.Payload) + " does not match request " + payload)
+				}
+				errs <- err
+			}(payload)
+		}
+
+		for range payloads {
+			assert.NoError(t, <-errs, "Each request should get its own reply")
+		}
+	})
+
+	t.Run("ResponderError", func(t *testing.T) {
+		fixture := NewRPCTestFixture(t)
+		responder := NewResponder(fixture.Producer, func(ctx context.Context, message *queue.Message) ([]byte, error) {
+			return nil, errors.New("order not found")
+		})
+		require.NoError(t, fixture.Consumer.Subscribe(fixture.Ctx, "lookup", responder.Handle))
+
+		_, err := fixture.Requester.Request(fixture.Ctx, "lookup", []byte("42"), nil)
+		var replyErr *ReplyError
+		require.ErrorAs(t, err, &replyErr, "Should return a reply error")
+		assert.Equal(t, "order not found", replyErr.Message, "Should carry the responder error")
+	})
+
+	t.Run("Timeout", func(t *testing.T) {
+		fixture := NewRPCTestFixture(t, WithTimeout(300*time.Millisecond))
+
+		_, err := fixture.Requester.Request(fixture.Ctx, "nobody-listens", []byte("hello"), nil)
+		assert.ErrorIs(t, err, ErrTimeout, "Should time out without responder")
+	})
+
+	t.Run("ContextCancelled", func(t *testing.T) {
+		fixture := NewRPCTestFixture(t)
+		ctx, cancel := context.WithTimeout(fixture.Ctx, 100*time.Millisecond)
+		defer cancel()
+
+		_, err := fixture.Requester.Request(ctx, "nobody-listens", []byte("hello"), nil)
+		assert.ErrorIs(t, err, context.DeadlineExceeded, "Should stop waiting when the context is done")
+	})
+
+	t.Run("Closed", func(t *testing.T) {
+		fixture := NewRPCTestFixture(t)
+		require.NoError(t, fixture.Requester.Close())
+
+		_, err := fixture.Requester.Request(fixture.Ctx, "echo", []byte("hello"), nil)
+		assert.Error(t, err, "Should not send requests after close")
+	})
+
+	t.Run("CloseWhileHandlingReply", func(t *testing.T) {
+		q := queue.NewMock()
+		defer q.Close()
+		consumer := &inFlightConsumer{reply: &queue.Message{ID: "reply-1", Headers: map[string]string{HeaderCorrelationID: "late"}}}
+		requester := NewRequester(broker.NewQueueProducer(q), consumer, WithTimeout(time.Millisecond))
+
+		_, err := requester.Request(context.Background(), "nobody-listens", []byte("hello"), nil)
+		require.ErrorIs(t, err, ErrTimeout)
+
+		closed := make(chan error)
+		go func() { closed <- requester.Close() }()
+		select {
+		case err := <-closed:
+			assert.NoError(t, err)
+		case <-time.After(5 * time.Second):
+			t.Fatal("Close should not wait for a reply handler blocked on the requester")
+		}
+	})
+}
+
+func TestResponder(t *testing.T) {
+	t.Run("WithoutReplyTo", func(t *testing.T) {
+		q := queue.NewMock()
+		defer q.Close()
+		responder := NewResponder(broker.NewQueueProducer(q), func(ctx context.Context, message *queue.Message) ([]byte, error) {
+			return []byte("ignored"), nil
+		})
+
+		err := responder.Handle(context.Background(), &queue.Message{ID: "1", Topic: "echo", Headers: map[string]string{}})
+		require.NoError(t, err, "Should handle fire-and-forget messages")
+
+		topics, err := q.Topics(context.Background())
+		require.NoError(t, err)
+		assert.Empty(t, topics, "Should not publish a reply")
+	})
+}
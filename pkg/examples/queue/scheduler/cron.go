@@ -0,0 +1,197 @@
+package scheduler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxSearch bounds how far Next looks for a matching time, expressions such as "0 0 30 2 *" never match
+const maxSearch = 5 * 366 * 24 * time.Hour
+
+// descriptors are the shorthands accepted in place of the five fields
+var descriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// field describes the values allowed in one of the five fields
+type field struct {
+	name     string
+	min, max int
+	names    map[string]int
+}
+
+var (
+	minutes     = field{name: "minute", min: 0, max: 59}
+	hours       = field{name: "hour", min: 0, max: 23}
+	daysOfMonth = field{name: "day of month", min: 1, max: 31}
+	months      = field{name: "month", min: 1, max: 12, names: map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}}
+	// Sunday is both 0 and 7
+	daysOfWeek = field{name: "day of week", min: 0, max: 7, names: map[string]int{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}}
+)
+
+// Schedule is a parsed cron expression
+type Schedule struct {
+	minute, hour, dayOfMonth, month, dayOfWeek uint64
+	// anyDayOfMonth and anyDayOfWeek tell whether the day fields are "*", which changes how they combine
+	anyDayOfMonth, anyDayOfWeek bool
+}
+
+// ParseCron parses a five-field cron expression "minute hour day-of-month month day-of-week"
+// Fields hold "*", values, ranges "1-5", steps "*/15" or "0-30/10" and comma-separated lists of them.
+// Months and days of week also accept names ("jan", "mon"). The descriptors @yearly, @monthly,
+// @weekly, @daily and @hourly are accepted too. Like cron, a time matches when both day fields match,
+// or either of them when neither is "*".
+func ParseCron(expression string) (*Schedule, error) {
+	expanded := strings.TrimSpace(expression)
+	if descriptor, ok := descriptors[strings.ToLower(expanded)]; ok {
+		expanded = descriptor
+	}
+
+	fields := strings.Fields(expanded)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid cron expression %q: expected 5 fields, got %d", expression, len(fields))
+	}
+
+	s := &Schedule{
+		anyDayOfMonth: fields[2] == "*",
+		anyDayOfWeek:  fields[4] == "*",
+	}
+	for i, target := range []struct {
+		bits  *uint64
+		field field
+	}{
+		{&s.minute, minutes},
+		{&s.hour, hours},
+		{&s.dayOfMonth, daysOfMonth},
+		{&s.month, months},
+		{&s.dayOfWeek, daysOfWeek},
+	} {
+		bits, err := target.field.parse(fields[i])
+		if err != nil {
+			return nil, fmt.Errorf("invalid cron expression %q: %w", expression, err)
+		}
+		*target.bits = bits
+	}
+
+	// 7 is another name for Sunday
+	if s.dayOfWeek&(1<<7) != 0 {
+		s.dayOfWeek |= 1
+	}
+	return s, nil
+}
+
+// Next returns the first time strictly after t matching the schedule, in the location of t
+// It returns the zero time when nothing matches within five years.
+func (s *Schedule) Next(t time.Time) time.Time {
+	loc := t.Location()
+	limit := t.Add(maxSearch)
+	t = t.Truncate(time.Minute).Add(time.Minute)
+
+	for t.Before(limit) {
+		year, month, day := t.Date()
+		switch {
+		case !has(s.month, int(month)):
+			t = time.Date(year, month+1, 1, 0, 0, 0, 0, loc)
+		case !s.matchesDay(t):
+			t = time.Date(year, month, day+1, 0, 0, 0, 0, loc)
+		case !has(s.hour, t.Hour()):
+			t = time.Date(year, month, day, t.Hour()+1, 0, 0, 0, loc)
+		case !has(s.minute, t.Minute()):
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// matchesDay checks the day fields of a date
+func (s *Schedule) matchesDay(t time.Time) bool {
+	dayOfMonth := has(s.dayOfMonth, t.Day())
+	dayOfWeek := has(s.dayOfWeek, int(t.Weekday()))
+	if s.anyDayOfMonth || s.anyDayOfWeek {
+		return dayOfMonth && dayOfWeek
+	}
+	return dayOfMonth || dayOfWeek
+}
+
+func has(bits uint64, value int) bool {
+	return bits&(1<<uint(value)) != 0
+}
+
+// parse returns the bit set of the values of a field
+func (f field) parse(expression string) (uint64, error) {
+	var bits uint64
+	for _, item := range strings.Split(expression, ",") {
+		rangeExpr, stepExpr, hasStep := strings.Cut(item, "/")
+
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepExpr); err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step %q in %s field", stepExpr, f.name)
+			}
+		}
+
+		var low, high int
+		switch lowExpr, highExpr, isRange := strings.Cut(rangeExpr, "-"); {
+		case rangeExpr == "*":
+			low, high = f.min, f.max
+		case isRange:
+			var err error
+			if low, err = f.value(lowExpr); err != nil {
+				return 0, err
+			}
+			if high, err = f.value(highExpr); err != nil {
+				return 0, err
+			}
+			if f.name == daysOfWeek.name && high == 0 {
+				// "fri-sun" ends on Sunday, the 7th day
+				high = 7
+			}
+			if low > high {
+				return 0, fmt.Errorf("invalid range %q in %s field", rangeExpr, f.name)
+			}
+		default:
+			var err error
+			if low, err = f.value(rangeExpr); err != nil {
+				return 0, err
+			}
+			high = low
+			if hasStep {
+				// "5/15" starts at 5 and repeats up to the maximum
+				high = f.max
+			}
+		}
+
+		for v := low; v <= high; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+// value parses a number or a name of the field
+func (f field) value(expression string) (int, error) {
+	if v, ok := f.names[strings.ToLower(expression)]; ok {
+		return v, nil
+	}
+	v, err := strconv.Atoi(expression)
+	if err != nil || v < f.min || v > f.max {
+		return 0, fmt.Errorf("invalid value %q in %s field, expected %d-%d", expression, f.name, f.min, f.max)
+	}
+	return v, nil
+}
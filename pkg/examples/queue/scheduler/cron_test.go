@@ -0,0 +1,109 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCron(t *testing.T) {
+	// Monday 2024-01-01 12:00 UTC
+	from := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	for _, tc := range []struct {
+		expression string
+		expected   []time.Time
+	}{
+		{"* * * * *", []time.Time{
+			time.Date(2024, 1, 1, 12, 1, 0, 0, time.UTC),
+			time.Date(2024, 1, 1, 12, 2, 0, 0, time.UTC),
+		}},
+		{"*/20 * * * *", []time.Time{
+			time.Date(2024, 1, 1, 12, 20, 0, 0, time.UTC),
+			time.Date(2024, 1, 1, 12, 40, 0, 0, time.UTC),
+			time.Date(2024, 1, 1, 13, 0, 0, 0, time.UTC),
+		}},
+		{"5,10-12 9 * * *", []time.Time{
+			time.Date(2024, 1, 2, 9, 5, 0, 0, time.UTC),
+			time.Date(2024, 1, 2, 9, 10, 0, 0, time.UTC),
+			time.Date(2024, 1, 2, 9, 11, 0, 0, time.UTC),
+			time.Date(2024, 1, 2, 9, 12, 0, 0, time.UTC),
+			time.Date(2024, 1, 3, 9, 5, 0, 0, time.UTC),
+		}},
+		{"0 9 * * fri-sun", []time.Time{
+			time.Date(2024, 1, 5, 9, 0, 0, 0, time.UTC),
+			time.Date(2024, 1, 6, 9, 0, 0, 0, time.UTC),
+			time.Date(2024, 1, 7, 9, 0, 0, 0, time.UTC),
+			time.Date(2024, 1, 12, 9, 0, 0, 0, time.UTC),
+		}},
+		{"0 0 * * 7", []time.Time{
+			time.Date(2024, 1, 7, 0, 0, 0, 0, time.UTC),
+		}},
+		{"30 6 1 */3 *", []time.Time{
+			time.Date(2024, 4, 1, 6, 30, 0, 0, time.UTC),
+			time.Date(2024, 7, 1, 6, 30, 0, 0, time.UTC),
+		}},
+		// Either day field matches when both are restricted
+		{"0 0 13 * 5", []time.Time{
+			time.Date(2024, 1, 5, 0, 0, 0, 0, time.UTC),
+			time.Date(2024, 1, 12, 0, 0, 0, 0, time.UTC),
+			time.Date(2024, 1, 13, 0, 0, 0, 0, time.UTC),
+			time.Date(2024, 1, 19, 0, 0, 0, 0, time.UTC),
+		}},
+		{"0 0 29 feb *", []time.Time{
+			time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC),
+			time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC),
+		}},
+		{"@hourly", []time.Time{
+			time.Date(2024, 1, 1, 13, 0, 0, 0, time.UTC),
+		}},
+		{"@weekly", []time.Time{
+			time.Date(2024, 1, 7, 0, 0, 0, 0, time.UTC),
+		}},
+	} {
+		t.Run(tc.expression, func(t *testing.T) {
+			schedule, err := ParseCron(tc.expression)
+			require.NoError(t, err)
+
+			next := from
+			for _, expected := range tc.expected {
+				next = schedule.Next(next)
+				assert.Equal(t, expected, next)
+			}
+		})
+	}
+
+	t.Run("Location", func(t *testing.T) {
+		paris, err := time.LoadLocation("Europe/Paris")
+		require.NoError(t, err)
+		schedule, err := ParseCron("0 9 * * *")
+		require.NoError(t, err)
+
+		assert.Equal(t, time.Date(2024, 1, 2, 8, 0, 0, 0, time.UTC), schedule.Next(from.In(paris)).UTC(), "Should match 9:00 in Paris, UTC+1 in winter")
+		assert.Equal(t, time.Date(2024, 7, 1, 7, 0, 0, 0, time.UTC), schedule.Next(time.Date(2024, 6, 30, 12, 0, 0, 0, paris)).UTC(), "UTC+2 in summer")
+
+		// 2:30 does not exist on 2024-03-31 in Paris
+		schedule, err = ParseCron("30 2 * * *")
+		require.NoError(t, err)
+		next := schedule.Next(time.Date(2024, 3, 30, 12, 0, 0, 0, paris))
+		assert.Equal(t, time.Date(2024, 4, 1, 2, 30, 0, 0, paris), next, "Should skip a time missing on DST change")
+	})
+
+	t.Run("NeverMatches", func(t *testing.T) {
+		schedule, err := ParseCron("0 0 30 2 *")
+		require.NoError(t, err)
+		assert.True(t, schedule.Next(from).IsZero())
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		for _, expression := range []string{
+			"", "* * * *", "* * * * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "* * * 13 *", "* * * * 8",
+			"*/0 * * * *", "5-1 * * * *", "a * * * *", "* * * foo *", "@often",
+		} {
+			_, err := ParseCron(expression)
+			assert.Error(t, err, "Should reject %q", expression)
+		}
+	})
+}
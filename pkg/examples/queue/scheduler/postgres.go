@@ -0,0 +1,97 @@
+package scheduler
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"hash/fnv"
+	"log"
+)
+
+// ensure that PostgresLocker implements the Locker interface
+var _ Locker = (*PostgresLocker)(nil)
+
+// PostgresLocker implements Locker with Postgres session advisory locks
+// Each lock holds a connection of the pool until it is unlocked, and Postgres releases it
+// if the instance dies. The database only needs a driver, no table is created.
+type PostgresLocker struct {
+	db        *sql.DB
+	namespace string
+	logger    *log.Logger
+}
+
+// PostgresLockerOption configures a PostgresLocker created with NewPostgresLocker
+type PostgresLockerOption func(*PostgresLocker)
+
+// WithNamespace prefixes the lock names, so applications sharing a database do not lock each other's jobs
+func WithNamespace(namespace string) PostgresLockerOption {
+	return func(l *PostgresLocker) {
+		l.namespace = namespace
+	}
+}
+
+// WithLockLogger logs the locks that could not be released to logger instead of the standard logger
+func WithLockLogger(logger *log.Logger) PostgresLockerOption {
+	return func(l *PostgresLocker) {
+		l.logger = logger
+	}
+}
+
+// NewPostgresLocker creates a locker using the given database
+func NewPostgresLocker(db *sql.DB, opts ...PostgresLockerOption) *PostgresLocker {
+	l := &PostgresLocker{db: db, logger: log.Default()}
+	for _, opt := range opts {
+		opt(l)
+	}
+	return l
+}
+
+// TryLock takes the advisory lock of the name with pg_try_advisory_lock
+func (l *PostgresLocker) TryLock(ctx context.Context, name string) (func(), bool, error) {
+	key := l.key(name)
+
+	// Session locks belong to a connection, so the lock and unlock must use the same one
+	conn, err := l.db.Conn(ctx)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to get a connection: %w", err)
+	}
+
+	var acquired bool
+	if err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", key).Scan(&acquired); err != nil {
+		conn.Close()
+		return nil, false, fmt.Errorf("failed to lock %s: %w", name, err)
+	}
+	if !acquired {
+		conn.Close()
+		return nil, false, nil
+	}
+
+	unlock := func() {
+		defer conn.Close()
+
+		var released bool
+		err := conn.QueryRowContext(context.Background(), "SELECT pg_advisory_unlock($1)", key).Scan(&released)
+		if err == nil && released {
+			return
+		}
+		if err == nil {
+			err = fmt.Errorf("the session did not hold it")
+		}
+		// Back in the pool the session would keep the lock, discard it so that Postgres releases it
+		l.logger.Printf("Failed to unlock %s, discarding its connection: %v", name, err)
+		conn.Raw(func(any) error {
+			return driver.ErrBadConn
+		})
+	}
+	return unlock, true, nil
+}
+
+// key hashes the lock name to the 64-bit key of the advisory lock
+func (l *PostgresLocker) key(name string) int64 {
+	h := fnv.New64a()
+	h.Write([]byte(l.namespace))
+	h.Write([]byte{0})
+	h.Write([]byte(name))
+	return int64(h.Sum64())
+}
@@ -0,0 +1,206 @@
+package scheduler
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakePostgres emulates the session advisory locks of a Postgres server shared by every fakeConn
+type fakePostgres struct {
+	mu      sync.Mutex
+	holders map[int64]*fakeConn
+	// unlockErr fails the unlock queries
+	unlockErr error
+	// closed counts the sessions closed
+	closed int
+}
+
+var advisoryLocks = &fakePostgres{holders: make(map[int64]*fakeConn)}
+
+func init() {
+	sql.Register("fakepostgres", advisoryLocks)
+}
+
+func (p *fakePostgres) Open(name string) (driver.Conn, error) {
+	return &fakeConn{server: p}, nil
+}
+
+// fakeConn is a session of fakePostgres, it only answers the advisory lock queries
+type fakeConn struct {
+	server *fakePostgres
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, fmt.Errorf("unsupported query: %s", query)
+}
+
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	return nil, fmt.Errorf("transactions are not supported")
+}
+
+// Close ends the session, which releases its locks
+func (c *fakeConn) Close() error {
+	c.server.mu.Lock()
+	defer c.server.mu.Unlock()
+	c.server.closed++
+	for key, holder := range c.server.holders {
+		if holder == c {
+			delete(c.server.holders, key)
+		}
+	}
+	return nil
+}
+
+func (c *fakeConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	c.server.mu.Lock()
+	defer c.server.mu.Unlock()
+	key := args[0].Value.(int64)
+	holder, held := c.server.holders[key]
+
+	switch query {
+	case "SELECT pg_try_advisory_lock($1)":
+		if !held {
+			c.server.holders[key] = c
+		}
+		return &boolRows{value: !held || holder == c}, nil
+	case "SELECT pg_advisory_unlock($1)":
+		if c.server.unlockErr != nil {
+			return nil, c.server.unlockErr
+		}
+		if holder == c {
+			delete(c.server.holders, key)
+		}
+		return &boolRows{value: holder == c}, nil
+	default:
+		return nil, fmt.Errorf("unsupported query: %s", query)
+	}
+}
+
+// boolRows is the single boolean row returned by the advisory lock functions
+type boolRows struct {
+	value bool
+	read  bool
+}
+
+func (r *boolRows) Columns() []string { return []string{"result"} }
+
+func (r *boolRows) Close() error { return nil }
+
+func (r *boolRows) Next(dest []driver.Value) error {
+	if r.read {
+		return io.EOF
+	}
+	r.read = true
+	dest[0] = r.value
+	return nil
+}
+
+func TestPostgresLocker(t *testing.T) {
+	ctx := context.Background()
+	// Two replicas with their own pools on the same database
+	openDB := func() *sql.DB {
+		db, err := sql.Open("fakepostgres", "")
+		require.NoError(t, err)
+		t.Cleanup(func() { db.Close() })
+		return db
+	}
+	first := NewPostgresLocker(openDB())
+	second := NewPostgresLocker(openDB())
+
+	unlock, acquired, err := first.TryLock(ctx, "orders")
+	require.NoError(t, err)
+	require.True(t, acquired)
+
+	_, acquired, err = second.TryLock(ctx, "orders")
+	require.NoError(t, err)
+	assert.False(t, acquired, "Another replica should not take a held lock")
+	_, acquired, err = first.TryLock(ctx, "orders")
+	require.NoError(t, err)
+	assert.False(t, acquired, "Another connection of the same pool should not take a held lock")
+
+	other, acquired, err := second.TryLock(ctx, "payments")
+	require.NoError(t, err)
+	assert.True(t, acquired, "Locks of other jobs should be independent")
+	other()
+
+	unlock()
+	unlock, acquired, err = second.TryLock(ctx, "orders")
+	require.NoError(t, err)
+	assert.True(t, acquired, "Should take the lock once released")
+	unlock()
+
+	t.Run("UnlockFails", func(t *testing.T) {
+		logs := &syncBuffer{}
+		locker := NewPostgresLocker(openDB(), WithLockLogger(log.New(logs, "", 0)))
+
+		unlock, acquired, err := locker.TryLock(ctx, "orders")
+		require.NoError(t, err)
+		require.True(t, acquired)
+
+		advisoryLocks.mu.Lock()
+		advisoryLocks.unlockErr = errors.New("connection reset by peer")
+		closed := advisoryLocks.closed
+		advisoryLocks.mu.Unlock()
+		defer func() {
+			advisoryLocks.mu.Lock()
+			advisoryLocks.unlockErr = nil
+			advisoryLocks.mu.Unlock()
+		}()
+
+		unlock()
+		assert.Equal(t, 1, logs.Count("Failed to unlock orders, discarding its connection: connection reset by peer"))
+		advisoryLocks.mu.Lock()
+		assert.Equal(t, closed+1, advisoryLocks.closed, "The connection should be closed rather than returned to the pool")
+		advisoryLocks.mu.Unlock()
+
+		unlock, acquired, err = second.TryLock(ctx, "orders")
+		require.NoError(t, err)
+		assert.True(t, acquired, "Closing the session should release its lock")
+		unlock()
+	})
+
+	t.Run("UnlockNotHeld", func(t *testing.T) {
+		logs := &syncBuffer{}
+		locker := NewPostgresLocker(openDB(), WithLockLogger(log.New(logs, "", 0)))
+
+		unlock, acquired, err := locker.TryLock(ctx, "orders")
+		require.NoError(t, err)
+		require.True(t, acquired)
+
+		// The lock was lost, e.g. released by pg_advisory_unlock_all
+		advisoryLocks.mu.Lock()
+		for key := range advisoryLocks.holders {
+			delete(advisoryLocks.holders, key)
+		}
+		advisoryLocks.mu.Unlock()
+
+		unlock()
+		assert.Equal(t, 1, logs.Count("Failed to unlock orders, discarding its connection: the session did not hold it"))
+	})
+
+	t.Run("Namespace", func(t *testing.T) {
+		db := openDB()
+		billing := NewPostgresLocker(db, WithNamespace("billing"))
+		shipping := NewPostgresLocker(db, WithNamespace("shipping"))
+
+		unlockBilling, acquired, err := billing.TryLock(ctx, "orders")
+		require.NoError(t, err)
+		require.True(t, acquired)
+		defer unlockBilling()
+
+		unlockShipping, acquired, err := shipping.TryLock(ctx, "orders")
+		require.NoError(t, err)
+		assert.True(t, acquired, "Namespaces should not lock each other's jobs")
+		unlockShipping()
+	})
+}
@@ -0,0 +1,286 @@
+// Package scheduler runs jobs, such as producers publishing periodic messages, on cron schedules.
+//
+//	s := scheduler.NewScheduler(scheduler.WithLogger(logger))
+//	s.Add(scheduler.Job{
+//		Name:          "nightly-report",
+//		Schedule:      "0 2 * * *",
+//		Location:      paris,
+//		Jitter:        time.Minute,
+//		SkipIfRunning: true,
+//		Locker:        scheduler.NewPostgresLocker(db),
+//		Run:           report.Publish,
+//	})
+//	s.Start(ctx)
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/syl/Go/pkg/examples/queue"
+)
+
+// DefaultLockHold is how long after its scheduled time a distributed job keeps its lock at least,
+// so replicas whose timers fire a little later do not run it again once it finished
+const DefaultLockHold = 10 * time.Second
+
+// JobFunc is the work of a job, the context is canceled when the scheduler stops
+type JobFunc func(ctx context.Context) error
+
+// Job is a function run on a cron schedule
+type Job struct {
+	// Name identifies the job in logs and is the name of its distributed lock
+	Name string
+	// Schedule is a cron expression (see ParseCron)
+	Schedule string
+	// Location is the time zone of the schedule, time.Local when nil
+	Location *time.Location
+	// Jitter delays every run by a random duration below it, to spread the load of replicas and jobs
+	Jitter time.Duration
+	// SkipIfRunning skips a run while the previous one is still running, runs overlap otherwise
+	SkipIfRunning bool
+	// Locker is optional, when set a run only happens on the instance acquiring the lock of the job
+	Locker Locker
+	Run    JobFunc
+}
+
+// Locker grants named locks to a single instance across replicas
+type Locker interface {
+	// TryLock acquires the lock without waiting, acquired is false when another instance holds it
+	TryLock(ctx context.Context, name string) (unlock func(), acquired bool, err error)
+}
+
+// Scheduler runs jobs on their schedules until Start returns
+type Scheduler struct {
+	clock    queue.Clock
+	logger   *log.Logger
+	lockHold time.Duration
+	// jitter returns a random duration in [0, max)
+	jitter func(max time.Duration) time.Duration
+
+	mu      sync.Mutex
+	entries []*entry
+	started bool
+	running sync.WaitGroup
+}
+
+// entry is a job with its parsed schedule
+type entry struct {
+	job      Job
+	schedule *Schedule
+	location *time.Location
+	active   atomic.Int32
+}
+
+// Option configures a Scheduler created with NewScheduler
+type Option func(*Scheduler)
+
+// WithClock drives the schedules with clock instead of the system clock
+func WithClock(clock queue.Clock) Option {
+	return func(s *Scheduler) {
+		s.clock = clock
+	}
+}
+
+// WithLogger logs runs, skips and failures to logger instead of the standard logger
+func WithLogger(logger *log.Logger) Option {
+	return func(s *Scheduler) {
+		s.logger = logger
+	}
+}
+
+// WithLockHold changes how long after its scheduled time a distributed job keeps its lock at least
+// It should exceed the jitter of the jobs plus the clock skew between replicas, and stay below their period.
+func WithLockHold(d time.Duration) Option {
+	return func(s *Scheduler) {
+		s.lockHold = d
+	}
+}
+
+// NewScheduler creates a scheduler without jobs
+func NewScheduler(opts ...Option) *Scheduler {
+	s := &Scheduler{
+		clock:    queue.SystemClock,
+		logger:   log.Default(),
+		lockHold: DefaultLockHold,
+		jitter: func(max time.Duration) time.Duration {
+			return time.Duration(rand.Int63n(int64(max)))
+		},
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Add registers a job, jobs must be added before Start
+func (s *Scheduler) Add(job Job) error {
+	if job.Name == "" {
+		return errors.New("job has no name")
+	}
+	if job.Run == nil {
+		return fmt.Errorf("job %s has no run function", job.Name)
+	}
+	if job.Jitter < 0 {
+		return fmt.Errorf("job %s has a negative jitter", job.Name)
+	}
+	schedule, err := ParseCron(job.Schedule)
+	if err != nil {
+		return fmt.Errorf("job %s: %w", job.Name, err)
+	}
+
+	location := job.Location
+	if location == nil {
+		location = time.Local
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.started {
+		return errors.New("scheduler is already started")
+	}
+	for _, e := range s.entries {
+		if e.job.Name == job.Name {
+			return fmt.Errorf("job %s already exists", job.Name)
+		}
+	}
+
+	s.entries = append(s.entries, &entry{job: job, schedule: schedule, location: location})
+	return nil
+}
+
+// Next returns the next scheduled time of a job, without jitter
+func (s *Scheduler) Next(name string) (time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, e := range s.entries {
+		if e.job.Name == name {
+			return e.schedule.Next(s.clock.Now().In(e.location)), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("job %s not found", name)
+}
+
+// Start runs the jobs until ctx is done, then waits for the running jobs and returns the context error
+func (s *Scheduler) Start(ctx context.Context) error {
+	s.mu.Lock()
+	if s.started {
+		s.mu.Unlock()
+		return errors.New("scheduler is already started")
+	}
+	s.started = true
+	entries := s.entries
+	s.mu.Unlock()
+
+	var loops sync.WaitGroup
+	for _, e := range entries {
+		loops.Add(1)
+		go func(e *entry) {
+			defer loops.Done()
+			s.loop(ctx, e)
+		}(e)
+	}
+
+	loops.Wait()
+	s.running.Wait()
+	return ctx.Err()
+}
+
+// loop waits for the scheduled times of a job and triggers its runs
+func (s *Scheduler) loop(ctx context.Context, e *entry) {
+	for {
+		scheduled := e.schedule.Next(s.clock.Now().In(e.location))
+		if scheduled.IsZero() {
+			s.logger.Printf("Job %s never runs, its schedule %q matches no time", e.job.Name, e.job.Schedule)
+			return
+		}
+
+		delay := -s.clock.Since(scheduled)
+		if e.job.Jitter > 0 {
+			delay += s.jitter(e.job.Jitter)
+		}
+
+		timer := s.clock.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C():
+		}
+
+		s.trigger(ctx, e, scheduled)
+	}
+}
+
+// trigger starts a run of the job unless it must be skipped
+func (s *Scheduler) trigger(ctx context.Context, e *entry, scheduled time.Time) {
+	if e.job.SkipIfRunning {
+		if !e.active.CompareAndSwap(0, 1) {
+			s.logger.Printf("Skipping job %s scheduled at %s, the previous run is still running", e.job.Name, scheduled)
+			return
+		}
+	} else {
+		e.active.Add(1)
+	}
+
+	s.running.Add(1)
+	go func() {
+		defer s.running.Done()
+		defer e.active.Add(-1)
+		s.run(ctx, e, scheduled)
+	}()
+}
+
+// run runs the job once, holding its distributed lock when it has a locker
+func (s *Scheduler) run(ctx context.Context, e *entry, scheduled time.Time) {
+	if e.job.Locker != nil {
+		unlock, acquired, err := e.job.Locker.TryLock(ctx, e.job.Name)
+		if err != nil {
+			s.logger.Printf("Skipping job %s scheduled at %s, failed to lock: %v", e.job.Name, scheduled, err)
+			return
+		}
+		if !acquired {
+			s.logger.Printf("Skipping job %s scheduled at %s, another instance runs it", e.job.Name, scheduled)
+			return
+		}
+		// The run is over once the job returns, only the lock outlives it
+		defer func() {
+			s.running.Add(1)
+			go func() {
+				defer s.running.Done()
+				s.holdLock(ctx, scheduled.Add(e.job.Jitter+s.lockHold))
+				unlock()
+			}()
+		}()
+	}
+
+	start := s.clock.Now()
+	if err := e.job.Run(ctx); err != nil {
+		s.logger.Printf("Job %s scheduled at %s failed after %s: %v", e.job.Name, scheduled, s.clock.Since(start), err)
+		return
+	}
+	s.logger.Printf("Job %s scheduled at %s completed in %s", e.job.Name, scheduled, s.clock.Since(start))
+}
+
+// holdLock waits until the given time or until the scheduler stops
+func (s *Scheduler) holdLock(ctx context.Context, until time.Time) {
+	delay := -s.clock.Since(until)
+	if delay <= 0 {
+		return
+	}
+
+	timer := s.clock.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+	case <-timer.C():
+	}
+}
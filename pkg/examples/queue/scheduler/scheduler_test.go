@@ -0,0 +1,261 @@
+package scheduler
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/syl/Go/pkg/examples/queue/inmemory/testutils"
+)
+
+// syncBuffer collects the scheduler logs written by several goroutines
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) Count(substr string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return strings.Count(b.buf.String(), substr)
+}
+
+// SchedulerTestFixture runs schedulers on a fake clock starting on Monday 2024-01-01 at 12:00 UTC
+type SchedulerTestFixture struct {
+	T     *testing.T
+	Ctx   context.Context
+	Clock *testutils.FakeClock
+	Logs  *syncBuffer
+
+	mu   sync.Mutex
+	runs []time.Time
+}
+
+func NewSchedulerTestFixture(t *testing.T) *SchedulerTestFixture {
+	return &SchedulerTestFixture{
+		T:     t,
+		Ctx:   context.Background(),
+		Clock: testutils.NewFakeClock(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)),
+		Logs:  &syncBuffer{},
+	}
+}
+
+// NewScheduler creates a scheduler on the fake clock logging to Logs
+func (f *SchedulerTestFixture) NewScheduler(opts ...Option) *Scheduler {
+	opts = append([]Option{WithClock(f.Clock), WithLogger(log.New(f.Logs, "", 0))}, opts...)
+	return NewScheduler(opts...)
+}
+
+// Start runs the scheduler until the test ends
+func (f *SchedulerTestFixture) Start(s *Scheduler) {
+	ctx, cancel := context.WithCancel(f.Ctx)
+	done := make(chan error, 1)
+	go func() { done <- s.Start(ctx) }()
+
+	f.T.Cleanup(func() {
+		cancel()
+		assert.ErrorIs(f.T, <-done, context.Canceled)
+	})
+}
+
+// Record is a job function recording the fake time of its runs
+func (f *SchedulerTestFixture) Record(ctx context.Context) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.runs = append(f.runs, f.Clock.Now())
+	return nil
+}
+
+// Runs returns the recorded run times
+func (f *SchedulerTestFixture) Runs() []time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]time.Time(nil), f.runs...)
+}
+
+// WaitForRuns advances the clock by step until n runs were recorded
+func (f *SchedulerTestFixture) WaitForRuns(step time.Duration, n int) []time.Time {
+	f.T.Helper()
+
+	f.Clock.AdvanceUntil(f.T, step, func() bool { return len(f.Runs()) >= n }, "Expected runs")
+	return f.Runs()
+}
+
+// memoryLocker is a Locker shared by the schedulers of a test, as a database would be by replicas
+type memoryLocker struct {
+	mu   sync.Mutex
+	held map[string]bool
+}
+
+func (l *memoryLocker) TryLock(ctx context.Context, name string) (func(), bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.held[name] {
+		return nil, false, nil
+	}
+	l.held[name] = true
+	return func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		delete(l.held, name)
+	}, true, nil
+}
+
+func TestScheduler(t *testing.T) {
+	t.Run("RunsOnSchedule", func(t *testing.T) {
+		fixture := NewSchedulerTestFixture(t)
+		s := fixture.NewScheduler()
+		require.NoError(t, s.Add(Job{Name: "orders", Schedule: "*/5 * * * *", Location: time.UTC, Run: fixture.Record}))
+		fixture.Start(s)
+
+		runs := fixture.WaitForRuns(time.Minute, 2)
+		assert.Equal(t, []time.Time{
+			time.Date(2024, 1, 1, 12, 5, 0, 0, time.UTC),
+			time.Date(2024, 1, 1, 12, 10, 0, 0, time.UTC),
+		}, runs[:2])
+		assert.Equal(t, 2, fixture.Logs.Count("Job orders scheduled at"), "Should log every run")
+	})
+
+	t.Run("Location", func(t *testing.T) {
+		fixture := NewSchedulerTestFixture(t)
+		paris, err := time.LoadLocation("Europe/Paris")
+		require.NoError(t, err)
+		s := fixture.NewScheduler()
+		require.NoError(t, s.Add(Job{Name: "report", Schedule: "0 9 * * *", Location: paris, Run: fixture.Record}))
+
+		next, err := s.Next("report")
+		require.NoError(t, err)
+		assert.Equal(t, time.Date(2024, 1, 2, 8, 0, 0, 0, time.UTC), next.UTC(), "Should run at 9:00 in Paris")
+		_, err = s.Next("unknown")
+		assert.Error(t, err)
+	})
+
+	t.Run("Jitter", func(t *testing.T) {
+		fixture := NewSchedulerTestFixture(t)
+		s := fixture.NewScheduler()
+		s.jitter = func(max time.Duration) time.Duration {
+			assert.Equal(t, time.Minute, max)
+			return 30 * time.Second
+		}
+		require.NoError(t, s.Add(Job{Name: "orders", Schedule: "*/5 * * * *", Location: time.UTC, Jitter: time.Minute, Run: fixture.Record}))
+		fixture.Start(s)
+
+		runs := fixture.WaitForRuns(10*time.Second, 2)
+		assert.Equal(t, time.Date(2024, 1, 1, 12, 5, 30, 0, time.UTC), runs[0], "Should delay the run by the jitter")
+		assert.Equal(t, time.Date(2024, 1, 1, 12, 10, 30, 0, time.UTC), runs[1], "Should not accumulate the jitter")
+	})
+
+	t.Run("SkipIfRunning", func(t *testing.T) {
+		fixture := NewSchedulerTestFixture(t)
+		s := fixture.NewScheduler()
+		release := make(chan struct{})
+		require.NoError(t, s.Add(Job{
+			Name:          "slow",
+			Schedule:      "* * * * *",
+			SkipIfRunning: true,
+			Run: func(ctx context.Context) error {
+				fixture.Record(ctx)
+				<-release
+				return nil
+			},
+		}))
+		fixture.Start(s)
+
+		fixture.WaitForRuns(10*time.Second, 1)
+		fixture.Clock.AdvanceUntil(t, 10*time.Second, func() bool {
+			return fixture.Logs.Count("Skipping job slow") >= 2
+		}, "Should skip the runs due while the job runs")
+		assert.Len(t, fixture.Runs(), 1)
+
+		close(release)
+		fixture.WaitForRuns(10*time.Second, 2)
+	})
+
+	t.Run("Overlap", func(t *testing.T) {
+		fixture := NewSchedulerTestFixture(t)
+		s := fixture.NewScheduler()
+		release := make(chan struct{})
+		defer close(release)
+		require.NoError(t, s.Add(Job{
+			Name:     "slow",
+			Schedule: "* * * * *",
+			Run: func(ctx context.Context) error {
+				fixture.Record(ctx)
+				<-release
+				return nil
+			},
+		}))
+		fixture.Start(s)
+
+		fixture.WaitForRuns(10*time.Second, 2)
+		assert.Zero(t, fixture.Logs.Count("Skipping"), "Runs should overlap without SkipIfRunning")
+	})
+
+	t.Run("Failure", func(t *testing.T) {
+		fixture := NewSchedulerTestFixture(t)
+		s := fixture.NewScheduler()
+		require.NoError(t, s.Add(Job{Name: "failing", Schedule: "* * * * *", Run: func(ctx context.Context) error {
+			fixture.Record(ctx)
+			return errors.New("broker unavailable")
+		}}))
+		fixture.Start(s)
+
+		fixture.WaitForRuns(10*time.Second, 2)
+		fixture.Clock.AdvanceUntil(t, time.Second, func() bool {
+			return fixture.Logs.Count("broker unavailable") >= 2
+		}, "Should log failures and keep running the job")
+	})
+
+	t.Run("DistributedLock", func(t *testing.T) {
+		fixture := NewSchedulerTestFixture(t)
+		locker := &memoryLocker{held: make(map[string]bool)}
+		for i := 0; i < 3; i++ {
+			replica := fixture.NewScheduler(WithLockHold(10 * time.Second))
+			require.NoError(t, replica.Add(Job{Name: "orders", Schedule: "* * * * *", Locker: locker, Run: fixture.Record}))
+			fixture.Start(replica)
+		}
+
+		runs := fixture.WaitForRuns(5*time.Second, 3)
+		fixture.Clock.Advance(5 * time.Second)
+		runs = fixture.Runs()
+		assert.Equal(t, []time.Time{
+			time.Date(2024, 1, 1, 12, 1, 0, 0, time.UTC),
+			time.Date(2024, 1, 1, 12, 2, 0, 0, time.UTC),
+			time.Date(2024, 1, 1, 12, 3, 0, 0, time.UTC),
+		}, runs[:3], "Only one replica should run each scheduled time")
+		assert.GreaterOrEqual(t, fixture.Logs.Count("another instance runs it"), 4)
+	})
+
+	t.Run("Add", func(t *testing.T) {
+		fixture := NewSchedulerTestFixture(t)
+		s := fixture.NewScheduler()
+
+		assert.Error(t, s.Add(Job{Schedule: "* * * * *", Run: fixture.Record}), "Should require a name")
+		assert.Error(t, s.Add(Job{Name: "orders", Schedule: "* * * * *"}), "Should require a run function")
+		assert.Error(t, s.Add(Job{Name: "orders", Schedule: "every minute", Run: fixture.Record}), "Should reject an invalid schedule")
+		assert.Error(t, s.Add(Job{Name: "orders", Schedule: "* * * * *", Jitter: -time.Second, Run: fixture.Record}), "Should reject a negative jitter")
+
+		require.NoError(t, s.Add(Job{Name: "orders", Schedule: "* * * * *", Run: fixture.Record}))
+		assert.Error(t, s.Add(Job{Name: "orders", Schedule: "@hourly", Run: fixture.Record}), "Should reject a duplicate name")
+
+		fixture.Start(s)
+		fixture.WaitForRuns(10*time.Second, 1)
+		assert.Error(t, s.Add(Job{Name: "payments", Schedule: "* * * * *", Run: fixture.Record}), "Should reject jobs once started")
+	})
+}
@@ -0,0 +1,235 @@
+package stream
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/syl/Go/pkg/examples/queue"
+)
+
+const (
+	// HeaderOffset is set on delivered messages to the offset of their record
+	HeaderOffset = "stream-offset"
+	// PollInterval is how often a group consumer reads new records, measured with the clock of the log
+	PollInterval = 100 * time.Millisecond
+)
+
+// ensure that GroupConsumer can be used wherever a Consumer is expected
+var _ queue.Consumer = (*GroupConsumer)(nil)
+
+// StartPosition is where a group starts reading a topic it never committed an offset for
+type StartPosition int
+
+const (
+	// StartEarliest replays every retained record
+	StartEarliest StartPosition = iota
+	// StartLatest only delivers records appended after subscribing
+	StartLatest
+)
+
+// ConsumerOption configures a GroupConsumer created with NewGroupConsumer
+type ConsumerOption func(*GroupConsumer)
+
+// WithStartPosition sets where the group starts reading topics without committed offset
+func WithStartPosition(position StartPosition) ConsumerOption {
+	return func(c *GroupConsumer) {
+		c.start = position
+	}
+}
+
+// WithBatchSize sets how many records are read from a topic on each poll
+func WithBatchSize(size int) ConsumerOption {
+	return func(c *GroupConsumer) {
+		c.batchSize = size
+	}
+}
+
+// GroupConsumer delivers the records of stream topics to handlers and commits offsets for a consumer group
+// A record is committed once its handler succeeded, a failing record is retried on the next poll.
+type GroupConsumer struct {
+	log           *Log
+	group         string
+	start         StartPosition
+	batchSize     int
+	subscriptions map[string]*subscription
+	mu            sync.Mutex
+	closed        bool
+}
+
+// subscription represents an active subscription to a stream topic
+type subscription struct {
+	topic   string
+	handler queue.MessageHandler
+	ctx     context.Context
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+}
+
+// NewGroupConsumer creates a consumer reading the log on behalf of the group
+func NewGroupConsumer(log *Log, group string, opts ...ConsumerOption) *GroupConsumer {
+	c := &GroupConsumer{
+		log:           log,
+		group:         group,
+		start:         StartEarliest,
+		batchSize:     100,
+		subscriptions: make(map[string]*subscription),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Subscribe starts delivering the records of the topic from the committed offset of the group
+func (c *GroupConsumer) Subscribe(ctx context.Context, topic string, handler queue.MessageHandler) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return fmt.Errorf("consumer is closed")
+	}
+
+	if _, exists := c.subscriptions[topic]; exists {
+		return fmt.Errorf("already subscribed to topic: %s", topic)
+	}
+
+	if _, committed, err := c.log.Committed(ctx, c.group, topic); err != nil {
+		return err
+	} else if !committed {
+		earliest, next, err := c.log.Offsets(ctx, topic)
+		if err != nil {
+			return err
+		}
+		start := earliest
+		if c.start == StartLatest {
+			start = next
+		}
+		if err := c.log.Commit(ctx, c.group, topic, start); err != nil {
+			return err
+		}
+	}
+
+	subCtx, cancel := context.WithCancel(ctx)
+	sub := &subscription{
+		topic:   topic,
+		handler: handler,
+		ctx:     subCtx,
+		cancel:  cancel,
+	}
+	c.subscriptions[topic] = sub
+
+	sub.wg.Add(1)
+	go c.consumeRecords(sub)
+
+	return nil
+}
+
+// Unsubscribe stops delivering the records of the topic, the committed offset is kept
+func (c *GroupConsumer) Unsubscribe(ctx context.Context, topic string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	sub, exists := c.subscriptions[topic]
+	if !exists {
+		return fmt.Errorf("not subscribed to topic: %s", topic)
+	}
+
+	sub.cancel()
+	sub.wg.Wait()
+	delete(c.subscriptions, topic)
+
+	return nil
+}
+
+// Seek moves the group to the offset so the next delivered record of the topic is the one at offset
+func (c *GroupConsumer) Seek(ctx context.Context, topic string, offset int64) error {
+	return c.log.Commit(ctx, c.group, topic, offset)
+}
+
+// SeekToTime moves the group to the first record of the topic with a timestamp at or after t
+func (c *GroupConsumer) SeekToTime(ctx context.Context, topic string, t time.Time) error {
+	offset, err := c.log.OffsetForTime(ctx, topic, t)
+	if err != nil {
+		return err
+	}
+	return c.Seek(ctx, topic, offset)
+}
+
+// Close stops all subscriptions, the committed offsets are kept in the log
+func (c *GroupConsumer) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return nil
+	}
+
+	c.closed = true
+
+	for _, sub := range c.subscriptions {
+		sub.cancel()
+	}
+
+	for _, sub := range c.subscriptions {
+		sub.wg.Wait()
+	}
+
+	c.subscriptions = make(map[string]*subscription)
+
+	return nil
+}
+
+// consumeRecords continuously polls the log for records after the committed offset
+func (c *GroupConsumer) consumeRecords(sub *subscription) {
+	defer sub.wg.Done()
+
+	ticker := c.log.clock.NewTicker(PollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-sub.ctx.Done():
+			return
+		case <-ticker.C():
+			c.consumeBatch(sub)
+		}
+	}
+}
+
+// consumeBatch hands the next records to the handler, committing after each success
+func (c *GroupConsumer) consumeBatch(sub *subscription) {
+	offset, _, err := c.log.Committed(sub.ctx, c.group, sub.topic)
+	if err != nil {
+		return
+	}
+
+	records, err := c.log.Read(sub.ctx, sub.topic, offset, c.batchSize)
+	if err != nil {
+		return
+	}
+
+	for _, record := range records {
+		if sub.ctx.Err() != nil {
+			return
+		}
+
+		message := record.Message
+		if message.Headers == nil {
+			message.Headers = make(map[string]string)
+		}
+		message.Headers[HeaderOffset] = strconv.FormatInt(record.Offset, 10)
+
+		if err := sub.handler(sub.ctx, message); err != nil {
+			return
+		}
+
+		// A concurrent Seek moved the group when the committed offset changed, the rest of the batch is stale
+		if !c.log.compareAndCommit(c.group, sub.topic, offset, record.Offset+1) {
+			return
+		}
+		offset = record.Offset + 1
+	}
+}
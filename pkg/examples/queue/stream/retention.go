@@ -0,0 +1,73 @@
+package stream
+
+import (
+	"time"
+
+	"github.com/syl/Go/pkg/examples/queue"
+)
+
+// Retention limits how many records a topic keeps, a zero value disables the limit
+type Retention struct {
+	// MaxRecords is the maximum number of records kept per topic
+	MaxRecords int
+	// MaxBytes is the maximum total size of payloads and headers kept per topic
+	MaxBytes int
+	// MaxAge is the maximum age of a record based on its message timestamp
+	MaxAge time.Duration
+}
+
+// Option configures a Log created with NewLog
+type Option func(*Log)
+
+// WithRetention sets the retention limits applied to every topic
+func WithRetention(retention Retention) Option {
+	return func(l *Log) {
+		l.retention = retention
+	}
+}
+
+// WithClock replaces the system clock, used to timestamp messages, compute record age and poll in group consumers
+func WithClock(clock queue.Clock) Option {
+	return func(l *Log) {
+		l.clock = clock
+	}
+}
+
+// apply removes the oldest records of the partition until all limits are met
+func (r Retention) apply(p *partition, now time.Time) {
+	drop := 0
+	bytes := p.bytes
+	for drop < len(p.records) {
+		record := p.records[drop]
+		remaining := len(p.records) - drop
+
+		expired := r.MaxAge > 0 && now.Sub(record.Message.Timestamp) > r.MaxAge
+		tooMany := r.MaxRecords > 0 && remaining > r.MaxRecords
+		tooBig := r.MaxBytes > 0 && bytes > r.MaxBytes
+		if !expired && !tooMany && !tooBig {
+			break
+		}
+
+		bytes -= recordSize(record.Message)
+		drop++
+	}
+
+	if drop == 0 {
+		return
+	}
+
+	for i := 0; i < drop; i++ {
+		p.records[i] = Record{}
+	}
+	p.records = p.records[drop:]
+	p.bytes = bytes
+}
+
+// recordSize is the number of bytes a message counts against MaxBytes
+func recordSize(message *queue.Message) int {
+	size := len(message.Payload)
+	for k, v := range message.Headers {
+		size += len(k) + len(v)
+	}
+	return size
+}
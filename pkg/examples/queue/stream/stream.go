@@ -0,0 +1,349 @@
+// Package stream provides append-only topics where messages are kept after being read.
+//
+// Every message appended to a topic gets a monotonically increasing offset. Consumer groups
+// commit the offset they processed up to, and can seek back to an offset or a timestamp
+// to replay history. Old messages are removed by retention limits on count, size and age.
+package stream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/syl/Go/pkg/examples/queue"
+)
+
+// HeaderProducerTimestamp holds the timestamp a record had before it was appended, in RFC 3339 format
+const HeaderProducerTimestamp = "producer-timestamp"
+
+// ErrOffsetOutOfRange is returned when reading or seeking past the end of a topic
+var ErrOffsetOutOfRange = errors.New("offset out of range")
+
+// ensure that Log can be used wherever a Producer is expected and supports health checks
+var (
+	_ queue.Producer      = (*Log)(nil)
+	_ queue.HealthChecker = (*Log)(nil)
+)
+
+// Record is a message stored in a topic at a given offset
+type Record struct {
+	Offset  int64
+	Message *queue.Message
+}
+
+// partition holds the retained records of a topic
+type partition struct {
+	records []Record
+	next    int64
+	bytes   int
+}
+
+// earliest returns the offset of the oldest retained record
+func (p *partition) earliest() int64 {
+	if len(p.records) == 0 {
+		return p.next
+	}
+	return p.records[0].Offset
+}
+
+// Log stores stream topics and the committed offsets of consumer groups
+type Log struct {
+	mu        sync.RWMutex
+	topics    map[string]*partition
+	committed map[string]map[string]int64
+	retention Retention
+	clock     queue.Clock
+	closed    bool
+}
+
+// NewLog creates an empty stream log
+func NewLog(opts ...Option) *Log {
+	l := &Log{
+		topics:    make(map[string]*partition),
+		committed: make(map[string]map[string]int64),
+		clock:     queue.SystemClock,
+	}
+	for _, opt := range opts {
+		opt(l)
+	}
+	return l
+}
+
+// Append adds a message to the end of the topic and returns its offset
+// The record is stamped with the append time, never earlier than the previous record of the topic,
+// A different timestamp set on the message is kept in the HeaderProducerTimestamp header.
+func (l *Log) Append(ctx context.Context, topic string, message *queue.Message) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.closed {
+		return 0, fmt.Errorf("stream log is closed")
+	}
+
+	p, exists := l.topics[topic]
+	if !exists {
+		p = &partition{}
+		l.topics[topic] = p
+	}
+
+	stored := message.Clone()
+	if stored.Topic == "" {
+		stored.Topic = topic
+	}
+	// Seeking by time and retention by age rely on timestamps increasing with offsets,
+	// so records are stamped when appended and a different time set by the producer goes to a header
+	produced := stored.Timestamp
+	stored.Timestamp = l.clock.Now()
+	if n := len(p.records); n > 0 && stored.Timestamp.Before(p.records[n-1].Message.Timestamp) {
+		stored.Timestamp = p.records[n-1].Message.Timestamp
+	}
+	if !produced.IsZero() && !produced.Equal(stored.Timestamp) {
+		if stored.Headers == nil {
+			stored.Headers = make(map[string]string)
+		}
+		stored.Headers[HeaderProducerTimestamp] = produced.Format(time.RFC3339Nano)
+	}
+
+	offset := p.next
+	p.records = append(p.records, Record{Offset: offset, Message: stored})
+	p.bytes += recordSize(stored)
+	p.next++
+
+	l.retention.apply(p, l.clock.Now())
+	return offset, nil
+}
+
+// Publish appends a new message built from the payload and headers
+func (l *Log) Publish(ctx context.Context, topic string, payload []byte, headers map[string]string) error {
+	_, err := l.Append(ctx, topic, &queue.Message{
+		ID:      uuid.New().String(),
+		Topic:   topic,
+		Payload: payload,
+		Headers: headers,
+	})
+	return err
+}
+
+// Read returns up to max records of the topic starting at offset
+// Offsets removed by retention are skipped, so reading from 0 always starts at the oldest retained record.
+func (l *Log) Read(ctx context.Context, topic string, offset int64, max int) ([]Record, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if max <= 0 {
+		return nil, fmt.Errorf("max must be positive")
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.closed {
+		return nil, fmt.Errorf("stream log is closed")
+	}
+
+	p, exists := l.topics[topic]
+	if !exists {
+		if offset > 0 {
+			return nil, fmt.Errorf("%w: %d > 0", ErrOffsetOutOfRange, offset)
+		}
+		return nil, nil
+	}
+
+	l.retention.apply(p, l.clock.Now())
+
+	if offset > p.next {
+		return nil, fmt.Errorf("%w: %d > %d", ErrOffsetOutOfRange, offset, p.next)
+	}
+
+	start := int(max64(offset, p.earliest()) - p.earliest())
+	end := min(start+max, len(p.records))
+
+	records := make([]Record, 0, end-start)
+	for _, record := range p.records[start:end] {
+		records = append(records, Record{Offset: record.Offset, Message: record.Message.Clone()})
+	}
+	return records, nil
+}
+
+// Offsets returns the offset of the oldest retained record and the offset the next record will get
+func (l *Log) Offsets(ctx context.Context, topic string) (earliest, next int64, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.closed {
+		return 0, 0, fmt.Errorf("stream log is closed")
+	}
+
+	p, exists := l.topics[topic]
+	if !exists {
+		return 0, 0, nil
+	}
+
+	l.retention.apply(p, l.clock.Now())
+	return p.earliest(), p.next, nil
+}
+
+// OffsetForTime returns the offset of the first retained record with a timestamp at or after t
+// The next offset is returned when every record is older than t.
+func (l *Log) OffsetForTime(ctx context.Context, topic string, t time.Time) (int64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.closed {
+		return 0, fmt.Errorf("stream log is closed")
+	}
+
+	p, exists := l.topics[topic]
+	if !exists {
+		return 0, nil
+	}
+
+	l.retention.apply(p, l.clock.Now())
+
+	i := sort.Search(len(p.records), func(i int) bool {
+		return !p.records[i].Message.Timestamp.Before(t)
+	})
+	if i == len(p.records) {
+		return p.next, nil
+	}
+	return p.records[i].Offset, nil
+}
+
+// Commit stores the offset of the next record the group should read from the topic
+func (l *Log) Commit(ctx context.Context, group, topic string, offset int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if offset < 0 {
+		return fmt.Errorf("%w: %d < 0", ErrOffsetOutOfRange, offset)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.closed {
+		return fmt.Errorf("stream log is closed")
+	}
+
+	next := int64(0)
+	if p, exists := l.topics[topic]; exists {
+		next = p.next
+	}
+	if offset > next {
+		return fmt.Errorf("%w: %d > %d", ErrOffsetOutOfRange, offset, next)
+	}
+
+	offsets, exists := l.committed[group]
+	if !exists {
+		offsets = make(map[string]int64)
+		l.committed[group] = offsets
+	}
+	offsets[topic] = offset
+	return nil
+}
+
+// compareAndCommit commits offset only when the group is still at expected
+func (l *Log) compareAndCommit(group, topic string, expected, offset int64) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.closed || l.committed[group][topic] != expected {
+		return false
+	}
+	l.committed[group][topic] = offset
+	return true
+}
+
+// Committed returns the offset committed by the group for the topic
+// The boolean is false when the group never committed an offset for the topic.
+func (l *Log) Committed(ctx context.Context, group, topic string) (int64, bool, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	if l.closed {
+		return 0, false, fmt.Errorf("stream log is closed")
+	}
+
+	offset, exists := l.committed[group][topic]
+	return offset, exists, nil
+}
+
+// Lag returns how many retained records the group has not processed yet
+func (l *Log) Lag(ctx context.Context, group, topic string) (int64, error) {
+	earliest, next, err := l.Offsets(ctx, topic)
+	if err != nil {
+		return 0, err
+	}
+
+	committed, _, err := l.Committed(ctx, group, topic)
+	if err != nil {
+		return 0, err
+	}
+	return next - max64(committed, earliest), nil
+}
+
+// Topics returns all stream topics
+func (l *Log) Topics(ctx context.Context) ([]string, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	if l.closed {
+		return nil, fmt.Errorf("stream log is closed")
+	}
+
+	topics := make([]string, 0, len(l.topics))
+	for topic := range l.topics {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	return topics, nil
+}
+
+// EnforceRetention removes the records that exceed the retention limits from every topic
+// Retention is also applied on every append and read, this is only needed to reclaim memory of idle topics.
+func (l *Log) EnforceRetention() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.clock.Now()
+	for _, p := range l.topics {
+		l.retention.apply(p, now)
+	}
+}
+
+// Close closes the log and releases resources
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.closed = true
+	l.topics = make(map[string]*partition)
+	l.committed = make(map[string]map[string]int64)
+	return nil
+}
+
+// CheckHealth reports an error once the log is closed
+func (l *Log) CheckHealth(ctx context.Context) error {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	if l.closed {
+		return fmt.Errorf("stream log is closed")
+	}
+	return nil
+}
+
+func max64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
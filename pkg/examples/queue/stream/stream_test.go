@@ -0,0 +1,375 @@
+package stream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/syl/Go/pkg/examples/queue"
+	"github.com/syl/Go/pkg/examples/queue/inmemory/testutils"
+)
+
+// StreamTestFixture provides a log with a controllable clock
+type StreamTestFixture struct {
+	Log   *Log
+	Ctx   context.Context
+	Clock *testutils.FakeClock
+	T     *testing.T
+}
+
+func NewStreamTestFixture(t *testing.T, opts ...Option) *StreamTestFixture {
+	t.Helper()
+
+	f := &StreamTestFixture{
+		Ctx:   context.Background(),
+		Clock: testutils.NewFakeClock(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)),
+		T:     t,
+	}
+	f.Log = NewLog(append([]Option{WithClock(f.Clock)}, opts...)...)
+	t.Cleanup(func() { f.Log.Close() })
+	return f
+}
+
+// Append adds messages with the given payloads one second apart
+func (f *StreamTestFixture) Append(topic string, payloads ...string) {
+	f.T.Helper()
+
+	for _, payload := range payloads {
+		_, err := f.Log.Append(f.Ctx, topic, &queue.Message{ID: payload, Payload: []byte(payload), Timestamp: f.Clock.Now()})
+		require.NoError(f.T, err, "Should append %s", payload)
+		f.Clock.Advance(time.Second)
+	}
+}
+
+// ReadPayloads returns the payloads of all retained records from offset
+func (f *StreamTestFixture) ReadPayloads(topic string, offset int64) []string {
+	f.T.Helper()
+
+	records, err := f.Log.Read(f.Ctx, topic, offset, 1000)
+	require.NoError(f.T, err)
+
+	payloads := make([]string, 0, len(records))
+	for _, record := range records {
+		payloads = append(payloads, string(record.Message.Payload))
+	}
+	return payloads
+}
+
+func TestLog(t *testing.T) {
+	t.Run("OffsetsAreMonotonic", func(t *testing.T) {
+		fixture := NewStreamTestFixture(t)
+
+		for i := 0; i < 3; i++ {
+			offset, err := fixture.Log.Append(fixture.Ctx, "orders", &queue.Message{ID: fmt.Sprint(i)})
+			require.NoError(t, err)
+			assert.Equal(t, int64(i), offset)
+		}
+
+		earliest, next, err := fixture.Log.Offsets(fixture.Ctx, "orders")
+		require.NoError(t, err)
+		assert.Equal(t, int64(0), earliest)
+		assert.Equal(t, int64(3), next)
+	})
+
+	t.Run("ReadKeepsMessages", func(t *testing.T) {
+		fixture := NewStreamTestFixture(t)
+		fixture.Append("orders", "o1", "o2", "o3")
+
+		assert.Equal(t, []string{"o2", "o3"}, fixture.ReadPayloads("orders", 1))
+		assert.Equal(t, []string{"o1", "o2", "o3"}, fixture.ReadPayloads("orders", 0), "Reading should not remove messages")
+	})
+
+	t.Run("ReadPastEnd", func(t *testing.T) {
+		fixture := NewStreamTestFixture(t)
+		fixture.Append("orders", "o1")
+
+		records, err := fixture.Log.Read(fixture.Ctx, "orders", 1, 10)
+		require.NoError(t, err)
+		assert.Empty(t, records, "Reading at the next offset should return nothing")
+
+		_, err = fixture.Log.Read(fixture.Ctx, "orders", 5, 10)
+		assert.ErrorIs(t, err, ErrOffsetOutOfRange)
+	})
+
+	t.Run("OffsetForTime", func(t *testing.T) {
+		fixture := NewStreamTestFixture(t)
+		start := fixture.Clock.Now()
+		fixture.Append("orders", "o1", "o2", "o3")
+
+		offset, err := fixture.Log.OffsetForTime(fixture.Ctx, "orders", start.Add(1500*time.Millisecond))
+		require.NoError(t, err)
+		assert.Equal(t, int64(2), offset)
+
+		offset, err = fixture.Log.OffsetForTime(fixture.Ctx, "orders", start.Add(time.Hour))
+		require.NoError(t, err)
+		assert.Equal(t, int64(3), offset, "Should return the next offset when all records are older")
+	})
+
+	t.Run("OutOfOrderTimestamps", func(t *testing.T) {
+		fixture := NewStreamTestFixture(t, WithRetention(Retention{MaxAge: time.Minute}))
+		start := fixture.Clock.Now()
+		for i, produced := range []time.Time{start, start.Add(-time.Hour), start.Add(time.Hour)} {
+			payload := fmt.Sprintf("o%d", i+1)
+			_, err := fixture.Log.Append(fixture.Ctx, "orders", &queue.Message{ID: payload, Payload: []byte(payload), Timestamp: produced})
+			require.NoError(t, err)
+			fixture.Clock.Advance(time.Second)
+		}
+
+		records, err := fixture.Log.Read(fixture.Ctx, "orders", 0, 10)
+		require.NoError(t, err)
+		require.Len(t, records, 3, "A record produced an hour ago should not be expired by age")
+		for i, record := range records {
+			assert.Equal(t, start.Add(time.Duration(i)*time.Second), record.Message.Timestamp, "Records should be stamped when appended")
+		}
+		assert.NotContains(t, records[0].Message.Headers, HeaderProducerTimestamp, "Should not repeat the append time")
+		assert.Equal(t, start.Add(-time.Hour).Format(time.RFC3339Nano), records[1].Message.Headers[HeaderProducerTimestamp])
+		assert.Equal(t, start.Add(time.Hour).Format(time.RFC3339Nano), records[2].Message.Headers[HeaderProducerTimestamp])
+
+		offset, err := fixture.Log.OffsetForTime(fixture.Ctx, "orders", start.Add(500*time.Millisecond))
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), offset, "Should seek by append time")
+
+		fixture.Clock.Advance(59 * time.Second)
+		assert.Equal(t, []string{"o3"}, fixture.ReadPayloads("orders", 0), "Should expire records in offset order")
+	})
+
+	t.Run("ClockGoingBackwards", func(t *testing.T) {
+		fixture := NewStreamTestFixture(t)
+		fixture.Append("orders", "o1")
+		fixture.Clock.Advance(-time.Hour)
+		fixture.Append("orders", "o2")
+
+		records, err := fixture.Log.Read(fixture.Ctx, "orders", 0, 10)
+		require.NoError(t, err)
+		require.Len(t, records, 2)
+		assert.False(t, records[1].Message.Timestamp.Before(records[0].Message.Timestamp), "Timestamps should never decrease")
+	})
+
+	t.Run("CommitPerGroup", func(t *testing.T) {
+		fixture := NewStreamTestFixture(t)
+		fixture.Append("orders", "o1", "o2", "o3")
+
+		require.NoError(t, fixture.Log.Commit(fixture.Ctx, "billing", "orders", 2))
+
+		offset, committed, err := fixture.Log.Committed(fixture.Ctx, "billing", "orders")
+		require.NoError(t, err)
+		assert.True(t, committed)
+		assert.Equal(t, int64(2), offset)
+
+		_, committed, err = fixture.Log.Committed(fixture.Ctx, "shipping", "orders")
+		require.NoError(t, err)
+		assert.False(t, committed, "Groups should not share offsets")
+
+		lag, err := fixture.Log.Lag(fixture.Ctx, "billing", "orders")
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), lag)
+
+		assert.ErrorIs(t, fixture.Log.Commit(fixture.Ctx, "billing", "orders", 4), ErrOffsetOutOfRange)
+	})
+
+	t.Run("RetentionByCount", func(t *testing.T) {
+		fixture := NewStreamTestFixture(t, WithRetention(Retention{MaxRecords: 2}))
+		fixture.Append("orders", "o1", "o2", "o3", "o4")
+
+		assert.Equal(t, []string{"o3", "o4"}, fixture.ReadPayloads("orders", 0))
+
+		earliest, next, err := fixture.Log.Offsets(fixture.Ctx, "orders")
+		require.NoError(t, err)
+		assert.Equal(t, int64(2), earliest, "Offsets should not be reused")
+		assert.Equal(t, int64(4), next)
+	})
+
+	t.Run("RetentionBySize", func(t *testing.T) {
+		fixture := NewStreamTestFixture(t, WithRetention(Retention{MaxBytes: 10}))
+		fixture.Append("orders", strings.Repeat("a", 4), strings.Repeat("b", 4), strings.Repeat("c", 4))
+
+		assert.Equal(t, []string{"bbbb", "cccc"}, fixture.ReadPayloads("orders", 0))
+	})
+
+	t.Run("RetentionByAge", func(t *testing.T) {
+		fixture := NewStreamTestFixture(t, WithRetention(Retention{MaxAge: time.Minute}))
+		fixture.Append("orders", "o1", "o2")
+
+		fixture.Clock.Advance(time.Minute)
+		fixture.Append("orders", "o3")
+
+		assert.Equal(t, []string{"o3"}, fixture.ReadPayloads("orders", 0))
+
+		fixture.Clock.Advance(time.Hour)
+		fixture.Log.EnforceRetention()
+
+		earliest, next, err := fixture.Log.Offsets(fixture.Ctx, "orders")
+		require.NoError(t, err)
+		assert.Equal(t, earliest, next, "Idle topics should be emptied")
+	})
+
+	t.Run("Closed", func(t *testing.T) {
+		fixture := NewStreamTestFixture(t)
+		require.NoError(t, fixture.Log.Close())
+
+		_, err := fixture.Log.Append(fixture.Ctx, "orders", &queue.Message{})
+		assert.Error(t, err)
+		_, err = fixture.Log.Read(fixture.Ctx, "orders", 0, 1)
+		assert.Error(t, err)
+	})
+}
+
+// collector records the payloads delivered to a handler
+type collector struct {
+	mu       sync.Mutex
+	payloads []string
+	offsets  []string
+}
+
+func (c *collector) handle(ctx context.Context, message *queue.Message) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.payloads = append(c.payloads, string(message.Payload))
+	c.offsets = append(c.offsets, message.Headers[HeaderOffset])
+	return nil
+}
+
+func (c *collector) received() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return append([]string(nil), c.payloads...)
+}
+
+func TestGroupConsumer(t *testing.T) {
+	t.Run("ConsumesAndCommits", func(t *testing.T) {
+		fixture := NewStreamTestFixture(t)
+		fixture.Append("orders", "o1", "o2")
+
+		consumer := NewGroupConsumer(fixture.Log, "billing")
+		defer consumer.Close()
+
+		c := &collector{}
+		require.NoError(t, consumer.Subscribe(fixture.Ctx, "orders", c.handle))
+
+		fixture.Clock.AdvanceUntil(t, PollInterval, func() bool { return len(c.received()) == 2 })
+		assert.Equal(t, []string{"o1", "o2"}, c.received())
+		assert.Equal(t, []string{"0", "1"}, c.offsets, "Should expose record offsets")
+
+		offset, _, err := fixture.Log.Committed(fixture.Ctx, "billing", "orders")
+		require.NoError(t, err)
+		assert.Equal(t, int64(2), offset, "Should commit processed records")
+	})
+
+	t.Run("GroupsAreIndependent", func(t *testing.T) {
+		fixture := NewStreamTestFixture(t)
+		fixture.Append("orders", "o1", "o2")
+
+		billing, shipping := &collector{}, &collector{}
+		for group, c := range map[string]*collector{"billing": billing, "shipping": shipping} {
+			consumer := NewGroupConsumer(fixture.Log, group)
+			defer consumer.Close()
+			require.NoError(t, consumer.Subscribe(fixture.Ctx, "orders", c.handle))
+		}
+
+		fixture.Clock.AdvanceUntil(t, PollInterval, func() bool {
+			return len(billing.received()) == 2 && len(shipping.received()) == 2
+		}, "Each group should receive every record")
+	})
+
+	t.Run("ResumesFromCommittedOffset", func(t *testing.T) {
+		fixture := NewStreamTestFixture(t)
+		fixture.Append("orders", "o1", "o2", "o3")
+		require.NoError(t, fixture.Log.Commit(fixture.Ctx, "billing", "orders", 2))
+
+		consumer := NewGroupConsumer(fixture.Log, "billing")
+		defer consumer.Close()
+
+		c := &collector{}
+		require.NoError(t, consumer.Subscribe(fixture.Ctx, "orders", c.handle))
+
+		fixture.Clock.AdvanceUntil(t, PollInterval, func() bool { return len(c.received()) == 1 })
+		assert.Equal(t, []string{"o3"}, c.received())
+	})
+
+	t.Run("StartLatest", func(t *testing.T) {
+		fixture := NewStreamTestFixture(t)
+		fixture.Append("orders", "o1", "o2")
+
+		consumer := NewGroupConsumer(fixture.Log, "billing", WithStartPosition(StartLatest))
+		defer consumer.Close()
+
+		c := &collector{}
+		require.NoError(t, consumer.Subscribe(fixture.Ctx, "orders", c.handle))
+		fixture.Append("orders", "o3")
+
+		fixture.Clock.AdvanceUntil(t, PollInterval, func() bool { return len(c.received()) == 1 })
+		assert.Equal(t, []string{"o3"}, c.received())
+	})
+
+	t.Run("FailedRecordIsRetried", func(t *testing.T) {
+		fixture := NewStreamTestFixture(t)
+		fixture.Append("orders", "o1", "o2")
+
+		consumer := NewGroupConsumer(fixture.Log, "billing")
+		defer consumer.Close()
+
+		var mu sync.Mutex
+		attempts := map[string]int{}
+		var delivered []string
+		err := consumer.Subscribe(fixture.Ctx, "orders", func(ctx context.Context, message *queue.Message) error {
+			mu.Lock()
+			defer mu.Unlock()
+
+			payload := string(message.Payload)
+			attempts[payload]++
+			if payload == "o1" && attempts[payload] == 1 {
+				return errors.New("temporary failure")
+			}
+			delivered = append(delivered, payload)
+			return nil
+		})
+		require.NoError(t, err)
+
+		fixture.Clock.AdvanceUntil(t, PollInterval, func() bool {
+			mu.Lock()
+			defer mu.Unlock()
+			return len(delivered) == 2
+		})
+		assert.Equal(t, []string{"o1", "o2"}, delivered, "Order should be preserved across retries")
+	})
+
+	t.Run("SeekReplays", func(t *testing.T) {
+		fixture := NewStreamTestFixture(t)
+		start := fixture.Clock.Now()
+		fixture.Append("orders", "o1", "o2", "o3")
+
+		consumer := NewGroupConsumer(fixture.Log, "read-model")
+		defer consumer.Close()
+
+		c := &collector{}
+		require.NoError(t, consumer.Subscribe(fixture.Ctx, "orders", c.handle))
+		fixture.Clock.AdvanceUntil(t, PollInterval, func() bool { return len(c.received()) == 3 })
+
+		require.NoError(t, consumer.Seek(fixture.Ctx, "orders", 0))
+		fixture.Clock.AdvanceUntil(t, PollInterval, func() bool { return len(c.received()) == 6 }, "Should replay from offset 0")
+
+		require.NoError(t, consumer.SeekToTime(fixture.Ctx, "orders", start.Add(2*time.Second)))
+		fixture.Clock.AdvanceUntil(t, PollInterval, func() bool { return len(c.received()) == 7 }, "Should replay from the timestamp")
+		assert.Equal(t, "o3", c.received()[6])
+	})
+
+	t.Run("DuplicateSubscription", func(t *testing.T) {
+		fixture := NewStreamTestFixture(t)
+
+		consumer := NewGroupConsumer(fixture.Log, "billing")
+		defer consumer.Close()
+
+		require.NoError(t, consumer.Subscribe(fixture.Ctx, "orders", (&collector{}).handle))
+		assert.Error(t, consumer.Subscribe(fixture.Ctx, "orders", (&collector{}).handle))
+		require.NoError(t, consumer.Unsubscribe(fixture.Ctx, "orders"))
+		assert.Error(t, consumer.Unsubscribe(fixture.Ctx, "orders"))
+	})
+}
@@ -0,0 +1,47 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Headers sent with every webhook request
+const (
+	// HeaderSignature holds "sha256=" followed by the hex HMAC-SHA256 of the timestamp, a dot and the body
+	HeaderSignature = "X-Webhook-Signature"
+	// HeaderTimestamp holds the Unix time of the attempt, receivers reject old timestamps to prevent replays
+	HeaderTimestamp = "X-Webhook-Timestamp"
+	// HeaderDelivery identifies the delivery, it is the same for every attempt so receivers can deduplicate
+	HeaderDelivery = "X-Webhook-Delivery"
+	// HeaderTopic is the topic of the message
+	HeaderTopic = "X-Webhook-Topic"
+)
+
+// Sign returns the signature of a request body sent at timestamp
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks the signature and timestamp headers of a received request
+// Requests signed more than tolerance ago are rejected, a zero tolerance skips the check.
+func Verify(secret, signature, timestamp string, body []byte, tolerance time.Duration, now time.Time) error {
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid timestamp %q", timestamp)
+	}
+	if tolerance > 0 && now.Sub(time.Unix(ts, 0)).Abs() > tolerance {
+		return fmt.Errorf("timestamp %d is outside the tolerance of %s", ts, tolerance)
+	}
+	if !strings.HasPrefix(signature, "sha256=") || !hmac.Equal([]byte(signature), []byte(Sign(secret, ts, body))) {
+		return fmt.Errorf("signature mismatch")
+	}
+	return nil
+}
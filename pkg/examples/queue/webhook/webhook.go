@@ -0,0 +1,601 @@
+// Package webhook pushes the messages of queue topics to registered HTTPS endpoints.
+//
+// A Dispatcher subscribes to the topics of its endpoints through a queue.Consumer and POSTs every message,
+// encoded as JSON, to each endpoint of the topic. Requests are signed with the secret of the endpoint
+// (see Sign and Verify), failed attempts are retried with exponential backoff, and every endpoint has
+// a circuit breaker so that a partner that is down is not hammered: while it is open, deliveries to the
+// endpoint wait for it instead of giving up. Every endpoint has its own backlog delivered in order by its own
+// goroutine, so an endpoint that is down or slow does not hold back the other endpoints of its topics.
+// The outcome of each delivery is kept in a bounded delivery log.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/syl/Go/pkg/examples/queue"
+	"github.com/syl/Go/pkg/examples/queue/broker"
+)
+
+const (
+	// DefaultLogSize is the number of deliveries kept in the delivery log
+	DefaultLogSize = 1000
+	// DefaultBacklogSize is the number of messages waiting for delivery per endpoint
+	DefaultBacklogSize = 1000
+	// DefaultBreakerThreshold is the number of consecutive failed attempts opening the breaker of an endpoint
+	DefaultBreakerThreshold = 5
+	// DefaultBreakerCooldown is how long an open breaker rejects deliveries before trying again
+	DefaultBreakerCooldown = time.Minute
+	// BreakerPollInterval is how often a delivery waiting for an open breaker checks it again
+	BreakerPollInterval = time.Second
+)
+
+// ErrEndpointNotFound is returned for unknown endpoint IDs
+var ErrEndpointNotFound = errors.New("endpoint not found")
+
+// RetryPolicy controls the attempts of a delivery
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts, including the first one
+	MaxAttempts int
+	// InitialBackoff is the wait before the first retry, it is multiplied by Multiplier after each retry
+	InitialBackoff time.Duration
+	// MaxBackoff caps the wait between attempts
+	MaxBackoff time.Duration
+	Multiplier float64
+}
+
+// DefaultRetryPolicy tries 5 times over about 15 seconds
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    5,
+	InitialBackoff: time.Second,
+	MaxBackoff:     30 * time.Second,
+	Multiplier:     2,
+}
+
+// Backoff returns the wait before the given retry, starting at 1
+func (p RetryPolicy) Backoff(retry int) time.Duration {
+	backoff := float64(p.InitialBackoff)
+	for i := 1; i < retry; i++ {
+		backoff *= p.Multiplier
+	}
+	if p.MaxBackoff > 0 && backoff > float64(p.MaxBackoff) {
+		return p.MaxBackoff
+	}
+	return time.Duration(backoff)
+}
+
+// Endpoint is a partner URL receiving the messages of some topics
+type Endpoint struct {
+	ID  string
+	URL string
+	// Topics are topic names or patterns, as accepted by queue.Consumer
+	Topics []string
+	// Secret signs the requests, a random secret is generated when it is empty
+	Secret    string
+	CreatedAt time.Time
+}
+
+// DeliveryStatus is the outcome of a delivery
+type DeliveryStatus string
+
+const (
+	// DeliverySucceeded means the endpoint answered with a 2xx status
+	DeliverySucceeded DeliveryStatus = "succeeded"
+	// DeliveryFailed means every attempt failed, the endpoint rejected the message, its backlog was full
+	// or the dispatcher stopped
+	DeliveryFailed DeliveryStatus = "failed"
+)
+
+// errBacklogFull is the error of the deliveries dropped because the endpoint has too many messages waiting
+var errBacklogFull = errors.New("endpoint backlog is full")
+
+// Delivery records how a message was delivered to an endpoint
+type Delivery struct {
+	ID         string
+	EndpointID string
+	MessageID  string
+	Topic      string
+	Status     DeliveryStatus
+	Attempts   int
+	// StatusCode is the HTTP status of the last attempt, zero when no response was received
+	StatusCode int
+	Error      string
+	StartedAt  time.Time
+	Duration   time.Duration
+}
+
+// Option configures a Dispatcher created with NewDispatcher
+type Option func(*Dispatcher)
+
+// WithHTTPClient sets the client sending the requests
+func WithHTTPClient(client *http.Client) Option {
+	return func(d *Dispatcher) {
+		d.client = client
+	}
+}
+
+// WithRetryPolicy sets the attempts and backoff of deliveries
+func WithRetryPolicy(policy RetryPolicy) Option {
+	return func(d *Dispatcher) {
+		d.retry = policy
+	}
+}
+
+// WithCircuitBreaker sets the number of consecutive failed attempts opening the breaker of an endpoint
+// and how long it stays open
+func WithCircuitBreaker(threshold int, cooldown time.Duration) Option {
+	return func(d *Dispatcher) {
+		d.breakerThreshold = threshold
+		d.breakerCooldown = cooldown
+	}
+}
+
+// WithLogSize sets the number of deliveries kept in the delivery log
+func WithLogSize(size int) Option {
+	return func(d *Dispatcher) {
+		d.logSize = size
+	}
+}
+
+// WithBacklogSize sets the number of messages waiting for delivery per endpoint
+// Messages arriving while the backlog of an endpoint is full are recorded as failed deliveries to it.
+func WithBacklogSize(size int) Option {
+	return func(d *Dispatcher) {
+		d.backlogSize = size
+	}
+}
+
+// WithClock sets the clock used for backoff, circuit breakers and the delivery log
+func WithClock(clock queue.Clock) Option {
+	return func(d *Dispatcher) {
+		d.clock = clock
+	}
+}
+
+// Dispatcher delivers the messages of the topics of its endpoints
+type Dispatcher struct {
+	consumer         queue.Consumer
+	client           *http.Client
+	clock            queue.Clock
+	retry            RetryPolicy
+	breakerThreshold int
+	breakerCooldown  time.Duration
+	logSize          int
+	backlogSize      int
+	ctx              context.Context
+	cancel           context.CancelFunc
+	// workers are the goroutines delivering the backlogs of the endpoints
+	workers sync.WaitGroup
+
+	// subMu serializes the changes of subscriptions, it is never held by handlers
+	// so that unsubscribing can wait for them
+	subMu  sync.Mutex
+	topics map[string]int
+	closed bool
+
+	mu        sync.RWMutex
+	endpoints map[string]*endpoint
+	log       []Delivery
+}
+
+// endpoint is a registered endpoint with its circuit breaker and the messages waiting for delivery
+type endpoint struct {
+	Endpoint
+	breaker *broker.CircuitBreaker
+	// backlog is closed once the endpoint is removed, its worker delivers what is left and stops
+	backlog chan pending
+}
+
+// pending is a message waiting for delivery to an endpoint
+type pending struct {
+	message *queue.Message
+	body    []byte
+}
+
+// subscribes reports whether the endpoint receives the messages of the subscribed topic
+func (e *endpoint) subscribes(topic string) bool {
+	for _, t := range e.Topics {
+		if t == topic {
+			return true
+		}
+	}
+	return false
+}
+
+// NewDispatcher creates a dispatcher subscribing to topics with consumer
+func NewDispatcher(consumer queue.Consumer, opts ...Option) *Dispatcher {
+	ctx, cancel := context.WithCancel(context.Background())
+	d := &Dispatcher{
+		consumer:         consumer,
+		client:           &http.Client{Timeout: 10 * time.Second},
+		clock:            queue.SystemClock,
+		retry:            DefaultRetryPolicy,
+		breakerThreshold: DefaultBreakerThreshold,
+		breakerCooldown:  DefaultBreakerCooldown,
+		logSize:          DefaultLogSize,
+		backlogSize:      DefaultBacklogSize,
+		ctx:              ctx,
+		cancel:           cancel,
+		endpoints:        make(map[string]*endpoint),
+		topics:           make(map[string]int),
+	}
+	for _, opt := range opts {
+		opt(d)
+	}
+	return d
+}
+
+// Register adds an endpoint and subscribes to its topics, it returns the endpoint with its ID and secret
+// The URL must use HTTPS.
+func (d *Dispatcher) Register(ctx context.Context, e Endpoint) (Endpoint, error) {
+	u, err := url.Parse(e.URL)
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		return Endpoint{}, fmt.Errorf("endpoint URL must be an absolute https URL, got %q", e.URL)
+	}
+	if len(e.Topics) == 0 {
+		return Endpoint{}, fmt.Errorf("endpoint must subscribe to at least one topic")
+	}
+	if e.Secret == "" {
+		if e.Secret, err = newSecret(); err != nil {
+			return Endpoint{}, err
+		}
+	}
+	e.ID = uuid.New().String()
+	e.CreatedAt = d.clock.Now()
+	e.Topics = unique(e.Topics)
+
+	breaker, err := broker.NewCircuitBreakerWithClock(d.clock, e.ID, d.breakerThreshold, d.breakerCooldown, nil)
+	if err != nil {
+		return Endpoint{}, err
+	}
+
+	d.subMu.Lock()
+	defer d.subMu.Unlock()
+
+	if d.closed {
+		return Endpoint{}, fmt.Errorf("dispatcher is closed")
+	}
+
+	// Add the endpoint before subscribing so that the first messages of its topics are delivered to it
+	registered := &endpoint{Endpoint: e, breaker: breaker, backlog: make(chan pending, max(d.backlogSize, 1))}
+	d.mu.Lock()
+	d.endpoints[e.ID] = registered
+	d.mu.Unlock()
+	d.workers.Add(1)
+	go d.run(registered)
+
+	for i, topic := range e.Topics {
+		if d.topics[topic] > 0 {
+			continue
+		}
+		if err := d.consumer.Subscribe(d.ctx, topic, d.handler(topic)); err != nil {
+			// Roll back the subscriptions of this endpoint
+			for _, subscribed := range e.Topics[:i] {
+				if d.topics[subscribed] == 0 {
+					d.consumer.Unsubscribe(ctx, subscribed)
+				}
+			}
+			d.remove(registered)
+			return Endpoint{}, err
+		}
+	}
+	for _, topic := range e.Topics {
+		d.topics[topic]++
+	}
+	return e, nil
+}
+
+// Unregister unsubscribes from the topics no other endpoint needs, then removes the endpoint
+// Messages dequeued before the unsubscription are still delivered to the endpoint, in the background.
+func (d *Dispatcher) Unregister(ctx context.Context, id string) error {
+	d.subMu.Lock()
+	defer d.subMu.Unlock()
+
+	d.mu.RLock()
+	e, exists := d.endpoints[id]
+	d.mu.RUnlock()
+
+	if !exists {
+		return fmt.Errorf("%w: %s", ErrEndpointNotFound, id)
+	}
+
+	var errs []error
+	for _, topic := range e.Topics {
+		d.topics[topic]--
+		if d.topics[topic] > 0 {
+			continue
+		}
+		delete(d.topics, topic)
+		if err := d.consumer.Unsubscribe(ctx, topic); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	d.remove(e)
+	return errors.Join(errs...)
+}
+
+// remove deletes the endpoint and closes its backlog
+// Handlers only add to a backlog while holding the read lock, so none is adding to it once it is closed.
+func (d *Dispatcher) remove(e *endpoint) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	delete(d.endpoints, e.ID)
+	close(e.backlog)
+}
+
+// Endpoints returns the registered endpoints sorted by creation time
+func (d *Dispatcher) Endpoints() []Endpoint {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	endpoints := make([]Endpoint, 0, len(d.endpoints))
+	for _, e := range d.endpoints {
+		endpoints = append(endpoints, e.Endpoint)
+	}
+	sort.Slice(endpoints, func(i, j int) bool {
+		if endpoints[i].CreatedAt.Equal(endpoints[j].CreatedAt) {
+			return endpoints[i].ID < endpoints[j].ID
+		}
+		return endpoints[i].CreatedAt.Before(endpoints[j].CreatedAt)
+	})
+	return endpoints
+}
+
+// Endpoint returns the registered endpoint with the given ID
+func (d *Dispatcher) Endpoint(id string) (Endpoint, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	e, exists := d.endpoints[id]
+	if !exists {
+		return Endpoint{}, fmt.Errorf("%w: %s", ErrEndpointNotFound, id)
+	}
+	return e.Endpoint, nil
+}
+
+// BreakerState returns the state of the circuit breaker of the endpoint
+func (d *Dispatcher) BreakerState(id string) (broker.BreakerState, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	e, exists := d.endpoints[id]
+	if !exists {
+		return broker.BreakerClosed, fmt.Errorf("%w: %s", ErrEndpointNotFound, id)
+	}
+	return e.breaker.State(), nil
+}
+
+// Deliveries returns up to limit deliveries to the endpoint, the most recent first
+// An empty endpoint ID returns the deliveries of every endpoint, a non-positive limit returns all of them.
+func (d *Dispatcher) Deliveries(endpointID string, limit int) []Delivery {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	deliveries := []Delivery{}
+	for i := len(d.log) - 1; i >= 0; i-- {
+		if limit > 0 && len(deliveries) == limit {
+			break
+		}
+		if endpointID == "" || d.log[i].EndpointID == endpointID {
+			deliveries = append(deliveries, d.log[i])
+		}
+	}
+	return deliveries
+}
+
+// Close unsubscribes from every topic and stops the deliveries in progress, the backlogs are dropped
+func (d *Dispatcher) Close() error {
+	d.subMu.Lock()
+	defer d.subMu.Unlock()
+
+	if d.closed {
+		return nil
+	}
+	d.closed = true
+
+	d.cancel()
+	var errs []error
+	for topic := range d.topics {
+		if err := d.consumer.Unsubscribe(context.Background(), topic); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	d.workers.Wait()
+	return errors.Join(errs...)
+}
+
+// handler adds the messages of a subscribed topic to the backlog of each of its endpoints
+// It does not wait for the deliveries, failures are recorded in the delivery log and the message is never
+// handed back to the queue so that endpoints that already received it do not get it twice.
+func (d *Dispatcher) handler(topic string) queue.MessageHandler {
+	return func(ctx context.Context, message *queue.Message) error {
+		body, err := json.Marshal(message)
+		if err != nil {
+			return err
+		}
+
+		var dropped []Delivery
+		d.mu.RLock()
+		for _, e := range d.endpoints {
+			if !e.subscribes(topic) {
+				continue
+			}
+			select {
+			case e.backlog <- pending{message: message, body: body}:
+			default:
+				dropped = append(dropped, Delivery{
+					ID:         uuid.New().String(),
+					EndpointID: e.ID,
+					MessageID:  message.ID,
+					Topic:      message.Topic,
+					Status:     DeliveryFailed,
+					Error:      errBacklogFull.Error(),
+					StartedAt:  d.clock.Now(),
+				})
+			}
+		}
+		d.mu.RUnlock()
+
+		for _, delivery := range dropped {
+			d.record(delivery)
+		}
+		return nil
+	}
+}
+
+// run delivers the backlog of the endpoint in order, until the backlog is closed or the dispatcher is closed
+func (d *Dispatcher) run(e *endpoint) {
+	defer d.workers.Done()
+
+	for {
+		select {
+		case p, ok := <-e.backlog:
+			if !ok {
+				return
+			}
+			d.record(d.deliver(d.ctx, e, p.message, p.body))
+		case <-d.ctx.Done():
+			return
+		}
+	}
+}
+
+// deliver sends the message to the endpoint, retrying failed attempts until the retry policy is exhausted
+// While the breaker of the endpoint rejects calls the delivery waits, holding back the next messages of the endpoint,
+// so that the message is not lost. Only attempts count against the retry policy.
+func (d *Dispatcher) deliver(ctx context.Context, e *endpoint, message *queue.Message, body []byte) Delivery {
+	delivery := Delivery{
+		ID:         uuid.New().String(),
+		EndpointID: e.ID,
+		MessageID:  message.ID,
+		Topic:      message.Topic,
+		StartedAt:  d.clock.Now(),
+	}
+	defer func() {
+		delivery.Duration = d.clock.Since(delivery.StartedAt)
+	}()
+
+	for {
+		if !e.breaker.Allow() {
+			if err := d.wait(ctx, BreakerPollInterval); err != nil {
+				delivery.Status = DeliveryFailed
+				delivery.Error = "circuit breaker open: " + err.Error()
+				return delivery
+			}
+			continue
+		}
+
+		delivery.Attempts++
+		code, retryable, err := d.attempt(ctx, e, delivery.ID, message.Topic, body)
+		delivery.StatusCode = code
+		if err == nil {
+			e.breaker.Success()
+			delivery.Status = DeliverySucceeded
+			delivery.Error = ""
+			return delivery
+		}
+		e.breaker.Failure(err)
+		delivery.Status = DeliveryFailed
+		delivery.Error = err.Error()
+
+		if !retryable || delivery.Attempts >= d.retry.MaxAttempts {
+			return delivery
+		}
+
+		if err := d.wait(ctx, d.retry.Backoff(delivery.Attempts)); err != nil {
+			delivery.Error = err.Error()
+			return delivery
+		}
+	}
+}
+
+// wait blocks for the duration, it returns the error of ctx when it is done first
+func (d *Dispatcher) wait(ctx context.Context, duration time.Duration) error {
+	timer := d.clock.NewTimer(duration)
+	select {
+	case <-timer.C():
+		return nil
+	case <-ctx.Done():
+		timer.Stop()
+		return ctx.Err()
+	}
+}
+
+// attempt sends one signed request, it reports whether a failure is worth retrying
+// Network errors, 429 and 5xx statuses are retried, other statuses mean the endpoint rejected the message.
+func (d *Dispatcher) attempt(ctx context.Context, e *endpoint, deliveryID, topic string, body []byte) (int, bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, false, err
+	}
+	timestamp := d.clock.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderSignature, Sign(e.Secret, timestamp, body))
+	req.Header.Set(HeaderTimestamp, fmt.Sprint(timestamp))
+	req.Header.Set(HeaderDelivery, deliveryID)
+	req.Header.Set(HeaderTopic, topic)
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, true, err
+	}
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return resp.StatusCode, false, nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return resp.StatusCode, true, fmt.Errorf("endpoint answered %d", resp.StatusCode)
+	default:
+		return resp.StatusCode, false, fmt.Errorf("endpoint rejected the message with %d", resp.StatusCode)
+	}
+}
+
+// record appends a delivery to the log, dropping the oldest one when the log is full
+func (d *Dispatcher) record(delivery Delivery) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.logSize <= 0 {
+		return
+	}
+	if len(d.log) == d.logSize {
+		copy(d.log, d.log[1:])
+		d.log = d.log[:d.logSize-1]
+	}
+	d.log = append(d.log, delivery)
+}
+
+func newSecret() (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(secret), nil
+}
+
+// unique returns a copy of topics without duplicates
+func unique(topics []string) []string {
+	seen := make(map[string]bool, len(topics))
+	result := make([]string, 0, len(topics))
+	for _, topic := range topics {
+		if !seen[topic] {
+			seen[topic] = true
+			result = append(result, topic)
+		}
+	}
+	return result
+}
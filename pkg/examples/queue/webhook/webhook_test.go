@@ -0,0 +1,338 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/syl/Go/pkg/examples/queue"
+	"github.com/syl/Go/pkg/examples/queue/broker"
+	"github.com/syl/Go/pkg/examples/queue/inmemory/testutils"
+)
+
+// WebhookTestFixture runs a dispatcher over a mock queue and a TLS receiver answering with the statuses of Responses
+type WebhookTestFixture struct {
+	T          *testing.T
+	Ctx        context.Context
+	Clock      *testutils.FakeClock
+	Dispatcher *Dispatcher
+	Producer   *broker.QueueProducer
+	Receiver   *httptest.Server
+
+	mu        sync.Mutex
+	Responses []int
+	Requests  []*http.Request
+	Bodies    [][]byte
+}
+
+func NewWebhookTestFixture(t *testing.T, opts ...Option) *WebhookTestFixture {
+	q := queue.NewMock()
+	consumer := broker.NewQueueConsumer(q)
+	f := &WebhookTestFixture{
+		T:        t,
+		Ctx:      context.Background(),
+		Clock:    testutils.NewFakeClock(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)),
+		Producer: broker.NewQueueProducer(q),
+	}
+
+	f.Receiver = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		f.Requests = append(f.Requests, r)
+		f.Bodies = append(f.Bodies, body)
+		status := http.StatusOK
+		if len(f.Responses) > 0 {
+			status, f.Responses = f.Responses[0], f.Responses[1:]
+		}
+		w.WriteHeader(status)
+	}))
+
+	opts = append([]Option{WithHTTPClient(f.Receiver.Client()), WithClock(f.Clock)}, opts...)
+	f.Dispatcher = NewDispatcher(consumer, opts...)
+
+	t.Cleanup(func() {
+		f.Dispatcher.Close()
+		consumer.Close()
+		f.Receiver.Close()
+		q.Close()
+	})
+	return f
+}
+
+// Respond queues the statuses of the next requests, the receiver answers 200 afterwards
+func (f *WebhookTestFixture) Respond(statuses ...int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.Responses = append(f.Responses, statuses...)
+}
+
+// Register adds an endpoint pointing at the receiver
+func (f *WebhookTestFixture) Register(topics ...string) Endpoint {
+	f.T.Helper()
+
+	e, err := f.Dispatcher.Register(f.Ctx, Endpoint{URL: f.Receiver.URL + "/hooks", Topics: topics})
+	require.NoError(f.T, err)
+	return e
+}
+
+// RegisterDown adds an endpoint pointing at a receiver that always answers 503
+func (f *WebhookTestFixture) RegisterDown(topics ...string) Endpoint {
+	f.T.Helper()
+
+	down := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	f.T.Cleanup(down.Close)
+
+	e, err := f.Dispatcher.Register(f.Ctx, Endpoint{URL: down.URL + "/hooks", Topics: topics})
+	require.NoError(f.T, err)
+	return e
+}
+
+// WaitForDeliveries advances the clock until the log holds n deliveries
+func (f *WebhookTestFixture) WaitForDeliveries(n int) []Delivery {
+	f.T.Helper()
+
+	f.Clock.AdvanceUntil(f.T, 100*time.Millisecond, func() bool {
+		return len(f.Dispatcher.Deliveries("", 0)) >= n
+	}, "Expected deliveries")
+	return f.Dispatcher.Deliveries("", 0)
+}
+
+func TestDispatcher(t *testing.T) {
+	t.Run("DeliversSignedMessages", func(t *testing.T) {
+		fixture := NewWebhookTestFixture(t)
+		e := fixture.Register("orders")
+		assert.NotEmpty(t, e.ID)
+		assert.Len(t, e.Secret, 64, "Should generate a secret")
+
+		require.NoError(t, fixture.Producer.Publish(fixture.Ctx, "orders", []byte(`{"order_id":"o1"}`), map[string]string{"region": "eu"}))
+
+		deliveries := fixture.WaitForDeliveries(1)
+		assert.Equal(t, DeliverySucceeded, deliveries[0].Status)
+		assert.Equal(t, 1, deliveries[0].Attempts)
+		assert.Equal(t, http.StatusOK, deliveries[0].StatusCode)
+		assert.Equal(t, e.ID, deliveries[0].EndpointID)
+
+		req, body := fixture.Requests[0], fixture.Bodies[0]
+		assert.Equal(t, "/hooks", req.URL.Path)
+		assert.Equal(t, "orders", req.Header.Get(HeaderTopic))
+		assert.Equal(t, deliveries[0].ID, req.Header.Get(HeaderDelivery))
+		assert.NoError(t, Verify(e.Secret, req.Header.Get(HeaderSignature), req.Header.Get(HeaderTimestamp), body, time.Minute, fixture.Clock.Now()))
+
+		var message queue.Message
+		require.NoError(t, json.Unmarshal(body, &message))
+		assert.Equal(t, `{"order_id":"o1"}`, string(message.Payload))
+		assert.Equal(t, "eu", message.Headers["region"])
+	})
+
+	t.Run("RetriesWithBackoff", func(t *testing.T) {
+		fixture := NewWebhookTestFixture(t, WithRetryPolicy(RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Second, Multiplier: 2}))
+		fixture.Register("orders")
+		fixture.Respond(http.StatusServiceUnavailable, http.StatusTooManyRequests)
+		start := fixture.Clock.Now()
+
+		require.NoError(t, fixture.Producer.Publish(fixture.Ctx, "orders", []byte("o1"), nil))
+
+		deliveries := fixture.WaitForDeliveries(1)
+		assert.Equal(t, DeliverySucceeded, deliveries[0].Status)
+		assert.Equal(t, 3, deliveries[0].Attempts)
+		assert.GreaterOrEqual(t, fixture.Clock.Since(start), 3*time.Second, "Should wait 1s then 2s between attempts")
+		assert.Equal(t, deliveries[0].ID, fixture.Requests[2].Header.Get(HeaderDelivery), "Every attempt should carry the same delivery ID")
+	})
+
+	t.Run("GivesUp", func(t *testing.T) {
+		fixture := NewWebhookTestFixture(t, WithRetryPolicy(RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Second}))
+		fixture.Register("orders")
+		fixture.Respond(http.StatusInternalServerError, http.StatusInternalServerError)
+
+		require.NoError(t, fixture.Producer.Publish(fixture.Ctx, "orders", []byte("o1"), nil))
+
+		deliveries := fixture.WaitForDeliveries(1)
+		assert.Equal(t, DeliveryFailed, deliveries[0].Status)
+		assert.Equal(t, 2, deliveries[0].Attempts)
+		assert.Equal(t, "endpoint answered 500", deliveries[0].Error)
+	})
+
+	t.Run("RejectedIsNotRetried", func(t *testing.T) {
+		fixture := NewWebhookTestFixture(t)
+		fixture.Register("orders")
+		fixture.Respond(http.StatusBadRequest)
+
+		require.NoError(t, fixture.Producer.Publish(fixture.Ctx, "orders", []byte("o1"), nil))
+
+		deliveries := fixture.WaitForDeliveries(1)
+		assert.Equal(t, DeliveryFailed, deliveries[0].Status)
+		assert.Equal(t, 1, deliveries[0].Attempts)
+		assert.Equal(t, http.StatusBadRequest, deliveries[0].StatusCode)
+	})
+
+	t.Run("CircuitBreaker", func(t *testing.T) {
+		fixture := NewWebhookTestFixture(t,
+			WithRetryPolicy(RetryPolicy{MaxAttempts: 1}),
+			WithCircuitBreaker(2, 10*time.Second))
+		e := fixture.Register("orders")
+		fixture.Respond(http.StatusBadGateway, http.StatusBadGateway)
+		start := fixture.Clock.Now()
+
+		for _, payload := range []string{"o1", "o2", "o3"} {
+			require.NoError(t, fixture.Producer.Publish(fixture.Ctx, "orders", []byte(payload), nil))
+		}
+
+		fixture.WaitForDeliveries(2)
+		state, err := fixture.Dispatcher.BreakerState(e.ID)
+		require.NoError(t, err)
+		assert.Equal(t, broker.BreakerOpen, state)
+
+		deliveries := fixture.WaitForDeliveries(3)
+		assert.Equal(t, DeliverySucceeded, deliveries[0].Status, "The third delivery should wait for the breaker instead of failing")
+		assert.Equal(t, 1, deliveries[0].Attempts)
+		assert.GreaterOrEqual(t, fixture.Clock.Since(start), 10*time.Second, "The open breaker should protect the endpoint until its cooldown")
+		assert.Len(t, fixture.Requests, 3)
+
+		state, err = fixture.Dispatcher.BreakerState(e.ID)
+		require.NoError(t, err)
+		assert.Equal(t, broker.BreakerClosed, state, "The successful trial should close the breaker")
+	})
+
+	t.Run("IsolatesEndpoints", func(t *testing.T) {
+		fixture := NewWebhookTestFixture(t,
+			WithRetryPolicy(RetryPolicy{MaxAttempts: 1}),
+			WithCircuitBreaker(1, time.Hour))
+		down := fixture.RegisterDown("orders")
+		healthy := fixture.Register("orders")
+
+		for _, payload := range []string{"o1", "o2", "o3"} {
+			require.NoError(t, fixture.Producer.Publish(fixture.Ctx, "orders", []byte(payload), nil))
+		}
+
+		fixture.Clock.AdvanceUntil(t, 100*time.Millisecond, func() bool {
+			return len(fixture.Dispatcher.Deliveries(healthy.ID, 0)) == 3
+		}, "The open breaker of another endpoint should not hold back the healthy one")
+		for _, delivery := range fixture.Dispatcher.Deliveries(healthy.ID, 0) {
+			assert.Equal(t, DeliverySucceeded, delivery.Status)
+		}
+
+		state, err := fixture.Dispatcher.BreakerState(down.ID)
+		require.NoError(t, err)
+		assert.Equal(t, broker.BreakerOpen, state)
+		deliveries := fixture.Dispatcher.Deliveries(down.ID, 0)
+		require.Len(t, deliveries, 1, "The next messages of the endpoint that is down should wait for its breaker")
+		assert.Equal(t, DeliveryFailed, deliveries[0].Status)
+	})
+
+	t.Run("BacklogFull", func(t *testing.T) {
+		fixture := NewWebhookTestFixture(t,
+			WithRetryPolicy(RetryPolicy{MaxAttempts: 1}),
+			WithCircuitBreaker(1, time.Hour),
+			WithBacklogSize(1))
+		down := fixture.RegisterDown("orders")
+
+		for _, payload := range []string{"o1", "o2", "o3", "o4", "o5"} {
+			require.NoError(t, fixture.Producer.Publish(fixture.Ctx, "orders", []byte(payload), nil))
+		}
+
+		fixture.Clock.AdvanceUntil(t, 100*time.Millisecond, func() bool {
+			for _, delivery := range fixture.Dispatcher.Deliveries(down.ID, 0) {
+				if delivery.Error == "endpoint backlog is full" {
+					return true
+				}
+			}
+			return false
+		}, "Messages beyond the backlog of the endpoint should be recorded as failed")
+	})
+
+	t.Run("DeliversAroundSubscriptionChanges", func(t *testing.T) {
+		fixture := NewWebhookTestFixture(t)
+		consumer := &inFlightConsumer{handlers: make(map[string]queue.MessageHandler)}
+		dispatcher := NewDispatcher(consumer, WithHTTPClient(fixture.Receiver.Client()), WithClock(fixture.Clock))
+		defer dispatcher.Close()
+
+		e, err := dispatcher.Register(fixture.Ctx, Endpoint{URL: fixture.Receiver.URL + "/hooks", Topics: []string{"orders"}})
+		require.NoError(t, err)
+		require.NoError(t, dispatcher.Unregister(fixture.Ctx, e.ID))
+
+		fixture.Clock.AdvanceUntil(t, 100*time.Millisecond, func() bool {
+			return len(dispatcher.Deliveries(e.ID, 0)) == 2
+		}, "Messages handled while subscribing and unsubscribing should reach the endpoint")
+		deliveries := dispatcher.Deliveries(e.ID, 0)
+		assert.Equal(t, "unsubscribing", deliveries[0].MessageID)
+		assert.Equal(t, "subscribing", deliveries[1].MessageID)
+	})
+
+	t.Run("DeliveryLog", func(t *testing.T) {
+		fixture := NewWebhookTestFixture(t, WithLogSize(2))
+		first := fixture.Register("orders")
+		second := fixture.Register("orders", "payments")
+
+		require.NoError(t, fixture.Producer.Publish(fixture.Ctx, "payments", []byte("p1"), nil))
+		fixture.WaitForDeliveries(1)
+		require.NoError(t, fixture.Producer.Publish(fixture.Ctx, "orders", []byte("o1"), nil))
+		fixture.Clock.AdvanceUntil(t, 100*time.Millisecond, func() bool {
+			return len(fixture.Dispatcher.Deliveries(first.ID, 0)) == 1
+		})
+
+		assert.Len(t, fixture.Dispatcher.Deliveries("", 0), 2, "Should keep the most recent deliveries")
+		assert.Len(t, fixture.Dispatcher.Deliveries(first.ID, 0), 1)
+		assert.Len(t, fixture.Dispatcher.Deliveries(second.ID, 1), 1, "Should honor the limit")
+	})
+
+	t.Run("Register", func(t *testing.T) {
+		fixture := NewWebhookTestFixture(t)
+
+		_, err := fixture.Dispatcher.Register(fixture.Ctx, Endpoint{URL: "http://partner.example.com/hooks", Topics: []string{"orders"}})
+		assert.Error(t, err, "Should require HTTPS")
+		_, err = fixture.Dispatcher.Register(fixture.Ctx, Endpoint{URL: "https://partner.example.com/hooks"})
+		assert.Error(t, err, "Should require topics")
+
+		e := fixture.Register("orders", "orders")
+		assert.Equal(t, []string{"orders"}, e.Topics)
+		assert.Len(t, fixture.Dispatcher.Endpoints(), 1)
+
+		require.NoError(t, fixture.Dispatcher.Unregister(fixture.Ctx, e.ID))
+		assert.Empty(t, fixture.Dispatcher.Endpoints())
+		assert.ErrorIs(t, fixture.Dispatcher.Unregister(fixture.Ctx, e.ID), ErrEndpointNotFound)
+	})
+}
+
+// inFlightConsumer hands a message to the handler while subscribing and while unsubscribing,
+// as a consumer does with the messages it dequeues around these calls
+type inFlightConsumer struct {
+	handlers map[string]queue.MessageHandler
+}
+
+func (c *inFlightConsumer) Subscribe(ctx context.Context, topic string, handler queue.MessageHandler) error {
+	c.handlers[topic] = handler
+	return handler(ctx, &queue.Message{ID: "subscribing", Topic: topic})
+}
+
+func (c *inFlightConsumer) Unsubscribe(ctx context.Context, topic string) error {
+	handler := c.handlers[topic]
+	delete(c.handlers, topic)
+	return handler(ctx, &queue.Message{ID: "unsubscribing", Topic: topic})
+}
+
+func (c *inFlightConsumer) Close() error {
+	return nil
+}
+
+func TestVerify(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	body := []byte(`{"id":"o1"}`)
+	signature := Sign("secret", now.Unix(), body)
+
+	assert.NoError(t, Verify("secret", signature, "1700000000", body, time.Minute, now))
+	assert.Error(t, Verify("other", signature, "1700000000", body, time.Minute, now), "Should reject another secret")
+	assert.Error(t, Verify("secret", signature, "1700000000", []byte(`{"id":"o2"}`), time.Minute, now), "Should reject a modified body")
+	assert.Error(t, Verify("secret", signature, "1700000000", body, time.Minute, now.Add(time.Hour)), "Should reject old requests")
+}